@@ -0,0 +1,596 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- ActivityPub: the blog as an account Mastodon users can follow ---
+//
+// One actor, @blog@<host> (MALT_AP_USERNAME to change it), found through WebFinger. Follows
+// are accepted automatically; each new post goes to every follower's inbox as a Create of a
+// Note with the title, description and a link, when it goes live (announce.go). Edits go out
+// as Update, deletions and posts taken back to drafts as Delete. Requests are signed, and inbox posts verified, with HTTP
+// Signatures (rsa-sha256), which is what Mastodon requires. The key pair is made on first
+// use and kept in the database. Deliveries are retried a few times from memory; a follower
+// whose inbox answers 410 Gone is dropped.
+//
+// Everything here is behind the "activitypub" feature flag.
+
+const (
+	apContentType = "application/activity+json"
+	apPublic      = "https://www.w3.org/ns/activitystreams#Public"
+)
+
+var apContext = []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1"}
+
+// apBackoff is the wait before each retry of a delivery.
+var apBackoff = []time.Duration{time.Minute, 10 * time.Minute, time.Hour}
+
+// apClient fetches actors and delivers activities; like link previews, only to public hosts.
+var apClient = &http.Client{
+	Timeout:       10 * time.Second,
+	Transport:     unfurlClient.Transport,
+	CheckRedirect: unfurlClient.CheckRedirect,
+}
+
+func apUsername() string {
+	if u := os.Getenv("MALT_AP_USERNAME"); u != "" {
+		return u
+	}
+	return "blog"
+}
+
+func actorURL(base string) string      { return base + "/ap/actor" }
+func noteURL(base, slug string) string { return base + "/ap/notes/" + slug }
+
+// actorKey is the actor's key pair, made and stored the first time it's needed.
+var actorKey = sync.OnceValues(func() (*rsa.PrivateKey, error) {
+	var pemKey string
+	err := db.QueryRow("SELECT private_key FROM activitypub_keys WHERE id = 1").Scan(&pemKey)
+	if err == sql.ErrNoRows {
+		var key *rsa.PrivateKey
+		if key, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			return nil, err
+		}
+		pemKey = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+		// Another instance may have stored its own meanwhile: the stored one is the key
+		_, err = execWrite("INSERT INTO activitypub_keys (id, private_key, created_at) VALUES (1, ?, ?) ON CONFLICT(id) DO NOTHING",
+			pemKey, time.Now().UTC())
+		if err == nil {
+			err = db.QueryRow("SELECT private_key FROM activitypub_keys WHERE id = 1").Scan(&pemKey)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, errors.New("activitypub: stored key is not PEM")
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+})
+
+// apJSON writes an ActivityPub document.
+func apJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", apContentType)
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(v)
+}
+
+// GET /.well-known/webfinger?resource=acct:blog@host - Where Mastodon looks the blog up
+func handleWebFinger(w http.ResponseWriter, r *http.Request) {
+	base := baseURL(r)
+	resource := r.URL.Query().Get("resource")
+	acct := "acct:" + apUsername() + "@" + siteHost(r.Host)
+	if !strings.EqualFold(resource, acct) && resource != actorURL(base) {
+		httpError(w, r, "No such account", 404)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/jrd+json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(map[string]any{
+		"subject": acct,
+		"aliases": []string{actorURL(base), base + "/"},
+		"links": []map[string]string{
+			{"rel": "self", "type": apContentType, "href": actorURL(base)},
+			{"rel": "http://webfinger.net/rel/profile-page", "type": "text/html", "href": base + "/"},
+		},
+	})
+}
+
+// GET /ap/actor - The blog's actor document, with its public key
+func handleActor(w http.ResponseWriter, r *http.Request) {
+	key, err := actorKey()
+	if err != nil {
+		httpError(w, r, "Key unavailable: "+err.Error(), 500)
+		return
+	}
+	pub, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+
+	base := baseURL(r)
+	actor := actorURL(base)
+	apJSON(w, map[string]any{
+		"@context":                  apContext,
+		"id":                        actor,
+		"type":                      "Person",
+		"preferredUsername":         apUsername(),
+		"name":                      siteTitle(),
+		"summary":                   "<p>Posts from " + html.EscapeString(siteTitle()) + ", " + html.EscapeString(base) + "</p>",
+		"url":                       base + "/",
+		"inbox":                     base + "/ap/inbox",
+		"outbox":                    base + "/ap/outbox",
+		"followers":                 base + "/ap/followers",
+		"manuallyApprovesFollowers": false,
+		"discoverable":              true,
+		"endpoints":                 map[string]string{"sharedInbox": base + "/ap/inbox"},
+		"publicKey": map[string]string{
+			"id":           actor + "#main-key",
+			"owner":        actor,
+			"publicKeyPem": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})),
+		},
+	})
+}
+
+// note is p as an ActivityPub Note: a link to the post rather than the whole of it.
+func note(base string, p Post) map[string]any {
+	content := `<p><a href="` + html.EscapeString(base+"/post/"+p.Slug) + `">` + html.EscapeString(p.Title) + `</a></p>`
+	if p.Description != "" {
+		content += "<p>" + html.EscapeString(p.Description) + "</p>"
+	}
+	n := map[string]any{
+		"id":           noteURL(base, p.Slug),
+		"type":         "Note",
+		"attributedTo": actorURL(base),
+		"url":          base + "/post/" + p.Slug,
+		"published":    p.PublishedAt.UTC().Format(time.RFC3339),
+		"to":           []string{apPublic},
+		"cc":           []string{base + "/ap/followers"},
+		"content":      content,
+	}
+	if p.UpdatedAt.Sub(p.PublishedAt) > time.Minute {
+		n["updated"] = p.UpdatedAt.UTC().Format(time.RFC3339)
+	}
+	return n
+}
+
+// activity wraps object in an activity of kind from the blog's actor.
+func activity(base, kind string, object map[string]any) map[string]any {
+	id, _ := object["id"].(string)
+	a := map[string]any{
+		"@context": apContext,
+		"id":       id + "#" + strings.ToLower(kind),
+		"type":     kind,
+		"actor":    actorURL(base),
+		"to":       []string{apPublic},
+		"cc":       []string{base + "/ap/followers"},
+		"object":   object,
+	}
+	if kind != "Create" {
+		// Each Update or Delete is a new activity, so it needs an ID of its own
+		a["id"] = fmt.Sprintf("%s#%s-%d", id, strings.ToLower(kind), time.Now().Unix())
+	}
+	if published, ok := object["published"]; ok && kind == "Create" {
+		a["published"] = published
+	}
+	return a
+}
+
+// GET /ap/notes/{slug} - A post as a Note, for servers that look it up by ID
+func handleNote(w http.ResponseWriter, r *http.Request) {
+	p, err := store.GetPost(r.PathValue("slug"))
+	if err != nil || p.Status != "published" || p.PublishedAt.After(time.Now()) {
+		httpError(w, r, "Post not found", 404)
+		return
+	}
+	n := note(baseURL(r), p)
+	n["@context"] = apContext
+	apJSON(w, n)
+}
+
+// GET /ap/outbox - The latest posts as Create activities
+func handleOutbox(w http.ResponseWriter, r *http.Request) {
+	page, err := store.ListPosts(PostQuery{Limit: 20})
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+
+	base := baseURL(r)
+	items := []map[string]any{}
+	for _, p := range page.Posts {
+		if p.PublishedAt.After(time.Now()) {
+			continue
+		}
+		a := activity(base, "Create", note(base, p))
+		delete(a, "@context")
+		items = append(items, a)
+	}
+	apJSON(w, map[string]any{
+		"@context":     apContext,
+		"id":           base + "/ap/outbox",
+		"type":         "OrderedCollection",
+		"totalItems":   page.Total,
+		"orderedItems": items,
+	})
+}
+
+// GET /ap/followers - How many followers there are; who they are isn't public
+func handleFollowers(w http.ResponseWriter, r *http.Request) {
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM activitypub_followers").Scan(&n)
+	apJSON(w, map[string]any{
+		"@context":   apContext,
+		"id":         baseURL(r) + "/ap/followers",
+		"type":       "OrderedCollection",
+		"totalItems": n,
+	})
+}
+
+// remoteActor is the part of someone else's actor document this side uses.
+type remoteActor struct {
+	ID        string `json:"id"`
+	Inbox     string `json:"inbox"`
+	Endpoints struct {
+		SharedInbox string `json:"sharedInbox"`
+	} `json:"endpoints"`
+	PublicKey struct {
+		ID           string `json:"id"`
+		Owner        string `json:"owner"`
+		PublicKeyPem string `json:"publicKeyPem"`
+	} `json:"publicKey"`
+}
+
+// inboxActivity is an incoming activity; Object is an ID or an embedded object.
+type inboxActivity struct {
+	ID     string          `json:"id"`
+	Type   string          `json:"type"`
+	Actor  string          `json:"actor"`
+	Object json.RawMessage `json:"object"`
+}
+
+// objectID is the ID in an activity's object, whether it's given as a string or embedded.
+func objectID(raw json.RawMessage) (id, kind string) {
+	if json.Unmarshal(raw, &id) == nil {
+		return id, ""
+	}
+	var obj struct{ ID, Type string }
+	json.Unmarshal(raw, &obj)
+	return obj.ID, obj.Type
+}
+
+// POST /ap/inbox - Follows and unfollows; anything else is acknowledged and dropped
+func handleInbox(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		httpError(w, r, "Too big", 413)
+		return
+	}
+	var a inboxActivity
+	if err := json.Unmarshal(body, &a); err != nil || a.Actor == "" {
+		httpError(w, r, "Bad JSON", 400)
+		return
+	}
+
+	// Servers announce every account deletion to everyone; only followers' are worth a lookup
+	if a.Type == "Delete" {
+		var follower bool
+		db.QueryRow("SELECT EXISTS(SELECT 1 FROM activitypub_followers WHERE actor = ?)", a.Actor).Scan(&follower)
+		if !follower {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+	}
+
+	base := baseURL(r)
+	actor, err := verifySignature(r, body, base)
+	if err != nil {
+		httpError(w, r, "Signature: "+err.Error(), 401)
+		return
+	}
+	if actor.ID != a.Actor {
+		httpError(w, r, "Signed by someone other than the actor", 401)
+		return
+	}
+
+	object, kind := objectID(a.Object)
+	switch {
+	case a.Type == "Follow" && object == actorURL(base):
+		_, err := execWrite(`
+			INSERT INTO activitypub_followers (actor, inbox, shared_inbox, followed_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT(actor) DO UPDATE SET inbox = excluded.inbox, shared_inbox = excluded.shared_inbox`,
+			actor.ID, actor.Inbox, actor.Endpoints.SharedInbox, time.Now().UTC())
+		if err != nil {
+			httpError(w, r, "Database error", 500)
+			return
+		}
+		accept := map[string]any{
+			"@context": apContext,
+			"id":       fmt.Sprintf("%s#accept-%d", actorURL(base), time.Now().UnixNano()),
+			"type":     "Accept",
+			"actor":    actorURL(base),
+			"object":   json.RawMessage(body),
+		}
+		go deliver(base, actor.Inbox, accept)
+		log.Printf("activitypub: %s followed", actor.ID)
+
+	case a.Type == "Undo" && kind == "Follow":
+		execWrite("DELETE FROM activitypub_followers WHERE actor = ?", actor.ID)
+		log.Printf("activitypub: %s unfollowed", actor.ID)
+
+	case a.Type == "Delete" && object == actor.ID:
+		execWrite("DELETE FROM activitypub_followers WHERE actor = ?", actor.ID)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// federate sends a post's Create, Update or Delete to every follower's server, once per
+// shared inbox.
+func federate(base, kind string, p Post) {
+	if !featureEnabled("activitypub") {
+		return
+	}
+
+	rows, err := db.Query("SELECT DISTINCT COALESCE(NULLIF(shared_inbox, ''), inbox) FROM activitypub_followers")
+	if err != nil {
+		log.Printf("activitypub: %v", err)
+		return
+	}
+	var inboxes []string
+	for rows.Next() {
+		var inbox string
+		if rows.Scan(&inbox) == nil {
+			inboxes = append(inboxes, inbox)
+		}
+	}
+	rows.Close()
+
+	var object map[string]any
+	if kind == "Delete" {
+		object = map[string]any{"id": noteURL(base, p.Slug), "type": "Tombstone"}
+	} else {
+		object = note(base, p)
+	}
+	if kind == "Update" {
+		// Mastodon only takes an edit that says when it happened
+		object["updated"] = p.UpdatedAt.UTC().Format(time.RFC3339)
+	}
+	a := activity(base, kind, object)
+	for _, inbox := range inboxes {
+		go deliver(base, inbox, a)
+	}
+}
+
+// deliver POSTs a signed activity to inbox, retrying on network errors and 5xx/429.
+func deliver(base, inbox string, a map[string]any) {
+	body, _ := json.Marshal(a)
+	for attempt := 0; ; attempt++ {
+		code, err := postSigned(base, inbox, body)
+		switch {
+		case err == nil:
+			return
+		case code == http.StatusGone:
+			execWrite("DELETE FROM activitypub_followers WHERE inbox = ? OR shared_inbox = ?", inbox, inbox)
+			log.Printf("activitypub: %s is gone; dropped its followers", inbox)
+			return
+		case code >= 400 && code < 500 && code != http.StatusTooManyRequests, attempt >= len(apBackoff):
+			log.Printf("activitypub: delivery to %s: %v", inbox, err)
+			return
+		}
+		time.Sleep(apBackoff[attempt])
+	}
+}
+
+func postSigned(base, inbox string, body []byte) (int, error) {
+	req, err := http.NewRequest("POST", inbox, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", apContentType)
+	if err := signRequest(req, body, base); err != nil {
+		return 0, err
+	}
+	resp, err := apClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, fmt.Errorf("%s answered %s", inbox, resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// fetchActor GETs an actor document, signed, since some servers insist. The document must
+// be the one at id: a server can say anything about actors elsewhere, keys included.
+func fetchActor(base, id string) (remoteActor, error) {
+	var actor remoteActor
+	req, err := http.NewRequest("GET", id, nil)
+	if err != nil {
+		return actor, err
+	}
+	req.Header.Set("Accept", apContentType+`, application/ld+json; profile="https://www.w3.org/ns/activitystreams"`)
+	req.Header.Set("User-Agent", "single-malt activitypub")
+	if err := signRequest(req, nil, base); err != nil {
+		return actor, err
+	}
+	resp, err := apClient.Do(req)
+	if err != nil {
+		return actor, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return actor, fmt.Errorf("%s answered %s", id, resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&actor); err != nil {
+		return actor, err
+	}
+	if actor.ID == "" || actor.Inbox == "" {
+		return actor, fmt.Errorf("%s is not an actor", id)
+	}
+	if actor.ID != id {
+		return actor, fmt.Errorf("%s claims to be %s", id, actor.ID)
+	}
+	return actor, nil
+}
+
+// signRequest adds Date, Digest (with a body) and a Signature over them, in the
+// draft-cavage HTTP Signatures form Mastodon speaks.
+func signRequest(req *http.Request, body []byte, base string) error {
+	key, err := actorKey()
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	headers := []string{"(request-target)", "host", "date"}
+	if body != nil {
+		sum := sha256.Sum256(body)
+		req.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]))
+		headers = append(headers, "digest")
+	}
+
+	target := strings.ToLower(req.Method) + " " + req.URL.RequestURI()
+	sum := sha256.Sum256([]byte(signingString(headers, target, req.URL.Host, req.Header)))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Signature", fmt.Sprintf(`keyId="%s#main-key",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		actorURL(base), strings.Join(headers, " "), base64.StdEncoding.EncodeToString(sig)))
+	return nil
+}
+
+func signingString(headers []string, target, host string, h http.Header) string {
+	lines := make([]string, len(headers))
+	for i, name := range headers {
+		switch name {
+		case "(request-target)":
+			lines[i] = name + ": " + target
+		case "host":
+			lines[i] = "host: " + host
+		default:
+			lines[i] = name + ": " + h.Get(name)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// verifySignature checks r's HTTP signature against its sender's published key and returns
+// the sender. The signature has to cover the request line, the date and the body's digest.
+func verifySignature(r *http.Request, body []byte, base string) (remoteActor, error) {
+	params := map[string]string{}
+	for _, part := range strings.Split(r.Header.Get("Signature"), ",") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(part), "="); ok {
+			params[k] = strings.Trim(v, `"`)
+		}
+	}
+	if params["keyId"] == "" || params["signature"] == "" {
+		return remoteActor{}, errors.New("missing")
+	}
+	headers := strings.Fields(strings.ToLower(params["headers"]))
+	for _, required := range []string{"(request-target)", "date", "digest"} {
+		if !slices.Contains(headers, required) {
+			return remoteActor{}, errors.New("doesn't cover " + required)
+		}
+	}
+
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil || time.Since(date).Abs() > time.Hour {
+		return remoteActor{}, errors.New("date missing or too far off")
+	}
+	sum := sha256.Sum256(body)
+	if r.Header.Get("Digest") != "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]) {
+		return remoteActor{}, errors.New("digest doesn't match the body")
+	}
+
+	keyURL, err := url.Parse(params["keyId"])
+	if err != nil {
+		return remoteActor{}, errors.New("bad keyId")
+	}
+	keyURL.Fragment = ""
+	actor, err := fetchActor(base, keyURL.String())
+	if err != nil {
+		return remoteActor{}, err
+	}
+	if actor.PublicKey.ID != params["keyId"] {
+		return remoteActor{}, errors.New("keyId isn't the actor's key")
+	}
+	block, _ := pem.Decode([]byte(actor.PublicKey.PublicKeyPem))
+	if block == nil {
+		return remoteActor{}, errors.New("actor's key isn't PEM")
+	}
+	var pub *rsa.PublicKey
+	if parsed, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		pub, _ = parsed.(*rsa.PublicKey)
+	} else if parsed, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		pub = parsed
+	}
+	if pub == nil {
+		return remoteActor{}, errors.New("actor's key isn't RSA")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil {
+		return remoteActor{}, errors.New("signature isn't base64")
+	}
+	target := strings.ToLower(r.Method) + " " + r.URL.RequestURI()
+	signed := sha256.Sum256([]byte(signingString(headers, target, r.Host, r.Header)))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, signed[:], sig); err != nil {
+		return remoteActor{}, errors.New("doesn't verify")
+	}
+	return actor, nil
+}
+
+// GET /api/activitypub/followers - Who follows the blog from the Fediverse
+func handleListFollowers(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	rows, err := db.Query("SELECT actor, inbox, followed_at FROM activitypub_followers ORDER BY followed_at DESC")
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	type follower struct {
+		Actor      string    `json:"actor"`
+		Inbox      string    `json:"inbox"`
+		FollowedAt time.Time `json:"followed_at"`
+	}
+	followers := []follower{}
+	for rows.Next() {
+		var f follower
+		if rows.Scan(&f.Actor, &f.Inbox, &f.FollowedAt) == nil {
+			followers = append(followers, f)
+		}
+	}
+
+	jsonResponse(w, followers)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// An actor document only speaks for itself: one served at one URL claiming another actor's
+// id would let its server sign as that actor.
+func TestFetchActorChecksID(t *testing.T) {
+	testServer(t)
+	transport := apClient.Transport
+	apClient.Transport = http.DefaultTransport // The test server is on loopback, which apClient refuses
+	t.Cleanup(func() { apClient.Transport = transport })
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := srv.URL + r.URL.Path
+		if r.URL.Path == "/impostor" {
+			id = "https://victim.example/users/a"
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"id":        id,
+			"inbox":     id + "/inbox",
+			"publicKey": map[string]string{"id": srv.URL + r.URL.Path + "#main-key"},
+		})
+	}))
+	defer srv.Close()
+
+	if actor, err := fetchActor("https://blog.example", srv.URL+"/actor"); err != nil || actor.ID != srv.URL+"/actor" {
+		t.Errorf("an actor at its own id: %+v, %v", actor, err)
+	}
+	if actor, err := fetchActor("https://blog.example", srv.URL+"/impostor"); err == nil {
+		t.Errorf("an actor claiming another's id was accepted: %+v", actor)
+	}
+}
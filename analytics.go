@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Views: how often each post is read, counted here instead of by a tracking script ---
+//
+// Every GET /api/posts/{slug} of a published post adds a view to that post's row for the day
+// (UTC), off the request path. With MALT_ANALYTICS_UNIQUES=true, daily unique readers are
+// counted too: a reader is a hash of IP and User-Agent with a salt that lives in memory and
+// changes every day, so yesterday's hashes can't be matched to today's and are deleted
+// anyway. Bots that say so aren't counted. Behind the "analytics" feature flag.
+
+// ViewStats is the views section of GET /api/stats.
+type ViewStats struct {
+	Total   int         `json:"total"`             // All time
+	Today   int         `json:"today"`             // Since midnight UTC
+	Window  int         `json:"window"`            // In the last Days days, today included
+	Days    int         `json:"days"`              // ?days=, default 30
+	Uniques *int        `json:"uniques,omitempty"` // In the window; only with MALT_ANALYTICS_UNIQUES
+	Top     []PostViews `json:"top"`               // Most read in the window
+}
+
+// PostViews is one post's numbers in the window.
+type PostViews struct {
+	Slug    string `json:"slug"`
+	Title   string `json:"title"`
+	Views   int    `json:"views"`
+	Uniques *int   `json:"uniques,omitempty"`
+}
+
+var viewSalt struct {
+	sync.Mutex
+	day  string
+	salt string
+}
+
+// readerHash identifies a reader of slug for one day and nothing more.
+func readerHash(day, slug string, r *http.Request) string {
+	viewSalt.Lock()
+	if viewSalt.day != day {
+		viewSalt.day, viewSalt.salt = day, rand.Text()
+		execWrite("DELETE FROM view_readers WHERE day < ?", day)
+	}
+	salt := viewSalt.salt
+	viewSalt.Unlock()
+
+	sum := sha256.Sum256([]byte(salt + "|" + slug + "|" + clientIP(r) + "|" + r.UserAgent()))
+	return hex.EncodeToString(sum[:16])
+}
+
+func isBot(userAgent string) bool {
+	ua := strings.ToLower(userAgent)
+	for _, s := range []string{"bot", "crawl", "spider", "slurp", "preview", "curl/", "wget/"} {
+		if strings.Contains(ua, s) {
+			return true
+		}
+	}
+	return ua == ""
+}
+
+// recordView counts a read of slug. Like recordArms, a lost count isn't worth a slow page.
+func recordView(slug string, r *http.Request) {
+	if !featureEnabled("analytics") || r.Method != http.MethodGet || isBot(r.UserAgent()) {
+		return
+	}
+
+	day := time.Now().UTC().Format("2006-01-02")
+	reader := ""
+	if envBool("MALT_ANALYTICS_UNIQUES", false) {
+		reader = readerHash(day, slug, r)
+	}
+
+	go func() {
+		unique := 0
+		if reader != "" {
+			result, err := execWrite("INSERT INTO view_readers (day, hash) VALUES (?, ?) ON CONFLICT DO NOTHING", day, reader)
+			if err == nil {
+				if n, _ := result.RowsAffected(); n > 0 {
+					unique = 1
+				}
+			}
+		}
+		_, err := execWrite(`
+			INSERT INTO post_views (post_slug, day, views, uniques) VALUES (?, ?, 1, ?)
+			ON CONFLICT(post_slug, day) DO UPDATE SET views = post_views.views + 1, uniques = post_views.uniques + excluded.uniques`,
+			slug, day, unique)
+		if err != nil {
+			log.Printf("analytics: %s: %v", slug, err)
+		}
+	}()
+}
+
+// viewStats sums post_views for GET /api/stats.
+func viewStats(days int) (*ViewStats, error) {
+	now := time.Now().UTC()
+	today := now.Format("2006-01-02")
+	since := now.AddDate(0, 0, 1-days).Format("2006-01-02")
+
+	vs := &ViewStats{Days: days, Top: []PostViews{}}
+	var uniques int
+	err := db.QueryRow(`
+		SELECT COALESCE(SUM(views), 0),
+			COALESCE(SUM(CASE WHEN day = ? THEN views END), 0),
+			COALESCE(SUM(CASE WHEN day >= ? THEN views END), 0),
+			COALESCE(SUM(CASE WHEN day >= ? THEN uniques END), 0)
+		FROM post_views`, today, since, since).Scan(&vs.Total, &vs.Today, &vs.Window, &uniques)
+	if err != nil {
+		return nil, err
+	}
+	showUniques := envBool("MALT_ANALYTICS_UNIQUES", false)
+	if showUniques {
+		vs.Uniques = &uniques
+	}
+
+	rows, err := db.Query(`
+		SELECT v.post_slug, COALESCE(p.title, ''), SUM(v.views), SUM(v.uniques)
+		FROM post_views v LEFT JOIN posts p ON p.slug = v.post_slug
+		WHERE v.day >= ?
+		GROUP BY v.post_slug, p.title ORDER BY 3 DESC, 1 LIMIT 10`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pv PostViews
+		var u int
+		if rows.Scan(&pv.Slug, &pv.Title, &pv.Views, &u) != nil {
+			continue
+		}
+		if showUniques {
+			pv.Uniques = &u
+		}
+		vs.Top = append(vs.Top, pv)
+	}
+	return vs, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// --- Announcements: followers and subscribers hear about a post when it goes live ---
+//
+// A post published now is announced (a Create to every follower, and the newsletter) right
+// away; a scheduled one waits in announcements until runAnnouncements finds its time has
+// come. Either way it's announced once: announced_at is the claim, so two writes, or two
+// instances, can't both send it. A post that was already long live when it was published
+// (an import, a back-dated post) isn't news, and isn't announced. Taking an announced post
+// back to a draft, into the future or to the trash sends followers a Delete; the newsletter,
+// once sent, stays sent, and isn't sent again when the post comes back.
+
+// announceWindow is how far in the past a post can be dated and still be news.
+const announceWindow = 48 * time.Hour
+
+// announced says whether followers have been told about slug.
+func announced(slug string) bool {
+	var ok bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM announcements WHERE post_slug = ? AND announced_at IS NOT NULL)", slug).Scan(&ok)
+	return ok
+}
+
+// syncAnnouncement brings followers up to date with p after a write. base is the site URL
+// a scheduled post will be announced under.
+func syncAnnouncement(base string, p Post) {
+	was := announced(p.Slug)
+	switch {
+	case isLive(p) && was:
+		federate(base, "Update", p)
+	case isLive(p):
+		if time.Since(p.PublishedAt) <= announceWindow {
+			announce(base, p)
+		} else {
+			// Once scheduled, since moved into the past: runAnnouncements mustn't find it
+			execWrite("DELETE FROM announcements WHERE post_slug = ? AND announced_at IS NULL", p.Slug)
+		}
+	case p.Status == "published": // Scheduled
+		if was {
+			federate(base, "Delete", p)
+		}
+		_, err := execWrite(`
+			INSERT INTO announcements (post_slug, base) VALUES (?, ?)
+			ON CONFLICT(post_slug) DO UPDATE SET base = excluded.base, announced_at = NULL`, p.Slug, base)
+		if err != nil {
+			log.Printf("announce %s: %v", p.Slug, err)
+		}
+	default:
+		unannounce(base, p.Slug)
+	}
+}
+
+// announce claims p's announcement and sends it, unless it's been sent already. It reports
+// whether this call sent it.
+func announce(base string, p Post) bool {
+	result, err := execWrite(`
+		INSERT INTO announcements (post_slug, base, announced_at) VALUES (?, ?, ?)
+		ON CONFLICT(post_slug) DO UPDATE SET base = excluded.base, announced_at = excluded.announced_at
+		WHERE announcements.announced_at IS NULL`, p.Slug, base, time.Now().UTC())
+	if err != nil {
+		log.Printf("announce %s: %v", p.Slug, err)
+		return false
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false
+	}
+	federate(base, "Create", p)
+	go sendNewsletter(base, p)
+	return true
+}
+
+// unannounce takes slug's announcement back, or drops it if it was still waiting.
+func unannounce(base, slug string) {
+	result, err := execWrite("DELETE FROM announcements WHERE post_slug = ? AND announced_at IS NOT NULL", slug)
+	if err != nil {
+		log.Printf("announce %s: %v", slug, err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		federate(base, "Delete", Post{Slug: slug})
+	}
+	execWrite("DELETE FROM announcements WHERE post_slug = ?", slug)
+}
+
+// runAnnouncements announces scheduled posts once their time has come, checking every minute.
+func runAnnouncements() {
+	for ; ; time.Sleep(time.Minute) {
+		announceDue()
+	}
+}
+
+// announceDue announces the scheduled posts whose time has come, and fires their
+// post.published: until now they weren't live.
+func announceDue() {
+	rows, err := db.Query(`
+		SELECT a.post_slug, a.base FROM announcements a JOIN posts p ON p.slug = a.post_slug
+		WHERE a.announced_at IS NULL AND p.status = 'published' AND p.deleted_at IS NULL AND p.published_at <= ?`,
+		time.Now().UTC())
+	if err != nil {
+		log.Printf("announce: %v", err)
+		return
+	}
+	due := map[string]string{}
+	for rows.Next() {
+		var slug, base string
+		if rows.Scan(&slug, &base) == nil {
+			due[slug] = base
+		}
+	}
+	rows.Close()
+
+	for slug, base := range due {
+		if p, err := store.GetPost(slug); err == nil && isLive(p) && announce(base, p) {
+			queueWebhooks(base, eventPublished, p)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// --- Archive: published posts by year and month, counted by the database ---
+//
+// Months are the post's own calendar month, as published_at was stored: an imported post
+// dated 23:30 in New York stays in the month its author saw. Postgres keeps the moment but
+// not the offset, so there months are UTC's. Scheduled posts aren't listed until they're out.
+
+// ArchivePost is a post as an archive page lists it.
+type ArchivePost struct {
+	Slug        string    `json:"slug"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Type        string    `json:"type"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// ArchiveMonth is one month that has posts.
+type ArchiveMonth struct {
+	Year  int           `json:"year"`
+	Month int           `json:"month"`
+	Count int           `json:"count"`
+	Posts []ArchivePost `json:"posts"`
+}
+
+// ArchiveYear is one year that has posts, newest month first.
+type ArchiveYear struct {
+	Year   int             `json:"year"`
+	Count  int             `json:"count"`
+	Months []*ArchiveMonth `json:"months"`
+}
+
+// Archive is the whole archive, or the year or month asked for.
+type Archive struct {
+	Total int            `json:"total"`
+	Years []*ArchiveYear `json:"years"`
+}
+
+// GET /api/archive - Every published post, grouped by year and month, newest first
+// GET /api/archive/{year} and /api/archive/{year}/{month} - The same, for one year or month
+func handleArchive(w http.ResponseWriter, r *http.Request) {
+	// "2024" or "2024-05": the prefix of published_at the posts must have
+	prefix := ""
+	if y := r.PathValue("year"); y != "" {
+		year, err := strconv.Atoi(y)
+		if err != nil || len(y) != 4 {
+			httpError(w, r, "year must look like 2024", 400)
+			return
+		}
+		prefix = fmt.Sprintf("%04d", year)
+		if m := r.PathValue("month"); m != "" {
+			month, err := strconv.Atoi(m)
+			if err != nil || month < 1 || month > 12 {
+				httpError(w, r, "month must be 01 to 12", 400)
+				return
+			}
+			prefix += fmt.Sprintf("-%02d", month)
+		}
+	}
+
+	if etag, modified, err := postsETag("archive:" + prefix); err == nil && notModified(w, r, etag, modified) {
+		return
+	}
+
+	// published_at as text starts YYYY-MM-DD (SQLite stores it so), so the grouping is a substr away
+	now := time.Now().UTC()
+	where := " WHERE status = 'published' AND deleted_at IS NULL AND published_at <= ? AND CAST(published_at AS TEXT) LIKE ? || '%'"
+	rows, err := db.Query(`
+		SELECT CAST(substr(CAST(published_at AS TEXT), 1, 4) AS INTEGER), CAST(substr(CAST(published_at AS TEXT), 6, 2) AS INTEGER), COUNT(*)
+		FROM posts`+where+`
+		GROUP BY 1, 2 ORDER BY 1 DESC, 2 DESC`, now, prefix)
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+
+	archive := Archive{Years: []*ArchiveYear{}}
+	months := map[[2]int]*ArchiveMonth{}
+	for rows.Next() {
+		m := &ArchiveMonth{Posts: []ArchivePost{}}
+		if err := rows.Scan(&m.Year, &m.Month, &m.Count); err != nil {
+			continue
+		}
+		if n := len(archive.Years); n == 0 || archive.Years[n-1].Year != m.Year {
+			archive.Years = append(archive.Years, &ArchiveYear{Year: m.Year})
+		}
+		y := archive.Years[len(archive.Years)-1]
+		y.Months = append(y.Months, m)
+		y.Count += m.Count
+		archive.Total += m.Count
+		months[[2]int{m.Year, m.Month}] = m
+	}
+	rows.Close()
+
+	if prefix != "" && archive.Total == 0 {
+		httpError(w, r, "No posts from then", 404)
+		return
+	}
+
+	rows, err = db.Query(`
+		SELECT CAST(substr(CAST(published_at AS TEXT), 1, 4) AS INTEGER), CAST(substr(CAST(published_at AS TEXT), 6, 2) AS INTEGER),
+			slug, title, description, type, published_at
+		FROM posts`+where+`
+		ORDER BY published_at DESC, slug`, now, prefix)
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var year, month int
+		var p ArchivePost
+		if err := rows.Scan(&year, &month, &p.Slug, &p.Title, &p.Description, &p.Type, &p.PublishedAt); err != nil {
+			continue
+		}
+		if m := months[[2]int{year, month}]; m != nil {
+			m.Posts = append(m.Posts, p)
+		}
+	}
+
+	jsonResponse(w, archive)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// --- AsciiDoc: the subset a blog post needs ---
+//
+// Section titles, paragraphs, lists, delimited blocks (---- .... ____ ==== **** ++++),
+// [source,lang] attributes, admonitions, images, links, and *bold* _italic_ `mono` #mark#.
+
+var (
+	adocHeading    = regexp.MustCompile(`^(=+)\s+(.*)$`)
+	adocAttribute  = regexp.MustCompile(`^:[\w-]+!?:`)
+	adocBlockAttr  = regexp.MustCompile(`^\[([^\]]*)\]$`)
+	adocListItem   = regexp.MustCompile(`^(\*+|-|\.+|\d+\.)\s+(.*)$`)
+	adocImage      = regexp.MustCompile(`^image::([^\[]+)\[([^\]]*)\]$`)
+	adocAdmonition = regexp.MustCompile(`^(NOTE|TIP|IMPORTANT|WARNING|CAUTION):\s+(.*)$`)
+	adocDelimiter  = regexp.MustCompile(`^(-{4,}|\.{4,}|_{4,}|={4,}|\*{4,}|\+{4,}|/{4,})$`)
+
+	adocCode        = regexp.MustCompile("(^|[\\s(\\[\"'>])`([^`]+)`")
+	adocPassthrough = regexp.MustCompile(`(^|[\s(\[>])\+([^+\s](?:[^+]*[^+\s])?)\+`)
+	adocMacro       = regexp.MustCompile(`(link:|image:|mailto:)?((?:https?://)?[^\s\[\]]+)\[([^\]]*)\]`)
+	adocBareURL     = regexp.MustCompile(`https?://[^\s\[\]\x00]+`)
+
+	adocBold   = emphasis("*", "strong")
+	adocItalic = emphasis("_", "em")
+	adocMark   = emphasis("#", "mark")
+)
+
+func renderAsciiDoc(src string) string {
+	var b strings.Builder
+	var para paragraph
+	var items []listItem
+	var style string // from the last [source,go] / [quote] style line
+
+	flushList := func() {
+		if len(items) > 0 {
+			b.WriteString(renderList(items) + "\n")
+		}
+		items = nil
+	}
+	flushAll := func() {
+		para.flush(&b, adocInline)
+		flushList()
+	}
+
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t")
+		trimmed := strings.TrimSpace(line)
+
+		if trimmed == "" {
+			flushAll()
+			continue
+		}
+
+		if adocDelimiter.MatchString(trimmed) {
+			flushAll()
+			var body []string
+			for i++; i < len(lines) && strings.TrimSpace(lines[i]) != trimmed; i++ {
+				body = append(body, lines[i])
+			}
+			inner := strings.Join(body, "\n")
+
+			switch trimmed[0] {
+			case '-':
+				lang := ""
+				if kind, arg, ok := strings.Cut(style, ","); ok && strings.TrimSpace(kind) == "source" {
+					lang, _, _ = strings.Cut(strings.TrimSpace(arg), ",")
+				}
+				b.WriteString(codeBlock(lang, body))
+			case '.':
+				b.WriteString(codeBlock("", body))
+			case '_':
+				b.WriteString("<blockquote>\n" + renderAsciiDoc(inner) + "</blockquote>\n")
+			case '=':
+				b.WriteString("<div class=\"example\">\n" + renderAsciiDoc(inner) + "</div>\n")
+			case '*':
+				b.WriteString("<aside>\n" + renderAsciiDoc(inner) + "</aside>\n")
+			case '+':
+				// Passthrough: raw HTML, trusted the same way HTML posts are
+				b.WriteString(inner + "\n")
+			case '/':
+				// Comment block
+			}
+			style = ""
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(trimmed, "//"), adocAttribute.MatchString(trimmed):
+			continue
+		case trimmed == "+" && len(items) > 0:
+			continue // List continuation marker; the next line joins the item
+		}
+
+		if m := adocBlockAttr.FindStringSubmatch(trimmed); m != nil {
+			flushAll()
+			style = m[1]
+			continue
+		}
+
+		if m := adocHeading.FindStringSubmatch(line); m != nil {
+			flushAll()
+			level := min(len(m[1]), 6)
+			tag := "h" + string(rune('0'+level))
+			b.WriteString("<" + tag + ">" + adocInline(m[2]) + "</" + tag + ">\n")
+			continue
+		}
+
+		if trimmed == "'''" {
+			flushAll()
+			b.WriteString("<hr>\n")
+			continue
+		}
+
+		if m := adocImage.FindStringSubmatch(trimmed); m != nil {
+			flushAll()
+			b.WriteString(`<figure><img src="` + html.EscapeString(safeHref(m[1])) + `" alt="` + html.EscapeString(adocAlt(m[2])) + `"></figure>` + "\n")
+			continue
+		}
+
+		if m := adocAdmonition.FindStringSubmatch(trimmed); m != nil {
+			flushAll()
+			kind := strings.ToLower(m[1])
+			b.WriteString(`<div class="admonition ` + kind + `"><strong>` + m[1][:1] + strings.ToLower(m[1][1:]) + ":</strong> " + adocInline(m[2]) + "</div>\n")
+			continue
+		}
+
+		if m := adocListItem.FindStringSubmatch(trimmed); m != nil {
+			para.flush(&b, adocInline)
+			marker := m[1]
+			ordered := marker[0] == '.' || (marker[0] >= '0' && marker[0] <= '9')
+			level := len(marker)
+			if marker == "-" || (marker[0] >= '0' && marker[0] <= '9') {
+				level = 1
+			}
+			items = append(items, listItem{Level: level, Ordered: ordered, HTML: adocInline(m[2])})
+			continue
+		}
+
+		// Text directly under a list item (no blank line) belongs to it
+		if len(items) > 0 {
+			items[len(items)-1].HTML += " " + adocInline(trimmed)
+			continue
+		}
+
+		para = append(para, trimmed)
+	}
+	flushAll()
+
+	return b.String()
+}
+
+// adocAlt takes the alt text out of an image macro's attribute list ("alt,width,height").
+func adocAlt(attrs string) string {
+	alt, _, _ := strings.Cut(attrs, ",")
+	return strings.Trim(alt, `"`)
+}
+
+func adocInline(s string) string {
+	var shelf inlineShelf
+	s = html.EscapeString(unshelvable(s))
+
+	s = adocCode.ReplaceAllStringFunc(s, func(m string) string {
+		sub := adocCode.FindStringSubmatch(m)
+		return sub[1] + shelf.park("<code>"+sub[2]+"</code>")
+	})
+	s = adocPassthrough.ReplaceAllStringFunc(s, func(m string) string {
+		sub := adocPassthrough.FindStringSubmatch(m)
+		return sub[1] + shelf.park(sub[2])
+	})
+
+	s = adocMacro.ReplaceAllStringFunc(s, func(m string) string {
+		sub := adocMacro.FindStringSubmatch(m)
+		prefix, target, text := sub[1], sub[2], sub[3]
+		if prefix == "" && !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+			return m // Just text in brackets
+		}
+		switch prefix {
+		case "image:":
+			return shelf.park(`<img src="` + safeHref(target) + `" alt="` + adocAlt(text) + `">`)
+		case "mailto:":
+			target = "mailto:" + target
+		}
+		if text == "" {
+			text = strings.TrimPrefix(target, "mailto:")
+		}
+		return shelf.park(`<a href="` + safeHref(target) + `">` + text + `</a>`)
+	})
+
+	s = adocBareURL.ReplaceAllStringFunc(s, func(u string) string {
+		trail := ""
+		for strings.ContainsAny(u[len(u)-1:], ".,;:!?)") {
+			u, trail = u[:len(u)-1], u[len(u)-1:]+trail
+		}
+		return shelf.park(`<a href="`+u+`">`+u+`</a>`) + trail
+	})
+
+	s = adocMark(adocItalic(adocBold(s)))
+	return shelf.restore(s)
+}
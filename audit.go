@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// --- Audit log: a record of admin actions that weaken a default ---
+
+// AuditEntry is one recorded action.
+type AuditEntry struct {
+	ID      int64     `json:"id"`
+	At      time.Time `json:"at"`
+	Action  string    `json:"action"`  // e.g. "raw_html.enable"
+	Subject string    `json:"subject"` // What it was done to, usually a post slug
+	Detail  string    `json:"detail,omitempty"`
+	IP      string    `json:"ip"`
+}
+
+// audit records an action. A failed write is logged but never fails the request that caused it.
+func audit(r *http.Request, action, subject, detail string) {
+	_, err := execWrite("INSERT INTO audit_log (at, action, subject, detail, ip) VALUES (?, ?, ?, ?, ?)",
+		time.Now().UTC(), action, subject, detail, clientIP(r))
+	if err != nil {
+		log.Printf("audit: %s %s: %v", action, subject, err)
+	}
+}
+
+// GET /api/audit - Most recent entries first; ?limit= (default 100)
+func handleListAudit(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	limit := 100
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= 1000 {
+		limit = n
+	}
+
+	rows, err := db.Query("SELECT id, at, action, subject, detail, ip FROM audit_log ORDER BY id DESC LIMIT ?", limit)
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.At, &e.Action, &e.Subject, &e.Detail, &e.IP); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	jsonResponse(w, entries)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Authors: who wrote a post, for blogs with more than one voice ---
+//
+// A post names its author by author_id when it's published; reads return the whole author
+// under "author". Posts without one are the blog's, as before. Authors are public, like the
+// bylines they end up in; changing them takes the admin key. Deleting an author leaves their
+// posts in place, unattributed.
+
+// Author is a person posts can be credited to.
+type Author struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	Avatar string `json:"avatar,omitempty"` // Image URL, absolute or on this site (/media/...)
+	Bio    string `json:"bio,omitempty"`
+	URL    string `json:"url,omitempty"` // Their own site
+}
+
+func listAuthors() ([]Author, error) {
+	rows, err := db.Query("SELECT id, name, avatar, bio, url FROM authors ORDER BY name, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	authors := []Author{}
+	for rows.Next() {
+		var a Author
+		if err := rows.Scan(&a.ID, &a.Name, &a.Avatar, &a.Bio, &a.URL); err != nil {
+			continue
+		}
+		authors = append(authors, a)
+	}
+	return authors, rows.Err()
+}
+
+func getAuthor(id int64) (Author, error) {
+	var a Author
+	err := db.QueryRow("SELECT id, name, avatar, bio, url FROM authors WHERE id = ?", id).
+		Scan(&a.ID, &a.Name, &a.Avatar, &a.Bio, &a.URL)
+	return a, err
+}
+
+// attachAuthors fills in Author on posts that have an AuthorID. There are only ever a few
+// authors, so they're read in one go rather than joined into every post query.
+func attachAuthors(posts []Post) error {
+	if !slices.ContainsFunc(posts, func(p Post) bool { return p.AuthorID != 0 }) {
+		return nil
+	}
+	authors, err := listAuthors()
+	if err != nil {
+		return err
+	}
+	byID := map[int64]*Author{}
+	for i := range authors {
+		byID[authors[i].ID] = &authors[i]
+	}
+	for i := range posts {
+		posts[i].Author = byID[posts[i].AuthorID]
+	}
+	return nil
+}
+
+// authorIDByName finds an author for front matter, which names them rather than numbering
+// them: by name (any case), or by ID. 0 when there's no such author.
+func authorIDByName(name string) int64 {
+	name = strings.TrimSpace(name)
+	var id int64
+	if n, err := strconv.ParseInt(name, 10, 64); err == nil {
+		db.QueryRow("SELECT id FROM authors WHERE id = ?", n).Scan(&id)
+		return id
+	}
+	db.QueryRow("SELECT id FROM authors WHERE lower(name) = lower(?) ORDER BY id LIMIT 1", name).Scan(&id)
+	return id
+}
+
+// decodeAuthor parses and validates an Author body.
+func decodeAuthor(r *http.Request) (Author, string) {
+	var a Author
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		return a, "Bad JSON"
+	}
+
+	if a.Name = strings.TrimSpace(a.Name); a.Name == "" {
+		return a, "name is required"
+	}
+	a.Bio = strings.TrimSpace(a.Bio)
+	if a.URL != "" {
+		u, ok := httpURL(a.URL)
+		if !ok {
+			return a, "url must be an absolute http(s) link"
+		}
+		a.URL = u
+	}
+	if a.Avatar = strings.TrimSpace(a.Avatar); a.Avatar != "" && !strings.HasPrefix(a.Avatar, "/") {
+		u, ok := httpURL(a.Avatar)
+		if !ok {
+			return a, "avatar must be an http(s) link or a path on this site"
+		}
+		a.Avatar = u
+	}
+	return a, ""
+}
+
+// GET /api/authors - Everyone posts can be credited to
+func handleListAuthors(w http.ResponseWriter, r *http.Request) {
+	authors, err := listAuthors()
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+
+	jsonResponse(w, authors)
+}
+
+// GET /api/authors/{id} - One author
+func handleGetAuthor(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	a, err := getAuthor(id)
+	if err == sql.ErrNoRows {
+		httpError(w, r, "Author not found", 404)
+		return
+	}
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+
+	jsonResponse(w, a)
+}
+
+// GET /api/authors/{id}/posts - An author's published posts, newest first
+func handleListAuthorPosts(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	author, err := getAuthor(id)
+	if err == sql.ErrNoRows {
+		httpError(w, r, "Author not found", 404)
+		return
+	}
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT slug, title, description, published_at, updated_at, type, link_url FROM posts
+		WHERE author_id = ? AND status = 'published' AND deleted_at IS NULL AND published_at <= ?
+		ORDER BY published_at DESC, slug`, id, time.Now().UTC())
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	posts := []Post{}
+	for rows.Next() {
+		p := Post{AuthorID: id, Author: &author}
+		if err := rows.Scan(&p.Slug, &p.Title, &p.Description, &p.PublishedAt, &p.UpdatedAt, &p.Type, &p.LinkURL); err != nil {
+			continue
+		}
+		posts = append(posts, p)
+	}
+
+	jsonResponse(w, posts)
+}
+
+// POST /api/authors - Add an author: {"name": "...", "avatar": "...", "bio": "...", "url": "..."}
+func handleCreateAuthor(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	a, problem := decodeAuthor(r)
+	if problem != "" {
+		httpError(w, r, problem, 400)
+		return
+	}
+
+	var err error
+	a.ID, err = insertID("INSERT INTO authors (name, avatar, bio, url, created_at) VALUES (?, ?, ?, ?, ?)",
+		a.Name, a.Avatar, a.Bio, a.URL, time.Now().UTC())
+	if err != nil {
+		httpError(w, r, "Failed to save: "+err.Error(), 500)
+		return
+	}
+
+	jsonResponse(w, a)
+}
+
+// PUT /api/authors/{id} - Replace an author's details
+func handleUpdateAuthor(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httpError(w, r, "Author not found", 404)
+		return
+	}
+
+	a, problem := decodeAuthor(r)
+	if problem != "" {
+		httpError(w, r, problem, 400)
+		return
+	}
+	a.ID = id
+
+	result, err := execWrite("UPDATE authors SET name = ?, avatar = ?, bio = ?, url = ? WHERE id = ?", a.Name, a.Avatar, a.Bio, a.URL, a.ID)
+	if err != nil {
+		httpError(w, r, "Database error: "+err.Error(), 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		httpError(w, r, "Author not found", 404)
+		return
+	}
+
+	// Bylines are part of every cached page and feed of theirs
+	execWrite("UPDATE posts SET updated_at = ? WHERE author_id = ?", time.Now().UTC(), a.ID)
+
+	jsonResponse(w, a)
+}
+
+// DELETE /api/authors/{id} - Remove an author; their posts stay, without a byline
+func handleDeleteAuthor(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	// Posts let go of the author first: author_id is a foreign key
+	found := false
+	err := withTx(r.Context(), func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE posts SET author_id = NULL, updated_at = ? WHERE author_id = ?", time.Now().UTC(), r.PathValue("id"))
+		if err != nil {
+			return err
+		}
+		result, err := tx.Exec("DELETE FROM authors WHERE id = ?", r.PathValue("id"))
+		if err != nil {
+			return err
+		}
+		n, _ := result.RowsAffected()
+		found = n > 0
+		return nil
+	})
+	if err != nil {
+		httpError(w, r, "Database error: "+err.Error(), 500)
+		return
+	}
+	if !found {
+		httpError(w, r, "Author not found", 404)
+		return
+	}
+
+	jsonResponse(w, map[string]string{"status": "deleted", "id": r.PathValue("id")})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// --- HTTPS via Let's Encrypt, for running straight on a VPS without a proxy in front ---
+//
+// MALT_DOMAIN=blog.example.com (comma-separate several) switches the listeners to :443 with
+// certificates fetched and renewed by autocert, and :80 answering ACME challenges and
+// redirecting everything else to https. Certificates are kept in MALT_CERT_DIR (default
+// certs/) so restarts don't hit Let's Encrypt's rate limits. MALT_ACME_EMAIL gets expiry
+// notices. MALT_HTTP2 and MALT_HTTP3 apply as they do with MALT_TLS_CERT.
+
+func serveAutocert(site http.Handler, domains string) error {
+	var hosts []string
+	for _, d := range strings.Split(domains, ",") {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			hosts = append(hosts, d)
+		}
+	}
+
+	dir := os.Getenv("MALT_CERT_DIR")
+	if dir == "" {
+		dir = "certs"
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Cache:      autocert.DirCache(dir),
+		Email:      os.Getenv("MALT_ACME_EMAIL"),
+	}
+
+	// :80 is only for the challenge and the redirect
+	redirect := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if !slices.Contains(hosts, strings.ToLower(host)) {
+			host = hosts[0]
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+	go func() {
+		log.Println("Malt (ACME, redirect) running on :80")
+		log.Fatal(newServer(":80", m.HTTPHandler(redirect)).ListenAndServe())
+	}()
+
+	handler := site
+	if envBool("MALT_HTTP3", false) {
+		h3 := &http3.Server{Addr: ":443", Handler: site, TLSConfig: http3.ConfigureTLSConfig(m.TLSConfig())}
+		handler = altSvc(h3, site)
+
+		go func() {
+			log.Println("Malt (QUIC) running on udp :443")
+			log.Fatal(h3.ListenAndServe())
+		}()
+	}
+
+	secure := newServer(":443", handler)
+	secure.TLSConfig = m.TLSConfig()
+	secure.TLSConfig.MinVersion = tls.VersionTLS12
+	secure.Protocols = new(http.Protocols)
+	secure.Protocols.SetHTTP1(true)
+	secure.Protocols.SetHTTP2(envBool("MALT_HTTP2", true))
+	if !secure.Protocols.HTTP2() {
+		secure.TLSConfig.NextProtos = slices.DeleteFunc(secure.TLSConfig.NextProtos, func(p string) bool { return p == "h2" })
+	}
+
+	log.Printf("Malt (TLS) running on :443 for %s", strings.Join(hosts, ", "))
+	return secure.ListenAndServeTLS("", "")
+}
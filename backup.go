@@ -0,0 +1,657 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// --- Backup and restore ---
+//
+// A backup is a consistent copy of the whole database (VACUUM INTO), taken without stopping
+// writers, and age-encrypted when recipients are configured. Restore takes such a file, or
+// any older malt.db, and imports its posts (with their tags, series, authors, comments and
+// revisions) and media into the running database. Encrypted backups have to be decrypted with `age -d` first: the server only holds
+// public keys. On Postgres, backups are pg_dump's job; restore works the same, and is how a
+// site moves there from malt.db.
+
+const maxRestore = 1 << 30
+
+// allowBulk gives a request cfg.BulkTimeout from now to finish reading and writing, in place
+// of the server's timeouts, which are sized for a post and would cut off a large body.
+func allowBulk(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(cfg.BulkTimeout)
+	rc.SetReadDeadline(deadline)
+	rc.SetWriteDeadline(deadline)
+}
+
+// GET /api/backup - The database as of now, as a download
+func handleBackup(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+	if onPostgres {
+		httpError(w, r, "This site is on Postgres: back it up with pg_dump", 501)
+		return
+	}
+	allowBulk(w)
+
+	dir, err := os.MkdirTemp("", "malt-backup-")
+	if err != nil {
+		httpError(w, r, "Backup failed", 500)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "malt.db")
+	if _, err := db.Exec("VACUUM INTO ?", path); err != nil {
+		httpError(w, r, "Backup failed: "+err.Error(), 500)
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		httpError(w, r, "Backup failed", 500)
+		return
+	}
+	defer f.Close()
+
+	name := "malt-" + time.Now().UTC().Format("20060102-150405") + ".db"
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	if !encryptionEnabled() {
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		io.Copy(w, f)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.age"`)
+	enc, err := encryptTo(w)
+	if err != nil {
+		httpError(w, r, "Encryption failed", 500)
+		return
+	}
+	io.Copy(enc, f)
+	enc.Close()
+}
+
+// RestoreResult says what a restore did with each post in the backup.
+type RestoreResult struct {
+	Imported    []string          `json:"imported"`
+	Overwritten []string          `json:"overwritten"`
+	Skipped     []string          `json:"skipped"`
+	Renamed     map[string]string `json:"renamed"`      // Backup slug -> slug it was imported as
+	Series      int               `json:"series"`       // New series; posts in one already here join it
+	Authors     int               `json:"authors"`      // New authors; one already here by name gets the byline
+	Comments    int               `json:"comments"`     // Comments copied onto the restored posts
+	Media       int               `json:"media"`        // New media files; ones already here are left alone
+	NotRestored []string          `json:"not_restored"` // Tables in the backup whose rows a restore leaves out
+}
+
+// unrestoredTables hold the site's settings and its readers rather than its writing: a
+// restore leaves them out, and says so when the backup has any.
+var unrestoredTables = []string{"links", "feeds", "subscribers", "activitypub_followers", "webhooks", "api_keys"}
+
+// POST /api/restore?on_conflict=skip|overwrite|rename - Import a backup sent as the request body.
+// When a slug exists in both, skip (the default) keeps ours, overwrite takes the backup's,
+// and rename imports the backup's under a fresh slug.
+func handleRestore(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+	allowBulk(w)
+
+	mode := r.URL.Query().Get("on_conflict")
+	if mode == "" {
+		mode = "skip"
+	}
+	if mode != "skip" && mode != "overwrite" && mode != "rename" {
+		httpError(w, r, "on_conflict must be skip, overwrite or rename", 400)
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "malt-restore-")
+	if err != nil {
+		httpError(w, r, "Restore failed", 500)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "backup.db")
+	f, err := os.Create(path)
+	if err != nil {
+		httpError(w, r, "Restore failed", 500)
+		return
+	}
+	_, err = io.Copy(f, http.MaxBytesReader(w, r.Body, maxRestore))
+	f.Close()
+	if err != nil {
+		httpError(w, r, "Could not read body: "+err.Error(), 400)
+		return
+	}
+
+	header := make([]byte, 32)
+	if f, err := os.Open(path); err == nil {
+		io.ReadFull(f, header)
+		f.Close()
+	}
+	switch {
+	case bytes.HasPrefix(header, []byte("age-encryption.org/")):
+		httpError(w, r, "This backup is encrypted; decrypt it with age -d first", 400)
+		return
+	case !bytes.HasPrefix(header, []byte("SQLite format 3\x00")):
+		httpError(w, r, "Not a SQLite database", 400)
+		return
+	}
+
+	result, err := restoreFrom(r.Context(), path, mode)
+	if err != nil {
+		httpError(w, r, "Restore failed: "+err.Error(), 500)
+		return
+	}
+	log.Printf("restore: %d imported, %d overwritten, %d renamed, %d skipped, %d media",
+		len(result.Imported), len(result.Overwritten), len(result.Renamed), len(result.Skipped), result.Media)
+
+	jsonResponse(w, result)
+}
+
+// restoreFrom copies posts, with their tags, authors, comments and revisions, and media out
+// of the database file at path. It opens
+// the file as a database of its own and writes through ours, whichever kind that is.
+// Backups from older versions lack newer columns; those keep their defaults.
+func restoreFrom(ctx context.Context, path, mode string) (RestoreResult, error) {
+	result := RestoreResult{Imported: []string{}, Overwritten: []string{}, Skipped: []string{}, Renamed: map[string]string{}, NotRestored: []string{}}
+
+	backup, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return result, err
+	}
+	defer backup.Close()
+
+	postCols, err := sharedColumns(ctx, backup, "posts")
+	if err != nil {
+		return result, err
+	}
+	if !slices.Contains(postCols, "slug") {
+		return result, errNotMaltBackup
+	}
+	// Authors and series are restored first, and get new IDs here: a post's series_id is
+	// looked up again by the series' slug, and its author_id by the author's name.
+	others := slices.DeleteFunc(slices.Clone(postCols), func(c string) bool {
+		return c == "slug" || c == "author_id" || c == "series_id"
+	})
+	bools, err := booleanColumns(ctx, "posts")
+	if err != nil {
+		return result, err
+	}
+	seriesSlugs := map[int64]string{} // By the backup's series IDs
+	if slices.Contains(postCols, "series_id") && tableExists(ctx, backup, "series") {
+		seriesCols, err := sharedColumns(ctx, backup, "series")
+		if err != nil {
+			return result, err
+		}
+		// A series already here with the same slug is the one its posts go back into
+		seriesCols = slices.DeleteFunc(seriesCols, func(c string) bool { return c == "id" })
+		if result.Series, err = copyRows(ctx, backup, "series", seriesCols, "ON CONFLICT(slug) DO NOTHING"); err != nil {
+			return result, err
+		}
+		rows, err := backup.QueryContext(ctx, "SELECT id, slug FROM series")
+		if err != nil {
+			return result, err
+		}
+		for rows.Next() {
+			var id int64
+			var slug string
+			if rows.Scan(&id, &slug) == nil {
+				seriesSlugs[id] = slug
+			}
+		}
+		rows.Close()
+	}
+
+	authorIDs := map[int64]int64{} // Backup's author IDs -> ours
+	if slices.Contains(postCols, "author_id") && tableExists(ctx, backup, "authors") {
+		if result.Authors, err = restoreAuthors(ctx, backup, authorIDs); err != nil {
+			return result, err
+		}
+	}
+
+	slugs, err := queryStrings(ctx, backup, "SELECT slug FROM posts")
+	if err != nil {
+		return result, err
+	}
+	hasTags := tableExists(ctx, backup, "post_tags") && tableExists(ctx, backup, "tags")
+	comments, err := newPostRows(ctx, backup, "comments", "id")
+	if err != nil {
+		return result, err
+	}
+	revisions, err := newPostRows(ctx, backup, "post_revisions", "rev")
+	if err != nil {
+		return result, err
+	}
+
+	cols := append([]string{"slug", "series_id", "author_id"}, others...)
+	values := append([]string{"?", "(SELECT id FROM series WHERE slug = ?)", "?"}, slices.Repeat([]string{"?"}, len(others))...)
+	insert := "INSERT INTO posts (" + joinColumns(cols) + ") VALUES (" + strings.Join(values, ", ") + ")"
+	read := "SELECT " + joinColumns(others)
+	if len(seriesSlugs) > 0 {
+		read += ", series_id"
+	}
+	if len(authorIDs) > 0 {
+		read += ", author_id"
+	}
+	read += " FROM posts WHERE slug = ?"
+
+	for _, slug := range slugs {
+		row := make([]any, len(others), len(others)+2)
+		var seriesID, backupAuthor sql.NullInt64
+		dest := scanTargets(row)
+		if len(seriesSlugs) > 0 {
+			dest = append(dest, &seriesID)
+		}
+		if len(authorIDs) > 0 {
+			dest = append(dest, &backupAuthor)
+		}
+		if err := backup.QueryRowContext(ctx, read, slug).Scan(dest...); err != nil {
+			return result, err
+		}
+		toBooleans(others, row, bools)
+		var authorID any
+		if id, ok := authorIDs[backupAuthor.Int64]; ok && backupAuthor.Valid {
+			authorID = id
+		}
+
+		var tags []string
+		if hasTags {
+			if tags, err = queryStrings(ctx, backup, `
+				SELECT t.name FROM post_tags pt JOIN tags t ON t.id = pt.tag_id WHERE pt.post_slug = ?`, slug); err != nil {
+				return result, err
+			}
+		}
+
+		postComments, err := comments.read(ctx, slug)
+		if err != nil {
+			return result, err
+		}
+		postRevisions, err := revisions.read(ctx, slug)
+		if err != nil {
+			return result, err
+		}
+
+		target := slug
+		var exists bool
+		db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM posts WHERE slug = ?)", slug).Scan(&exists)
+		if exists {
+			switch mode {
+			case "skip":
+				result.Skipped = append(result.Skipped, slug)
+				continue
+			case "rename":
+				target = uniqueSlug(slug)
+			}
+		}
+
+		copied := 0
+		err = withTx(ctx, func(tx *sql.Tx) error {
+			if exists && mode == "overwrite" {
+				// Our version stays in the post's revisions, so an overwrite can be undone
+				if _, err := tx.Exec("DELETE FROM posts WHERE slug = ?", slug); err != nil {
+					return err
+				}
+				if _, err := tx.Exec("DELETE FROM post_tags WHERE post_slug = ?", slug); err != nil {
+					return err
+				}
+			}
+			if _, err := tx.Exec(insert, append([]any{target, seriesSlugs[seriesID.Int64], authorID}, row...)...); err != nil {
+				return err
+			}
+			if len(tags) > 0 {
+				if err := saveTags(tx, target, tags); err != nil {
+					return err
+				}
+			}
+			if err := revisions.copyRevisions(tx, postRevisions, target); err != nil {
+				return err
+			}
+			if copied, err = comments.copyComments(tx, postComments, target); err != nil {
+				return err
+			}
+			return recordRevision(tx, target, "restore")
+		})
+		if err != nil {
+			return result, err
+		}
+		result.Comments += copied
+
+		switch {
+		case target != slug:
+			result.Renamed[slug] = target
+		case exists:
+			result.Overwritten = append(result.Overwritten, slug)
+		default:
+			result.Imported = append(result.Imported, slug)
+		}
+	}
+
+	// Media IDs are content hashes, so one already here is the same file
+	if tableExists(ctx, backup, "media") {
+		mediaCols, err := sharedColumns(ctx, backup, "media")
+		if err != nil {
+			return result, err
+		}
+		if result.Media, err = copyRows(ctx, backup, "media", mediaCols, "ON CONFLICT(id) DO NOTHING"); err != nil {
+			return result, err
+		}
+	}
+
+	for _, table := range unrestoredTables {
+		var hasRows bool
+		if tableExists(ctx, backup, table) && backup.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM "+table+")").Scan(&hasRows) == nil && hasRows {
+			result.NotRestored = append(result.NotRestored, table)
+		}
+	}
+
+	return result, nil
+}
+
+// restoreAuthors adds the backup's authors that aren't here, and records in ids which of ours
+// each backup author ID is. Authors have no slug, so one here with the same name is the same
+// person. It counts the authors added.
+func restoreAuthors(ctx context.Context, backup *sql.DB, ids map[int64]int64) (int, error) {
+	cols, err := sharedColumns(ctx, backup, "authors")
+	if err != nil {
+		return 0, err
+	}
+	cols = slices.DeleteFunc(cols, func(c string) bool { return c == "id" || c == "name" })
+	cols = append([]string{"name"}, cols...)
+	bools, err := booleanColumns(ctx, "authors")
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := backup.QueryContext(ctx, "SELECT id, "+joinColumns(cols)+" FROM authors ORDER BY id")
+	if err != nil {
+		return 0, err
+	}
+	backupIDs, authors := []int64{}, [][]any{}
+	for rows.Next() {
+		var id int64
+		row := make([]any, len(cols))
+		if err := rows.Scan(append([]any{&id}, scanTargets(row)...)...); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		toBooleans(cols, row, bools)
+		backupIDs, authors = append(backupIDs, id), append(authors, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	insert := "INSERT INTO authors (" + joinColumns(cols) + ") VALUES (" + strings.Join(slices.Repeat([]string{"?"}, len(cols)), ", ") + ")"
+	added := 0
+	for i, row := range authors {
+		var ours int64
+		err := db.QueryRowContext(ctx, "SELECT id FROM authors WHERE name = ? ORDER BY id LIMIT 1", row[0]).Scan(&ours)
+		if err == sql.ErrNoRows {
+			ours, err = insertID(insert, row...)
+			added++
+		}
+		if err != nil {
+			return added, err
+		}
+		ids[backupIDs[i]] = ours
+	}
+	return added, nil
+}
+
+// postRows reads one post's rows of a table kept by post_slug out of a backup: key, the
+// table's own id or number for a row, and cols, the rest of the columns both sides have.
+type postRows struct {
+	backup *sql.DB
+	table  string
+	key    string
+	cols   []string
+	bools  []string
+}
+
+// newPostRows is postRows for table, or one that reads nothing if the backup hasn't got it.
+func newPostRows(ctx context.Context, backup *sql.DB, table, key string) (postRows, error) {
+	pr := postRows{backup: backup, table: table, key: key}
+	if !tableExists(ctx, backup, table) {
+		return pr, nil
+	}
+	cols, err := sharedColumns(ctx, backup, table)
+	if err != nil {
+		return pr, err
+	}
+	pr.cols = slices.DeleteFunc(cols, func(c string) bool { return c == key || c == "post_slug" })
+	pr.bools, err = booleanColumns(ctx, table)
+	return pr, err
+}
+
+// read is slug's rows, key first in each, in key order.
+func (pr postRows) read(ctx context.Context, slug string) ([][]any, error) {
+	if len(pr.cols) == 0 {
+		return nil, nil
+	}
+	cols := append([]string{pr.key}, pr.cols...)
+	rows, err := pr.backup.QueryContext(ctx,
+		"SELECT "+joinColumns(cols)+" FROM "+pr.table+" WHERE post_slug = ? ORDER BY "+joinColumns([]string{pr.key}), slug)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out [][]any
+	for rows.Next() {
+		row := make([]any, len(cols))
+		if err := rows.Scan(scanTargets(row)...); err != nil {
+			return nil, err
+		}
+		toBooleans(cols, row, pr.bools)
+		out = append(out, row)
+	}
+	return out, rows.Err()
+}
+
+// copyRevisions adds revisions to target's history after any it already has, so an
+// overwritten post keeps ours as well as gaining the backup's.
+func (pr postRows) copyRevisions(tx *sql.Tx, revisions [][]any, target string) error {
+	if len(revisions) == 0 {
+		return nil
+	}
+	var last int64
+	if err := tx.QueryRow("SELECT COALESCE(MAX(rev), 0) FROM post_revisions WHERE post_slug = ?", target).Scan(&last); err != nil {
+		return err
+	}
+	insert := "INSERT INTO post_revisions (post_slug, rev, " + joinColumns(pr.cols) + ") VALUES (?, ?, " +
+		strings.Join(slices.Repeat([]string{"?"}, len(pr.cols)), ", ") + ")"
+	for _, row := range revisions {
+		rev, _ := row[0].(int64)
+		if _, err := tx.Exec(insert, append([]any{target, last + rev}, row[1:]...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyComments adds comments to target, replies under their copied parents, and counts the
+// ones added. A comment target already has, the same words by the same name, isn't copied.
+func (pr postRows) copyComments(tx *sql.Tx, comments [][]any, target string) (int, error) {
+	if len(comments) == 0 {
+		return 0, nil
+	}
+	cols := slices.DeleteFunc(slices.Clone(pr.cols), func(c string) bool { return c == "parent_id" })
+	insert := "INSERT INTO comments (post_slug, parent_id, " + joinColumns(cols) + ") VALUES (?, ?, " +
+		strings.Join(slices.Repeat([]string{"?"}, len(cols)), ", ") + ") ON CONFLICT DO NOTHING RETURNING id"
+	// Rows have the comment's id first, then pr.cols
+	parentAt, author, content := slices.Index(pr.cols, "parent_id")+1, slices.Index(pr.cols, "author_name")+1, slices.Index(pr.cols, "content")+1
+
+	ids := map[int64]int64{} // Backup's comment IDs -> ours; parents come before their replies
+	added := 0
+	for _, row := range comments {
+		backupID, _ := row[0].(int64)
+		var id int64
+		if author > 0 && content > 0 {
+			err := tx.QueryRow("SELECT id FROM comments WHERE post_slug = ? AND author_name = ? AND content = ?",
+				target, row[author], row[content]).Scan(&id)
+			if err == nil {
+				ids[backupID] = id
+				continue
+			}
+			if err != sql.ErrNoRows {
+				return added, err
+			}
+		}
+
+		var parent any
+		if parentAt > 0 {
+			if p, ok := row[parentAt].(int64); ok {
+				if id, ok := ids[p]; ok {
+					parent = id
+				}
+			}
+		}
+		values := []any{target, parent}
+		for i, c := range pr.cols {
+			if c != "parent_id" {
+				values = append(values, row[i+1])
+			}
+		}
+		err := tx.QueryRow(insert, values...).Scan(&id)
+		if err == sql.ErrNoRows {
+			continue // Imported from Disqus, and already here on another post
+		}
+		if err != nil {
+			return added, err
+		}
+		ids[backupID] = id
+		added++
+	}
+	return added, nil
+}
+
+var errNotMaltBackup = errors.New("not a malt backup: it has no posts table")
+
+// copyRows copies cols of every row in the backup's table into ours, adding onConflict to
+// each insert, and counts the rows that went in.
+func copyRows(ctx context.Context, backup *sql.DB, table string, cols []string, onConflict string) (int, error) {
+	bools, err := booleanColumns(ctx, table)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := backup.QueryContext(ctx, "SELECT "+joinColumns(cols)+" FROM "+table)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	insert := "INSERT INTO " + table + " (" + joinColumns(cols) + ") VALUES (" +
+		strings.Join(slices.Repeat([]string{"?"}, len(cols)), ", ") + ") " + onConflict
+	n := 0
+	for rows.Next() {
+		row := make([]any, len(cols))
+		if err := rows.Scan(scanTargets(row)...); err != nil {
+			return n, err
+		}
+		toBooleans(cols, row, bools)
+		result, err := execWrite(insert, row...)
+		if err != nil {
+			return n, err
+		}
+		added, _ := result.RowsAffected()
+		n += int(added)
+	}
+	return n, rows.Err()
+}
+
+// scanTargets points Scan at each value of row.
+func scanTargets(row []any) []any {
+	dest := make([]any, len(row))
+	for i := range row {
+		dest[i] = &row[i]
+	}
+	return dest
+}
+
+// booleanColumns are table's BOOLEAN columns here, if they need telling apart: SQLite hands
+// booleans over as 0 and 1, which Postgres won't take for one.
+func booleanColumns(ctx context.Context, table string) ([]string, error) {
+	if !onPostgres {
+		return nil, nil
+	}
+	return queryStrings(ctx, db, `
+		SELECT column_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = ? AND data_type = 'boolean'`, table)
+}
+
+// toBooleans turns the 0s and 1s in bools' columns of row into false and true.
+func toBooleans(cols []string, row []any, bools []string) {
+	for i, c := range cols {
+		if n, ok := row[i].(int64); ok && slices.Contains(bools, c) {
+			row[i] = n != 0
+		}
+	}
+}
+
+// sharedColumns lists the columns table has both here and in the backup.
+func sharedColumns(ctx context.Context, backup *sql.DB, table string) ([]string, error) {
+	query := "SELECT name FROM pragma_table_info(?)"
+	if onPostgres {
+		query = "SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = ? ORDER BY ordinal_position"
+	}
+	ours, err := queryStrings(ctx, db, query, table)
+	if err != nil {
+		return nil, err
+	}
+	theirs, err := queryStrings(ctx, backup, "SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(ours, func(c string) bool { return !slices.Contains(theirs, c) }), nil
+}
+
+func tableExists(ctx context.Context, backup *sql.DB, table string) bool {
+	var n int
+	backup.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&n)
+	return n > 0
+}
+
+func queryStrings(ctx context.Context, q *sql.DB, query string, args ...any) ([]string, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// joinColumns quotes column names for splicing into SQL. They come from our own schema,
+// never from the request.
+func joinColumns(cols []string) string {
+	quoted := make([]string, len(cols))
+	for i, c := range cols {
+		quoted[i] = `"` + c + `"`
+	}
+	return strings.Join(quoted, ", ")
+}
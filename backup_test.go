@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// A full backup restored into an empty database brings back bylines, comments and
+// revisions, and says which tables it left out.
+func TestRestoreRoundTrip(t *testing.T) {
+	testServer(t)
+	author, err := insertID("INSERT INTO authors (name, bio) VALUES ('Ann', 'Writes things')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	byline := `"author_id": ` + strconv.FormatInt(author, 10)
+	publish(t, `{"slug": "bylined", "title": "Bylined", "content": "<p>First</p>", `+byline+`}`)
+	publish(t, `{"slug": "bylined", "title": "Bylined", "content": "<p>Second</p>", `+byline+`}`)
+	parent, err := insertID("INSERT INTO comments (post_slug, author_name, content, status) VALUES ('bylined', 'Bob', 'Nice', 'approved')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		"INSERT INTO comments (post_slug, parent_id, author_name, content, status) VALUES ('bylined', " + strconv.FormatInt(parent, 10) + ", 'Ann', 'Thanks', 'approved')",
+		"INSERT INTO links (title, url) VALUES ('Friend', 'https://friend.example')",
+		"INSERT INTO subscribers (email, token, status) VALUES ('reader@example.com', 't', 'confirmed')",
+	} {
+		if _, err := execWrite(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := call(handleBackup, "GET", "", "admin", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("backup: %d %s", w.Code, w.Body)
+	}
+	backup := w.Body.String()
+
+	testServer(t) // An empty database
+	w = call(handleRestore, "POST", "", "admin", backup)
+	var result RestoreResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("restore: %d %v", w.Code, err)
+	}
+	if result.Authors != 1 || result.Comments != 2 {
+		t.Errorf("restored %d authors and %d comments, want 1 and 2", result.Authors, result.Comments)
+	}
+	if !slices.Contains(result.NotRestored, "links") || !slices.Contains(result.NotRestored, "subscribers") {
+		t.Errorf("not_restored = %q, want links and subscribers in it", result.NotRestored)
+	}
+
+	var name string
+	if err := db.QueryRow("SELECT a.name FROM posts p JOIN authors a ON a.id = p.author_id WHERE p.slug = 'bylined'").Scan(&name); err != nil || name != "Ann" {
+		t.Errorf("byline after restore: %q, %v", name, err)
+	}
+	var reply string
+	err = db.QueryRow(`
+		SELECT c.content FROM comments c JOIN comments p ON p.id = c.parent_id
+		WHERE c.post_slug = 'bylined' AND p.content = 'Nice'`).Scan(&reply)
+	if err != nil || reply != "Thanks" {
+		t.Errorf("reply after restore: %q, %v", reply, err)
+	}
+	var revs int
+	db.QueryRow("SELECT COUNT(*) FROM post_revisions WHERE post_slug = 'bylined'").Scan(&revs)
+	if revs != 2 {
+		t.Errorf("%d revisions after restore, want the backup's 2", revs)
+	}
+
+	// Restoring it again finds everything here already
+	w = call(handleRestore, "POST", "", "admin", backup)
+	result = RestoreResult{}
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil || result.Authors != 0 || result.Comments != 0 || len(result.Skipped) != 1 {
+		t.Errorf("restoring again: %+v, %v", result, err)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Bluesky: replies to a post's syndicated skeet, fetched on demand and cached with a TTL ---
+
+// The public AppView answers unauthenticated thread reads.
+const defaultBlueskyAppView = "https://public.api.bsky.app"
+
+var (
+	blueskyClient = &http.Client{Timeout: 5 * time.Second}
+	blueskyMu     sync.Mutex // One refresh at a time; readers behind it see the fresh cache
+)
+
+// blueskyThread is the recursive threadViewPost shape from app.bsky.feed.getPostThread.
+type blueskyThread struct {
+	Post struct {
+		URI    string `json:"uri"`
+		Author struct {
+			Handle      string `json:"handle"`
+			DisplayName string `json:"displayName"`
+			Avatar      string `json:"avatar"`
+		} `json:"author"`
+		Record struct {
+			Text      string    `json:"text"`
+			CreatedAt time.Time `json:"createdAt"`
+		} `json:"record"`
+	} `json:"post"`
+	Replies []blueskyThread `json:"replies"`
+}
+
+// blueskyATURI accepts an at:// URI or a bsky.app post link and returns the at:// form.
+func blueskyATURI(s string) string {
+	s = strings.TrimSpace(s)
+	u, err := url.Parse(s)
+	if err != nil || u.Host != "bsky.app" {
+		return s
+	}
+
+	// https://bsky.app/profile/{actor}/post/{rkey}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) != 4 || parts[0] != "profile" || parts[2] != "post" {
+		return s
+	}
+	return "at://" + parts[1] + "/app.bsky.feed.post/" + parts[3]
+}
+
+func blueskyTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("MALT_BLUESKY_TTL"))
+	if err != nil || ttl < time.Minute {
+		return 10 * time.Minute
+	}
+	return ttl
+}
+
+// refreshBlueskyIfStale refetches the thread when the cache is older than the TTL.
+// Failures keep the old replies and still bump fetched_at, so a dead API isn't hit per page view.
+func refreshBlueskyIfStale(slug, uri string) {
+	blueskyMu.Lock()
+	defer blueskyMu.Unlock()
+
+	var fetched time.Time
+	err := db.QueryRow("SELECT fetched_at FROM bluesky_threads WHERE post_slug = ?", slug).Scan(&fetched)
+	if err == nil && time.Since(fetched) < blueskyTTL() {
+		return
+	}
+
+	replies, fetchErr := fetchBlueskyThread(uri)
+	if fetchErr != nil {
+		log.Printf("bluesky: %s: %v", slug, fetchErr)
+	}
+
+	err = withTx(context.Background(), func(tx *sql.Tx) error {
+		if fetchErr == nil {
+			if _, err := tx.Exec("DELETE FROM bluesky_replies WHERE post_slug = ?", slug); err != nil {
+				return err
+			}
+			for _, c := range replies {
+				_, err := tx.Exec(`
+					INSERT INTO bluesky_replies
+						(id, post_slug, url, in_reply_to, author_name, author_handle, author_url, author_avatar, content, created_at)
+					VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+					ON CONFLICT(id) DO UPDATE SET `+replaceReply+`
+				`, c.ID, slug, c.URL, c.InReplyTo, c.Author.Name, c.Author.Handle, c.Author.URL, c.Author.Avatar,
+					c.Content, c.CreatedAt)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		_, err := tx.Exec(`
+			INSERT INTO bluesky_threads (post_slug, fetched_at) VALUES (?, ?)
+			ON CONFLICT(post_slug) DO UPDATE SET fetched_at = excluded.fetched_at`, slug, time.Now().UTC())
+		return err
+	})
+	if err != nil {
+		log.Printf("bluesky: %s: %v", slug, err)
+	}
+}
+
+// fetchBlueskyThread returns every reply below the post, flattened, with in_reply_to pointing at the parent.
+func fetchBlueskyThread(uri string) ([]RemoteReply, error) {
+	appview := strings.TrimSuffix(os.Getenv("MALT_BLUESKY_APPVIEW"), "/")
+	if appview == "" {
+		appview = defaultBlueskyAppView
+	}
+
+	q := url.Values{"uri": {uri}, "depth": {"10"}, "parentHeight": {"0"}}
+	res, err := blueskyClient.Get(appview + "/xrpc/app.bsky.feed.getPostThread?" + q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	var body struct {
+		Thread blueskyThread `json:"thread"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	var replies []RemoteReply
+	var walk func(parent string, children []blueskyThread)
+	walk = func(parent string, children []blueskyThread) {
+		for _, t := range children {
+			// Blocked and deleted replies come back without a post
+			if t.Post.URI == "" {
+				continue
+			}
+			handle := t.Post.Author.Handle
+			profile := "https://bsky.app/profile/" + handle
+			replies = append(replies, RemoteReply{
+				ID:        t.Post.URI,
+				URL:       profile + "/post/" + t.Post.URI[strings.LastIndex(t.Post.URI, "/")+1:],
+				InReplyTo: parent,
+				Author:    RemoteAuthor{Name: t.Post.Author.DisplayName, Handle: handle, URL: profile, Avatar: t.Post.Author.Avatar},
+				Content:   t.Post.Record.Text,
+				CreatedAt: t.Post.Record.CreatedAt.UTC(),
+			})
+			walk(t.Post.URI, t.Replies)
+		}
+	}
+	walk(uri, body.Thread.Replies)
+
+	return replies, nil
+}
+
+// GET /api/posts/{slug}/bluesky-comments - Bluesky replies, oldest first
+func handleBlueskyComments(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+
+	var uri string
+	if err := db.QueryRow("SELECT bluesky_uri FROM posts WHERE slug = ? AND deleted_at IS NULL", slug).Scan(&uri); err != nil {
+		httpError(w, r, "Post not found", 404)
+		return
+	}
+	if uri != "" {
+		refreshBlueskyIfStale(slug, uri)
+	}
+
+	replies, err := cachedReplies("bluesky_replies", slug)
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+
+	jsonResponse(w, replies)
+}
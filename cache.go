@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- HTTP caching: ETag and Last-Modified, so clients revalidate instead of refetching ---
+//
+// Responses say Cache-Control: no-cache (keep it, but ask first), or max-age=MALT_CACHE_MAX_AGE
+// seconds when that's set. Asking again with If-None-Match or If-Modified-Since gets a 304
+// when nothing changed. For GET /api/posts that check runs before the list is read at all.
+
+// cacheControl is the Cache-Control for a response to r. Anything fetched with a key or a
+// preview token may hold drafts, so shared caches don't get to keep it.
+func cacheControl(r *http.Request) string {
+	scope := "public"
+	if r.Header.Get("X-MALT-KEY") != "" || r.URL.Query().Has("token") {
+		scope = "private"
+	}
+	if age := envInt("MALT_CACHE_MAX_AGE", 0); age > 0 {
+		return scope + ", max-age=" + strconv.Itoa(age)
+	}
+	return scope + ", no-cache"
+}
+
+// notModified sets the validators and, when the request already has this version, answers
+// 304 and returns true. A zero modified sends no Last-Modified.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	h := w.Header()
+	h.Set("ETag", etag)
+	h.Set("Cache-Control", cacheControl(r))
+	h.Add("Vary", "X-MALT-KEY")
+	if !modified.IsZero() {
+		h.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	// If-None-Match wins when both are sent (RFC 9110 13.2.2)
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatches(inm, etag) {
+			return false
+		}
+	} else {
+		since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err != nil || modified.IsZero() || modified.Truncate(time.Second).After(since) {
+			return false
+		}
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches is the weak comparison If-None-Match calls for.
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyETag is a strong ETag over a response body.
+func bodyETag(body []byte) string {
+	h := fnv.New64a()
+	h.Write(body)
+	return fmt.Sprintf(`"%x"`, h.Sum64())
+}
+
+// jsonCached is jsonResponse with an ETag over the encoded body.
+func jsonCached(w http.ResponseWriter, r *http.Request, data any, modified time.Time) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		httpError(w, r, "Could not encode response", 500)
+		return
+	}
+	body = append(body, '\n')
+	if notModified(w, r, bodyETag(body), modified) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// postsETag fingerprints the posts table without reading it: every write to a post sets its
+// updated_at, and deleting one changes the count. variant tells apart responses built from
+// the same posts (a query string, say). modified is the newest updated_at.
+func postsETag(variant string) (etag string, modified time.Time, err error) {
+	var count, live int
+	var newestUpdate, newestPublish string
+	// live changes when a scheduled post's time comes, though nothing was written then
+	err = db.QueryRow(`
+		SELECT COUNT(*), COUNT(CASE WHEN published_at <= ? THEN 1 END),
+			COALESCE(MAX(CAST(updated_at AS TEXT)), ''), COALESCE(MAX(CAST(published_at AS TEXT)), '')
+		FROM posts WHERE deleted_at IS NULL`, time.Now().UTC()).Scan(&count, &live, &newestUpdate, &newestPublish)
+	if err != nil {
+		return "", modified, err
+	}
+	if count > 0 {
+		db.QueryRow("SELECT updated_at FROM posts WHERE deleted_at IS NULL ORDER BY updated_at DESC LIMIT 1").Scan(&modified)
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s|%d|%d|%s|%s", variant, count, live, newestUpdate, newestPublish)
+	return fmt.Sprintf(`W/"%x"`, h.Sum64()), modified, nil
+}
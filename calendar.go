@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// --- Content calendar: what went out (or will) on which day, gaps included ---
+
+// CalendarPost is a post as the planning view shows it.
+type CalendarPost struct {
+	Slug        string    `json:"slug"`
+	Title       string    `json:"title"`
+	PublishedAt time.Time `json:"published_at"`
+	State       string    `json:"state"` // "published", or "scheduled" when published_at is still ahead
+}
+
+// CalendarDay is one day of the month; days without posts are listed too.
+type CalendarDay struct {
+	Date  string         `json:"date"`
+	Posts []CalendarPost `json:"posts"`
+}
+
+// Calendar is one month of the posting schedule.
+type Calendar struct {
+	Month     string        `json:"month"`
+	Published int           `json:"published"`
+	Scheduled int           `json:"scheduled"`
+	Days      []CalendarDay `json:"days"`
+}
+
+// GET /api/calendar?month=2025-07&tz=Europe/Berlin - Posts per day; month defaults to this one, tz to UTC
+func handleCalendar(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	loc := time.UTC
+	if tz := r.URL.Query().Get("tz"); tz != "" {
+		l, err := time.LoadLocation(tz)
+		if err != nil {
+			httpError(w, r, "Unknown time zone", 400)
+			return
+		}
+		loc = l
+	}
+
+	now := time.Now().In(loc)
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	if m := r.URL.Query().Get("month"); m != "" {
+		t, err := time.ParseInLocation("2006-01", m, loc)
+		if err != nil {
+			httpError(w, r, "month must look like 2025-07", 400)
+			return
+		}
+		start = t
+	}
+	end := start.AddDate(0, 1, 0)
+
+	cal := Calendar{Month: start.Format("2006-01")}
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		cal.Days = append(cal.Days, CalendarDay{Date: d.Format("2006-01-02"), Posts: []CalendarPost{}})
+	}
+
+	// published_at isn't stored in a format SQLite can compare, so the month is picked out here
+	rows, err := db.Query("SELECT slug, title, published_at FROM posts WHERE status = 'published' AND deleted_at IS NULL ORDER BY published_at")
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p CalendarPost
+		if err := rows.Scan(&p.Slug, &p.Title, &p.PublishedAt); err != nil {
+			continue
+		}
+		at := p.PublishedAt.In(loc)
+		if at.Before(start) || !at.Before(end) {
+			continue
+		}
+
+		p.State = "published"
+		if p.PublishedAt.After(now) {
+			p.State = "scheduled"
+			cal.Scheduled++
+		} else {
+			cal.Published++
+		}
+		day := &cal.Days[at.Day()-1]
+		day.Posts = append(day.Posts, p)
+	}
+
+	jsonResponse(w, cal)
+}
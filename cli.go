@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// --- CLI: the same binary as a client for a running server ---
+//
+//	single-malt publish post.md [more.md ...]
+//	single-malt list [-drafts]
+//	single-malt get slug
+//	single-malt delete slug
+//
+// The server is MALT_URL (default http://localhost:8080) and the key MALT_SECRET, from the
+// environment or from the config file ($XDG_CONFIG_HOME/malt/config on Linux), which holds
+// the same names as KEY=value lines. The environment wins.
+
+type cliCommand struct {
+	usage string
+	run   func(c *client, args []string) error
+}
+
+var cliCommands = map[string]cliCommand{
+	"publish": {"publish FILE.md...  publish Markdown files with front matter", cliPublish},
+	"list":    {"list [-drafts]      list published posts (or drafts)", cliList},
+	"get":     {"get SLUG            print a post as Markdown", cliGet},
+	"delete":  {"delete SLUG         delete a post", cliDelete},
+}
+
+var errUsage = errors.New("usage")
+
+// runCLI runs a subcommand and reports whether args named one, so main knows to stop there.
+func runCLI(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	if args[0] == "help" {
+		fmt.Fprintln(os.Stderr, "Usage: single-malt [flags]   run the server")
+		for _, name := range []string{"publish", "list", "get", "delete"} {
+			fmt.Fprintln(os.Stderr, "       single-malt "+cliCommands[name].usage)
+		}
+		return true
+	}
+	cmd, ok := cliCommands[args[0]]
+	if !ok {
+		return false
+	}
+
+	c, err := newClient()
+	if err == nil {
+		err = cmd.run(c, args[1:])
+	}
+	if err == errUsage {
+		fmt.Fprintln(os.Stderr, "Usage: single-malt "+cmd.usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "malt "+args[0]+": "+err.Error())
+		os.Exit(1)
+	}
+	return true
+}
+
+type client struct {
+	base   string
+	secret string
+	http   *http.Client
+}
+
+func newClient() (*client, error) {
+	conf := cliConfig()
+	setting := func(name, fallback string) string {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+		if v := conf[name]; v != "" {
+			return v
+		}
+		return fallback
+	}
+
+	c := &client{
+		base:   strings.TrimSuffix(setting("MALT_URL", "http://localhost:8080"), "/"),
+		secret: setting("MALT_SECRET", ""),
+		http:   &http.Client{Timeout: 30 * time.Second},
+	}
+	if c.secret == "" {
+		return nil, errors.New("no key: set MALT_SECRET, or put MALT_SECRET=... in " + cliConfigPath())
+	}
+	return c, nil
+}
+
+func cliConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "malt", "config")
+}
+
+// cliConfig reads KEY=value lines; blank lines and # comments are skipped.
+func cliConfig() map[string]string {
+	conf := map[string]string{}
+	f, err := os.Open(cliConfigPath())
+	if err != nil {
+		return conf
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if k, v, ok := strings.Cut(line, "="); ok {
+			conf[strings.TrimSpace(k)] = strings.Trim(strings.TrimSpace(v), `"'`)
+		}
+	}
+	return conf
+}
+
+// do sends one request and decodes a JSON answer into out (when out isn't nil).
+func (c *client) do(method, path, contentType string, body io.Reader, out any) error {
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-MALT-KEY", c.secret)
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s (%d)", apiErr.Error, resp.StatusCode)
+		}
+		return errors.New(resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+func cliPublish(c *client, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	failed := 0
+	for _, file := range args {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var result PublishResult
+		if err := c.do("POST", "/api/publish", "text/markdown", bytes.NewReader(src), &result); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s: %s %s%s\n", file, result.Status, c.base, result.Link)
+		for _, d := range result.Duplicates {
+			fmt.Printf("  looks like /post/%s\n", d.Slug)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed", failed, len(args))
+	}
+	return nil
+}
+
+func cliList(c *client, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	drafts := fs.Bool("drafts", false, "list drafts instead")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	path := "/api/posts"
+	if *drafts {
+		path = "/api/drafts"
+	}
+	var posts []Post
+	if err := c.do("GET", path, "", nil, &posts); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, p := range posts {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", p.PublishedAt.Format("2006-01-02"), p.Slug, p.Title)
+	}
+	return tw.Flush()
+}
+
+func cliGet(c *client, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	var p Post
+	if err := c.do("GET", "/api/posts/"+url.PathEscape(args[0]), "", nil, &p); err != nil {
+		return err
+	}
+
+	// The front matter publish reads (values unquoted, as its parser takes them), so
+	// get > file, edit, publish file round-trips
+	var b strings.Builder
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "title: %s\n", p.Title)
+	fmt.Fprintf(&b, "slug: %s\n", p.Slug)
+	if p.Description != "" {
+		fmt.Fprintf(&b, "description: %s\n", p.Description)
+	}
+	if len(p.Tags) > 0 {
+		b.WriteString("tags: [" + strings.Join(p.Tags, ", ") + "]\n")
+	}
+	fmt.Fprintf(&b, "published_at: %s\n", p.PublishedAt.Format(time.RFC3339))
+	if p.Type == "link" {
+		fmt.Fprintf(&b, "type: link\nlink_url: %s\n", p.LinkURL)
+	}
+	if p.ContentFormat != "" && p.ContentFormat != FormatMarkdown {
+		fmt.Fprintf(&b, "content_format: %s\n", p.ContentFormat)
+	}
+	if p.Status == "draft" {
+		b.WriteString("status: draft\n")
+	}
+	b.WriteString("---\n")
+	b.WriteString(p.Content)
+	fmt.Println(strings.TrimRight(b.String(), "\n"))
+	return nil
+}
+
+func cliDelete(c *client, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	if err := c.do("DELETE", "/api/posts/"+url.PathEscape(args[0]), "", nil, nil); err != nil {
+		return err
+	}
+	fmt.Println("deleted " + args[0])
+	return nil
+}
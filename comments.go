@@ -0,0 +1,269 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// --- Comments: native ones, posted by readers and approved by the owner ---
+//
+// New comments wait as "pending" until approved, unless MALT_COMMENTS_AUTO_APPROVE is set.
+// Spam defences are the cheap ones: a honeypot field a person never sees, a per-IP limit of
+// MALT_COMMENT_RATE comments per hour (default 5, bursts of 3), and length caps. Comments are
+// plain text; readers' markup is escaped on the way out, never rendered.
+
+const (
+	maxCommentName    = 100
+	maxCommentContent = 5000
+)
+
+// Comment is one comment. Email and IP only go to the moderation endpoints.
+type Comment struct {
+	ID          int64     `json:"id"`
+	PostSlug    string    `json:"post_slug"`
+	ParentID    *int64    `json:"parent_id,omitempty"`
+	AuthorName  string    `json:"author_name"`
+	AuthorEmail string    `json:"author_email,omitempty"`
+	AuthorURL   string    `json:"author_url,omitempty"`
+	Content     string    `json:"content"`
+	Status      string    `json:"status,omitempty"`
+	Source      string    `json:"source,omitempty"` // "disqus" for imported ones
+	IP          string    `json:"ip,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// commentForm is what a reader sends. Company is the honeypot: hidden in the form, so only
+// bots fill it in.
+type commentForm struct {
+	AuthorName  string `json:"author_name"`
+	AuthorEmail string `json:"author_email"`
+	AuthorURL   string `json:"author_url"`
+	Content     string `json:"content"`
+	ParentID    int64  `json:"parent_id"`
+	Company     string `json:"company"`
+}
+
+var commentLimiter = &rateLimiter{
+	rate:    float64(max(1, envInt("MALT_COMMENT_RATE", 5))) / 3600,
+	burst:   3,
+	buckets: map[string]*bucket{},
+}
+
+// GET /api/posts/{slug}/comments - Approved comments, oldest first
+func handleListComments(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query(`
+		SELECT c.id, c.post_slug, c.parent_id, c.author_name, c.author_url, c.content, c.created_at
+		FROM comments c JOIN posts p ON p.slug = c.post_slug
+		WHERE c.post_slug = ? AND c.status = 'approved' AND p.status = 'published' AND p.deleted_at IS NULL
+		ORDER BY c.created_at, c.id`, r.PathValue("slug"))
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	comments := []Comment{}
+	for rows.Next() {
+		var c Comment
+		var parent sql.NullInt64
+		if err := rows.Scan(&c.ID, &c.PostSlug, &parent, &c.AuthorName, &c.AuthorURL, &c.Content, &c.CreatedAt); err != nil {
+			continue
+		}
+		if parent.Valid {
+			c.ParentID = &parent.Int64
+		}
+		comments = append(comments, c)
+	}
+
+	jsonResponse(w, comments)
+}
+
+// POST /api/posts/{slug}/comments - Leave a comment (JSON or a plain form post)
+func handleCreateComment(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+
+	var f commentForm
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&f); err != nil {
+			httpError(w, r, "Bad JSON", 400)
+			return
+		}
+	} else {
+		r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+		f.AuthorName, f.AuthorEmail, f.AuthorURL = r.FormValue("author_name"), r.FormValue("author_email"), r.FormValue("author_url")
+		f.Content, f.Company = r.FormValue("content"), r.FormValue("company")
+		f.ParentID, _ = strconv.ParseInt(r.FormValue("parent_id"), 10, 64)
+	}
+
+	// Bots get the same answer as people, so there's nothing to learn from trying
+	if f.Company != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		jsonResponse(w, map[string]string{"status": "pending"})
+		return
+	}
+
+	var postStatus string
+	var publishedAt time.Time
+	err := db.QueryRow("SELECT status, published_at FROM posts WHERE slug = ? AND deleted_at IS NULL", slug).Scan(&postStatus, &publishedAt)
+	if err != nil || postStatus != "published" || publishedAt.After(time.Now()) {
+		httpError(w, r, "Post not found", 404)
+		return
+	}
+
+	c := Comment{
+		PostSlug:    slug,
+		AuthorName:  strings.TrimSpace(f.AuthorName),
+		AuthorEmail: strings.TrimSpace(f.AuthorEmail),
+		AuthorURL:   strings.TrimSpace(f.AuthorURL),
+		Content:     strings.TrimSpace(f.Content),
+		Status:      "pending",
+		IP:          clientIP(r),
+		CreatedAt:   time.Now().UTC(),
+	}
+	switch {
+	case c.AuthorName == "" || utf8.RuneCountInString(c.AuthorName) > maxCommentName:
+		httpError(w, r, "A name (up to 100 characters) is required", 400)
+		return
+	case c.Content == "" || utf8.RuneCountInString(c.Content) > maxCommentContent:
+		httpError(w, r, "A comment (up to 5000 characters) is required", 400)
+		return
+	}
+	if c.AuthorEmail != "" {
+		if _, err := mail.ParseAddress(c.AuthorEmail); err != nil {
+			httpError(w, r, "That email address doesn't look right", 400)
+			return
+		}
+	}
+	if c.AuthorURL != "" {
+		if u, err := url.Parse(c.AuthorURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			httpError(w, r, "The website must be an http(s) URL", 400)
+			return
+		}
+	}
+	if f.ParentID != 0 {
+		var ok bool
+		db.QueryRow("SELECT EXISTS(SELECT 1 FROM comments WHERE id = ? AND post_slug = ? AND status = 'approved')", f.ParentID, slug).Scan(&ok)
+		if !ok {
+			httpError(w, r, "The comment you're replying to isn't there", 400)
+			return
+		}
+		c.ParentID = &f.ParentID
+	}
+
+	// Only comments that would be saved count against the limit, so typos don't lock anyone out
+	if ok, wait := commentLimiter.allow(clientIP(r), time.Now()); !ok {
+		tooMany(w, r, wait, "That's a lot of comments; try again later")
+		return
+	}
+	if envBool("MALT_COMMENTS_AUTO_APPROVE", false) {
+		c.Status = "approved"
+	}
+
+	c.ID, err = insertID(`
+		INSERT INTO comments (post_slug, parent_id, author_name, author_email, author_url, content, status, created_at, source, source_id, ip)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, '', NULL, ?)`,
+		c.PostSlug, c.ParentID, c.AuthorName, c.AuthorEmail, c.AuthorURL, c.Content, c.Status, c.CreatedAt, c.IP)
+	if err != nil {
+		httpError(w, r, "Failed to save: "+err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	jsonResponse(w, map[string]any{"status": c.Status, "id": c.ID})
+}
+
+// GET /api/comments?status=pending - Moderation queue; status is pending (default), approved or all
+func handleModerationQueue(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "pending"
+	}
+	query := `SELECT id, post_slug, parent_id, author_name, author_email, author_url, content, status, source, ip, created_at FROM comments`
+	args := []any{}
+	switch status {
+	case "all":
+	case "pending", "approved":
+		query += " WHERE status = ?"
+		args = append(args, status)
+	default:
+		httpError(w, r, "status must be pending, approved or all", 400)
+		return
+	}
+
+	rows, err := db.Query(query+" ORDER BY created_at DESC, id DESC", args...)
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	comments := []Comment{}
+	for rows.Next() {
+		var c Comment
+		var parent sql.NullInt64
+		var email sql.NullString
+		if err := rows.Scan(&c.ID, &c.PostSlug, &parent, &c.AuthorName, &email, &c.AuthorURL, &c.Content, &c.Status, &c.Source, &c.IP, &c.CreatedAt); err != nil {
+			continue
+		}
+		if parent.Valid {
+			c.ParentID = &parent.Int64
+		}
+		c.AuthorEmail = email.String
+		comments = append(comments, c)
+	}
+
+	jsonResponse(w, comments)
+}
+
+// POST /api/comments/{id}/approve - Show a comment under its post
+func handleApproveComment(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	result, err := execWrite("UPDATE comments SET status = 'approved' WHERE id = ?", r.PathValue("id"))
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		httpError(w, r, "Comment not found", 404)
+		return
+	}
+
+	jsonResponse(w, map[string]string{"status": "approved", "id": r.PathValue("id")})
+}
+
+// DELETE /api/comments/{id} - Remove a comment; its replies stay, as top-level comments
+func handleDeleteComment(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	id := r.PathValue("id")
+	result, err := execWrite("DELETE FROM comments WHERE id = ?", id)
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		httpError(w, r, "Comment not found", 404)
+		return
+	}
+	execWrite("UPDATE comments SET parent_id = NULL WHERE parent_id = ?", id)
+
+	jsonResponse(w, map[string]string{"status": "deleted", "id": id})
+}
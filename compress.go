@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// --- Compression: gzip for text responses, when the client takes it ---
+//
+// Only responses of at least MALT_COMPRESS_MIN_BYTES (default 1024) get compressed; below
+// that the gzip framing costs more than it saves. Images and other already-compressed
+// types pass through untouched. MALT_COMPRESS=false turns it off. Brotli would squeeze a
+// little more, but the standard library has no encoder for it, and gzip is what every
+// client accepts anyway.
+
+var gzipWriters = sync.Pool{New: func() any {
+	w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+	return w
+}}
+
+// compress wraps the site in gzip, unless it's turned off.
+func compress(next http.Handler) http.Handler {
+	if !envBool("MALT_COMPRESS", true) {
+		return next
+	}
+	threshold := envInt("MALT_COMPRESS_MIN_BYTES", 1024)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, threshold: threshold}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// acceptsGzip reads Accept-Encoding, honouring an explicit gzip;q=0.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if coding = strings.ToLower(strings.TrimSpace(coding)); coding != "gzip" && coding != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compressible is true for the text types worth compressing.
+func compressible(contentType string) bool {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mt, "text/"):
+		return true
+	case mt == "application/json", mt == "application/javascript", mt == "application/xml",
+		mt == "image/svg+xml", strings.HasSuffix(mt, "+xml"), strings.HasSuffix(mt, "+json"):
+		return true
+	}
+	return false
+}
+
+// compressWriter holds the start of a response until it knows whether compressing pays.
+type compressWriter struct {
+	http.ResponseWriter
+	threshold int
+	code      int
+	buf       bytes.Buffer
+	gz        *gzip.Writer
+	decided   bool
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.code == 0 {
+		cw.code = code
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.code == 0 {
+		cw.code = http.StatusOK
+	}
+	if cw.decided {
+		if cw.gz != nil {
+			return cw.gz.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.buf.Write(p)
+	if cw.buf.Len() >= cw.threshold {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide sends the headers, compressed or not, then whatever was held back.
+func (cw *compressWriter) decide(bigEnough bool) error {
+	cw.decided = true
+	h := cw.Header()
+	if h.Get("Content-Type") == "" && cw.buf.Len() > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf.Bytes()))
+	}
+
+	if bigEnough && h.Get("Content-Encoding") == "" && cw.code != http.StatusNoContent &&
+		cw.code != http.StatusNotModified && h.Get("Content-Range") == "" && compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		// The compressed bytes differ, but it's the same representation
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.gz = gzipWriters.Get().(*gzip.Writer)
+		cw.gz.Reset(cw.ResponseWriter)
+	}
+
+	if cw.code != 0 {
+		cw.ResponseWriter.WriteHeader(cw.code)
+	}
+	if cw.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if cw.gz != nil {
+		_, err = cw.gz.Write(cw.buf.Bytes())
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf.Bytes())
+	}
+	cw.buf.Reset()
+	return err
+}
+
+// Close finishes the response: small ones go out as they are.
+func (cw *compressWriter) Close() {
+	if !cw.decided {
+		cw.decide(false)
+	}
+	if cw.gz != nil {
+		cw.gz.Close()
+		gzipWriters.Put(cw.gz)
+		cw.gz = nil
+	}
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// --- Config: the server's own settings, from flags, the environment or a file ---
+//
+// A flag beats its environment variable, which beats the config file, which beats the
+// default. The file (-config, or MALT_CONFIG) is TOML's flat subset, one key = value a line:
+//
+//	listen = ":8080"
+//	db = "/var/lib/malt/malt.db"
+//	base_url = "https://blog.example.com"
+//	write_timeout = "30s"
+//
+// The secret and the SMTP password have no flag, so they never show up in ps. Everything
+// else (feature settings, integrations) is still read from its MALT_* variable where it's used.
+
+// Config is the resolved server configuration.
+type Config struct {
+	Listen       string        // Plain HTTP
+	TLSListen    string        // HTTPS, when MALT_TLS_CERT/MALT_TLS_KEY are set
+	DSN          string        // SQLite file, file: DSN or postgres:// URL
+	StaticDir    string        // index.html and the default templates
+	Secret       string        // The admin key
+	BaseURL      string        // Absolute site root, "" to go by the request's Host
+	ReadTimeout  time.Duration // Per request, headers and body
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration // Keep-alive connections
+	BulkTimeout  time.Duration // Replaces both for backup, restore and import, whose bodies can be large
+
+	// Outgoing mail, for the newsletter; no SMTPAddr, no mail
+	SMTPAddr     string // host:port; 465 means TLS from the start, anything else STARTTLS
+	SMTPUser     string
+	SMTPPassword string
+	SMTPFrom     string // "Blog <blog@example.com>"
+}
+
+var cfg = Config{
+	Listen:       ":8080",
+	TLSListen:    ":8443",
+	DSN:          "malt.db",
+	StaticDir:    "static",
+	ReadTimeout:  5 * time.Second,
+	WriteTimeout: 10 * time.Second,
+	IdleTimeout:  2 * time.Minute,
+	BulkTimeout:  30 * time.Minute,
+}
+
+// configSetting is one setting under its three names.
+type configSetting struct {
+	key, env, flag, usage string
+	str                   *string
+	dur                   *time.Duration
+}
+
+func configSettings() []configSetting {
+	return []configSetting{
+		{key: "listen", env: "MALT_LISTEN", flag: "listen", usage: "address for plain HTTP", str: &cfg.Listen},
+		{key: "tls_listen", env: "MALT_TLS_LISTEN", flag: "tls-listen", usage: "address for HTTPS (with MALT_TLS_CERT)", str: &cfg.TLSListen},
+		{key: "db", env: "MALT_DSN", flag: "db", usage: "SQLite database file, file: DSN or postgres:// URL", str: &cfg.DSN},
+		{key: "static_dir", env: "MALT_STATIC_DIR", flag: "static", usage: "directory with index.html", str: &cfg.StaticDir},
+		{key: "secret", env: "MALT_SECRET", str: &cfg.Secret},
+		{key: "base_url", env: "MALT_BASE_URL", flag: "base-url", usage: "absolute site URL for feeds and links", str: &cfg.BaseURL},
+		{key: "read_timeout", env: "MALT_READ_TIMEOUT", flag: "read-timeout", usage: "time to read a request", dur: &cfg.ReadTimeout},
+		{key: "write_timeout", env: "MALT_WRITE_TIMEOUT", flag: "write-timeout", usage: "time to write a response", dur: &cfg.WriteTimeout},
+		{key: "idle_timeout", env: "MALT_IDLE_TIMEOUT", flag: "idle-timeout", usage: "keep-alive timeout", dur: &cfg.IdleTimeout},
+		{key: "bulk_timeout", env: "MALT_BULK_TIMEOUT", flag: "bulk-timeout", usage: "time to send a backup or receive a restore or import", dur: &cfg.BulkTimeout},
+		{key: "smtp_addr", env: "MALT_SMTP_ADDR", flag: "smtp-addr", usage: "SMTP server host:port for the newsletter", str: &cfg.SMTPAddr},
+		{key: "smtp_user", env: "MALT_SMTP_USER", flag: "smtp-user", usage: "SMTP username", str: &cfg.SMTPUser},
+		{key: "smtp_password", env: "MALT_SMTP_PASSWORD", str: &cfg.SMTPPassword},
+		{key: "smtp_from", env: "MALT_SMTP_FROM", flag: "smtp-from", usage: "newsletter sender address", str: &cfg.SMTPFrom},
+	}
+}
+
+// loadConfig registers the config flags, parses the command line and resolves cfg.
+// Anything invalid stops startup with every problem listed at once.
+func loadConfig() {
+	settings := configSettings()
+	flagValues := map[string]*string{}
+	for _, s := range settings {
+		if s.flag != "" {
+			flagValues[s.flag] = flag.String(s.flag, "", s.usage+" (env "+s.env+")")
+		}
+	}
+	configFile := flag.String("config", "", "config file (env MALT_CONFIG)")
+	flag.Parse()
+
+	set := func(s configSetting, value, from string) error {
+		if s.dur == nil {
+			*s.str = value
+			return nil
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a duration like 10s", from, value)
+		}
+		*s.dur = d
+		return nil
+	}
+
+	var problems []error
+	path := *configFile
+	if path == "" {
+		path = os.Getenv("MALT_CONFIG")
+	}
+	if path != "" {
+		file, err := readConfigFile(path)
+		if err != nil {
+			log.Fatalf("config: %v", err)
+		}
+		known := map[string]bool{}
+		for _, s := range settings {
+			known[s.key] = true
+			if v, ok := file[s.key]; ok {
+				problems = append(problems, set(s, v, path+": "+s.key))
+			}
+		}
+		for key := range file {
+			if !known[key] {
+				problems = append(problems, fmt.Errorf("%s: unknown setting %q", path, key))
+			}
+		}
+	}
+
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for _, s := range settings {
+		if v := os.Getenv(s.env); v != "" {
+			problems = append(problems, set(s, v, s.env))
+		}
+		if explicit[s.flag] {
+			problems = append(problems, set(s, *flagValues[s.flag], "-"+s.flag))
+		}
+	}
+
+	problems = append(problems, cfg.validate()...)
+	if err := errors.Join(problems...); err != nil {
+		log.Fatalf("config:\n%v", err)
+	}
+	if cfg.Secret == "" {
+		log.Println("config: no MALT_SECRET; only keys from /api/keys can sign in, and there are none until one exists")
+	}
+}
+
+// readConfigFile reads key = value lines; # starts a comment.
+func readConfigFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		values[strings.TrimSpace(key)] = unquote(value)
+	}
+	return values, scanner.Err()
+}
+
+func (c *Config) validate() []error {
+	var problems []error
+	for name, addr := range map[string]string{"listen": c.Listen, "tls_listen": c.TLSListen} {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %q is not host:port", name, addr))
+		}
+	}
+	for name, d := range map[string]time.Duration{"read_timeout": c.ReadTimeout, "write_timeout": c.WriteTimeout, "idle_timeout": c.IdleTimeout, "bulk_timeout": c.BulkTimeout} {
+		if d <= 0 {
+			problems = append(problems, fmt.Errorf("%s must be positive", name))
+		}
+	}
+	if _, err := os.Stat(filepath.Join(c.StaticDir, "index.html")); err != nil {
+		problems = append(problems, fmt.Errorf("static_dir: no index.html in %s", c.StaticDir))
+	}
+	if c.BaseURL != "" {
+		u, err := url.Parse(c.BaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Errorf("base_url: %q is not an http(s) URL", c.BaseURL))
+		}
+		c.BaseURL = strings.TrimSuffix(c.BaseURL, "/")
+	}
+	if c.DSN == "" {
+		problems = append(problems, errors.New("db must not be empty"))
+	}
+	if c.SMTPAddr != "" {
+		if _, _, err := net.SplitHostPort(c.SMTPAddr); err != nil {
+			problems = append(problems, fmt.Errorf("smtp_addr: %q is not host:port", c.SMTPAddr))
+		}
+		if _, err := mail.ParseAddress(c.SMTPFrom); err != nil {
+			problems = append(problems, fmt.Errorf("smtp_from: %q is not an email address", c.SMTPFrom))
+		}
+	}
+	return problems
+}
+
+// sqliteParams are added to every file: DSN that doesn't set them itself, keyed by what the
+// DSN would contain if it did.
+var sqliteParams = []struct{ key, param string }{
+	// The planet fetcher and friends write in the background: wait for the lock instead of failing
+	{"busy_timeout", "_pragma=busy_timeout(5000)"},
+	// Readers don't block the writer, nor it them
+	{"journal_mode", "_pragma=journal_mode(WAL)"},
+	// Safe with WAL, and a commit doesn't wait for fsync
+	{"synchronous", "_pragma=synchronous(NORMAL)"},
+	{"foreign_keys", "_pragma=foreign_keys(1)"},
+	// A transaction takes the write lock when it begins, so one that reads first can't be
+	// refused with "database is locked" when it gets to writing
+	{"_txlock", "_txlock=immediate"},
+}
+
+// dsn is cfg.DSN ready for sql.Open: a bare path becomes a file: DSN, with sqliteParams.
+func (c Config) dsn() string {
+	if strings.Contains(c.DSN, "://") {
+		return c.DSN
+	}
+	dsn := c.DSN
+	if !strings.HasPrefix(dsn, "file:") {
+		dsn = "file:" + dsn
+	}
+	for _, p := range sqliteParams {
+		if strings.Contains(dsn, p.key) {
+			continue
+		}
+		if strings.Contains(dsn, "?") {
+			dsn += "&" + p.param
+		} else {
+			dsn += "?" + p.param
+		}
+	}
+	return dsn
+}
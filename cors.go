@@ -0,0 +1,88 @@
+package main
+
+import (
+	"cmp"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// --- CORS: the API, for front ends served from somewhere else ---
+//
+// Off unless MALT_CORS_ORIGINS lists who may call /api/ from a browser: comma-separated
+// origins like https://blog.example.com,http://localhost:5173, or * for anyone. Keys travel
+// in X-MALT-KEY rather than cookies, so no credentials are allowed and a stranger's page
+// can't borrow a visitor's session. MALT_CORS_METHODS and MALT_CORS_HEADERS override what
+// preflights allow; MALT_CORS_MAX_AGE (seconds, default 600) is how long browsers may
+// remember the answer.
+
+const (
+	corsMethods = "GET, HEAD, POST, PUT, PATCH, DELETE"
+	corsHeaders = "Content-Type, X-MALT-KEY, If-None-Match, If-Modified-Since"
+	// Response headers scripts may read besides the basic ones
+	corsExpose = "ETag, Last-Modified, Retry-After, X-Request-ID, X-Robots-Tag"
+)
+
+// corsOrigins parses MALT_CORS_ORIGINS. A malformed origin stops startup: a typo would
+// otherwise look like the browser's fault.
+func corsOrigins() []string {
+	var origins []string
+	for _, o := range strings.Split(os.Getenv("MALT_CORS_ORIGINS"), ",") {
+		o = strings.TrimSuffix(strings.TrimSpace(o), "/")
+		if o == "" {
+			continue
+		}
+		if o != "*" {
+			u, err := url.Parse(o)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+				log.Fatalf("MALT_CORS_ORIGINS: %q is not an origin like https://example.com", o)
+			}
+			o = u.Scheme + "://" + strings.ToLower(u.Host)
+		}
+		origins = append(origins, o)
+	}
+	return origins
+}
+
+// cors answers preflights for /api/ and marks its responses readable by the allowed origins.
+func cors(next http.Handler) http.Handler {
+	origins := corsOrigins()
+	if len(origins) == 0 {
+		return next
+	}
+	methods := cmp.Or(os.Getenv("MALT_CORS_METHODS"), corsMethods)
+	headers := cmp.Or(os.Getenv("MALT_CORS_HEADERS"), corsHeaders)
+	maxAge := strconv.Itoa(max(0, envInt("MALT_CORS_MAX_AGE", 600)))
+	anyone := slices.Contains(origins, "*")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		if !anyone && !slices.Contains(origins, strings.ToLower(origin)) {
+			next.ServeHTTP(w, r) // The browser keeps the response from the page
+			return
+		}
+		h.Set("Access-Control-Allow-Origin", origin)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Add("Vary", "Access-Control-Request-Method, Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", methods)
+			h.Set("Access-Control-Allow-Headers", headers)
+			h.Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.Set("Access-Control-Expose-Headers", corsExpose)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// --- Disqus import: bring old threads home into the native comments table ---
+
+const maxDisqusExport = 64 << 20
+
+type disqusThread struct {
+	DsqID      string `xml:"http://disqus.com/disqus-internals id,attr"`
+	Identifier string `xml:"id"`
+	Link       string `xml:"link"`
+}
+
+type disqusPost struct {
+	DsqID     string    `xml:"http://disqus.com/disqus-internals id,attr"`
+	Message   string    `xml:"message"`
+	CreatedAt time.Time `xml:"createdAt"`
+	IsDeleted bool      `xml:"isDeleted"`
+	IsSpam    bool      `xml:"isSpam"`
+	Author    struct {
+		Name     string `xml:"name"`
+		Email    string `xml:"email"`
+		Username string `xml:"username"`
+	} `xml:"author"`
+	Thread struct {
+		DsqID string `xml:"http://disqus.com/disqus-internals id,attr"`
+	} `xml:"thread"`
+	Parent struct {
+		DsqID string `xml:"http://disqus.com/disqus-internals id,attr"`
+	} `xml:"parent"`
+}
+
+// DisqusImport is the summary returned to whoever ran the import.
+type DisqusImport struct {
+	Imported       int      `json:"imported"`
+	AlreadyPresent int      `json:"already_present"`
+	Skipped        int      `json:"skipped"`           // Deleted, spam, or on an unmatched thread
+	UnmatchedLinks []string `json:"unmatched_threads"` // Threads that don't map to any post
+}
+
+// threadSlug maps a Disqus thread onto an existing post: /post/{slug} in the link, then the
+// thread identifier, then the link's last path segment.
+func threadSlug(tx *sql.Tx, t disqusThread) (string, bool) {
+	var candidates []string
+	if u, err := url.Parse(t.Link); err == nil {
+		path := strings.Trim(u.Path, "/")
+		if rest, ok := strings.CutPrefix(path, "post/"); ok {
+			candidates = append(candidates, rest)
+		}
+		candidates = append(candidates, t.Identifier, path[strings.LastIndex(path, "/")+1:])
+	} else {
+		candidates = append(candidates, t.Identifier)
+	}
+
+	for _, c := range candidates {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if c == "" {
+			continue
+		}
+		var exists bool
+		err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM posts WHERE slug = ?)", c).Scan(&exists)
+		if err == nil && exists {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// readDisqusExport parses a Disqus XML export into its threads and posts, in document order.
+func readDisqusExport(body io.Reader) (threads []disqusThread, posts []disqusPost, err error) {
+	d := xml.NewDecoder(body)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return threads, posts, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "thread":
+			var t disqusThread
+			if err := d.DecodeElement(&t, &start); err != nil {
+				return nil, nil, err
+			}
+			threads = append(threads, t)
+		case "post":
+			var p disqusPost
+			if err := d.DecodeElement(&p, &start); err != nil {
+				return nil, nil, err
+			}
+			posts = append(posts, p)
+		}
+	}
+}
+
+// POST /api/import/disqus - Import a Disqus XML export (the request body) into native comments.
+// Re-running the same export is safe: comments are keyed by their Disqus ID.
+func handleImportDisqus(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	// The whole upload is read before the write transaction opens, so a slow client
+	// doesn't hold the writer
+	threadList, posts, err := readDisqusExport(http.MaxBytesReader(w, r.Body, maxDisqusExport))
+	if err != nil {
+		httpError(w, r, "Bad Disqus export: "+err.Error(), 400)
+		return
+	}
+
+	summary := DisqusImport{UnmatchedLinks: []string{}}
+	err = withTx(r.Context(), func(tx *sql.Tx) error {
+		threads := map[string]string{} // Disqus thread ID -> post slug ("" when unmatched)
+		imported := map[string]int64{} // Disqus post ID -> comment ID, for wiring up parents
+		parents := map[string]string{} // Disqus post ID -> Disqus parent ID
+
+		for _, t := range threadList {
+			slug, found := threadSlug(tx, t)
+			threads[t.DsqID] = slug
+			if !found {
+				summary.UnmatchedLinks = append(summary.UnmatchedLinks, t.Link)
+			}
+		}
+
+		for _, p := range posts {
+			slug := threads[p.Thread.DsqID]
+			if p.IsDeleted || p.IsSpam || slug == "" {
+				summary.Skipped++
+				continue
+			}
+
+			name := p.Author.Name
+			if name == "" {
+				name = p.Author.Username
+			}
+			var id int64
+			err := tx.QueryRow(`
+				INSERT INTO comments (post_slug, author_name, author_email, content, status, created_at, source, source_id)
+				VALUES (?, ?, ?, ?, 'approved', ?, 'disqus', ?)
+				ON CONFLICT DO NOTHING RETURNING id
+			`, slug, name, p.Author.Email, strings.TrimSpace(p.Message), p.CreatedAt.UTC(), p.DsqID).Scan(&id)
+			if err == sql.ErrNoRows {
+				summary.AlreadyPresent++
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			summary.Imported++
+			imported[p.DsqID] = id
+			if p.Parent.DsqID != "" {
+				parents[p.DsqID] = p.Parent.DsqID
+			}
+		}
+
+		// Parents can appear after their replies in the export, so thread them up at the end
+		for child, parent := range parents {
+			_, err := tx.Exec(`
+				UPDATE comments SET parent_id = (SELECT id FROM comments WHERE source = 'disqus' AND source_id = ?)
+				WHERE id = ?`, parent, imported[child])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		httpError(w, r, "Failed to save: "+err.Error(), 500)
+		return
+	}
+
+	jsonResponse(w, summary)
+}
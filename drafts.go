@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// --- Drafts: pushed like any post with "status": "draft", invisible until published ---
+
+// GET /api/drafts - Work in progress, most recently pushed first
+func handleListDrafts(w http.ResponseWriter, r *http.Request) {
+	if !requireScope(w, r, scopePublish) {
+		return
+	}
+
+	rows, err := db.Query("SELECT slug, title, description, published_at, type, link_url, status FROM posts WHERE status = 'draft' AND deleted_at IS NULL ORDER BY published_at DESC")
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	drafts := []Post{}
+	for rows.Next() {
+		var p Post
+		if err := rows.Scan(&p.Slug, &p.Title, &p.Description, &p.PublishedAt, &p.Type, &p.LinkURL, &p.Status); err != nil {
+			continue
+		}
+		drafts = append(drafts, p)
+	}
+
+	jsonResponse(w, drafts)
+}
+
+// POST /api/posts/{slug}/publish - Take a draft live, dated now
+func handlePublishDraft(w http.ResponseWriter, r *http.Request) {
+	if !requireScope(w, r, scopePublish) {
+		return
+	}
+
+	slug := r.PathValue("slug")
+	now := time.Now().UTC()
+	result, err := execWrite("UPDATE posts SET status = 'published', published_at = ?, updated_at = ? WHERE slug = ? AND status = 'draft' AND deleted_at IS NULL", now, now, slug)
+	if err != nil {
+		httpError(w, r, "Database error: "+err.Error(), 500)
+		return
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		var exists bool
+		db.QueryRow("SELECT EXISTS(SELECT 1 FROM posts WHERE slug = ? AND deleted_at IS NULL)", slug).Scan(&exists)
+		if exists {
+			httpError(w, r, "Post is already published", 409)
+		} else {
+			httpError(w, r, "Post not found", 404)
+		}
+		return
+	}
+
+	postChanged(r, slug, false)
+
+	jsonResponse(w, map[string]any{"status": "published", "link": "/post/" + slug, "published_at": now})
+}
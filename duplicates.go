@@ -0,0 +1,125 @@
+package main
+
+import (
+	"database/sql"
+	"hash/fnv"
+	"log"
+	"math/bits"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// --- Duplicate detection: simhash over word shingles, so near-copies land close together ---
+//
+// Every post's fingerprint is stored on publish. A new post within MALT_DUPLICATE_DISTANCE
+// differing bits (default 3 of 64) of an existing one is reported in the publish response.
+
+// Duplicate is an existing post that looks like the one being published.
+type Duplicate struct {
+	Slug       string  `json:"slug"`
+	Title      string  `json:"title"`
+	Similarity float64 `json:"similarity"` // 1 = identical fingerprints
+}
+
+func duplicateDistance() int {
+	if n, err := strconv.Atoi(os.Getenv("MALT_DUPLICATE_DISTANCE")); err == nil && n >= 0 {
+		return n
+	}
+	return 3
+}
+
+// simhash fingerprints a post's title and text. Empty text gives 0, which is never compared.
+func simhash(title, format, content string) uint64 {
+	body, err := renderContent(format, content)
+	if err != nil {
+		body = content
+	}
+	words := strings.FieldsFunc(strings.ToLower(title+" "+plainText(body)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) == 0 {
+		return 0
+	}
+
+	// Three-word shingles, or the whole thing when it's shorter than that
+	const size = 3
+	var shingles []string
+	for i := 0; i+size <= len(words); i++ {
+		shingles = append(shingles, strings.Join(words[i:i+size], " "))
+	}
+	if len(shingles) == 0 {
+		shingles = []string{strings.Join(words, " ")}
+	}
+
+	var weights [64]int
+	for _, s := range shingles {
+		h := fnv.New64a()
+		h.Write([]byte(s))
+		sum := h.Sum64()
+		for bit := range 64 {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var hash uint64
+	for bit, w := range weights {
+		if w > 0 {
+			hash |= 1 << bit
+		}
+	}
+	return hash
+}
+
+// findDuplicates compares hash against every other post, fingerprinting older posts that
+// predate the simhash column on the way.
+func findDuplicates(slug string, hash uint64) []Duplicate {
+	if hash == 0 {
+		return nil
+	}
+
+	rows, err := db.Query("SELECT slug, title, content, content_format, simhash FROM posts WHERE slug != ? AND deleted_at IS NULL", slug)
+	if err != nil {
+		log.Printf("duplicates: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	maxDistance := duplicateDistance()
+	backfill := map[string]uint64{}
+	var dupes []Duplicate
+	for rows.Next() {
+		var s, title, content, format string
+		var stored sql.NullInt64
+		if err := rows.Scan(&s, &title, &content, &format, &stored); err != nil {
+			continue
+		}
+
+		other := uint64(stored.Int64)
+		if !stored.Valid {
+			other = simhash(title, format, content)
+			backfill[s] = other
+		}
+		if other == 0 {
+			continue
+		}
+
+		if d := bits.OnesCount64(hash ^ other); d <= maxDistance {
+			dupes = append(dupes, Duplicate{Slug: s, Title: title, Similarity: 1 - float64(d)/64})
+		}
+	}
+	rows.Close()
+
+	for s, h := range backfill {
+		execWrite("UPDATE posts SET simhash = ? WHERE slug = ?", int64(h), s)
+	}
+
+	sort.Slice(dupes, func(i, j int) bool { return dupes[i].Similarity > dupes[j].Similarity })
+	return dupes
+}
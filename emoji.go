@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// --- Emoji: :tada: shortcodes become emoji when a post is rendered ---
+//
+// The common GitHub/Slack names are built in. Site-specific emoji come from a JSON file of
+// name -> image URL named by MALT_EMOJI, and win over the built-ins. MALT_EMOJI_SHORTCODES=0
+// turns expansion off.
+
+var shortcode = regexp.MustCompile(`:([a-z0-9_+-]+):`)
+
+// customEmoji is MALT_EMOJI, loaded once at startup.
+var customEmoji = map[string]string{}
+
+var builtinEmoji = map[string]string{
+	"+1": "👍", "-1": "👎", "thumbsup": "👍", "thumbsdown": "👎", "ok_hand": "👌", "wave": "👋",
+	"clap": "👏", "raised_hands": "🙌", "pray": "🙏", "muscle": "💪", "point_right": "👉", "point_left": "👈",
+	"point_up": "☝️", "point_down": "👇", "v": "✌️", "handshake": "🤝", "eyes": "👀", "brain": "🧠",
+
+	"smile": "😄", "smiley": "😃", "grin": "😁", "grinning": "😀", "laughing": "😆", "joy": "😂",
+	"rofl": "🤣", "sweat_smile": "😅", "wink": "😉", "blush": "😊", "innocent": "😇", "heart_eyes": "😍",
+	"kissing_heart": "😘", "yum": "😋", "stuck_out_tongue": "😛", "sunglasses": "😎", "nerd_face": "🤓",
+	"thinking": "🤔", "neutral_face": "😐", "expressionless": "😑", "unamused": "😒", "roll_eyes": "🙄",
+	"grimacing": "😬", "relieved": "😌", "pensive": "😔", "sleepy": "😪", "sleeping": "😴", "mask": "😷",
+	"confused": "😕", "worried": "😟", "slightly_frowning_face": "🙁", "slightly_smiling_face": "🙂",
+	"upside_down_face": "🙃", "open_mouth": "😮", "hushed": "😯", "astonished": "😲", "flushed": "😳",
+	"scream": "😱", "cry": "😢", "sob": "😭", "angry": "😠", "rage": "😡", "exploding_head": "🤯",
+	"partying_face": "🥳", "shushing_face": "🤫", "zany_face": "🤪", "facepalm": "🤦", "shrug": "🤷",
+	"skull": "💀", "ghost": "👻", "alien": "👽", "robot": "🤖", "poop": "💩", "clown_face": "🤡",
+
+	"heart": "❤️", "orange_heart": "🧡", "yellow_heart": "💛", "green_heart": "💚", "blue_heart": "💙",
+	"purple_heart": "💜", "black_heart": "🖤", "broken_heart": "💔", "sparkling_heart": "💖", "100": "💯",
+	"fire": "🔥", "sparkles": "✨", "star": "⭐", "star2": "🌟", "zap": "⚡", "boom": "💥", "tada": "🎉",
+	"confetti_ball": "🎊", "balloon": "🎈", "gift": "🎁", "trophy": "🏆", "medal": "🏅", "crown": "👑",
+	"rocket": "🚀", "bulb": "💡", "warning": "⚠️", "no_entry": "⛔", "x": "❌", "white_check_mark": "✅",
+	"heavy_check_mark": "✔️", "question": "❓", "exclamation": "❗", "bangbang": "‼️", "memo": "📝",
+	"pencil": "📝", "pencil2": "✏️", "book": "📖", "books": "📚", "bookmark": "🔖", "link": "🔗",
+	"paperclip": "📎", "pushpin": "📌", "calendar": "📅", "date": "📅", "clock": "🕐", "hourglass": "⌛",
+	"lock": "🔒", "unlock": "🔓", "key": "🔑", "hammer": "🔨", "wrench": "🔧", "gear": "⚙️", "tools": "🛠️",
+	"bug": "🐛", "computer": "💻", "keyboard": "⌨️", "iphone": "📱", "email": "📧", "envelope": "✉️",
+	"inbox_tray": "📥", "outbox_tray": "📤", "package": "📦", "chart_with_upwards_trend": "📈",
+	"chart_with_downwards_trend": "📉", "bar_chart": "📊", "mag": "🔍", "bell": "🔔", "loudspeaker": "📢",
+	"mega": "📣", "speech_balloon": "💬", "thought_balloon": "💭", "zzz": "💤", "recycle": "♻️",
+	"construction": "🚧", "checkered_flag": "🏁", "triangular_flag_on_post": "🚩", "dart": "🎯",
+
+	"coffee": "☕", "tea": "🍵", "beer": "🍺", "beers": "🍻", "wine_glass": "🍷", "tumbler_glass": "🥃",
+	"cocktail": "🍸", "pizza": "🍕", "hamburger": "🍔", "taco": "🌮", "cake": "🍰", "birthday": "🎂",
+	"cookie": "🍪", "apple": "🍎", "avocado": "🥑", "hot_pepper": "🌶️", "popcorn": "🍿",
+
+	"sunny": "☀️", "cloud": "☁️", "umbrella": "☔", "snowflake": "❄️", "rainbow": "🌈", "ocean": "🌊",
+	"earth_africa": "🌍", "earth_americas": "🌎", "earth_asia": "🌏", "globe_with_meridians": "🌐",
+	"crescent_moon": "🌙", "seedling": "🌱", "evergreen_tree": "🌲", "deciduous_tree": "🌳",
+	"cactus": "🌵", "rose": "🌹", "sunflower": "🌻", "tulip": "🌷", "fallen_leaf": "🍂", "mushroom": "🍄",
+
+	"cat": "🐱", "dog": "🐶", "fox_face": "🦊", "bear": "🐻", "panda_face": "🐼", "koala": "🐨",
+	"tiger": "🐯", "lion": "🦁", "cow": "🐮", "pig": "🐷", "frog": "🐸", "monkey": "🐒", "see_no_evil": "🙈",
+	"chicken": "🐔", "penguin": "🐧", "bird": "🐦", "owl": "🦉", "snake": "🐍", "turtle": "🐢",
+	"octopus": "🐙", "whale": "🐳", "dolphin": "🐬", "fish": "🐟", "crab": "🦀", "bee": "🐝",
+	"butterfly": "🦋", "snail": "🐌", "unicorn": "🦄", "dragon": "🐉", "hamster": "🐹",
+
+	"airplane": "✈️", "car": "🚗", "bike": "🚲", "train": "🚆", "ship": "🚢", "house": "🏠",
+	"office": "🏢", "tent": "⛺", "camera": "📷", "movie_camera": "🎥", "tv": "📺", "radio": "📻",
+	"headphones": "🎧", "musical_note": "🎵", "notes": "🎶", "guitar": "🎸", "video_game": "🎮",
+	"art": "🎨", "soccer": "⚽", "basketball": "🏀", "running": "🏃", "money_with_wings": "💸",
+	"moneybag": "💰", "gem": "💎", "hourglass_flowing_sand": "⏳", "stopwatch": "⏱️", "alarm_clock": "⏰",
+	"arrow_right": "➡️", "arrow_left": "⬅️", "arrow_up": "⬆️", "arrow_down": "⬇️", "repeat": "🔁",
+	"new": "🆕", "free": "🆓", "cool": "🆒", "sos": "🆘", "copyright": "©️", "registered": "®️", "tm": "™️",
+}
+
+// loadEmoji reads the custom emoji map named by MALT_EMOJI. A broken file stops startup.
+func loadEmoji() {
+	path := os.Getenv("MALT_EMOJI")
+	if path == "" {
+		return
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("emoji: %v", err)
+	}
+	if err := json.Unmarshal(raw, &customEmoji); err != nil {
+		log.Fatalf("emoji %s: %v", path, err)
+	}
+	for name, src := range customEmoji {
+		if safeHref(src) == "#" {
+			log.Fatalf("emoji %s: %q has an unusable image URL", path, name)
+		}
+	}
+}
+
+// emojiHTML is what a :name: shortcode becomes, if it's one we know.
+func emojiHTML(name string) (string, bool) {
+	if src, ok := customEmoji[name]; ok {
+		return `<img class="emoji" src="` + html.EscapeString(src) + `" alt=":` + name + `:" title=":` + name + `:">`, true
+	}
+	if e, ok := builtinEmoji[name]; ok {
+		return e, true
+	}
+	return "", false
+}
+
+// expandEmoji replaces known shortcodes in text; escape handles everything in between.
+func expandEmoji(text string, escape func(string) string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range shortcode.FindAllStringSubmatchIndex(text, -1) {
+		out, ok := emojiHTML(text[m[2]:m[3]])
+		if !ok {
+			continue
+		}
+		b.WriteString(escape(text[last:m[0]]) + out)
+		last = m[1]
+	}
+	b.WriteString(escape(text[last:]))
+	return b.String()
+}
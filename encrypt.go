@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// --- Encryption at rest: backups leave the server as age files ---
+//
+// A backup is the whole database: drafts, subscribers' addresses, API key hashes, the
+// ActivityPub private key. Those are what's encrypted; public downloads like the blogroll
+// aren't. MALT_AGE_RECIPIENTS lists age public keys (age1..., comma or whitespace
+// separated); MALT_AGE_RECIPIENTS_FILE names a file of them, one per line, # for comments,
+// the same format age -R reads. With neither set, backups stay plaintext.
+
+var ageRecipients []age.Recipient
+
+// loadAgeRecipients parses the configured keys once at startup. A bad key stops startup:
+// silently writing plaintext would be worse.
+func loadAgeRecipients() {
+	keys := os.Getenv("MALT_AGE_RECIPIENTS")
+	if path := os.Getenv("MALT_AGE_RECIPIENTS_FILE"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("age recipients: %v", err)
+		}
+		defer f.Close()
+		recipients, err := age.ParseRecipients(f)
+		if err != nil {
+			log.Fatalf("age recipients %s: %v", path, err)
+		}
+		ageRecipients = append(ageRecipients, recipients...)
+	}
+
+	for _, key := range strings.FieldsFunc(keys, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' }) {
+		recipient, err := age.ParseX25519Recipient(key)
+		if err != nil {
+			log.Fatalf("age recipients: %v", err)
+		}
+		ageRecipients = append(ageRecipients, recipient)
+	}
+}
+
+func encryptionEnabled() bool {
+	return len(ageRecipients) > 0
+}
+
+// encryptTo wraps w so what's written to it comes out encrypted to every recipient.
+// Close finishes the age stream; it does not close w.
+func encryptTo(w io.Writer) (io.WriteCloser, error) {
+	return age.Encrypt(w, ageRecipients...)
+}
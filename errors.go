@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The fallback page when the active theme doesn't ship its own.
+// Themes override it with errors/<code>.html or errors/error.html.
+const defaultErrorPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>{{.Code}} - {{.Title}}</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, Helvetica, Arial, sans-serif; max-width: 680px; margin: 0 auto; padding: 2rem 1.5rem; line-height: 1.6; }
+        @media (prefers-color-scheme: dark) { body { background: #111111; color: #e1e1e1; } a { color: #4da3ff; } }
+    </style>
+</head>
+<body>
+    <h1>{{.Code}} - {{.Title}}</h1>
+    <p>{{.Message}}</p>
+    {{if .RequestID}}<p><small>Request ID: {{.RequestID}}</small></p>{{end}}
+    <p><a href="/">Go back home</a></p>
+</body>
+</html>`
+
+var fallbackErrorTmpl = template.Must(template.New("error").Parse(defaultErrorPage))
+
+type errorPage struct {
+	Code      int
+	Title     string
+	Message   string
+	RequestID string
+}
+
+// httpError replaces http.Error: JSON for /api/* (and clients asking for it), themed HTML for site routes.
+func httpError(w http.ResponseWriter, r *http.Request, msg string, code int) {
+	id := requestID(r)
+	if code >= 500 {
+		slog.Error("request failed", "request_id", id, "status", code, "error", msg, "path", r.URL.Path)
+	}
+
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		body := map[string]any{"error": msg, "status": code}
+		if id != "" {
+			body["request_id"] = id
+		}
+		json.NewEncoder(w).Encode(body)
+		return
+	}
+
+	page := errorPage{Code: code, Title: http.StatusText(code), Message: msg, RequestID: id}
+	// Don't leak internals (SQL errors etc.) onto public HTML pages.
+	if code >= 500 {
+		page.Message = "Something broke on our side. Try again in a bit."
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := errorTemplate(code).Execute(w, page); err != nil {
+		log.Printf("error page %d: %v", code, err)
+	}
+}
+
+func wantsJSON(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+// errorTemplate picks errors/<code>.html, then errors/error.html from the theme, then the built-in page.
+func errorTemplate(code int) *template.Template {
+	return themeTemplate(fallbackErrorTmpl, filepath.Join("errors", strconv.Itoa(code)+".html"), filepath.Join("errors", "error.html"))
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// --- Experiments: alternative titles/descriptions, served per visitor and counted per arm ---
+
+// Variant is one arm of a post's title experiment. Arm 0 is the control: the post's own title.
+type Variant struct {
+	ID          int64   `json:"id"`
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	Impressions int     `json:"impressions"` // Times the title was shown in the post list
+	Views       int     `json:"views"`       // Times the post itself was opened
+	CTR         float64 `json:"ctr"`         // Views per impression
+}
+
+// loadVariants returns the alternative titles per post, in creation order. An empty slug loads every post.
+func loadVariants(slug string) (map[string][]Variant, error) {
+	query := "SELECT id, post_slug, title, description FROM post_variants"
+	args := []any{}
+	if slug != "" {
+		query += " WHERE post_slug = ?"
+		args = append(args, slug)
+	}
+
+	rows, err := db.Query(query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := map[string][]Variant{}
+	for rows.Next() {
+		var v Variant
+		var s string
+		if err := rows.Scan(&v.ID, &s, &v.Title, &v.Description); err != nil {
+			continue
+		}
+		variants[s] = append(variants[s], v)
+	}
+	return variants, rows.Err()
+}
+
+// applyVariant deterministically assigns the visitor an arm (same IP + User-Agent, same arm)
+// and swaps its title/description into p. It returns the arm ID, 0 for the control.
+func applyVariant(p *Post, variants []Variant, r *http.Request) int64 {
+	if len(variants) == 0 {
+		return 0
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(p.Slug + "|" + clientIP(r) + "|" + r.UserAgent()))
+	arm := int(h.Sum32() % uint32(len(variants)+1))
+	if arm == 0 {
+		return 0
+	}
+
+	v := variants[arm-1]
+	if v.Title != "" {
+		p.Title = v.Title
+	}
+	if v.Description != "" {
+		p.Description = v.Description
+	}
+	return v.ID
+}
+
+// recordArms bumps a counter ("impressions" or "views") for the arm each post was served with.
+// It runs off the request path; a lost count is not worth a slow page.
+func recordArms(counter string, arms map[string]int64) {
+	if len(arms) == 0 || !featureEnabled("analytics") {
+		return
+	}
+
+	go func() {
+		for slug, arm := range arms {
+			_, err := execWrite(`
+				INSERT INTO experiment_stats (post_slug, variant_id, `+counter+`) VALUES (?, ?, 1)
+				ON CONFLICT(post_slug, variant_id) DO UPDATE SET `+counter+` = experiment_stats.`+counter+` + 1
+			`, slug, arm)
+			if err != nil {
+				log.Printf("experiments: %s: %v", slug, err)
+			}
+		}
+	}()
+}
+
+// GET /api/posts/{slug}/variants - The experiment so far, control first
+func handleListVariants(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	slug := r.PathValue("slug")
+	var control Variant
+	if err := db.QueryRow("SELECT title, description FROM posts WHERE slug = ? AND deleted_at IS NULL", slug).Scan(&control.Title, &control.Description); err != nil {
+		httpError(w, r, "Post not found", 404)
+		return
+	}
+
+	variants, err := loadVariants(slug)
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	arms := append([]Variant{control}, variants[slug]...)
+
+	rows, err := db.Query("SELECT variant_id, impressions, views FROM experiment_stats WHERE post_slug = ?", slug)
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var impressions, views int
+		if err := rows.Scan(&id, &impressions, &views); err != nil {
+			continue
+		}
+		for i := range arms {
+			if arms[i].ID == id {
+				arms[i].Impressions, arms[i].Views = impressions, views
+			}
+		}
+	}
+	for i := range arms {
+		if arms[i].Impressions > 0 {
+			arms[i].CTR = float64(arms[i].Views) / float64(arms[i].Impressions)
+		}
+	}
+
+	jsonResponse(w, arms)
+}
+
+// POST /api/posts/{slug}/variants - Add an alternative title/description
+func handleCreateVariant(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	var v Variant
+	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+		httpError(w, r, "Bad JSON", 400)
+		return
+	}
+	v.Title, v.Description = strings.TrimSpace(v.Title), strings.TrimSpace(v.Description)
+	if v.Title == "" && v.Description == "" {
+		httpError(w, r, "A variant needs a title or a description", 400)
+		return
+	}
+	// The winner's text is written onto the post, so it's held to the post's limits
+	var fields []FieldError
+	if utf8.RuneCountInString(v.Title) > maxTitleLen {
+		fields = append(fields, FieldError{"title", fmt.Sprintf("must be at most %d characters", maxTitleLen)})
+	}
+	if utf8.RuneCountInString(v.Description) > maxDescriptionLen {
+		fields = append(fields, FieldError{"description", fmt.Sprintf("must be at most %d characters", maxDescriptionLen)})
+	}
+	if len(fields) > 0 {
+		validationFailed(w, r, fields)
+		return
+	}
+
+	slug := r.PathValue("slug")
+	var err error
+	v.ID, err = insertID(`
+		INSERT INTO post_variants (post_slug, title, description)
+		SELECT slug, ?, ? FROM posts WHERE slug = ?`, v.Title, v.Description, slug)
+	if err == sql.ErrNoRows {
+		httpError(w, r, "Post not found", 404)
+		return
+	}
+	if err != nil {
+		httpError(w, r, "Failed to save: "+err.Error(), 500)
+		return
+	}
+
+	jsonResponse(w, v)
+}
+
+// DELETE /api/posts/{slug}/variants/{id} - Drop an arm from the experiment
+func handleDeleteVariant(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	slug, id := r.PathValue("slug"), r.PathValue("id")
+	result, err := execWrite("DELETE FROM post_variants WHERE post_slug = ? AND id = ?", slug, id)
+	if err != nil {
+		httpError(w, r, "Database error: "+err.Error(), 500)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		httpError(w, r, "Variant not found", 404)
+		return
+	}
+	execWrite("DELETE FROM experiment_stats WHERE post_slug = ? AND variant_id = ?", slug, id)
+
+	jsonResponse(w, map[string]string{"status": "deleted", "id": id})
+}
+
+// POST /api/posts/{slug}/variants/{id}/winner - End the experiment. The winning arm becomes the
+// post's title/description (0 keeps the control) and all variants and counts are cleared.
+func handlePickVariant(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	slug := r.PathValue("slug")
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httpError(w, r, "Variant not found", 404)
+		return
+	}
+
+	wasLive := postLive(slug)
+	found := true
+	err = withTx(r.Context(), func(tx *sql.Tx) error {
+		if id != 0 {
+			result, err := tx.Exec(`
+				UPDATE posts SET
+					title = COALESCE(NULLIF(v.title, ''), posts.title),
+					description = COALESCE(NULLIF(v.description, ''), posts.description),
+					updated_at = ?
+				FROM post_variants v
+				WHERE v.post_slug = posts.slug AND posts.slug = ? AND v.id = ?`, time.Now().UTC(), slug, id)
+			if err != nil {
+				return err
+			}
+			if n, _ := result.RowsAffected(); n == 0 {
+				found = false
+				return nil
+			}
+		}
+
+		if _, err := tx.Exec("DELETE FROM post_variants WHERE post_slug = ?", slug); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM experiment_stats WHERE post_slug = ?", slug); err != nil {
+			return err
+		}
+		return recordRevision(tx, slug, "variant")
+	})
+	if err != nil {
+		httpError(w, r, "Database error: "+err.Error(), 500)
+		return
+	}
+	if !found {
+		httpError(w, r, "Variant not found", 404)
+		return
+	}
+	if id != 0 {
+		postChanged(r, slug, wasLive)
+	}
+
+	jsonResponse(w, map[string]string{"status": "decided", "slug": slug, "winner": r.PathValue("id")})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// --- Static export: the whole site as plain files, for GitHub Pages, S3 and friends ---
+//
+// `single-malt -export ./out` runs the same handlers the server does and writes what they
+// return: pages come from the SSR templates, feeds and the sitemap from their handlers.
+// Posts go to post/{slug}/index.html so /post/{slug} resolves on any static host. Pages
+// leave out the SPA's script, since there's no API behind them.
+
+type staticExportKey struct{}
+
+// isStaticExport reports whether r is one of exportStatic's own requests.
+func isStaticExport(r *http.Request) bool {
+	return r.Context().Value(staticExportKey{}) != nil
+}
+
+var shellScripts = regexp.MustCompile(`(?s)<script\b.*?</script>\s*`)
+
+// exportStatic writes every public page, feed and asset under dir.
+func exportStatic(dir string) error {
+	base := cfg.BaseURL
+	if base == "" {
+		base = "http://localhost:8080"
+		log.Printf("export: no base URL (-base-url or MALT_BASE_URL); feeds and the sitemap will point at %s", base)
+	}
+
+	save := func(name string, h http.HandlerFunc, path string, values ...string) error {
+		req := httptest.NewRequest("GET", base+path, nil)
+		req = req.WithContext(context.WithValue(req.Context(), staticExportKey{}, true))
+		req.Header.Set("Accept", "text/html")
+		for i := 0; i+1 < len(values); i += 2 {
+			req.SetPathValue(values[i], values[i+1])
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		if rec.Code != http.StatusOK && !(name == "404.html" && rec.Code == http.StatusNotFound) {
+			return fmt.Errorf("%s: %d %s", path, rec.Code, rec.Body.String())
+		}
+
+		out := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(out, rec.Body.Bytes(), 0o644)
+	}
+
+	pages := []struct {
+		name string
+		h    http.HandlerFunc
+		path string
+	}{
+		{"index.html", handleIndex, "/"},
+		{"links/index.html", handleLinksPage, "/links"},
+		{"feed.xml", handleRSS, "/feed.xml"},
+		{"atom.xml", handleAtom, "/atom.xml"},
+		{"sitemap.xml", handleSitemap, "/sitemap.xml"},
+		{"robots.txt", handleRobotsTxt, "/robots.txt"},
+		{"blogroll.opml", handleBlogrollOPML, "/blogroll.opml"},
+		{"404.html", func(w http.ResponseWriter, r *http.Request) {
+			httpError(w, r, "Nothing lives at this address.", 404)
+		}, "/404"},
+	}
+	for _, p := range pages {
+		if err := save(p.name, p.h, p.path); err != nil {
+			return err
+		}
+	}
+
+	// The sitemap is an index once it outgrows one file
+	req := httptest.NewRequest("GET", base+"/sitemap.xml", nil)
+	urls, err := sitemapURLs(req)
+	if err != nil {
+		return err
+	}
+	if size := sitemapPageSize(); len(urls) > size {
+		for page := 1; (page-1)*size < len(urls); page++ {
+			name := strconv.Itoa(page) + ".xml"
+			if err := save("sitemaps/"+name, handleSitemapPage, "/sitemaps/"+name, "page", name); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Every published post that's already out; drafts and scheduled posts stay home
+	rows, err := db.Query("SELECT slug, published_at FROM posts WHERE status = 'published' AND deleted_at IS NULL")
+	if err != nil {
+		return err
+	}
+	var slugs []string
+	now := time.Now()
+	for rows.Next() {
+		var slug string
+		var at time.Time
+		if rows.Scan(&slug, &at) == nil && !at.After(now) {
+			slugs = append(slugs, slug)
+		}
+	}
+	rows.Close()
+	for _, slug := range slugs {
+		if err := save("post/"+slug+"/index.html", handlePostPage, "/post/"+slug, "slug", slug); err != nil {
+			return err
+		}
+	}
+
+	rows, err = db.Query("SELECT id FROM media")
+	if err != nil {
+		return err
+	}
+	var media []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			media = append(media, id)
+		}
+	}
+	rows.Close()
+	for _, id := range media {
+		if err := save("media/"+id, handleServeMedia, "/media/"+id, "id", id); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("export: %d posts and %d media files written to %s", len(slugs), len(media), dir)
+	return nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"cmp"
+	"encoding/xml"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// --- Feeds: RSS 2.0 at /feed.xml and Atom at /atom.xml, full content included ---
+
+type rssDoc struct {
+	XMLName   xml.Name `xml:"rss"`
+	Version   string   `xml:"version,attr"`
+	ContentNS string   `xml:"xmlns:content,attr"`
+	AtomNS    string   `xml:"xmlns:atom,attr"`
+	DCNS      string   `xml:"xmlns:dc,attr"`
+	Channel   struct {
+		Title         string `xml:"title"`
+		Link          string `xml:"link"`
+		Self          rssSelf
+		Description   string    `xml:"description"`
+		LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+		Items         []rssItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+type rssSelf struct {
+	XMLName xml.Name `xml:"atom:link"`
+	Href    string   `xml:"href,attr"`
+	Rel     string   `xml:"rel,attr"`
+	Type    string   `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        string   `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Description string   `xml:"description"`
+	Creator     string   `xml:"dc:creator,omitempty"` // RSS's own <author> wants an email address
+	Categories  []string `xml:"category"`
+	Content     struct {
+		Text string `xml:",cdata"`
+	} `xml:"content:encoded"`
+}
+
+type atomDoc struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Link       atomLink       `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Summary    string         `xml:"summary,omitempty"`
+	Author     *atomPerson    `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomText       `xml:"content"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+func feedSize() int {
+	if n, err := strconv.Atoi(os.Getenv("MALT_FEED_SIZE")); err == nil && n > 0 {
+		return n
+	}
+	return 20
+}
+
+// feedPosts returns the newest published posts, fully rendered. Scheduled posts wait their turn.
+func feedPosts(r *http.Request) ([]Post, error) {
+	rows, err := db.Query("SELECT slug FROM posts WHERE status = 'published' AND deleted_at IS NULL ORDER BY published_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	var slugs []string
+	for rows.Next() {
+		var slug string
+		if rows.Scan(&slug) == nil {
+			slugs = append(slugs, slug)
+		}
+	}
+	rows.Close()
+
+	now := time.Now()
+	posts := []Post{}
+	for _, slug := range slugs {
+		if len(posts) == feedSize() {
+			break
+		}
+		p, err := store.GetPost(slug)
+		if err != nil || p.PublishedAt.After(now) {
+			continue
+		}
+		if p.ContentHTML, err = renderPostHTML(p, siteHost(r.Host)); err != nil {
+			return nil, err
+		}
+		posts = append(posts, p)
+	}
+	return posts, nil
+}
+
+// GET /feed.xml - RSS 2.0
+func handleRSS(w http.ResponseWriter, r *http.Request) {
+	posts, err := feedPosts(r)
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+
+	base := baseURL(r)
+	doc := rssDoc{Version: "2.0", ContentNS: "http://purl.org/rss/1.0/modules/content/", AtomNS: "http://www.w3.org/2005/Atom", DCNS: "http://purl.org/dc/elements/1.1/"}
+	doc.Channel.Title = siteTitle()
+	doc.Channel.Link = base + "/"
+	doc.Channel.Self = rssSelf{Href: base + "/feed.xml", Rel: "self", Type: "application/rss+xml"}
+	doc.Channel.Description = siteTitle()
+	if len(posts) > 0 {
+		doc.Channel.LastBuildDate = posts[0].PublishedAt.UTC().Format(time.RFC1123Z)
+	}
+
+	for _, p := range posts {
+		link := base + "/post/" + p.Slug
+		item := rssItem{Title: p.Title, Link: link, GUID: link, PubDate: p.PublishedAt.UTC().Format(time.RFC1123Z), Description: cmp.Or(p.Description, p.Excerpt), Categories: p.Tags}
+		if p.Author != nil {
+			item.Creator = p.Author.Name
+		}
+		item.Content.Text = p.ContentHTML
+		doc.Channel.Items = append(doc.Channel.Items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(doc)
+}
+
+// GET /atom.xml - Atom 1.0
+func handleAtom(w http.ResponseWriter, r *http.Request) {
+	posts, err := feedPosts(r)
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+
+	base := baseURL(r)
+	doc := atomDoc{
+		Title:   siteTitle(),
+		ID:      base + "/",
+		Updated: time.Unix(0, 0).UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: base + "/"},
+			{Href: base + "/atom.xml", Rel: "self", Type: "application/atom+xml"},
+		},
+	}
+	var updated time.Time
+	for _, p := range posts {
+		if t := lastMod(p); t.After(updated) {
+			updated = t
+			doc.Updated = t.Format(time.RFC3339)
+		}
+	}
+
+	for _, p := range posts {
+		link := base + "/post/" + p.Slug
+		entry := atomEntry{
+			Title:     p.Title,
+			ID:        link,
+			Link:      atomLink{Href: link},
+			Published: p.PublishedAt.UTC().Format(time.RFC3339),
+			Updated:   lastMod(p).Format(time.RFC3339),
+			Summary:   cmp.Or(p.Description, p.Excerpt),
+			Content:   atomText{Type: "html", Body: p.ContentHTML},
+		}
+		if p.Author != nil {
+			entry.Author = &atomPerson{Name: p.Author.Name, URI: p.Author.URL}
+		}
+		for _, t := range p.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: t})
+		}
+		doc.Entries = append(doc.Entries, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(doc)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Feature flags: heavy subsystems stay off unless wanted ---
+//
+// A flag's boot default comes from MALT_FEATURE_<NAME> (e.g. MALT_FEATURE_PLANET=1).
+// Toggling it through the API stores an override in feature_flags, which wins over env.
+// Instances sharing a database pick up each other's toggles within a minute.
+
+// features lists every optional subsystem that consults a flag.
+var features = map[string]string{
+	"comments":    "Native comments and the Disqus importer",
+	"analytics":   "Impression and view counting",
+	"federation":  "Mastodon and Bluesky replies under posts",
+	"activitypub": "The blog as a followable ActivityPub account",
+	"newsletter":  "Email subscriptions and new posts by email",
+	"planet":      "The feed aggregator, its API and page",
+	"experiments": "Alternative titles/descriptions served per visitor",
+}
+
+// Flag is one feature as the admin sees it.
+type Flag struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+	Source      string `json:"source"` // "db", "env" or "default"
+}
+
+var (
+	flagsMu        sync.RWMutex
+	flagsOverrides = map[string]bool{} // Mirror of feature_flags; checked on hot paths
+)
+
+// loadFlags reads the stored overrides into memory at startup, and again every minute.
+func loadFlags() {
+	if err := readFlags(); err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		for range time.Tick(time.Minute) {
+			if err := readFlags(); err != nil {
+				log.Printf("flags: %v", err)
+			}
+		}
+	}()
+}
+
+// readFlags replaces the mirror with what feature_flags holds now.
+func readFlags() error {
+	rows, err := db.Query("SELECT name, enabled FROM feature_flags")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	overrides := map[string]bool{}
+	for rows.Next() {
+		var name string
+		var enabled bool
+		if rows.Scan(&name, &enabled) == nil {
+			overrides[name] = enabled
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	flagsMu.Lock()
+	flagsOverrides = overrides
+	flagsMu.Unlock()
+	return nil
+}
+
+func flagState(name string) Flag {
+	f := Flag{Name: name, Description: features[name], Source: "default"}
+
+	flagsMu.RLock()
+	enabled, ok := flagsOverrides[name]
+	flagsMu.RUnlock()
+	if ok {
+		f.Enabled, f.Source = enabled, "db"
+		return f
+	}
+
+	if env := "MALT_FEATURE_" + strings.ToUpper(name); os.Getenv(env) != "" {
+		f.Enabled, f.Source = envBool(env, false), "env"
+	}
+	return f
+}
+
+// featureEnabled is what subsystems ask before doing optional work.
+func featureEnabled(name string) bool {
+	return flagState(name).Enabled
+}
+
+// feature gates a route: while the flag is off, the endpoint doesn't exist.
+func feature(name string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !featureEnabled(name) {
+			httpError(w, r, "Nothing lives at this address.", 404)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// GET /api/flags - Every feature flag and where its value comes from
+func handleListFlags(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	names := make([]string, 0, len(features))
+	for name := range features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	flags := make([]Flag, 0, len(names))
+	for _, name := range names {
+		flags = append(flags, flagState(name))
+	}
+
+	jsonResponse(w, flags)
+}
+
+// PUT /api/flags/{name} - Switch a feature on or off at runtime: {"enabled": true}
+func handleSetFlag(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	name := r.PathValue("name")
+	if _, ok := features[name]; !ok {
+		httpError(w, r, "Unknown feature", 404)
+		return
+	}
+
+	var body struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Enabled == nil {
+		httpError(w, r, `Bad JSON: want {"enabled": true|false}`, 400)
+		return
+	}
+
+	_, err := execWrite(`
+		INSERT INTO feature_flags (name, enabled) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET enabled=excluded.enabled
+	`, name, *body.Enabled)
+	if err != nil {
+		httpError(w, r, "Failed to save: "+err.Error(), 500)
+		return
+	}
+
+	flagsMu.Lock()
+	flagsOverrides[name] = *body.Enabled
+	flagsMu.Unlock()
+
+	jsonResponse(w, flagState(name))
+}
+
+// DELETE /api/flags/{name} - Forget the runtime override and fall back to env
+func handleResetFlag(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	name := r.PathValue("name")
+	if _, ok := features[name]; !ok {
+		httpError(w, r, "Unknown feature", 404)
+		return
+	}
+
+	if _, err := execWrite("DELETE FROM feature_flags WHERE name = ?", name); err != nil {
+		httpError(w, r, "Database error: "+err.Error(), 500)
+		return
+	}
+
+	flagsMu.Lock()
+	delete(flagsOverrides, name)
+	flagsMu.Unlock()
+
+	jsonResponse(w, flagState(name))
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Front matter: Markdown files with a YAML (---) or TOML (+++) header become Posts ---
+//
+// Every static-site generator names things differently, so the key -> field mapping is
+// configurable. Point MALT_FRONTMATTER at a JSON file like:
+//
+//	{"fields": {"summary": "description", "permalink": "slug"}, "date_formats": ["02/01/2006"]}
+//
+// Entries there are merged over the defaults below; map a key to "" to ignore it.
+
+// FrontMatterConfig maps front-matter keys onto Post fields.
+type FrontMatterConfig struct {
+	Fields      map[string]string `json:"fields"`       // front-matter key -> Post JSON field name
+	DateFormats []string          `json:"date_formats"` // Tried before the built-in layouts
+}
+
+var frontMatter = FrontMatterConfig{
+	Fields: map[string]string{
+		"title":       "title",
+		"description": "description",
+		"summary":     "description",
+		"excerpt":     "description",
+		"slug":        "slug",
+		"date":        "published_at",
+		"published":   "published_at",
+		"type":        "type",
+		"link":        "link_url",
+		"format":      "content_format",
+		"tags":        "tags",
+		"categories":  "tags",
+		"draft":       "status",
+		"status":      "status",
+		"author":      "author",
+		"authors":     "author",
+		"series":      "series",
+		"part":        "series_position",
+	},
+	DateFormats: []string{
+		time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05 -0700", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02",
+	},
+}
+
+// loadFrontMatterConfig merges MALT_FRONTMATTER over the defaults. A broken file stops startup.
+func loadFrontMatterConfig() {
+	path := os.Getenv("MALT_FRONTMATTER")
+	if path == "" {
+		return
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("front matter config: %v", err)
+	}
+	var cfg FrontMatterConfig
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		log.Fatalf("front matter config %s: %v", path, err)
+	}
+
+	for key, field := range cfg.Fields {
+		if field != "" && !isMappableField(field) {
+			log.Fatalf("front matter config %s: %q is not a post field", path, field)
+		}
+		frontMatter.Fields[strings.ToLower(key)] = field
+	}
+	// Custom layouts go first: they're there because the defaults got it wrong
+	frontMatter.DateFormats = append(cfg.DateFormats, frontMatter.DateFormats...)
+}
+
+func isMappableField(field string) bool {
+	switch field {
+	case "title", "description", "slug", "published_at", "type", "link_url", "content_format", "tags", "status", "author", "series", "series_position":
+		return true
+	}
+	return false
+}
+
+// postFromMarkdown splits off the front matter and maps it onto a Post; the rest is Content.
+func postFromMarkdown(src string) (Post, error) {
+	var p Post
+	meta, body, err := splitFrontMatter(src)
+	if err != nil {
+		return p, err
+	}
+	p.Content = strings.TrimSpace(body)
+
+	for key, value := range meta {
+		field := frontMatter.Fields[strings.ToLower(key)]
+		if field == "" {
+			continue
+		}
+		s := ""
+		if v, ok := value.(string); ok {
+			s = v
+		} else if list, ok := value.([]string); ok && len(list) > 0 {
+			s = list[0]
+		}
+
+		switch field {
+		case "title":
+			p.Title = s
+		case "description":
+			p.Description = s
+		case "slug":
+			p.Slug = s
+		case "type":
+			p.Type = s
+		case "link_url":
+			p.LinkURL = s
+		case "content_format":
+			p.ContentFormat = strings.ToLower(s)
+		case "status":
+			// Hugo's draft: true, or a status spelled out
+			switch strings.ToLower(s) {
+			case "true", "draft":
+				p.Status = "draft"
+			case "false", "published":
+				p.Status = "published"
+			}
+		case "author":
+			// By name; an author this blog doesn't have is left off rather than refused
+			p.AuthorID = authorIDByName(s)
+		case "series":
+			// Like Hugo's series taxonomy: by title or slug, and only one
+			p.Series = seriesByName(s)
+		case "series_position":
+			p.SeriesPosition, _ = strconv.Atoi(strings.TrimSpace(s))
+		case "tags":
+			// A list, or a single comma-separated string
+			if list, ok := value.([]string); ok {
+				p.Tags = append(p.Tags, list...)
+			} else {
+				p.Tags = append(p.Tags, strings.Split(s, ",")...)
+			}
+		case "published_at":
+			// Jekyll's published: false means a draft, not a date
+			if b := strings.ToLower(s); b == "true" || b == "false" {
+				if b == "false" {
+					p.Status = "draft"
+				}
+				continue
+			}
+			t, err := parseFrontMatterDate(s)
+			if err != nil {
+				return p, fmt.Errorf("front matter %q: %w", key, err)
+			}
+			p.PublishedAt = t
+		}
+	}
+	return p, nil
+}
+
+func parseFrontMatterDate(s string) (time.Time, error) {
+	for _, layout := range frontMatter.DateFormats {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date %q", s)
+}
+
+// splitFrontMatter parses the header block. Values are strings or, for lists, []string.
+// It handles the subset generators actually emit: scalars, quoted strings, [inline, lists]
+// and "- item" block lists for YAML; key = value for TOML. No front matter is not an error.
+func splitFrontMatter(src string) (map[string]any, string, error) {
+	src = strings.TrimPrefix(strings.ReplaceAll(src, "\r\n", "\n"), "\ufeff")
+
+	var fence, sep string
+	switch {
+	case strings.HasPrefix(src, "---\n"):
+		fence, sep = "---", ":"
+	case strings.HasPrefix(src, "+++\n"):
+		fence, sep = "+++", "="
+	default:
+		return map[string]any{}, src, nil
+	}
+
+	header, body, found := strings.Cut(src[len(fence)+1:], "\n"+fence)
+	if !found {
+		return nil, "", fmt.Errorf("front matter opened with %s but never closed", fence)
+	}
+	// Drop the rest of the closing fence line
+	if _, rest, ok := strings.Cut(body, "\n"); ok {
+		body = rest
+	} else {
+		body = ""
+	}
+
+	meta := map[string]any{}
+	lastKey := ""
+	for _, line := range strings.Split(header, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// YAML block list item belonging to the previous key
+		if item, ok := strings.CutPrefix(trimmed, "- "); ok && lastKey != "" {
+			list, _ := meta[lastKey].([]string)
+			meta[lastKey] = append(list, unquote(item))
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, sep)
+		if !ok {
+			continue
+		}
+		lastKey = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		switch {
+		case value == "":
+			meta[lastKey] = []string{} // A block list may follow
+		case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+			list := []string{}
+			for _, item := range strings.Split(value[1:len(value)-1], ",") {
+				if item = unquote(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			meta[lastKey] = list
+		default:
+			meta[lastKey] = unquote(value)
+		}
+	}
+	return meta, body, nil
+}
+
+// unquote strips surrounding quotes and trailing comments from a scalar.
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') {
+		if end := strings.IndexByte(s[1:], s[0]); end >= 0 {
+			return s[1 : end+1]
+		}
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// --- Import: a folder of Markdown files with front matter, e.g. a Hugo or Jekyll site ---
+//
+// POST /api/import takes the folder as a zip; `single-malt -import ./site` reads it from disk.
+// Front matter maps onto posts the way it does for text/markdown publishes (MALT_FRONTMATTER
+// included). On top of that:
+//
+//   - Only content/ (Hugo) or _posts/ and _drafts/ (Jekyll) are read when the folder has them.
+//   - Jekyll's YYYY-MM-DD-slug.md names supply the date and slug; Hugo page bundles
+//     (my-post/index.md) are named after their folder. _drafts/ and draft: true make drafts.
+//   - .adoc, .org and .html files import in their own format.
+//   - Images the posts reference from inside the folder move into the media store.
+
+const maxImport = 256 << 20
+
+var importFormats = map[string]string{
+	".md": FormatMarkdown, ".markdown": FormatMarkdown, ".mdown": FormatMarkdown,
+	".adoc": FormatAsciiDoc, ".asciidoc": FormatAsciiDoc,
+	".org":  FormatOrg,
+	".html": FormatHTML,
+}
+
+var (
+	jekyllName = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})-(.+)$`)
+	imageRef   = regexp.MustCompile(`(!\[[^\]]*\]\()([^)\s]+)|(<img\b[^>]*\bsrc=")([^"]+)`)
+)
+
+// ImportResult says what happened to each file.
+type ImportResult struct {
+	Imported    []string          `json:"imported"`
+	Overwritten []string          `json:"overwritten"`
+	Skipped     []string          `json:"skipped"`
+	Renamed     map[string]string `json:"renamed"` // Slug the file asked for -> slug it got
+	Failed      map[string]string `json:"failed"`  // File -> why
+	Media       int               `json:"media"`
+}
+
+// importFiles lists the content files under fsys, in a stable order.
+func importFiles(fsys fs.FS) ([]string, error) {
+	roots := []string{"."}
+	if st, err := fs.Stat(fsys, "content"); err == nil && st.IsDir() {
+		roots = []string{"content"}
+	} else {
+		var jekyll []string
+		for _, dir := range []string{"_posts", "_drafts"} {
+			if st, err := fs.Stat(fsys, dir); err == nil && st.IsDir() {
+				jekyll = append(jekyll, dir)
+			}
+		}
+		if len(jekyll) > 0 {
+			roots = jekyll
+		}
+	}
+
+	var files []string
+	for _, root := range roots {
+		err := fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			name := d.Name()
+			if d.IsDir() {
+				if p != root && (strings.HasPrefix(name, ".") || name == "node_modules" || (strings.HasPrefix(name, "_") && name != "_posts" && name != "_drafts")) {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			ext := strings.ToLower(path.Ext(name))
+			if _, ok := importFormats[ext]; !ok || strings.HasPrefix(name, "_index.") {
+				return nil
+			}
+			// Loose HTML is usually a layout; only take it from a posts folder
+			if ext == ".html" && !strings.Contains("/"+p, "/_posts/") && !strings.Contains("/"+p, "/_drafts/") {
+				return nil
+			}
+			if base := strings.ToUpper(strings.TrimSuffix(name, path.Ext(name))); base == "README" || base == "LICENSE" || base == "CHANGELOG" {
+				return nil
+			}
+			files = append(files, p)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// importPost turns one file into a Post, filling in what the front matter left out.
+func importPost(fsys fs.FS, file string) (Post, error) {
+	src, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return Post{}, err
+	}
+	p, err := postFromMarkdown(string(src))
+	if err != nil {
+		return p, err
+	}
+
+	ext := strings.ToLower(path.Ext(file))
+	if p.ContentFormat == "" {
+		p.ContentFormat = importFormats[ext]
+	}
+
+	name := strings.TrimSuffix(path.Base(file), path.Ext(file))
+	if name == "index" || name == "README" {
+		name = path.Base(path.Dir(file)) // Hugo page bundle
+	}
+	if m := jekyllName.FindStringSubmatch(name); m != nil {
+		name = m[2]
+		if p.PublishedAt.IsZero() {
+			p.PublishedAt, _ = time.Parse("2006-01-02", m[1])
+		}
+	}
+	words := strings.ReplaceAll(name, "-", " ")
+	if p.Slug == "" {
+		p.Slug = slugify(words)
+	}
+	if p.Title == "" {
+		p.Title = words
+	}
+	if strings.Contains("/"+file, "/_drafts/") {
+		p.Status = "draft"
+	}
+	return p, nil
+}
+
+// importImages moves images referenced from content into the media store and points the
+// references at /media/{id}. References outside the folder are left alone.
+func importImages(fsys fs.FS, file, content string, all []string) (string, int) {
+	stored := 0
+	replace := func(ref string) string {
+		if strings.Contains(ref, "://") || strings.HasPrefix(ref, "data:") || strings.HasPrefix(ref, "/media/") {
+			return ref
+		}
+		clean := strings.SplitN(strings.SplitN(ref, "?", 2)[0], "#", 2)[0]
+
+		var candidates []string
+		if strings.HasPrefix(clean, "/") {
+			// Site-absolute: /assets/x.png lives at assets/x.png, or static/assets/x.png for Hugo
+			for _, f := range all {
+				if strings.HasSuffix("/"+f, clean) {
+					candidates = append(candidates, f)
+				}
+			}
+		} else {
+			candidates = append(candidates, path.Join(path.Dir(file), clean))
+		}
+
+		for _, c := range candidates {
+			data, err := fs.ReadFile(fsys, c)
+			if err != nil {
+				continue
+			}
+			m, err := storeMedia(path.Base(c), data)
+			if err != nil {
+				continue
+			}
+			if !m.Existing {
+				stored++
+			}
+			return m.URL
+		}
+		return ref
+	}
+
+	content = imageRef.ReplaceAllStringFunc(content, func(match string) string {
+		parts := imageRef.FindStringSubmatch(match)
+		if parts[1] != "" {
+			return parts[1] + replace(parts[2])
+		}
+		return parts[3] + replace(parts[4])
+	})
+	return content, stored
+}
+
+// importSite saves every post under fsys. mode handles slugs that already exist, as for restore.
+func importSite(r *http.Request, fsys fs.FS, mode string) (ImportResult, error) {
+	result := ImportResult{Imported: []string{}, Overwritten: []string{}, Skipped: []string{}, Renamed: map[string]string{}, Failed: map[string]string{}}
+
+	files, err := importFiles(fsys)
+	if err != nil {
+		return result, err
+	}
+	var all []string
+	fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			all = append(all, p)
+		}
+		return nil
+	})
+
+	for _, file := range files {
+		p, err := importPost(fsys, file)
+		if err != nil {
+			result.Failed[file] = err.Error()
+			continue
+		}
+
+		asked := strings.ToLower(p.Slug)
+		var exists bool
+		db.QueryRow("SELECT EXISTS(SELECT 1 FROM posts WHERE slug = ?)", asked).Scan(&exists)
+		if exists {
+			switch mode {
+			case "skip":
+				result.Skipped = append(result.Skipped, asked)
+				continue
+			case "rename":
+				p.Slug = uniqueSlug(asked)
+			}
+		}
+
+		var n int
+		p.Content, n = importImages(fsys, file, p.Content, all)
+		result.Media += n
+
+		if _, err := savePost(r, &p); err != nil {
+			var perr *publishError
+			if errors.As(err, &perr) {
+				err = errors.New(perr.Error())
+			}
+			result.Failed[file] = err.Error()
+			continue
+		}
+
+		switch {
+		case p.Slug != asked:
+			result.Renamed[asked] = p.Slug
+		case exists:
+			result.Overwritten = append(result.Overwritten, p.Slug)
+		default:
+			result.Imported = append(result.Imported, p.Slug)
+		}
+	}
+	return result, nil
+}
+
+// POST /api/import?on_conflict=skip|overwrite|rename - Import a zipped folder of posts
+func handleImport(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+	allowBulk(w)
+
+	mode := r.URL.Query().Get("on_conflict")
+	if mode == "" {
+		mode = "skip"
+	}
+	if mode != "skip" && mode != "overwrite" && mode != "rename" {
+		httpError(w, r, "on_conflict must be skip, overwrite or rename", 400)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImport))
+	if err != nil {
+		httpError(w, r, "Could not read body: "+err.Error(), 400)
+		return
+	}
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		httpError(w, r, "Send the folder as a zip: "+err.Error(), 400)
+		return
+	}
+
+	// A zip of "site/" holds everything under site/; look inside it
+	var fsys fs.FS = archive
+	if entries, err := fs.ReadDir(archive, "."); err == nil && len(entries) == 1 && entries[0].IsDir() {
+		fsys, _ = fs.Sub(archive, entries[0].Name())
+	}
+
+	result, err := importSite(r, fsys, mode)
+	if err != nil {
+		httpError(w, r, "Import failed: "+err.Error(), 400)
+		return
+	}
+	log.Printf("import: %d imported, %d overwritten, %d renamed, %d skipped, %d failed",
+		len(result.Imported), len(result.Overwritten), len(result.Renamed), len(result.Skipped), len(result.Failed))
+
+	jsonResponse(w, result)
+}
+
+// importDir is the -import flag: the same import, from a folder on disk, overwriting nothing.
+func importDir(dir string) error {
+	if st, err := os.Stat(dir); err != nil || !st.IsDir() {
+		return errors.New("-import: " + dir + " is not a folder")
+	}
+	r := httptest.NewRequest("POST", "/api/import", nil)
+	result, err := importSite(r, os.DirFS(dir), "skip")
+	if err != nil {
+		return err
+	}
+	for file, why := range result.Failed {
+		log.Printf("import: %s: %s", file, why)
+	}
+	log.Printf("import: %d imported, %d skipped (slug taken), %d failed, %d images",
+		len(result.Imported), len(result.Skipped), len(result.Failed), result.Media)
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Email-to-post: Mailgun forwards mail, allowed senders get a draft ---
+//
+// Point a Mailgun route at POST /api/inbound/mailgun ("forward" action) and set:
+//
+//	MALT_MAILGUN_SIGNING_KEY   the webhook signing key; the endpoint exists only when set
+//	MALT_INBOUND_SENDERS       comma-separated addresses allowed to post
+//	MALT_INBOUND_REQUIRE_DKIM  default 1: the message must carry a passing DKIM signature
+//
+// Subject becomes the title, the body (minus quoted replies and signature) Markdown content.
+// Image attachments land in the media store and are appended to the draft.
+
+const maxInboundMail = 32 << 20
+
+// mailgunFreshness bounds how old a signed webhook may be, so a captured one can't be replayed later.
+const mailgunFreshness = 5 * time.Minute
+
+// verifyMailgun checks the webhook signature: HMAC-SHA256 of timestamp+token under the signing key.
+func verifyMailgun(timestamp, token, signature string) bool {
+	key := os.Getenv("MALT_MAILGUN_SIGNING_KEY")
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if key == "" || err != nil || time.Since(time.Unix(ts, 0)).Abs() > mailgunFreshness {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(timestamp + token))
+	want := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(want), []byte(strings.ToLower(signature)))
+}
+
+func allowedSender(addr string) bool {
+	for _, a := range strings.Split(os.Getenv("MALT_INBOUND_SENDERS"), ",") {
+		if a = strings.TrimSpace(a); a != "" && strings.EqualFold(a, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// dkimPassed reads Mailgun's verdict out of the forwarded headers.
+func dkimPassed(messageHeaders string) bool {
+	var headers [][2]string
+	if err := json.Unmarshal([]byte(messageHeaders), &headers); err != nil {
+		return false
+	}
+	for _, h := range headers {
+		if strings.EqualFold(h[0], "X-Mailgun-Dkim-Check-Result") {
+			return strings.EqualFold(strings.TrimSpace(h[1]), "Pass")
+		}
+	}
+	return false
+}
+
+// POST /api/inbound/mailgun - Turn a forwarded email into a draft post
+func handleMailgunInbound(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxInboundMail)
+	if err := r.ParseMultipartForm(maxInboundMail); err != nil && err != http.ErrNotMultipart {
+		httpError(w, r, "Bad form: "+err.Error(), 400)
+		return
+	}
+
+	if !verifyMailgun(r.FormValue("timestamp"), r.FormValue("token"), r.FormValue("signature")) {
+		httpError(w, r, "Bad signature", 401)
+		return
+	}
+
+	// 406 tells Mailgun not to retry: the mail was received and refused
+	from, err := mail.ParseAddress(r.FormValue("from"))
+	if err != nil || !allowedSender(from.Address) {
+		log.Printf("inbound: refused mail from %q", r.FormValue("from"))
+		httpError(w, r, "Sender not allowed", 406)
+		return
+	}
+	if envBool("MALT_INBOUND_REQUIRE_DKIM", true) && !dkimPassed(r.FormValue("message-headers")) {
+		log.Printf("inbound: refused mail from %s without a passing DKIM signature", from.Address)
+		httpError(w, r, "Unsigned mail", 406)
+		return
+	}
+
+	p := Post{
+		Title:         strings.TrimSpace(r.FormValue("subject")),
+		Content:       strings.TrimSpace(r.FormValue("stripped-text")),
+		ContentFormat: FormatMarkdown,
+		Type:          "article",
+		Status:        "draft",
+		PublishedAt:   time.Now().UTC(),
+	}
+	if p.Content == "" {
+		p.Content = strings.TrimSpace(r.FormValue("body-plain"))
+	}
+	if p.Title == "" {
+		p.Title = "Untitled"
+	}
+	p.Slug = uniqueSlug(slugify(p.Title))
+
+	// Images go to the media store and onto the end of the draft; anything else stays in the mail
+	var attached []string
+	skipped := 0
+	if r.MultipartForm != nil {
+		for n := 1; ; n++ {
+			files := r.MultipartForm.File["attachment-"+strconv.Itoa(n)]
+			if len(files) == 0 {
+				break
+			}
+			m, err := storeUpload(files[0])
+			if err != nil {
+				log.Printf("inbound: skipped attachment %q: %v", files[0].Filename, err)
+				skipped++
+				continue
+			}
+			attached = append(attached, m.URL)
+			p.Content += "\n\n![" + strings.TrimSuffix(m.Filename, filepath.Ext(m.Filename)) + "](" + m.URL + ")"
+		}
+	}
+	p.Content = strings.TrimSpace(p.Content)
+
+	// A mail that doesn't make a valid post won't make one on a retry either
+	if fields := validatePost(p); len(fields) > 0 {
+		problems := make([]string, len(fields))
+		for i, f := range fields {
+			problems[i] = f.Field + " " + f.Message
+		}
+		log.Printf("inbound: refused mail from %s: %s", from.Address, strings.Join(problems, "; "))
+		httpError(w, r, "Invalid post: "+strings.Join(problems, "; "), 406)
+		return
+	}
+
+	if p, err = withRendered(p); err != nil {
+		httpError(w, r, "Could not render content: "+err.Error(), 406)
+		return
+	}
+	if err := store.InsertPost(r.Context(), &p, "email"); err != nil {
+		httpError(w, r, "Failed to save: "+err.Error(), 500)
+		return
+	}
+
+	log.Printf("inbound: draft %q from %s", p.Slug, from.Address)
+
+	jsonResponse(w, map[string]any{"status": "draft", "link": "/post/" + p.Slug, "attachments": attached, "attachments_skipped": skipped})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- API keys: more than one X-MALT-KEY, each with a scope ---
+//
+// MALT_SECRET stays the owner's admin key. Keys made through /api/keys can be handed out
+// separately and revoked one by one: a "publish" key (for CI, an editor, the CLI) can write
+// and update posts, publish drafts and upload media, but not delete anything or touch
+// settings. Only a hash of each key is stored; the key itself is shown once, on creation.
+
+const (
+	scopePublish = "publish"
+	scopeAdmin   = "admin"
+)
+
+// APIKey is a key as listed; the secret part is never returned after creation.
+type APIKey struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Scope      string     `json:"scope"`
+	Prefix     string     `json:"prefix"` // The key's first characters, to tell keys apart
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	Key        string     `json:"key,omitempty"` // Only in the POST /api/keys response
+}
+
+// keyScope is the scope key grants: scopeAdmin, scopePublish, or "" for no access.
+func keyScope(key string) string {
+	if key == "" {
+		return ""
+	}
+	if cfg.Secret != "" && subtle.ConstantTimeCompare([]byte(key), []byte(cfg.Secret)) == 1 {
+		return scopeAdmin
+	}
+
+	// Looked up by hash, so how long the lookup takes says nothing about the stored keys
+	sum := sha256.Sum256([]byte(key))
+	hash := hex.EncodeToString(sum[:])
+	var id int64
+	var scope string
+	err := db.QueryRow("SELECT id, scope FROM api_keys WHERE hash = ? AND revoked_at IS NULL", hash).Scan(&id, &scope)
+	if err != nil {
+		return ""
+	}
+	execWrite("UPDATE api_keys SET last_used_at = ? WHERE id = ?", time.Now().UTC(), id)
+	return scope
+}
+
+// scopeKey holds the scope of a request that proved its key some other way than X-MALT-KEY
+// (XML-RPC clients send it as the password).
+type scopeKey struct{}
+
+// hasScope reports whether r's key allows scope. Admin keys allow everything.
+func hasScope(r *http.Request, scope string) bool {
+	granted, _ := r.Context().Value(scopeKey{}).(string)
+	if granted == "" {
+		granted, _ = checkKey(r, r.Header.Get("X-MALT-KEY"))
+	}
+	return granted == scopeAdmin || (granted != "" && granted == scope)
+}
+
+// requireScope is requireKey for endpoints a narrower key may use.
+// It writes the 401 (no valid key), 403 (key lacks the scope) or 429 (locked out) itself.
+func requireScope(w http.ResponseWriter, r *http.Request, scope string) bool {
+	granted, wait := checkKey(r, r.Header.Get("X-MALT-KEY"))
+	switch {
+	case wait > 0:
+		tooMany(w, r, wait, "Too many wrong keys; try again later")
+		return false
+	case granted == "":
+		httpError(w, r, "Go away", 401)
+		return false
+	case granted != scopeAdmin && granted != scope:
+		httpError(w, r, "This key can't do that; it needs the "+scope+" scope", 403)
+		return false
+	}
+	return true
+}
+
+// GET /api/keys - Every key, revoked ones included
+func handleListKeys(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	rows, err := db.Query("SELECT id, name, scope, prefix, created_at, last_used_at, revoked_at FROM api_keys ORDER BY id")
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		var used, revoked sql.NullTime
+		if err := rows.Scan(&k.ID, &k.Name, &k.Scope, &k.Prefix, &k.CreatedAt, &used, &revoked); err != nil {
+			continue
+		}
+		if used.Valid {
+			k.LastUsedAt = &used.Time
+		}
+		if revoked.Valid {
+			k.RevokedAt = &revoked.Time
+		}
+		keys = append(keys, k)
+	}
+
+	jsonResponse(w, keys)
+}
+
+// POST /api/keys - Make a key: {"name": "ci", "scope": "publish"}. The response is the only
+// time the key itself is shown.
+func handleCreateKey(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	var k APIKey
+	if err := json.NewDecoder(r.Body).Decode(&k); err != nil {
+		httpError(w, r, "Bad JSON", 400)
+		return
+	}
+	k.Name = strings.TrimSpace(k.Name)
+	if k.Name == "" {
+		httpError(w, r, "A key needs a name", 400)
+		return
+	}
+	if k.Scope == "" {
+		k.Scope = scopePublish
+	}
+	if k.Scope != scopePublish && k.Scope != scopeAdmin {
+		httpError(w, r, "scope must be publish or admin", 400)
+		return
+	}
+
+	k.Key = "malt_" + rand.Text()
+	k.Prefix = k.Key[:9]
+	k.CreatedAt = time.Now().UTC()
+	sum := sha256.Sum256([]byte(k.Key))
+
+	var err error
+	k.ID, err = insertID("INSERT INTO api_keys (name, scope, prefix, hash, created_at) VALUES (?, ?, ?, ?, ?)",
+		k.Name, k.Scope, k.Prefix, hex.EncodeToString(sum[:]), k.CreatedAt)
+	if err != nil {
+		httpError(w, r, "Database error: "+err.Error(), 500)
+		return
+	}
+	audit(r, "key.create", k.Name, k.Scope)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	jsonResponse(w, k)
+}
+
+// DELETE /api/keys/{id} - Revoke a key. It stays listed, so the audit trail still makes sense.
+func handleRevokeKey(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httpError(w, r, "Key not found", 404)
+		return
+	}
+	var name string
+	if err := db.QueryRow("SELECT name FROM api_keys WHERE id = ?", id).Scan(&name); err != nil {
+		httpError(w, r, "Key not found", 404)
+		return
+	}
+
+	if _, err := execWrite("UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", time.Now().UTC(), id); err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	audit(r, "key.revoke", name, "")
+
+	jsonResponse(w, map[string]any{"status": "revoked", "id": id})
+}
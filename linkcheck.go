@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// --- Internal link check: catch /post/typo before readers do ---
+//
+// MALT_LINK_CHECK=warn (default) reports internal links to missing or draft posts in the publish response,
+// "block" refuses to publish with them, "off" skips the check.
+
+// LinkIssue is one internal link that doesn't lead anywhere.
+type LinkIssue struct {
+	Href    string `json:"href"`
+	Slug    string `json:"slug"`
+	Problem string `json:"problem"` // "missing", or "draft" when readers would get a 404 for now
+}
+
+func linkCheckMode() string {
+	switch mode := strings.ToLower(os.Getenv("MALT_LINK_CHECK")); mode {
+	case "block", "off":
+		return mode
+	}
+	return "warn"
+}
+
+// internalSlugs returns the post slugs linked from body, by href. Absolute links count when
+// they point at this site.
+func internalSlugs(body, site string) map[string]string {
+	slugs := map[string]string{}
+	z := html.NewTokenizer(strings.NewReader(body))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return slugs
+		}
+		if tt != html.StartTagToken {
+			continue
+		}
+		t := z.Token()
+		if t.Data != "a" {
+			continue
+		}
+		for _, a := range t.Attr {
+			if a.Key != "href" {
+				continue
+			}
+			u, err := url.Parse(strings.TrimSpace(a.Val))
+			if err != nil || (u.Host != "" && !hostWithin(strings.ToLower(u.Hostname()), site)) {
+				continue
+			}
+			if slug, ok := strings.CutPrefix(u.Path, "/post/"); ok && slug != "" {
+				slugs[a.Val] = strings.ToLower(strings.TrimSuffix(slug, "/"))
+			}
+		}
+	}
+}
+
+// checkInternalLinks resolves p's links to other posts. Links to itself are fine, since it
+// is about to exist.
+func checkInternalLinks(p Post, site string) []LinkIssue {
+	body, err := renderContent(p.ContentFormat, p.Content)
+	if err != nil {
+		return nil
+	}
+
+	issues := []LinkIssue{}
+	for href, slug := range internalSlugs(body, site) {
+		if slug == p.Slug {
+			continue
+		}
+		var status string
+		switch err := db.QueryRow("SELECT status FROM posts WHERE slug = ? AND deleted_at IS NULL", slug).Scan(&status); {
+		case err == sql.ErrNoRows:
+			issues = append(issues, LinkIssue{Href: href, Slug: slug, Problem: "missing"})
+		case err == nil && status == "draft":
+			issues = append(issues, LinkIssue{Href: href, Slug: slug, Problem: "draft"})
+		}
+	}
+	sort.Slice(issues, func(i, j int) bool { return issues[i].Href < issues[j].Href })
+	return issues
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// --- Link policy: rel/target on outbound links, applied when a post is rendered ---
+//
+//	MALT_LINK_REL=noopener,nofollow   rel values added to external links (default "noopener")
+//	MALT_LINK_TARGET_BLANK=1          open external links in a new tab
+//	MALT_LINK_FOLLOW=go.dev,example.org  domains (and their subdomains) exempt from nofollow
+//
+// A link is external when it is absolute and its host is neither this site
+// (MALT_BASE_URL, else the request's Host) nor a subdomain of it.
+
+type LinkPolicy struct {
+	Rel         []string
+	TargetBlank bool
+	Follow      []string
+}
+
+func linkPolicy() LinkPolicy {
+	p := LinkPolicy{Rel: []string{"noopener"}, TargetBlank: envBool("MALT_LINK_TARGET_BLANK", false)}
+	if v, ok := os.LookupEnv("MALT_LINK_REL"); ok {
+		p.Rel = strings.Fields(strings.ReplaceAll(strings.ToLower(v), ",", " "))
+	}
+	for _, d := range strings.Split(os.Getenv("MALT_LINK_FOLLOW"), ",") {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			p.Follow = append(p.Follow, strings.TrimPrefix(d, "."))
+		}
+	}
+	return p
+}
+
+// siteHost is the host this blog is served from, for telling internal links from external ones.
+func siteHost(fallback string) string {
+	if u, err := url.Parse(cfg.BaseURL); err == nil && u.Host != "" {
+		return strings.ToLower(u.Hostname())
+	}
+	host := strings.ToLower(fallback)
+	if h, _, ok := strings.Cut(host, ":"); ok {
+		host = h
+	}
+	return host
+}
+
+func hostWithin(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// applyLinkPolicy rewrites external <a> tags in body and passes everything else through untouched.
+func applyLinkPolicy(body, site string) string {
+	policy := linkPolicy()
+	if len(policy.Rel) == 0 && !policy.TargetBlank {
+		return body
+	}
+
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(body))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return b.String()
+		}
+		raw := string(z.Raw()) // Token() below would reuse the buffer
+		if tt != html.StartTagToken {
+			b.WriteString(raw)
+			continue
+		}
+
+		t := z.Token()
+		host, external := externalHost(t, site)
+		if t.Data != "a" || !external {
+			b.WriteString(raw)
+			continue
+		}
+
+		// Merge with whatever rel the author set, minus nofollow for trusted domains
+		rel := map[string]bool{}
+		var order []string
+		add := func(v string) {
+			if !rel[v] {
+				rel[v] = true
+				order = append(order, v)
+			}
+		}
+		attrs := t.Attr[:0]
+		for _, a := range t.Attr {
+			switch strings.ToLower(a.Key) {
+			case "rel":
+				for _, v := range strings.Fields(strings.ToLower(a.Val)) {
+					add(v)
+				}
+			case "target":
+				if !policy.TargetBlank {
+					attrs = append(attrs, a)
+				}
+			default:
+				attrs = append(attrs, a)
+			}
+		}
+		for _, v := range policy.Rel {
+			add(v)
+		}
+		for _, d := range policy.Follow {
+			if hostWithin(host, d) {
+				delete(rel, "nofollow")
+			}
+		}
+
+		var kept []string
+		for _, v := range order {
+			if rel[v] {
+				kept = append(kept, v)
+			}
+		}
+		if len(kept) > 0 {
+			attrs = append(attrs, html.Attribute{Key: "rel", Val: strings.Join(kept, " ")})
+		}
+		if policy.TargetBlank {
+			attrs = append(attrs, html.Attribute{Key: "target", Val: "_blank"})
+		}
+		t.Attr = attrs
+		b.WriteString(t.String())
+	}
+}
+
+// externalHost reports the host of an <a>'s href when it points off-site.
+func externalHost(t html.Token, site string) (string, bool) {
+	for _, a := range t.Attr {
+		if a.Key != "href" {
+			continue
+		}
+		u, err := url.Parse(strings.TrimSpace(a.Val))
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "") {
+			return "", false
+		}
+		host := strings.ToLower(u.Hostname())
+		return host, !hostWithin(host, site)
+	}
+	return "", false
+}
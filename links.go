@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Link is one blogroll entry: someone else's corner of the web worth pointing at.
+type Link struct {
+	ID          int64  `json:"id"`
+	Title       string `json:"title"`
+	URL         string `json:"url"`
+	Description string `json:"description"`
+	Category    string `json:"category"`
+	FeedURL     string `json:"feed_url,omitempty"` // RSS/Atom, for the OPML export
+}
+
+const defaultLinksPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Links | Goholic</title>
+    <meta name="description" content="Blogs and sites worth reading.">
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, Helvetica, Arial, sans-serif; max-width: 680px; margin: 0 auto; padding: 2rem 1.5rem; line-height: 1.6; }
+        @media (prefers-color-scheme: dark) { body { background: #111111; color: #e1e1e1; } a { color: #4da3ff; } }
+        .desc { color: #888; }
+    </style>
+</head>
+<body>
+    <p><a href="/">goholic.in</a></p>
+    <h1>Links</h1>
+    {{range .}}
+    <h2>{{if .Category}}{{.Category}}{{else}}Elsewhere{{end}}</h2>
+    <ul>
+        {{range .Links}}<li><a href="{{.URL}}">{{.Title}}</a>{{if .Description}} <span class="desc">- {{.Description}}</span>{{end}}</li>
+        {{end}}
+    </ul>
+    {{else}}
+    <p>No links yet.</p>
+    {{end}}
+</body>
+</html>`
+
+var fallbackLinksTmpl = template.Must(template.New("links").Parse(defaultLinksPage))
+
+// linkGroup is one category section on the /links page.
+type linkGroup struct {
+	Category string
+	Links    []Link
+}
+
+func listLinks() ([]Link, error) {
+	rows, err := db.Query("SELECT id, title, url, description, category, feed_url FROM links ORDER BY category, title")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []Link{}
+	for rows.Next() {
+		var l Link
+		if err := rows.Scan(&l.ID, &l.Title, &l.URL, &l.Description, &l.Category, &l.FeedURL); err != nil {
+			continue
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+// decodeLink parses and validates a Link body. Only absolute http(s) URLs make it in.
+func decodeLink(r *http.Request) (Link, string) {
+	var l Link
+	if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+		return l, "Bad JSON"
+	}
+
+	u, err := url.Parse(strings.TrimSpace(l.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return l, "URL must be an absolute http(s) link"
+	}
+	l.URL = u.String()
+
+	if l.FeedURL != "" {
+		feed, ok := httpURL(l.FeedURL)
+		if !ok {
+			return l, "Feed URL must be an absolute http(s) link"
+		}
+		l.FeedURL = feed
+	}
+
+	// Untitled links fall back to the host name
+	if l.Title = strings.TrimSpace(l.Title); l.Title == "" {
+		l.Title = u.Host
+	}
+	l.Category = strings.TrimSpace(l.Category)
+
+	return l, ""
+}
+
+// GET /api/links - The blogroll
+func handleListLinks(w http.ResponseWriter, r *http.Request) {
+	links, err := listLinks()
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+
+	jsonResponse(w, links)
+}
+
+// GET /api/links/export - The blogroll as a downloadable file
+func handleExportLinks(w http.ResponseWriter, r *http.Request) {
+	links, err := listLinks()
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="blogroll.json"`)
+	jsonResponse(w, links)
+}
+
+// POST /api/links - Add a link
+func handleCreateLink(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	l, problem := decodeLink(r)
+	if problem != "" {
+		httpError(w, r, problem, 400)
+		return
+	}
+
+	var err error
+	l.ID, err = insertID("INSERT INTO links (title, url, description, category, feed_url) VALUES (?, ?, ?, ?, ?)",
+		l.Title, l.URL, l.Description, l.Category, l.FeedURL)
+	if err != nil {
+		httpError(w, r, "Failed to save: "+err.Error(), 500)
+		return
+	}
+
+	jsonResponse(w, l)
+}
+
+// PUT /api/links/{id} - Replace a link
+func handleUpdateLink(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httpError(w, r, "Link not found", 404)
+		return
+	}
+
+	l, problem := decodeLink(r)
+	if problem != "" {
+		httpError(w, r, problem, 400)
+		return
+	}
+	l.ID = id
+
+	result, err := execWrite("UPDATE links SET title = ?, url = ?, description = ?, category = ?, feed_url = ? WHERE id = ?",
+		l.Title, l.URL, l.Description, l.Category, l.FeedURL, l.ID)
+	if err != nil {
+		httpError(w, r, "Database error: "+err.Error(), 500)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		httpError(w, r, "Link not found", 404)
+		return
+	}
+
+	jsonResponse(w, l)
+}
+
+// DELETE /api/links/{id} - Remove a link
+func handleDeleteLink(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	result, err := execWrite("DELETE FROM links WHERE id = ?", r.PathValue("id"))
+	if err != nil {
+		httpError(w, r, "Database error: "+err.Error(), 500)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		httpError(w, r, "Link not found", 404)
+		return
+	}
+
+	jsonResponse(w, map[string]string{"status": "deleted", "id": r.PathValue("id")})
+}
+
+// GET /links - The blogroll page, grouped by category
+func handleLinksPage(w http.ResponseWriter, r *http.Request) {
+	links, err := listLinks()
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+
+	// Links arrive sorted by category, so grouping is a single pass
+	var groups []linkGroup
+	for _, l := range links {
+		if n := len(groups); n == 0 || groups[n-1].Category != l.Category {
+			groups = append(groups, linkGroup{Category: l.Category})
+		}
+		groups[len(groups)-1].Links = append(groups[len(groups)-1].Links, l)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	themeTemplate(fallbackLinksTmpl, "links.html").Execute(w, groups)
+}
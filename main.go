@@ -3,13 +3,21 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
 	"os"
-	"regexp"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/text/unicode/norm"
 	_ "modernc.org/sqlite"
 )
 
@@ -18,141 +26,483 @@ type Post struct {
 	Slug        string    `json:"slug"`        // The SEO link: /post/my-first-post
 	Title       string    `json:"title"`       // Browser Tab Title
 	Description string    `json:"description"` // Meta Description for SEO
-	Content     string    `json:"content"`     // The HTML/Markdown body
+	Content     string    `json:"content"`     // The body, as written
 	PublishedAt time.Time `json:"published_at"`
+	UpdatedAt   time.Time `json:"updated_at"` // Last write; feeds and the sitemap report it as lastmod
+
+	// Content is stored as written and rendered to HTML on the way out
+	ContentFormat string `json:"content_format"`         // "html" (default), "markdown", "asciidoc" or "org"
+	ContentMD     string `json:"content_md,omitempty"`   // Content again when it's Markdown; accepted on publish too
+	ContentHTML   string `json:"content_html,omitempty"` // Rendered and sanitized Content, cached at write time
+	RawHTML       bool   `json:"raw_html"`               // Trusted: skip the sanitizer (embeds, scripts)
+
+	// Measured from the rendered body whenever it's stored; ignored on publish
+	WordCount      int    `json:"word_count"`
+	ReadingMinutes int    `json:"reading_minutes"`   // At 200 words a minute, rounded up
+	Excerpt        string `json:"excerpt,omitempty"` // The opening of the text, up to 280 characters
+
+	Status string `json:"status"` // "published" (default) or "draft"; drafts are only visible with the key
+
+	Tags []string `json:"tags"` // Lowercase; omit on publish to keep the current ones
+
+	AuthorID int64   `json:"author_id,omitempty"` // Who wrote it, from /api/authors; 0 for the blog itself
+	Author   *Author `json:"author,omitempty"`    // The same author in full, on reads
+
+	Series         string     `json:"series,omitempty"`          // Slug of the series it's a part of, from /api/series
+	SeriesPosition int        `json:"series_position,omitempty"` // Its place there; 0 on publish for where it is, or the end
+	SeriesNav      *SeriesNav `json:"series_nav,omitempty"`      // The parts either side, on GET /api/posts/{slug}
+
+	// Robots: kept out of search results and the sitemap (job ads, event pages)
+	NoIndex  bool `json:"noindex"`
+	NoFollow bool `json:"nofollow"`
+
+	// Link posts point somewhere else; the Link* fields are fetched from the target at publish time
+	Type            string `json:"type"` // "article" (default) or "link"
+	LinkURL         string `json:"link_url,omitempty"`
+	LinkTitle       string `json:"link_title,omitempty"`
+	LinkDescription string `json:"link_description,omitempty"`
+	LinkImage       string `json:"link_image,omitempty"`
+
+	// Syndication: where the post was shared, so replies there can be pulled back in
+	MastodonStatus string `json:"mastodon_status,omitempty"` // Status ID on MALT_MASTODON_INSTANCE
+	BlueskyURI     string `json:"bluesky_uri,omitempty"`     // at:// URI of the Bluesky post
 }
 
 // --- 2. The Store (Keep it boring) ---
 var db *sql.DB
 
 func initDB() {
-	var err error
-
-	// just create a single db file malt.db
-	db, err = sql.Open("sqlite", "malt.db")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	query := `
-	CREATE TABLE IF NOT EXISTS posts (
-		slug TEXT PRIMARY KEY,
-		title TEXT,
-		description TEXT,
-		content TEXT,
-		published_at DATETIME
-	);`
-
-	if _, err := db.Exec(query); err != nil {
-		log.Fatal(err)
-	}
+	openStore()
+	migrate()
 }
 
 // --- 3. Handlers (Minimal logic) ---
 
-// GET /api/posts - Returns list for the homepage
+// GET /api/posts?tag=golang&limit=20&cursor=... - Returns list for the homepage, optionally one
+// topic only. Without limit or cursor it's every post as a bare array, as it always was.
 func handleListPosts(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Query("SELECT slug, title, description, published_at FROM posts ORDER BY published_at DESC")
+	q := r.URL.Query()
+	query := PostQuery{Tag: q.Get("tag"), Cursor: q.Get("cursor")}
+	paged := q.Has("limit") || q.Has("cursor")
+	if paged {
+		query.Limit = 20
+		if n, err := strconv.Atoi(q.Get("limit")); err == nil && n > 0 {
+			query.Limit = min(n, 100)
+		}
+	}
+
+	// Title experiments make the list differ per visitor and count each view, so no 304s then
+	if !featureEnabled("experiments") {
+		if etag, modified, err := postsETag(r.URL.RawQuery); err == nil && notModified(w, r, etag, modified) {
+			return
+		}
+	}
+
+	page, err := store.ListPosts(query)
+	if errors.Is(err, errBadCursor) {
+		httpError(w, r, "Bad cursor", 400)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Database error", 500)
+		httpError(w, r, "Database error", 500)
 		return
 	}
-	defer rows.Close()
+	posts := page.Posts
 
-	var posts []Post
-	for rows.Next() {
-		var p Post
-		// Note: We don't fetch 'Content' here to keep the list payload tiny
-		if err := rows.Scan(&p.Slug, &p.Title, &p.Description, &p.PublishedAt); err != nil {
-			continue
+	if len(posts) > 0 {
+		slugs := make([]string, len(posts))
+		for i, p := range posts {
+			slugs[i] = p.Slug
+		}
+		tags, err := loadTags(slugs...)
+		if err != nil {
+			httpError(w, r, "Database error", 500)
+			return
+		}
+		for i := range posts {
+			posts[i].Tags = tags[posts[i].Slug]
 		}
-		posts = append(posts, p)
 	}
 
+	// Title experiments: each visitor sees their arm, and the arm gets an impression
+	if featureEnabled("experiments") {
+		variants, err := loadVariants("")
+		if err != nil {
+			httpError(w, r, "Database error", 500)
+			return
+		}
+		shown := map[string]int64{}
+		for i := range posts {
+			if vs := variants[posts[i].Slug]; len(vs) > 0 {
+				shown[posts[i].Slug] = applyVariant(&posts[i], vs, r)
+			}
+		}
+		recordArms("impressions", shown)
+	}
+
+	if paged {
+		if posts == nil {
+			posts = []Post{}
+		}
+		page.Posts = posts
+		jsonResponse(w, page)
+		return
+	}
 	jsonResponse(w, posts)
 }
 
+// Robots is the value for the robots meta tag and X-Robots-Tag header, "" when indexing is fine.
+func (p Post) Robots() string {
+	var directives []string
+	if p.NoIndex {
+		directives = append(directives, "noindex")
+	}
+	if p.NoFollow {
+		directives = append(directives, "nofollow")
+	}
+	return strings.Join(directives, ", ")
+}
+
 // GET /api/posts/{slug} - Returns single post for rendering
 func handleGetPost(w http.ResponseWriter, r *http.Request) {
 	slug := r.PathValue("slug") // Go 1.22 feature
 
-	var p Post
-	row := db.QueryRow("SELECT slug, title, description, content, published_at FROM posts WHERE slug = ?", slug)
-	if err := row.Scan(&p.Slug, &p.Title, &p.Description, &p.Content, &p.PublishedAt); err != nil {
-		http.Error(w, "Post not found", 404)
+	p, err := store.GetPost(slug)
+	if err != nil || !canView(w, r, p) {
+		httpError(w, r, "Post not found", 404)
 		return
 	}
+	if p.Series != "" {
+		p.SeriesNav, _ = seriesNav(p)
+	}
+	if robots := p.Robots(); robots != "" {
+		w.Header().Set("X-Robots-Tag", robots)
+	}
+
+	if p.ContentHTML, err = renderPostHTML(p, siteHost(r.Host)); err != nil {
+		httpError(w, r, "Could not render post: "+err.Error(), 500)
+		return
+	}
+	if p.ContentFormat == FormatMarkdown {
+		p.ContentMD = p.Content
+	}
 
-	jsonResponse(w, p)
+	if featureEnabled("experiments") {
+		if variants, err := loadVariants(p.Slug); err == nil && len(variants[p.Slug]) > 0 {
+			recordArms("views", map[string]int64{p.Slug: applyVariant(&p, variants[p.Slug], r)})
+		}
+	}
+	if p.Status == "published" {
+		recordView(p.Slug, r)
+	}
+
+	jsonCached(w, r, p, lastMod(p))
 }
 
-// POST /api/publish - The protected push endpoint
+// POST /api/publish?on_conflict=overwrite|rename|error - The protected push endpoint
 func handlePublish(w http.ResponseWriter, r *http.Request) {
-	// "Torvalds" Auth: Simple, fast, secure enough for personal use.
-	if r.Header.Get("X-MALT-KEY") != os.Getenv("MALT_SECRET") {
-		http.Error(w, "Go away", 401)
+	if !requireScope(w, r, scopePublish) {
 		return
 	}
 
+	// Either a JSON Post, or a Markdown file with front matter (Content-Type: text/markdown)
 	var p Post
-	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
-		http.Error(w, "Bad JSON", 400)
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "text/markdown" {
+		src, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPostBytes()))
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			httpError(w, r, fmt.Sprintf("Body is over %d MB", tooBig.Limit>>20), 413)
+			return
+		}
+		if err != nil {
+			httpError(w, r, "Could not read body", 400)
+			return
+		}
+		if p, err = postFromMarkdown(string(src)); err != nil {
+			httpError(w, r, "Bad front matter: "+err.Error(), 400)
+			return
+		}
+		if p.ContentFormat == "" {
+			p.ContentFormat = FormatMarkdown
+		}
+	} else if !decodeStrict(w, r, &p) {
 		return
 	}
 
-	// Auto-generate Slug if missing
-	if p.Slug == "" {
-		// 1. Lowercase
-		s := strings.ToLower(p.Title)
-		// 2. Remove anything that isn't a-z, 0-9, or space
-		reg := regexp.MustCompile("[^a-z0-9 ]+")
-		s = reg.ReplaceAllString(s, "")
-		// 3. Replace spaces with hyphens
-		p.Slug = strings.ReplaceAll(s, " ", "-")
+	// A slug the client picked that's already taken updates that post, unless asked otherwise
+	switch mode := r.URL.Query().Get("on_conflict"); mode {
+	case "", "overwrite":
+	case "error", "rename":
+		if p.Slug == "" {
+			break
+		}
+		slug := strings.ToLower(p.Slug)
+		var exists bool
+		db.QueryRow("SELECT EXISTS(SELECT 1 FROM posts WHERE slug = ?)", slug).Scan(&exists)
+		if exists && mode == "error" {
+			httpError(w, r, "A post with slug "+strconv.Quote(slug)+" already exists", 409)
+			return
+		}
+		if exists {
+			p.Slug = uniqueSlug(slug)
+		}
+	default:
+		httpError(w, r, "on_conflict must be overwrite, rename or error", 400)
+		return
 	}
 
-	p.PublishedAt = time.Now()
+	result, err := savePost(r, &p)
+	if err != nil {
+		writePublishError(w, r, err)
+		return
+	}
+
+	jsonResponse(w, result)
+}
 
-	_, err := db.Exec(`
-		INSERT INTO posts (slug, title, description, content, published_at) 
-		VALUES (?, ?, ?, ?, ?) 
-		ON CONFLICT(slug) DO UPDATE SET 
-			title=excluded.title, 
-			content=excluded.content, 
-			description=excluded.description
-	`, p.Slug, p.Title, p.Description, p.Content, p.PublishedAt)
+// writePublishError answers with what savePost refused, in as much detail as it gave.
+func writePublishError(w http.ResponseWriter, r *http.Request, err error) {
+	var perr *publishError
+	switch {
+	case !errors.As(err, &perr):
+		httpError(w, r, "Failed to save: "+err.Error(), 500)
+	case len(perr.Fields) > 0:
+		validationFailed(w, r, perr.Fields)
+	case len(perr.Issues) > 0:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(perr.Code)
+		json.NewEncoder(w).Encode(map[string]any{"error": perr.Msg, "status": perr.Code, "link_issues": perr.Issues})
+	default:
+		httpError(w, r, perr.Msg, perr.Code)
+	}
+}
+
+// PublishResult is the publish response: where the post lives, plus anything worth a second look.
+type PublishResult struct {
+	Status     string      `json:"status"`
+	Link       string      `json:"link"`
+	LinkIssues []LinkIssue `json:"link_issues,omitempty"`
+	Duplicates []Duplicate `json:"duplicates,omitempty"`
+}
+
+// publishError is a post savePost refused, with the HTTP status to answer with.
+type publishError struct {
+	Code   int
+	Msg    string
+	Issues []LinkIssue
+	Fields []FieldError
+}
+
+func (e *publishError) Error() string {
+	msg := e.Msg
+	for i, f := range e.Fields {
+		sep := ", "
+		if i == 0 {
+			sep = ": "
+		}
+		msg += sep + f.Field + " " + f.Message
+	}
+	return msg
+}
+
+// savePost fills in defaults, validates and upserts p. Every way of publishing goes through it.
+func savePost(r *http.Request, p *Post) (PublishResult, error) {
+	if p.Content == "" && p.ContentMD != "" {
+		p.Content, p.ContentFormat = p.ContentMD, FormatMarkdown
+	}
+	if p.ContentFormat == "" {
+		p.ContentFormat = FormatHTML
+	}
+	if p.Status == "" {
+		p.Status = "published"
+	}
 
+	// Link posts: one field in, title/description/image out
+	if p.Type == "" {
+		p.Type = "article"
+	}
+	if p.Type == "link" {
+		if err := unfurl(p); err != nil && p.Title == "" {
+			return PublishResult{}, &publishError{Code: 400, Msg: "Could not fetch link: " + err.Error()}
+		}
+	}
+
+	p.MastodonStatus = mastodonStatusID(p.MastodonStatus)
+	p.BlueskyURI = blueskyATURI(p.BlueskyURI)
+
+	// Slugs are lowercase so /post/{slug} has exactly one spelling
+	p.Slug = strings.ToLower(p.Slug)
+
+	// Auto-generate Slug if missing; a title that's been used before gets -2, -3, ...
+	generated := p.Slug == ""
+	if generated {
+		p.Slug = uniqueSlug(slugify(p.Title))
+	}
+
+	if fields := validatePost(*p); len(fields) > 0 {
+		return PublishResult{}, &publishError{Code: 422, Msg: "Validation failed", Fields: fields}
+	}
+
+	// Trusting a post's HTML is worth a paper trail, and so is taking the trust away
+	var wasRaw, trashed bool
+	var before string
+	var storedAt time.Time
+	db.QueryRow("SELECT raw_html, status, deleted_at IS NOT NULL, published_at FROM posts WHERE slug = ?", p.Slug).Scan(&wasRaw, &before, &trashed, &storedAt)
+	wasLive := isLive(Post{Status: before, PublishedAt: storedAt})
+	if trashed {
+		return PublishResult{}, &publishError{Code: 409, Msg: "A post in the trash has this slug; restore it or purge it first"}
+	}
+
+	// Editing a raw_html post counts as setting it, since the new content would run
+	// unsanitized too. With MALT_RAW_HTML=off nothing runs unsanitized, so only new trust is refused.
+	if p.RawHTML && !rawHTMLAllowed(r) {
+		if rawHTMLMode() != "off" {
+			return PublishResult{}, &publishError{Code: 403, Msg: "raw_html needs an admin key; send raw_html: false to have the post sanitized"}
+		}
+		if !wasRaw {
+			return PublishResult{}, &publishError{Code: 403, Msg: "raw_html is turned off on this site (MALT_RAW_HTML=off)"}
+		}
+	}
+
+	// Imports keep their original date and a post published again under its slug its own,
+	// except a draft going live, which is published now, as by POST /api/posts/{slug}/publish.
+	// Everything else is published now.
+	if p.PublishedAt.IsZero() && !(before == "draft" && p.Status == "published") {
+		p.PublishedAt = storedAt
+	}
+	if p.PublishedAt.IsZero() {
+		p.PublishedAt = time.Now()
+	}
+
+	result := PublishResult{Status: p.Status, Link: "/post/" + p.Slug}
+	if mode := linkCheckMode(); mode != "off" {
+		result.LinkIssues = checkInternalLinks(*p, siteHost(r.Host))
+		if mode == "block" && len(result.LinkIssues) > 0 {
+			return result, &publishError{Code: 422, Msg: "Broken internal links", Issues: result.LinkIssues}
+		}
+	}
+
+	// Importers have a habit of creating the same post twice under slightly different slugs
+	fingerprint := simhash(p.Title, p.ContentFormat, p.Content)
+	result.Duplicates = findDuplicates(p.Slug, fingerprint)
+
+	if p.Tags != nil {
+		p.Tags = normalizeTags(p.Tags)
+	}
+	stored, err := withRendered(*p)
 	if err != nil {
-		http.Error(w, "Failed to save: "+err.Error(), 500)
-		return
+		return result, &publishError{Code: 400, Msg: "Could not render content: " + err.Error()}
 	}
 
-	jsonResponse(w, map[string]string{"status": "published", "link": "/post/" + p.Slug})
+	// A generated slug is a new post's, never an update: if another post took it after
+	// uniqueSlug looked, InsertPost picks the next free one
+	if generated {
+		err = store.InsertPost(r.Context(), &stored, "publish")
+	} else {
+		err = store.UpsertPost(r.Context(), &stored, "publish")
+	}
+	if err != nil {
+		return result, err
+	}
+	p.Slug, p.UpdatedAt, p.SeriesPosition = stored.Slug, stored.UpdatedAt, stored.SeriesPosition
+	result.Link = "/post/" + p.Slug
+	postChanged(r, p.Slug, wasLive)
+
+	if p.RawHTML && !wasRaw {
+		audit(r, "raw_html.enable", p.Slug, "sanitizer bypassed for this post")
+	} else if !p.RawHTML && wasRaw {
+		audit(r, "raw_html.disable", p.Slug, "")
+	}
+
+	if p.MastodonStatus != "" && featureEnabled("federation") {
+		go refreshMastodonReplies(p.Slug, p.MastodonStatus)
+	}
+
+	return result, nil
+}
+
+// slugify turns a title into a URL slug: "Über Go: Part 2" becomes "uber-go-part-2".
+// Scripts without a Latin spelling keep their letters, so a Greek title gets a Greek slug.
+func slugify(title string) string {
+	// 1. Split accented letters into letter + accent
+	s := strings.ToLower(norm.NFKD.String(title))
+
+	// 2. Keep letters and digits, drop Latin accents and apostrophes, and turn every other run
+	// of characters into one hyphen
+	var b strings.Builder
+	var base rune
+	pendingHyphen := false
+	for _, r := range s {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			if !unicode.Is(unicode.Latin, base) {
+				b.WriteRune(r) // Kana voicing marks and the like are part of the letter
+			}
+			continue
+		case r == '\'' || r == '’':
+			continue
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			pendingHyphen = b.Len() > 0
+			continue
+		}
+		if pendingHyphen {
+			b.WriteByte('-')
+			pendingHyphen = false
+		}
+		if t, ok := transliterations[r]; ok {
+			b.WriteString(t)
+		} else {
+			b.WriteRune(r)
+		}
+		base = r
+	}
+
+	// 3. Put back together what step 1 took apart but step 2 kept
+	return norm.NFC.String(b.String())
+}
+
+// transliterations spell out Latin letters that don't decompose into letter + accent.
+var transliterations = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'ł': "l", 'đ': "d", 'ð': "d", 'þ': "th", 'ı': "i",
+}
+
+// uniqueSlug appends -2, -3, ... until slug is free, so a new post never overwrites another.
+func uniqueSlug(slug string) string {
+	if slug == "" {
+		slug = "untitled"
+	}
+	candidate := slug
+	for n := 2; ; n++ {
+		var exists bool
+		if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM posts WHERE slug = ?)", candidate).Scan(&exists); err != nil || !exists {
+			return candidate
+		}
+		candidate = slug + "-" + strconv.Itoa(n)
+	}
 }
 
-// DELETE /api/posts/{slug} - Remove a post
+// DELETE /api/posts/{slug} - Move a post to the trash
 func handleDeletePost(w http.ResponseWriter, r *http.Request) {
 	// 1. Auth Check
-	if r.Header.Get("X-MALT-KEY") != os.Getenv("MALT_SECRET") {
-		http.Error(w, "Go away", 401)
+	if !requireKey(w, r) {
 		return
 	}
 
 	slug := r.PathValue("slug")
 
 	// 2. Execute Delete
-	result, err := db.Exec("DELETE FROM posts WHERE slug = ?", slug)
+	deleted, err := store.DeletePost(slug)
 	if err != nil {
-		http.Error(w, "Database error: "+err.Error(), 500)
+		httpError(w, r, "Database error: "+err.Error(), 500)
 		return
 	}
 
 	// 3. Verify if anything was actually deleted
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		http.Error(w, "Post not found", 404)
+	if !deleted {
+		httpError(w, r, "Post not found", 404)
 		return
 	}
+	postDeleted(r, slug)
 
 	jsonResponse(w, map[string]string{"status": "deleted", "slug": slug})
 }
@@ -160,40 +510,139 @@ func handleDeletePost(w http.ResponseWriter, r *http.Request) {
 // PUT /api/posts/{slug} - Update an existing post
 func handleUpdatePost(w http.ResponseWriter, r *http.Request) {
 	// 1. Auth Check
-	if r.Header.Get("X-MALT-KEY") != os.Getenv("MALT_SECRET") {
-		http.Error(w, "Go away", 401)
+	if !requireScope(w, r, scopePublish) {
 		return
 	}
 
 	slug := r.PathValue("slug")
 
-	// 2. Parse the updates
+	// 2. Parse the updates. PUT replaces, so a missing title or content is an error, not a blank
+	// (PATCH changes single fields).
 	var p Post
-	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
-		http.Error(w, "Bad JSON", 400)
+	if !decodeStrict(w, r, &p) {
+		return
+	}
+
+	// The rest of the post comes from what's stored, so it's checked as a whole, like savePost does
+	stored, err := store.GetPost(slug)
+	if err == sql.ErrNoRows {
+		httpError(w, r, "Post not found", 404)
+		return
+	}
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	// As in savePost: new content in a raw_html post runs unsanitized too
+	if stored.RawHTML && !rawHTMLAllowed(r) && rawHTMLMode() != "off" {
+		httpError(w, r, "raw_html needs an admin key; PATCH raw_html: false to have the post sanitized", 403)
+		return
+	}
+	stored.Title, stored.Description, stored.Content = p.Title, p.Description, p.Content
+	if fields := validatePost(stored); len(fields) > 0 {
+		validationFailed(w, r, fields)
 		return
 	}
 
 	// 3. Execute Update (We do NOT update the slug or published_at to preserve history/links)
 	// We only update Title, Description, and Content.
-	result, err := db.Exec(`
-        UPDATE posts 
-        SET title = ?, description = ?, content = ? 
-        WHERE slug = ?
-    `, p.Title, p.Description, p.Content, slug)
+	wasLive := isLive(stored)
+	if stored, err = withRendered(stored); err != nil {
+		httpError(w, r, "Could not render content: "+err.Error(), 400)
+		return
+	}
+	if err := store.UpsertPost(r.Context(), &stored, "update"); err != nil {
+		httpError(w, r, "Database error: "+err.Error(), 500)
+		return
+	}
+	postChanged(r, slug, wasLive)
+
+	jsonResponse(w, map[string]string{"status": "updated", "slug": slug})
+}
+
+// postPatch is a PATCH body: nil means "leave it alone", so only fields sent are changed.
+type postPatch struct {
+	Title          *string    `json:"title"`
+	Description    *string    `json:"description"`
+	Content        *string    `json:"content"`
+	ContentFormat  *string    `json:"content_format"`
+	Status         *string    `json:"status"`
+	Tags           *[]string  `json:"tags"`
+	PublishedAt    *time.Time `json:"published_at"`
+	NoIndex        *bool      `json:"noindex"`
+	NoFollow       *bool      `json:"nofollow"`
+	RawHTML        *bool      `json:"raw_html"`
+	AuthorID       *int64     `json:"author_id"` // 0 takes the byline off
+	Series         *string    `json:"series"`    // "" takes the post out of its series
+	SeriesPosition *int       `json:"series_position"`
+}
+
+// PATCH /api/posts/{slug} - Change only the fields sent; unknown ones (slug included) are refused
+func handlePatchPost(w http.ResponseWriter, r *http.Request) {
+	if !requireScope(w, r, scopePublish) {
+		return
+	}
+
+	var patch postPatch
+	if !decodeStrict(w, r, &patch) {
+		return
+	}
 
+	p, err := store.GetPost(r.PathValue("slug"))
 	if err != nil {
-		http.Error(w, "Database error: "+err.Error(), 500)
+		httpError(w, r, "Post not found", 404)
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		http.Error(w, "Post not found", 404)
+	set := func(dst *string, v *string) {
+		if v != nil {
+			*dst = *v
+		}
+	}
+	set(&p.Title, patch.Title)
+	set(&p.Description, patch.Description)
+	set(&p.Content, patch.Content)
+	set(&p.ContentFormat, patch.ContentFormat)
+	if p.Status == "draft" && patch.Status != nil && *patch.Status == "published" && patch.PublishedAt == nil {
+		p.PublishedAt = time.Time{} // A draft that's published without a date is published now
+	}
+	set(&p.Status, patch.Status)
+	if patch.Tags != nil {
+		p.Tags = *patch.Tags // [] clears them
+	}
+	if patch.PublishedAt != nil {
+		p.PublishedAt = *patch.PublishedAt
+	}
+	if patch.NoIndex != nil {
+		p.NoIndex = *patch.NoIndex
+	}
+	if patch.NoFollow != nil {
+		p.NoFollow = *patch.NoFollow
+	}
+	if patch.RawHTML != nil {
+		p.RawHTML = *patch.RawHTML
+	}
+	if patch.AuthorID != nil {
+		p.AuthorID = *patch.AuthorID
+	}
+	if patch.Series != nil && *patch.Series != p.Series {
+		p.Series, p.SeriesPosition = *patch.Series, 0 // To the end of the new series, unless told otherwise
+	}
+	if patch.SeriesPosition != nil {
+		p.SeriesPosition = *patch.SeriesPosition
+	}
+	if _, err := savePost(r, &p); err != nil {
+		writePublishError(w, r, err)
 		return
 	}
 
-	jsonResponse(w, map[string]string{"status": "updated", "slug": slug})
+	jsonResponse(w, map[string]string{"status": "updated", "slug": p.Slug})
+}
+
+// requireKey is the "Torvalds" Auth: Simple, fast, secure enough for personal use.
+// It takes an admin key (see keys.go) and writes the 401 itself, so callers just return on false.
+func requireKey(w http.ResponseWriter, r *http.Request) bool {
+	return requireScope(w, r, scopeAdmin)
 }
 
 // Helper for JSON
@@ -204,8 +653,37 @@ func jsonResponse(w http.ResponseWriter, data any) {
 
 // --- 4. The Core ---
 func main() {
+	// `single-malt publish post.md` and friends talk to a running server instead of being one
+	if runCLI(os.Args[1:]) {
+		return
+	}
+
+	exportDir := flag.String("export", "", "write the site as static files to this directory, then exit")
+	importFrom := flag.String("import", "", "import a folder of Markdown posts (Hugo, Jekyll, ...), then exit")
+	setupLogging()
+	loadConfig()
+
 	initDB()
 	defer db.Close()
+	initRevisions()
+	loadFlags()
+	loadFrontMatterConfig()
+	loadEmoji()
+	loadAgeRecipients()
+	initReadingStats()
+
+	if *importFrom != "" {
+		if err := importDir(*importFrom); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *exportDir != "" {
+		if err := exportStatic(*exportDir); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	mux := http.NewServeMux()
 
@@ -216,20 +694,220 @@ func main() {
 
 	// --- NEW ROUTES ---
 	mux.HandleFunc("DELETE /api/posts/{slug}", handleDeletePost)
+	mux.HandleFunc("POST /api/posts/{slug}/restore", handleRestorePost)
+	mux.HandleFunc("GET /api/trash", handleListTrash)
+	mux.HandleFunc("DELETE /api/trash", handleEmptyTrash)
+	mux.HandleFunc("DELETE /api/trash/{slug}", handlePurgePost)
 	mux.HandleFunc("PUT /api/posts/{slug}", handleUpdatePost)
-	// 2. Serve Frontend (SPA Catch-all)
-	// This serves index.html for any route that doesn't match above (e.g., /post/my-slug)
+	mux.HandleFunc("PATCH /api/posts/{slug}", handlePatchPost)
+	mux.HandleFunc("POST /api/posts/{slug}/publish", handlePublishDraft)
+	mux.HandleFunc("GET /api/posts/{slug}/revisions", handleListRevisions)
+	mux.HandleFunc("POST /api/posts/{slug}/revert/{rev}", handleRevertPost)
+	mux.HandleFunc("GET /api/drafts", handleListDrafts)
+	mux.HandleFunc("GET /api/tags", handleListTags)
+	mux.HandleFunc("GET /api/search", handleSearch)
+	mux.HandleFunc("GET /api/stats", handleStats)
+	mux.HandleFunc("GET /api/calendar", handleCalendar)
+	mux.HandleFunc("GET /api/archive", handleArchive)
+	mux.HandleFunc("GET /api/archive/{year}", handleArchive)
+	mux.HandleFunc("GET /api/archive/{year}/{month}", handleArchive)
+	mux.HandleFunc("GET /api/posts/{slug}/preview-link", handlePreviewLink)
+	mux.HandleFunc("GET /api/authors", handleListAuthors)
+	mux.HandleFunc("GET /api/authors/{id}", handleGetAuthor)
+	mux.HandleFunc("GET /api/authors/{id}/posts", handleListAuthorPosts)
+	mux.HandleFunc("POST /api/authors", handleCreateAuthor)
+	mux.HandleFunc("PUT /api/authors/{id}", handleUpdateAuthor)
+	mux.HandleFunc("DELETE /api/authors/{id}", handleDeleteAuthor)
+	mux.HandleFunc("GET /api/series", handleListSeries)
+	mux.HandleFunc("GET /api/series/{slug}", handleGetSeries)
+	mux.HandleFunc("POST /api/series", handleCreateSeries)
+	mux.HandleFunc("PUT /api/series/{slug}", handleUpdateSeries)
+	mux.HandleFunc("DELETE /api/series/{slug}", handleDeleteSeries)
+	mux.HandleFunc("GET /api/openapi.json", handleOpenAPI)
+	mux.HandleFunc("GET /api/docs", handleAPIDocs)
+	if os.Getenv("MALT_MAILGUN_SIGNING_KEY") != "" {
+		mux.HandleFunc("POST /api/inbound/mailgun", handleMailgunInbound)
+	}
+	mux.HandleFunc("GET /api/audit", handleListAudit)
+	mux.HandleFunc("GET /api/keys", handleListKeys)
+	mux.HandleFunc("POST /api/keys", handleCreateKey)
+	mux.HandleFunc("DELETE /api/keys/{id}", handleRevokeKey)
+
+	// Webhooks
+	mux.HandleFunc("GET /api/webhooks", handleListWebhooks)
+	mux.HandleFunc("POST /api/webhooks", handleCreateWebhook)
+	mux.HandleFunc("PUT /api/webhooks/{id}", handleUpdateWebhook)
+	mux.HandleFunc("DELETE /api/webhooks/{id}", handleDeleteWebhook)
+	mux.HandleFunc("POST /api/webhooks/{id}/ping", handlePingWebhook)
+	mux.HandleFunc("GET /api/webhooks/{id}/deliveries", handleListDeliveries)
+	mux.HandleFunc("GET /api/flags", handleListFlags)
+	mux.HandleFunc("PUT /api/flags/{name}", handleSetFlag)
+	mux.HandleFunc("DELETE /api/flags/{name}", handleResetFlag)
+
+	// Optional subsystems, each behind a feature flag
+	mux.HandleFunc("GET /api/posts/{slug}/mastodon-comments", feature("federation", handleMastodonComments))
+	mux.HandleFunc("GET /api/posts/{slug}/bluesky-comments", feature("federation", handleBlueskyComments))
+	mux.HandleFunc("POST /api/import/disqus", feature("comments", handleImportDisqus))
+	mux.HandleFunc("GET /api/posts/{slug}/comments", feature("comments", handleListComments))
+	mux.HandleFunc("POST /api/posts/{slug}/comments", feature("comments", handleCreateComment))
+	mux.HandleFunc("GET /api/comments", feature("comments", handleModerationQueue))
+	mux.HandleFunc("POST /api/comments/{id}/approve", feature("comments", handleApproveComment))
+	mux.HandleFunc("DELETE /api/comments/{id}", feature("comments", handleDeleteComment))
+
+	// ActivityPub
+	mux.HandleFunc("GET /.well-known/webfinger", feature("activitypub", handleWebFinger))
+	mux.HandleFunc("GET /ap/actor", feature("activitypub", handleActor))
+	mux.HandleFunc("GET /ap/outbox", feature("activitypub", handleOutbox))
+	mux.HandleFunc("GET /ap/followers", feature("activitypub", handleFollowers))
+	mux.HandleFunc("GET /ap/notes/{slug}", feature("activitypub", handleNote))
+	mux.HandleFunc("POST /ap/inbox", feature("activitypub", handleInbox))
+	mux.HandleFunc("GET /api/activitypub/followers", feature("activitypub", handleListFollowers))
+
+	// Newsletter
+	mux.HandleFunc("POST /api/subscribe", feature("newsletter", handleSubscribe))
+	mux.HandleFunc("GET /subscribe/confirm", feature("newsletter", handleConfirmPage))
+	mux.HandleFunc("POST /subscribe/confirm", feature("newsletter", handleConfirmSubscription))
+	mux.HandleFunc("GET /unsubscribe", feature("newsletter", handleUnsubscribePage))
+	mux.HandleFunc("POST /unsubscribe", feature("newsletter", handleUnsubscribe))
+	mux.HandleFunc("GET /api/subscribers", feature("newsletter", handleListSubscribers))
+	mux.HandleFunc("DELETE /api/subscribers/{id}", feature("newsletter", handleDeleteSubscriber))
+
+	// Title experiments
+	mux.HandleFunc("GET /api/posts/{slug}/variants", feature("experiments", handleListVariants))
+	mux.HandleFunc("POST /api/posts/{slug}/variants", feature("experiments", handleCreateVariant))
+	mux.HandleFunc("DELETE /api/posts/{slug}/variants/{id}", feature("experiments", handleDeleteVariant))
+	mux.HandleFunc("POST /api/posts/{slug}/variants/{id}/winner", feature("experiments", handlePickVariant))
+
+	// Blogroll
+	mux.HandleFunc("GET /api/links", handleListLinks)
+	mux.HandleFunc("GET /api/links/export", handleExportLinks)
+	mux.HandleFunc("POST /api/links", handleCreateLink)
+	mux.HandleFunc("PUT /api/links/{id}", handleUpdateLink)
+	mux.HandleFunc("DELETE /api/links/{id}", handleDeleteLink)
+	mux.HandleFunc("POST /api/import/opml", handleImportOPML)
+	mux.HandleFunc("POST /api/import", handleImport)
+	mux.HandleFunc("GET /api/backup", handleBackup)
+	mux.HandleFunc("POST /api/restore", handleRestore)
+
+	// Media
+	mux.HandleFunc("POST /api/media", handleUploadMedia)
+	mux.HandleFunc("GET /api/media", handleListMedia)
+	mux.HandleFunc("DELETE /api/media/{id}", handleDeleteMedia)
+	mux.HandleFunc("GET /media/{id}", handleServeMedia)
+
+	// Planet
+	mux.HandleFunc("GET /api/feeds", feature("planet", handleListFeeds))
+	mux.HandleFunc("POST /api/feeds", feature("planet", handleCreateFeed))
+	mux.HandleFunc("DELETE /api/feeds/{id}", feature("planet", handleDeleteFeed))
+	mux.HandleFunc("GET /api/firehose", feature("planet", handleFirehose))
+
+	// 2. Serve Frontend (SPA)
+	// index.html handles the known SPA routes; anything else gets a real 404 page.
+	mux.HandleFunc("GET /{$}", handleIndex)
+	mux.HandleFunc("GET /post/{slug}", handlePostPage)
+	mux.HandleFunc("GET /links", handleLinksPage)
+	mux.HandleFunc("GET /rsd.xml", handleRSD)
+	mux.HandleFunc("GET /blogroll.opml", handleBlogrollOPML)
+	mux.HandleFunc("GET /sitemap.xml", handleSitemap)
+	mux.HandleFunc("GET /robots.txt", handleRobotsTxt)
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.HandleFunc("GET /metrics", handleMetrics)
+	mux.HandleFunc("GET /feed.xml", handleRSS)
+	mux.HandleFunc("GET /atom.xml", handleAtom)
+	mux.HandleFunc("GET /sitemaps/{page}", handleSitemapPage)
+	mux.HandleFunc("POST /xmlrpc", handleXMLRPC)
+	mux.HandleFunc("POST /xmlrpc.php", handleXMLRPC) // Where editors look first
+	if envBool("MALT_PLANET_PAGE", false) {
+		mux.HandleFunc("GET /planet", feature("planet", handlePlanetPage))
+	}
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		// Serve index.html directly
-		http.ServeFile(w, r, "static/index.html")
+		httpError(w, r, "Nothing lives at this address.", 404)
+	})
+
+	// Background jobs
+	go runPlanet()
+	go runMastodon()
+	go runWebhooks()
+	go runTrash()
+	go runAnnouncements()
+
+	// Every listener sees the same middleware stack.
+	site := accessLog(instrument(cors(compress(rateLimit(canonicalURL(mux))))))
+
+	// 3. Listeners
+	// A domain to get certificates for replaces all of the below with :80 and :443.
+	if domains := os.Getenv("MALT_DOMAIN"); domains != "" {
+		log.Fatal(serveAutocert(site, domains))
+	}
+
+	// Plaintext always runs. h2c (prior knowledge) is opt-in for reverse proxies that speak it.
+	plain := newServer(cfg.Listen, site)
+	plain.Protocols = new(http.Protocols)
+	plain.Protocols.SetHTTP1(true)
+	plain.Protocols.SetUnencryptedHTTP2(envBool("MALT_H2C", false))
+
+	// TLS is opt-in: point MALT_TLS_CERT/MALT_TLS_KEY at a cert pair. HTTP/2 is on by default there.
+	certFile, keyFile := os.Getenv("MALT_TLS_CERT"), os.Getenv("MALT_TLS_KEY")
+	if certFile != "" && keyFile != "" {
+		handler := site
+
+		// HTTP/3 rides on UDP next to the TLS listener; clients discover it via Alt-Svc.
+		if envBool("MALT_HTTP3", false) {
+			h3 := &http3.Server{Addr: cfg.TLSListen, Handler: site}
+			handler = altSvc(h3, site)
+
+			go func() {
+				log.Println("Malt (QUIC) running on udp " + cfg.TLSListen)
+				log.Fatal(h3.ListenAndServeTLS(certFile, keyFile))
+			}()
+		}
+
+		secure := newServer(cfg.TLSListen, handler)
+		secure.Protocols = new(http.Protocols)
+		secure.Protocols.SetHTTP1(true)
+		secure.Protocols.SetHTTP2(envBool("MALT_HTTP2", true))
+
+		go func() {
+			log.Println("Malt (TLS) running on " + cfg.TLSListen)
+			log.Fatal(secure.ListenAndServeTLS(certFile, keyFile))
+		}()
+	}
+
+	log.Println("Malt running on " + cfg.Listen)
+	log.Fatal(plain.ListenAndServe())
+}
+
+func newServer(addr string, h http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         addr,
+		Handler:      h,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+}
+
+// altSvc advertises the QUIC listener on every TCP response so clients can switch over.
+func altSvc(h3 *http3.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h3.SetQUICHeaders(w.Header())
+		next.ServeHTTP(w, r)
 	})
+}
+
+// envBool reads a boolean toggle like MALT_H2C=1, falling back to def when unset or garbage.
+func envBool(key string, def bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
 
-	log.Println("Malt running on :8080")
-	server := &http.Server{
-		Addr:         ":8080",
-		Handler:      mux,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
+func envInt(key string, def int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
 	}
-	log.Fatal(server.ListenAndServe())
+	return n
 }
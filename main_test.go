@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		title, want string
+	}{
+		{"Hello, World!", "hello-world"},
+		{"  Trim me  ", "trim-me"},
+		{"Crème brûlée", "creme-brulee"},
+		{"Straße", "strasse"},
+		{"Don't panic", "dont-panic"},
+		{"Ünïcödé — dash", "unicode-dash"},
+		{"C++ & Go", "c-go"},
+		{"snake_case_title", "snake-case-title"},
+		{"2024: a year", "2024-a-year"},
+		{"Привет мир", "привет-мир"},
+		{"ガイド", "ガイド"}, // The voicing mark stays with its kana
+		{"!!!", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got := slugify(tt.title)
+		if got != tt.want {
+			t.Errorf("slugify(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+		if got != "" && !validSlug.MatchString(got) {
+			t.Errorf("slugify(%q) = %q, which validatePost refuses", tt.title, got)
+		}
+	}
+}
+
+func TestUniqueSlug(t *testing.T) {
+	testServer(t)
+	publish(t, `{"slug": "taken", "title": "Taken", "content": "<p>Body</p>"}`)
+	publish(t, `{"slug": "taken-2", "title": "Taken", "content": "<p>Body</p>"}`)
+
+	tests := []struct {
+		slug, want string
+	}{
+		{"free", "free"},
+		{"taken", "taken-3"},
+		{"", "untitled"},
+	}
+	for _, tt := range tests {
+		if got := uniqueSlug(tt.slug); got != tt.want {
+			t.Errorf("uniqueSlug(%q) = %q, want %q", tt.slug, got, tt.want)
+		}
+	}
+}
+
+// publish saves a post through POST /api/publish with the admin key, failing the test if it's refused.
+func publish(t *testing.T, body string) {
+	t.Helper()
+	if w := call(handlePublish, "POST", "", "admin", body); w.Code != http.StatusOK {
+		t.Fatalf("publishing %s: %d %s", body, w.Code, w.Body)
+	}
+}
+
+// A PATCH saves every field it sends, published_at included.
+func TestPatchRoundTrip(t *testing.T) {
+	testServer(t)
+	publish(t, `{"slug": "dated", "title": "Dated", "content": "<p>Body</p>"}`)
+
+	w := call(handlePatchPost, "PATCH", "dated", "admin",
+		`{"title": "Redated", "published_at": "2020-01-01T00:00:00Z", "noindex": true, "tags": ["go"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PATCH: %d %s", w.Code, w.Body)
+	}
+
+	p, err := store.GetPost("dated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC); !p.PublishedAt.Equal(want) {
+		t.Errorf("published_at = %v, want %v", p.PublishedAt, want)
+	}
+	if p.Title != "Redated" || !p.NoIndex || len(p.Tags) != 1 || p.Tags[0] != "go" {
+		t.Errorf("after PATCH: title %q, noindex %t, tags %q", p.Title, p.NoIndex, p.Tags)
+	}
+	if p.Content != "<p>Body</p>" {
+		t.Errorf("content not sent but changed: %q", p.Content)
+	}
+
+	// Published again under its slug without a date, the post keeps the one it has
+	publish(t, `{"slug": "dated", "title": "Redated", "content": "<p>New body</p>"}`)
+	if p, _ := store.GetPost("dated"); !p.PublishedAt.Equal(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("published_at after publishing again = %v", p.PublishedAt)
+	}
+}
+
+// A draft published through PATCH goes live now, not on the day it was started, so it's
+// announced like one published through POST /api/posts/{slug}/publish.
+func TestPatchPublishesDraftNow(t *testing.T) {
+	testServer(t)
+	publish(t, `{"slug": "old-draft", "title": "Old draft", "content": "<p>Body</p>", "status": "draft", "published_at": "2020-01-01T00:00:00Z"}`)
+
+	start := time.Now()
+	if w := call(handlePatchPost, "PATCH", "old-draft", "admin", `{"status": "published"}`); w.Code != http.StatusOK {
+		t.Fatalf("PATCH: %d %s", w.Code, w.Body)
+	}
+	p, err := store.GetPost("old-draft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != "published" || p.PublishedAt.Before(start.Add(-time.Second)) {
+		t.Errorf("after publishing: status %q, published_at %v", p.Status, p.PublishedAt)
+	}
+	if !announced("old-draft") {
+		t.Error("the draft went live but wasn't announced")
+	}
+
+	// With a date, the date stands
+	publish(t, `{"slug": "dated-draft", "title": "Dated draft", "content": "<p>Body</p>", "status": "draft"}`)
+	call(handlePatchPost, "PATCH", "dated-draft", "admin", `{"status": "published", "published_at": "2020-01-01T00:00:00Z"}`)
+	if p, _ := store.GetPost("dated-draft"); !p.PublishedAt.Equal(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("published_at = %v, want the one sent", p.PublishedAt)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// --- Mastodon: replies to a post's syndicated status, shown under the post ---
+
+// RemoteAuthor is someone replying from another network.
+type RemoteAuthor struct {
+	Name   string `json:"name"`
+	Handle string `json:"handle"`
+	URL    string `json:"url"`
+	Avatar string `json:"avatar"`
+}
+
+// RemoteReply is a cached reply from the Fediverse. Content is plain text: we don't
+// trust someone else's HTML enough to put it on our pages.
+type RemoteReply struct {
+	ID        string       `json:"id"`
+	URL       string       `json:"url"`
+	InReplyTo string       `json:"in_reply_to"`
+	Author    RemoteAuthor `json:"author"`
+	Content   string       `json:"content"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// mastodonStatus is the slice of the Mastodon status entity we care about.
+type mastodonStatus struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	InReplyToID string    `json:"in_reply_to_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Content     string    `json:"content"`
+	Visibility  string    `json:"visibility"`
+	Account     struct {
+		DisplayName string `json:"display_name"`
+		Acct        string `json:"acct"`
+		URL         string `json:"url"`
+		Avatar      string `json:"avatar"`
+	} `json:"account"`
+}
+
+var mastodonClient = &http.Client{Timeout: 10 * time.Second}
+
+// mastodonStatusID accepts a bare ID or a full status URL (https://host/@me/1234) and keeps the ID.
+func mastodonStatusID(s string) string {
+	s = strings.TrimSuffix(strings.TrimSpace(s), "/")
+	return s[strings.LastIndex(s, "/")+1:]
+}
+
+// refreshMastodonReplies replaces the cached replies for a post with the status's current thread.
+func refreshMastodonReplies(slug, statusID string) {
+	instance := strings.TrimSuffix(os.Getenv("MALT_MASTODON_INSTANCE"), "/")
+	if instance == "" {
+		return
+	}
+
+	replies, err := fetchMastodonContext(instance, statusID)
+	if err != nil {
+		log.Printf("mastodon: %s: %v", slug, err)
+		return
+	}
+
+	// Replace wholesale so deleted replies disappear too
+	err = withTx(context.Background(), func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM mastodon_replies WHERE post_slug = ?", slug); err != nil {
+			return err
+		}
+		for _, s := range replies {
+			if s.Visibility != "public" && s.Visibility != "unlisted" {
+				continue
+			}
+			_, err := tx.Exec(`
+				INSERT INTO mastodon_replies
+					(id, post_slug, url, in_reply_to, author_name, author_handle, author_url, author_avatar, content, created_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT(id) DO UPDATE SET `+replaceReply+`
+			`, s.ID, slug, s.URL, s.InReplyToID, s.Account.DisplayName, s.Account.Acct, s.Account.URL, s.Account.Avatar,
+				plainText(s.Content), s.CreatedAt.UTC())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("mastodon: %s: %v", slug, err)
+	}
+}
+
+// fetchMastodonContext returns every reply below a status (the "descendants" of its context).
+func fetchMastodonContext(instance, statusID string) ([]mastodonStatus, error) {
+	req, err := http.NewRequest("GET", instance+"/api/v1/statuses/"+statusID+"/context", nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("MALT_MASTODON_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := mastodonClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	var context struct {
+		Descendants []mastodonStatus `json:"descendants"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&context); err != nil {
+		return nil, err
+	}
+	return context.Descendants, nil
+}
+
+// runMastodon is the background job: refresh replies for every syndicated post
+// every MALT_MASTODON_INTERVAL (default 15m). Needs MALT_MASTODON_INSTANCE and the federation flag.
+func runMastodon() {
+	if os.Getenv("MALT_MASTODON_INSTANCE") == "" {
+		return
+	}
+
+	every, err := time.ParseDuration(os.Getenv("MALT_MASTODON_INTERVAL"))
+	if err != nil || every < time.Minute {
+		every = 15 * time.Minute
+	}
+
+	for ; ; time.Sleep(every) {
+		if !featureEnabled("federation") {
+			continue
+		}
+
+		rows, err := db.Query("SELECT slug, mastodon_status FROM posts WHERE mastodon_status != '' AND deleted_at IS NULL")
+		if err != nil {
+			log.Printf("mastodon: %v", err)
+		} else {
+			pending := map[string]string{}
+			for rows.Next() {
+				var slug, status string
+				if rows.Scan(&slug, &status) == nil {
+					pending[slug] = status
+				}
+			}
+			rows.Close()
+
+			for slug, status := range pending {
+				refreshMastodonReplies(slug, status)
+			}
+		}
+	}
+}
+
+// GET /api/posts/{slug}/mastodon-comments - Cached Fediverse replies, oldest first
+func handleMastodonComments(w http.ResponseWriter, r *http.Request) {
+	replies, err := cachedReplies("mastodon_replies", r.PathValue("slug"))
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+
+	jsonResponse(w, replies)
+}
+
+// replaceReply is the SET clause for a reply seen again, in either cache.
+const replaceReply = `post_slug = excluded.post_slug, url = excluded.url, in_reply_to = excluded.in_reply_to,
+	author_name = excluded.author_name, author_handle = excluded.author_handle, author_url = excluded.author_url,
+	author_avatar = excluded.author_avatar, content = excluded.content, created_at = excluded.created_at`
+
+// cachedReplies reads one of the remote reply caches (mastodon_replies, bluesky_replies) for a post.
+func cachedReplies(table, slug string) ([]RemoteReply, error) {
+	rows, err := db.Query(`
+		SELECT id, url, in_reply_to, author_name, author_handle, author_url, author_avatar, content, created_at
+		FROM `+table+` WHERE post_slug = ? ORDER BY created_at`, slug)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	replies := []RemoteReply{}
+	for rows.Next() {
+		var c RemoteReply
+		err := rows.Scan(&c.ID, &c.URL, &c.InReplyTo, &c.Author.Name, &c.Author.Handle, &c.Author.URL, &c.Author.Avatar,
+			&c.Content, &c.CreatedAt)
+		if err != nil {
+			continue
+		}
+		replies = append(replies, c)
+	}
+	return replies, rows.Err()
+}
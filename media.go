@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Media: images stored in malt.db, served from /media/{id} ---
+//
+// An asset's ID is the start of its SHA-256, so uploading the same screenshot twice gives
+// back the same URL, and a URL never points at different bytes.
+
+// Media is one stored asset. The bytes themselves only come out of /media/{id}.
+type Media struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+	Existing    bool      `json:"existing,omitempty"` // The same bytes were already uploaded
+}
+
+var (
+	errMediaType     = errors.New("only images can be uploaded")
+	errMediaTooLarge = errors.New("file is too large")
+)
+
+// Sniffed types that are safe to serve back from our own origin. No SVG: it can carry script.
+var mediaTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"image/bmp":  ".bmp",
+	"image/avif": ".avif",
+}
+
+// maxMediaSize is MALT_MEDIA_MAX_MB (default 10), in bytes.
+func maxMediaSize() int64 {
+	if n, err := strconv.ParseInt(os.Getenv("MALT_MEDIA_MAX_MB"), 10, 64); err == nil && n > 0 {
+		return n << 20
+	}
+	return 10 << 20
+}
+
+// sniffMedia decides the type from the bytes, never from what the uploader claimed.
+func sniffMedia(data []byte) string {
+	// http.DetectContentType predates AVIF
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" && (string(data[8:12]) == "avif" || string(data[8:12]) == "avis") {
+		return "image/avif"
+	}
+	return http.DetectContentType(data)
+}
+
+// storeMedia saves data unless the same bytes are already stored, and returns the asset.
+func storeMedia(filename string, data []byte) (Media, error) {
+	if int64(len(data)) > maxMediaSize() {
+		return Media{}, errMediaTooLarge
+	}
+	contentType := sniffMedia(data)
+	if _, ok := mediaTypes[contentType]; !ok {
+		return Media{}, errMediaType
+	}
+
+	sum := sha256.Sum256(data)
+	m := Media{
+		ID:          hex.EncodeToString(sum[:8]),
+		Filename:    filepath.Base(filename),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		CreatedAt:   time.Now().UTC(),
+	}
+	if m.Filename == "." || m.Filename == "/" {
+		m.Filename = m.ID + mediaTypes[contentType]
+	}
+	m.URL = "/media/" + m.ID
+
+	result, err := execWrite(`
+		INSERT INTO media (id, sha256, filename, content_type, size, data, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?) ON CONFLICT(id) DO NOTHING`,
+		m.ID, hex.EncodeToString(sum[:]), m.Filename, m.ContentType, m.Size, data, m.CreatedAt)
+	if err != nil {
+		return Media{}, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		err := db.QueryRow("SELECT filename, created_at FROM media WHERE id = ?", m.ID).Scan(&m.Filename, &m.CreatedAt)
+		m.Existing = true
+		return m, err
+	}
+	return m, nil
+}
+
+// storeUpload reads one multipart file into the media table.
+func storeUpload(fh *multipart.FileHeader) (Media, error) {
+	if fh.Size > maxMediaSize() {
+		return Media{}, errMediaTooLarge
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return Media{}, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxMediaSize()+1))
+	if err != nil {
+		return Media{}, err
+	}
+	return storeMedia(fh.Filename, data)
+}
+
+// POST /api/media - Upload one or more images as multipart "file" fields
+func handleUploadMedia(w http.ResponseWriter, r *http.Request) {
+	if !requireScope(w, r, scopePublish) {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 4*maxMediaSize())
+	if err := r.ParseMultipartForm(maxMediaSize()); err != nil {
+		httpError(w, r, "Bad upload: "+err.Error(), 400)
+		return
+	}
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
+		httpError(w, r, "No file field in the upload", 400)
+		return
+	}
+
+	uploaded := []Media{}
+	for _, fh := range files {
+		m, err := storeUpload(fh)
+		switch {
+		case errors.Is(err, errMediaType):
+			httpError(w, r, fh.Filename+": "+err.Error(), 415)
+			return
+		case errors.Is(err, errMediaTooLarge):
+			httpError(w, r, fh.Filename+": "+err.Error(), 413)
+			return
+		case err != nil:
+			httpError(w, r, "Failed to save: "+err.Error(), 500)
+			return
+		}
+		uploaded = append(uploaded, m)
+	}
+
+	jsonResponse(w, uploaded)
+}
+
+// GET /api/media - Every asset, newest first
+func handleListMedia(w http.ResponseWriter, r *http.Request) {
+	if !requireScope(w, r, scopePublish) {
+		return
+	}
+
+	rows, err := db.Query("SELECT id, filename, content_type, size, created_at FROM media ORDER BY created_at DESC")
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	media := []Media{}
+	for rows.Next() {
+		var m Media
+		if err := rows.Scan(&m.ID, &m.Filename, &m.ContentType, &m.Size, &m.CreatedAt); err != nil {
+			continue
+		}
+		m.URL = "/media/" + m.ID
+		media = append(media, m)
+	}
+
+	jsonResponse(w, media)
+}
+
+// DELETE /api/media/{id} - Remove an asset; posts still pointing at it get a 404 image
+func handleDeleteMedia(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	result, err := execWrite("DELETE FROM media WHERE id = ?", r.PathValue("id"))
+	if err != nil {
+		httpError(w, r, "Database error: "+err.Error(), 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		httpError(w, r, "Media not found", 404)
+		return
+	}
+
+	jsonResponse(w, map[string]string{"status": "deleted", "id": r.PathValue("id")})
+}
+
+// GET /media/{id} - The bytes. IDs are content hashes, so they can be cached forever.
+func handleServeMedia(w http.ResponseWriter, r *http.Request) {
+	// Tolerate an extension, e.g. /media/{id}.png, for tools that want one
+	id := strings.TrimSuffix(r.PathValue("id"), filepath.Ext(r.PathValue("id")))
+
+	var contentType, sum string
+	var data []byte
+	var created time.Time
+	err := db.QueryRow("SELECT content_type, sha256, data, created_at FROM media WHERE id = ?", id).Scan(&contentType, &sum, &data, &created)
+	if err != nil {
+		httpError(w, r, "Nothing lives at this address.", 404)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+sum+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", created, bytes.NewReader(data))
+}
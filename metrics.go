@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Metrics and health: /metrics for Prometheus, /healthz for uptime checks ---
+//
+// /metrics speaks the Prometheus text format: requests and latencies per route, how long the
+// store's queries take, and how many posts there are. Set MALT_METRICS_TOKEN to make
+// scrapers send it as a bearer token. /healthz answers 200 while the database does.
+
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64 // Per bucket, not cumulative; the +Inf bucket is count
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(seconds float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	for i, le := range latencyBuckets {
+		if seconds <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+type requestKey struct {
+	route, method, code string
+}
+
+var metrics = struct {
+	sync.Mutex
+	started  time.Time
+	requests map[requestKey]uint64
+	latency  map[string]*histogram // By route
+	queries  map[string]*histogram // By store operation
+}{
+	started:  time.Now(),
+	requests: map[requestKey]uint64{},
+	latency:  map[string]*histogram{},
+	queries:  map[string]*histogram{},
+}
+
+func observe(m map[string]*histogram, name string, d time.Duration) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	h := m[name]
+	if h == nil {
+		h = &histogram{}
+		m[name] = h
+	}
+	h.observe(d.Seconds())
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	code  int
+	bytes int64
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.code == 0 {
+		s.code = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.code == 0 {
+		s.code = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += int64(n)
+	return n, err
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// instrument counts and times every request by the mux pattern that served it, so
+// /post/{slug} is one series however many posts there are.
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		route := r.Pattern // Set by the mux on this same request
+		if route == "" {
+			route = "unmatched" // Redirected or refused before routing
+		}
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+		observe(metrics.latency, route, time.Since(start))
+		metrics.Lock()
+		metrics.requests[requestKey{route, r.Method, strconv.Itoa(rec.code)}]++
+		metrics.Unlock()
+	})
+}
+
+// timedStore times each Store call into the query histograms.
+type timedStore struct {
+	Store
+}
+
+func (s timedStore) ListPosts(q PostQuery) (PostPage, error) {
+	start := time.Now()
+	page, err := s.Store.ListPosts(q)
+	observe(metrics.queries, "list_posts", time.Since(start))
+	return page, err
+}
+
+func (s timedStore) GetPost(slug string) (Post, error) {
+	start := time.Now()
+	p, err := s.Store.GetPost(slug)
+	observe(metrics.queries, "get_post", time.Since(start))
+	return p, err
+}
+
+func (s timedStore) UpsertPost(ctx context.Context, p *Post, action string) error {
+	start := time.Now()
+	err := s.Store.UpsertPost(ctx, p, action)
+	observe(metrics.queries, "upsert_post", time.Since(start))
+	return err
+}
+
+func (s timedStore) InsertPost(ctx context.Context, p *Post, action string) error {
+	start := time.Now()
+	err := s.Store.InsertPost(ctx, p, action)
+	observe(metrics.queries, "insert_post", time.Since(start))
+	return err
+}
+
+func (s timedStore) DeletePost(slug string) (bool, error) {
+	start := time.Now()
+	deleted, err := s.Store.DeletePost(slug)
+	observe(metrics.queries, "delete_post", time.Since(start))
+	return deleted, err
+}
+
+func (s timedStore) RestorePost(slug string) (bool, error) {
+	start := time.Now()
+	restored, err := s.Store.RestorePost(slug)
+	observe(metrics.queries, "restore_post", time.Since(start))
+	return restored, err
+}
+
+func (s timedStore) PurgePost(slug string) (bool, error) {
+	start := time.Now()
+	purged, err := s.Store.PurgePost(slug)
+	observe(metrics.queries, "purge_post", time.Since(start))
+	return purged, err
+}
+
+// GET /healthz - 200 while the database answers, 503 when it doesn't
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	w.Header().Set("Cache-Control", "no-store")
+	var one int
+	if err := db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		http.Error(w, "database: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	io.WriteString(w, "ok\n")
+}
+
+// GET /metrics - Prometheus text exposition
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if token := os.Getenv("MALT_METRICS_TOKEN"); token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+		httpError(w, r, "Go away", 401)
+		return
+	}
+
+	var b strings.Builder
+	metrics.Lock()
+	b.WriteString("# HELP malt_http_requests_total HTTP requests by route, method and status.\n# TYPE malt_http_requests_total counter\n")
+	keys := make([]requestKey, 0, len(metrics.requests))
+	for k := range metrics.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+	for _, k := range keys {
+		fmt.Fprintf(&b, "malt_http_requests_total{route=%q,method=%q,code=%q} %d\n", k.route, k.method, k.code, metrics.requests[k])
+	}
+	writeHistograms(&b, "malt_http_request_duration_seconds", "HTTP request latency by route.", "route", metrics.latency)
+	writeHistograms(&b, "malt_store_query_duration_seconds", "Time spent in post store operations.", "op", metrics.queries)
+	started := metrics.started
+	metrics.Unlock()
+
+	b.WriteString("# HELP malt_posts Posts by status.\n# TYPE malt_posts gauge\n")
+	counts := map[string]int{"published": 0, "draft": 0, "trashed": 0}
+	if rows, err := db.QueryContext(r.Context(), "SELECT CASE WHEN deleted_at IS NULL THEN status ELSE 'trashed' END, COUNT(*) FROM posts GROUP BY 1"); err == nil {
+		for rows.Next() {
+			var status string
+			var n int
+			if rows.Scan(&status, &n) == nil {
+				counts[status] = n
+			}
+		}
+		rows.Close()
+	}
+	statuses := make([]string, 0, len(counts))
+	for s := range counts {
+		statuses = append(statuses, s)
+	}
+	sort.Strings(statuses)
+	for _, s := range statuses {
+		fmt.Fprintf(&b, "malt_posts{status=%q} %d\n", s, counts[s])
+	}
+
+	fmt.Fprintf(&b, "# HELP malt_start_time_seconds When the server started.\n# TYPE malt_start_time_seconds gauge\nmalt_start_time_seconds %d\n", started.Unix())
+	fmt.Fprintf(&b, "# HELP malt_goroutines Goroutines running now.\n# TYPE malt_goroutines gauge\nmalt_goroutines %d\n", runtime.NumGoroutine())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	io.WriteString(w, b.String())
+}
+
+func writeHistograms(b *strings.Builder, name, help, label string, hs map[string]*histogram) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	names := make([]string, 0, len(hs))
+	for n := range hs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		h := hs[n]
+		var cumulative uint64
+		for i, le := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(b, "%s_bucket{%s=%q,le=%q} %d\n", name, label, n, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(b, "%s_bucket{%s=%q,le=\"+Inf\"} %d\n", name, label, n, h.count)
+		fmt.Fprintf(b, "%s_sum{%s=%q} %g\n", name, label, n, h.sum)
+		fmt.Fprintf(b, "%s_count{%s=%q} %d\n", name, label, n, h.count)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// canonicalURL 301s site pages to a single spelling (no doubled or trailing slashes,
+// lowercase slugs, no /index.html) so crawlers and analytics see one URL per page.
+// The API is left alone: redirecting a PUT or DELETE helps nobody.
+func canonicalURL(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if p := canonicalPath(r.URL.Path); p != r.URL.Path {
+			u := *r.URL
+			u.Path, u.RawPath = p, ""
+			http.Redirect(w, r, u.RequestURI(), http.StatusMovedPermanently)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func canonicalPath(p string) string {
+	for strings.Contains(p, "//") {
+		p = strings.ReplaceAll(p, "//", "/")
+	}
+	if len(p) > 1 {
+		p = strings.TrimSuffix(p, "/")
+	}
+	if p == "/index.html" {
+		p = "/"
+	}
+	// Slugs and tags are lowercase; media IDs, sitemap pages and the like needn't be
+	for _, prefix := range []string{"/post/", "/tag/"} {
+		if len(p) > len(prefix) && strings.EqualFold(p[:len(prefix)], prefix) {
+			return prefix + strings.ToLower(p[len(prefix):])
+		}
+	}
+	return p
+}
+
+// clientIP is the visitor's address without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// baseURL is the site's absolute root without a trailing slash: MALT_BASE_URL when set,
+// otherwise whatever the request came in on.
+func baseURL(r *http.Request) string {
+	if cfg.BaseURL != "" {
+		return cfg.BaseURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
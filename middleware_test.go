@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestCanonicalPath(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"/", "/"},
+		{"/index.html", "/"},
+		{"//post//hello/", "/post/hello"},
+		{"/post/Hello-World", "/post/hello-world"},
+		{"/POST/Hello", "/post/hello"},
+		{"/tag/Go", "/tag/go"},
+		{"/media/AbC123.png", "/media/AbC123.png"},
+		{"/sitemaps/Posts-2", "/sitemaps/Posts-2"},
+		{"/ap/notes/Hello", "/ap/notes/Hello"},
+		{"/post/", "/post"},
+	}
+	for _, tt := range tests {
+		if got := canonicalPath(tt.in); got != tt.want {
+			t.Errorf("canonicalPath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,716 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// --- Migrations: the schema, one numbered step at a time ---
+//
+// schema_migrations records the steps a database has had. At startup migrate runs the missing
+// ones in order, each in its own transaction, so a step either happened or didn't. A database
+// with steps this build doesn't know was opened by a newer malt, and is left alone.
+//
+// Released steps are never edited: changing the schema means adding a step to the end of
+// migrations. Step 1 is the schema as it stood before versioning, written so it also brings
+// an unversioned malt.db of any age up to date.
+//
+// Postgres has its own list, postgresMigrations, which starts at step 6: the whole schema as
+// it stood when Postgres arrived, in Postgres's types. From there on a change is a step in
+// both lists, under the same number and in each one's own SQL.
+
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+var migrations = []migration{
+	{1, "baseline", migrateBaseline},
+	{2, "authors", execSQL(`
+		CREATE TABLE authors (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			avatar TEXT NOT NULL DEFAULT '',
+			bio TEXT NOT NULL DEFAULT '',
+			url TEXT NOT NULL DEFAULT '',
+			created_at DATETIME
+		);
+		ALTER TABLE posts ADD COLUMN author_id INTEGER REFERENCES authors(id);
+		CREATE INDEX idx_posts_author ON posts(author_id);`)},
+	{3, "preview keys", execSQL(`
+		CREATE TABLE preview_keys (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			secret TEXT NOT NULL,
+			created_at DATETIME
+		);`)},
+	{4, "series", execSQL(`
+		CREATE TABLE series (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			slug TEXT NOT NULL UNIQUE,
+			title TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			created_at DATETIME
+		);
+		ALTER TABLE posts ADD COLUMN series_id INTEGER REFERENCES series(id);
+		ALTER TABLE posts ADD COLUMN series_position INTEGER;
+		CREATE INDEX idx_posts_series ON posts(series_id, series_position);`)},
+	// NULL until measured: initReadingStats fills in posts from before this step
+	{5, "reading stats", execSQL(`
+		ALTER TABLE posts ADD COLUMN word_count INTEGER;
+		ALTER TABLE posts ADD COLUMN reading_minutes INTEGER;
+		ALTER TABLE posts ADD COLUMN excerpt TEXT;`)},
+	// Posts published before this step were announced when they were saved, scheduled or not
+	{6, "announcements", execSQL(`
+		CREATE TABLE announcements (
+			post_slug TEXT PRIMARY KEY,
+			base TEXT NOT NULL DEFAULT '',
+			announced_at DATETIME
+		);
+		INSERT INTO announcements (post_slug, announced_at)
+			SELECT slug, published_at FROM posts WHERE status = 'published' AND deleted_at IS NULL;`)},
+}
+
+// postgresMigrations are the steps for Postgres.
+var postgresMigrations = []migration{
+	{6, "postgres", execSQL(postgresSchema + postgresSearchSchema)},
+}
+
+// migrate brings the database up to the newest step.
+func migrate() {
+	steps, timestamp := migrations, "DATETIME"
+	if onPostgres {
+		steps, timestamp = postgresMigrations, "TIMESTAMPTZ"
+	}
+	_, err := writer.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at ` + timestamp + `
+	)`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	current, err := schemaVersion()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if latest := steps[len(steps)-1].version; current > latest {
+		log.Fatalf("The database is at schema version %d, but this build only knows up to %d; upgrade malt", current, latest)
+	}
+
+	for _, m := range steps {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(m); err != nil {
+			log.Fatalf("migration %d (%s): %v", m.version, m.name, err)
+		}
+		log.Printf("Migrated the database to schema version %d (%s)", m.version, m.name)
+	}
+}
+
+// schemaVersion is the newest step the database has had, 0 for none.
+func schemaVersion() (int, error) {
+	var version int
+	err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return version, err
+}
+
+func applyMigration(m migration) error {
+	tx, err := writer.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", m.version, m.name, time.Now().UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// execSQL is a step that's nothing but SQL.
+func execSQL(query string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}
+}
+
+// addColumn is ALTER TABLE ADD COLUMN, but only if the column is missing, for the baseline:
+// unversioned databases have whichever columns their malt had.
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	var n int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	_, err := tx.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+// migrateBaseline is every table, column and index malt had before schema_migrations.
+func migrateBaseline(tx *sql.Tx) error {
+	if _, err := tx.Exec(baselineSchema); err != nil {
+		return err
+	}
+
+	// Columns that arrived after the first release
+	for _, c := range []struct{ table, column, definition string }{
+		{"posts", "type", "TEXT NOT NULL DEFAULT 'article'"},
+		{"posts", "link_url", "TEXT NOT NULL DEFAULT ''"},
+		{"posts", "link_title", "TEXT NOT NULL DEFAULT ''"},
+		{"posts", "link_description", "TEXT NOT NULL DEFAULT ''"},
+		{"posts", "link_image", "TEXT NOT NULL DEFAULT ''"},
+		{"posts", "mastodon_status", "TEXT NOT NULL DEFAULT ''"},
+		{"posts", "bluesky_uri", "TEXT NOT NULL DEFAULT ''"},
+		{"posts", "content_format", "TEXT NOT NULL DEFAULT 'html'"},
+		{"posts", "raw_html", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"posts", "simhash", "INTEGER"},
+		{"posts", "status", "TEXT NOT NULL DEFAULT 'published'"},
+		{"posts", "noindex", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"posts", "nofollow", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"links", "feed_url", "TEXT NOT NULL DEFAULT ''"},
+		{"posts", "updated_at", "DATETIME"},
+		{"posts", "content_html", "TEXT"},
+		{"posts", "content_html_key", "TEXT NOT NULL DEFAULT ''"},
+		{"comments", "ip", "TEXT NOT NULL DEFAULT ''"},
+		{"posts", "deleted_at", "DATETIME"},
+	} {
+		if err := addColumn(tx, c.table, c.column, c.definition); err != nil {
+			return fmt.Errorf("%s.%s: %w", c.table, c.column, err)
+		}
+	}
+
+	// Posts from before updated_at existed were last touched, as far as anyone knows, when published
+	if _, err := tx.Exec("UPDATE posts SET updated_at = published_at WHERE updated_at IS NULL"); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(searchSchema); err != nil {
+		return err
+	}
+	// Posts written before the search index existed
+	_, err := tx.Exec(`
+		INSERT INTO posts_fts (rowid, title, description, content)
+		SELECT rowid, title, description, plain_text(content) FROM posts
+		WHERE rowid NOT IN (SELECT rowid FROM posts_fts)`)
+	return err
+}
+
+const baselineSchema = `
+	CREATE TABLE IF NOT EXISTS posts (
+		slug TEXT PRIMARY KEY,
+		title TEXT,
+		description TEXT,
+		content TEXT,
+		published_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS links (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		title TEXT,
+		url TEXT,
+		description TEXT,
+		category TEXT
+	);
+
+	CREATE TABLE IF NOT EXISTS feeds (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		title TEXT NOT NULL DEFAULT '',
+		url TEXT UNIQUE,
+		site_url TEXT NOT NULL DEFAULT '',
+		etag TEXT NOT NULL DEFAULT '',
+		last_modified TEXT NOT NULL DEFAULT '',
+		last_fetched_at DATETIME,
+		last_error TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE TABLE IF NOT EXISTS feed_items (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		feed_id INTEGER,
+		guid TEXT,
+		title TEXT,
+		url TEXT,
+		summary TEXT,
+		published_at DATETIME,
+		UNIQUE(feed_id, guid)
+	);
+
+	CREATE TABLE IF NOT EXISTS mastodon_replies (
+		id TEXT PRIMARY KEY,
+		post_slug TEXT,
+		url TEXT,
+		in_reply_to TEXT,
+		author_name TEXT,
+		author_handle TEXT,
+		author_url TEXT,
+		author_avatar TEXT,
+		content TEXT,
+		created_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS bluesky_replies (
+		id TEXT PRIMARY KEY,
+		post_slug TEXT,
+		url TEXT,
+		in_reply_to TEXT,
+		author_name TEXT,
+		author_handle TEXT,
+		author_url TEXT,
+		author_avatar TEXT,
+		content TEXT,
+		created_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS comments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		post_slug TEXT,
+		parent_id INTEGER,
+		author_name TEXT,
+		author_email TEXT,
+		author_url TEXT NOT NULL DEFAULT '',
+		content TEXT,
+		status TEXT NOT NULL DEFAULT 'pending',
+		created_at DATETIME,
+		source TEXT NOT NULL DEFAULT '',
+		source_id TEXT,
+		UNIQUE(source, source_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS bluesky_threads (
+		post_slug TEXT PRIMARY KEY,
+		fetched_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS post_variants (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		post_slug TEXT,
+		title TEXT,
+		description TEXT
+	);
+
+	CREATE TABLE IF NOT EXISTS experiment_stats (
+		post_slug TEXT,
+		variant_id INTEGER,
+		impressions INTEGER NOT NULL DEFAULT 0,
+		views INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (post_slug, variant_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS feature_flags (
+		name TEXT PRIMARY KEY,
+		enabled BOOLEAN NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS tags (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT UNIQUE
+	);
+
+	CREATE TABLE IF NOT EXISTS post_tags (
+		post_slug TEXT,
+		tag_id INTEGER,
+		PRIMARY KEY (post_slug, tag_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		at DATETIME,
+		action TEXT,
+		subject TEXT,
+		detail TEXT NOT NULL DEFAULT '',
+		ip TEXT
+	);
+
+	CREATE TABLE IF NOT EXISTS post_revisions (
+		post_slug TEXT,
+		rev INTEGER,
+		action TEXT,
+		title TEXT,
+		description TEXT,
+		content TEXT,
+		content_format TEXT,
+		created_at DATETIME,
+		PRIMARY KEY (post_slug, rev)
+	);
+
+	CREATE TABLE IF NOT EXISTS media (
+		id TEXT PRIMARY KEY,
+		sha256 TEXT,
+		filename TEXT,
+		content_type TEXT,
+		size INTEGER,
+		data BLOB,
+		created_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		scope TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		hash TEXT NOT NULL UNIQUE,
+		created_at DATETIME,
+		last_used_at DATETIME,
+		revoked_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL,
+		active BOOLEAN NOT NULL DEFAULT 1,
+		created_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL,
+		event TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at DATETIME,
+		response_code INTEGER,
+		error TEXT NOT NULL DEFAULT '',
+		created_at DATETIME,
+		delivered_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+
+	CREATE TABLE IF NOT EXISTS activitypub_keys (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		private_key TEXT NOT NULL,
+		created_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS activitypub_followers (
+		actor TEXT PRIMARY KEY,
+		inbox TEXT NOT NULL,
+		shared_inbox TEXT NOT NULL DEFAULT '',
+		followed_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS subscribers (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		email TEXT NOT NULL UNIQUE,
+		token TEXT NOT NULL UNIQUE,
+		status TEXT NOT NULL,
+		created_at DATETIME,
+		confirmed_at DATETIME,
+		unsubscribed_at DATETIME,
+		ip TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE TABLE IF NOT EXISTS newsletter_sends (
+		post_slug TEXT PRIMARY KEY,
+		sent_at DATETIME,
+		recipients INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS post_views (
+		post_slug TEXT NOT NULL,
+		day TEXT NOT NULL,
+		views INTEGER NOT NULL DEFAULT 0,
+		uniques INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (post_slug, day)
+	);
+
+	CREATE TABLE IF NOT EXISTS view_readers (
+		day TEXT NOT NULL,
+		hash TEXT NOT NULL,
+		PRIMARY KEY (day, hash)
+	);`
+
+// postgresSchema is every table as of step 6, for Postgres. Search is in postgresSearchSchema.
+const postgresSchema = `
+	CREATE TABLE authors (
+		id BIGSERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		avatar TEXT NOT NULL DEFAULT '',
+		bio TEXT NOT NULL DEFAULT '',
+		url TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ
+	);
+
+	CREATE TABLE series (
+		id BIGSERIAL PRIMARY KEY,
+		slug TEXT NOT NULL UNIQUE,
+		title TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ
+	);
+
+	CREATE TABLE posts (
+		slug TEXT PRIMARY KEY,
+		title TEXT,
+		description TEXT,
+		content TEXT,
+		published_at TIMESTAMPTZ,
+		type TEXT NOT NULL DEFAULT 'article',
+		link_url TEXT NOT NULL DEFAULT '',
+		link_title TEXT NOT NULL DEFAULT '',
+		link_description TEXT NOT NULL DEFAULT '',
+		link_image TEXT NOT NULL DEFAULT '',
+		mastodon_status TEXT NOT NULL DEFAULT '',
+		bluesky_uri TEXT NOT NULL DEFAULT '',
+		content_format TEXT NOT NULL DEFAULT 'html',
+		raw_html BOOLEAN NOT NULL DEFAULT false,
+		simhash BIGINT,
+		status TEXT NOT NULL DEFAULT 'published',
+		noindex BOOLEAN NOT NULL DEFAULT false,
+		nofollow BOOLEAN NOT NULL DEFAULT false,
+		updated_at TIMESTAMPTZ,
+		content_html TEXT,
+		content_html_key TEXT NOT NULL DEFAULT '',
+		deleted_at TIMESTAMPTZ,
+		author_id BIGINT REFERENCES authors(id),
+		series_id BIGINT REFERENCES series(id),
+		series_position INTEGER,
+		word_count INTEGER,
+		reading_minutes INTEGER,
+		excerpt TEXT
+	);
+	CREATE INDEX idx_posts_author ON posts(author_id);
+	CREATE INDEX idx_posts_series ON posts(series_id, series_position);
+
+	CREATE TABLE links (
+		id BIGSERIAL PRIMARY KEY,
+		title TEXT,
+		url TEXT,
+		description TEXT,
+		category TEXT,
+		feed_url TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE TABLE feeds (
+		id BIGSERIAL PRIMARY KEY,
+		title TEXT NOT NULL DEFAULT '',
+		url TEXT UNIQUE,
+		site_url TEXT NOT NULL DEFAULT '',
+		etag TEXT NOT NULL DEFAULT '',
+		last_modified TEXT NOT NULL DEFAULT '',
+		last_fetched_at TIMESTAMPTZ,
+		last_error TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE TABLE feed_items (
+		id BIGSERIAL PRIMARY KEY,
+		feed_id BIGINT,
+		guid TEXT,
+		title TEXT,
+		url TEXT,
+		summary TEXT,
+		published_at TIMESTAMPTZ,
+		UNIQUE(feed_id, guid)
+	);
+
+	CREATE TABLE mastodon_replies (
+		id TEXT PRIMARY KEY,
+		post_slug TEXT,
+		url TEXT,
+		in_reply_to TEXT,
+		author_name TEXT,
+		author_handle TEXT,
+		author_url TEXT,
+		author_avatar TEXT,
+		content TEXT,
+		created_at TIMESTAMPTZ
+	);
+
+	CREATE TABLE bluesky_replies (
+		id TEXT PRIMARY KEY,
+		post_slug TEXT,
+		url TEXT,
+		in_reply_to TEXT,
+		author_name TEXT,
+		author_handle TEXT,
+		author_url TEXT,
+		author_avatar TEXT,
+		content TEXT,
+		created_at TIMESTAMPTZ
+	);
+
+	CREATE TABLE bluesky_threads (
+		post_slug TEXT PRIMARY KEY,
+		fetched_at TIMESTAMPTZ
+	);
+
+	CREATE TABLE comments (
+		id BIGSERIAL PRIMARY KEY,
+		post_slug TEXT,
+		parent_id BIGINT,
+		author_name TEXT,
+		author_email TEXT,
+		author_url TEXT NOT NULL DEFAULT '',
+		content TEXT,
+		status TEXT NOT NULL DEFAULT 'pending',
+		created_at TIMESTAMPTZ,
+		source TEXT NOT NULL DEFAULT '',
+		source_id TEXT,
+		ip TEXT NOT NULL DEFAULT '',
+		UNIQUE(source, source_id)
+	);
+
+	CREATE TABLE post_variants (
+		id BIGSERIAL PRIMARY KEY,
+		post_slug TEXT,
+		title TEXT,
+		description TEXT
+	);
+
+	CREATE TABLE experiment_stats (
+		post_slug TEXT,
+		variant_id BIGINT,
+		impressions INTEGER NOT NULL DEFAULT 0,
+		views INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (post_slug, variant_id)
+	);
+
+	CREATE TABLE feature_flags (
+		name TEXT PRIMARY KEY,
+		enabled BOOLEAN NOT NULL
+	);
+
+	CREATE TABLE tags (
+		id BIGSERIAL PRIMARY KEY,
+		name TEXT UNIQUE
+	);
+
+	CREATE TABLE post_tags (
+		post_slug TEXT,
+		tag_id BIGINT,
+		PRIMARY KEY (post_slug, tag_id)
+	);
+
+	CREATE TABLE audit_log (
+		id BIGSERIAL PRIMARY KEY,
+		at TIMESTAMPTZ,
+		action TEXT,
+		subject TEXT,
+		detail TEXT NOT NULL DEFAULT '',
+		ip TEXT
+	);
+
+	CREATE TABLE post_revisions (
+		post_slug TEXT,
+		rev INTEGER,
+		action TEXT,
+		title TEXT,
+		description TEXT,
+		content TEXT,
+		content_format TEXT,
+		created_at TIMESTAMPTZ,
+		PRIMARY KEY (post_slug, rev)
+	);
+
+	CREATE TABLE media (
+		id TEXT PRIMARY KEY,
+		sha256 TEXT,
+		filename TEXT,
+		content_type TEXT,
+		size BIGINT,
+		data BYTEA,
+		created_at TIMESTAMPTZ
+	);
+
+	CREATE TABLE api_keys (
+		id BIGSERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		scope TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		hash TEXT NOT NULL UNIQUE,
+		created_at TIMESTAMPTZ,
+		last_used_at TIMESTAMPTZ,
+		revoked_at TIMESTAMPTZ
+	);
+
+	CREATE TABLE webhooks (
+		id BIGSERIAL PRIMARY KEY,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL,
+		active BOOLEAN NOT NULL DEFAULT true,
+		created_at TIMESTAMPTZ
+	);
+
+	CREATE TABLE webhook_deliveries (
+		id BIGSERIAL PRIMARY KEY,
+		webhook_id BIGINT NOT NULL,
+		event TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMPTZ,
+		response_code INTEGER,
+		error TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ,
+		delivered_at TIMESTAMPTZ
+	);
+	CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+
+	CREATE TABLE activitypub_keys (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		private_key TEXT NOT NULL,
+		created_at TIMESTAMPTZ
+	);
+
+	CREATE TABLE activitypub_followers (
+		actor TEXT PRIMARY KEY,
+		inbox TEXT NOT NULL,
+		shared_inbox TEXT NOT NULL DEFAULT '',
+		followed_at TIMESTAMPTZ
+	);
+
+	CREATE TABLE subscribers (
+		id BIGSERIAL PRIMARY KEY,
+		email TEXT NOT NULL UNIQUE,
+		token TEXT NOT NULL UNIQUE,
+		status TEXT NOT NULL,
+		created_at TIMESTAMPTZ,
+		confirmed_at TIMESTAMPTZ,
+		unsubscribed_at TIMESTAMPTZ,
+		ip TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE TABLE newsletter_sends (
+		post_slug TEXT PRIMARY KEY,
+		sent_at TIMESTAMPTZ,
+		recipients INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE post_views (
+		post_slug TEXT NOT NULL,
+		day TEXT NOT NULL,
+		views INTEGER NOT NULL DEFAULT 0,
+		uniques INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (post_slug, day)
+	);
+
+	CREATE TABLE view_readers (
+		day TEXT NOT NULL,
+		hash TEXT NOT NULL,
+		PRIMARY KEY (day, hash)
+	);
+
+	CREATE TABLE preview_keys (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		secret TEXT NOT NULL,
+		created_at TIMESTAMPTZ
+	);
+
+	CREATE TABLE announcements (
+		post_slug TEXT PRIMARY KEY,
+		base TEXT NOT NULL DEFAULT '',
+		announced_at TIMESTAMPTZ
+	);`
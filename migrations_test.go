@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Steps are numbered one after another, and both lists end at the same step.
+func TestMigrationSteps(t *testing.T) {
+	for name, steps := range map[string][]migration{"migrations": migrations, "postgresMigrations": postgresMigrations} {
+		for i := 1; i < len(steps); i++ {
+			if steps[i].version != steps[i-1].version+1 {
+				t.Errorf("%s: step %d follows step %d", name, steps[i].version, steps[i-1].version)
+			}
+		}
+	}
+	if migrations[0].version != 1 {
+		t.Errorf("migrations start at step %d, want 1", migrations[0].version)
+	}
+	if a, b := migrations[len(migrations)-1].version, postgresMigrations[len(postgresMigrations)-1].version; a != b {
+		t.Errorf("SQLite is at step %d, Postgres at %d", a, b)
+	}
+}
+
+// A new database gets every step once; migrating again changes nothing.
+func TestMigrateFresh(t *testing.T) {
+	testServer(t)
+	latest := migrations[len(migrations)-1].version
+	if v, err := schemaVersion(); err != nil || v != latest {
+		t.Fatalf("schema version %d, %v; want %d", v, err, latest)
+	}
+
+	migrate()
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&n)
+	if n != len(migrations) {
+		t.Errorf("%d steps recorded after migrating twice, want %d", n, len(migrations))
+	}
+}
+
+// The malt.db in the repository predates versioning; it comes up to date.
+func TestMigrateUnversioned(t *testing.T) {
+	old, err := os.ReadFile("malt.db")
+	if err != nil {
+		t.Skip("no malt.db to upgrade:", err)
+	}
+	cfg.DSN, cfg.Secret = filepath.Join(t.TempDir(), "malt.db"), "admin"
+	if err := os.WriteFile(cfg.DSN, old, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	initDB()
+	t.Cleanup(func() {
+		db.Close()
+		writer.Close()
+	})
+
+	if v, err := schemaVersion(); err != nil || v != migrations[len(migrations)-1].version {
+		t.Errorf("schema version %d, %v after upgrading", v, err)
+	}
+	// Every column a post has now is there to write
+	publish(t, `{"slug": "after-upgrade", "title": "After", "content": "<p>Body</p>", "tags": ["go"]}`)
+	if _, err := store.GetPost("after-upgrade"); err != nil {
+		t.Errorf("reading a post back after upgrading: %v", err)
+	}
+}
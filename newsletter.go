@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// --- Newsletter: new posts by email, to readers who asked twice ---
+//
+// POST /api/subscribe takes an address and mails it a confirmation link, to a page with the
+// button that confirms; only confirmed addresses get posts (double opt-in). When a post goes
+// live (announce.go: scheduled posts when their time comes, imports never) it's sent,
+// rendered, to every confirmed subscriber, once: a post that goes back to draft and is
+// published again isn't sent a second time. Every email carries the subscriber's own
+// unsubscribe link, also as a one-click List-Unsubscribe header. The SMTP server is part of the config (smtp_addr,
+// smtp_user, smtp_password, smtp_from); without one nothing is sent.
+//
+// Behind the "newsletter" feature flag.
+
+// Subscriber is one address as the owner sees it.
+type Subscriber struct {
+	ID             int64      `json:"id"`
+	Email          string     `json:"email"`
+	Status         string     `json:"status"` // pending, confirmed or unsubscribed
+	CreatedAt      time.Time  `json:"created_at"`
+	ConfirmedAt    *time.Time `json:"confirmed_at,omitempty"`
+	UnsubscribedAt *time.Time `json:"unsubscribed_at,omitempty"`
+}
+
+var subscribeLimiter = &rateLimiter{
+	rate:    5.0 / 3600,
+	burst:   3,
+	buckets: map[string]*bucket{},
+}
+
+// POST /api/subscribe - Ask for posts by email: {"email": "..."} or a form post. The answer
+// is the same whether or not the address was already on the list.
+func handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	var f struct {
+		Email   string `json:"email"`
+		Company string `json:"company"` // Honeypot, as on the comment form
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&f); err != nil {
+			httpError(w, r, "Bad JSON", 400)
+			return
+		}
+	} else {
+		r.Body = http.MaxBytesReader(w, r.Body, 4<<10)
+		f.Email, f.Company = r.FormValue("email"), r.FormValue("company")
+	}
+	if f.Company != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		jsonResponse(w, map[string]string{"status": "pending"})
+		return
+	}
+
+	addr, err := mail.ParseAddress(strings.TrimSpace(f.Email))
+	if err != nil || addr.Name != "" {
+		httpError(w, r, "That email address doesn't look right", 400)
+		return
+	}
+	email := strings.ToLower(addr.Address)
+	if cfg.SMTPAddr == "" {
+		httpError(w, r, "The newsletter can't send mail yet", 503)
+		return
+	}
+	if ok, wait := subscribeLimiter.allow(clientIP(r), time.Now()); !ok {
+		tooMany(w, r, wait, "That's a lot of sign-ups; try again later")
+		return
+	}
+
+	var status, token string
+	err = db.QueryRow("SELECT status, token FROM subscribers WHERE email = ?", email).Scan(&status, &token)
+	switch {
+	case err == sql.ErrNoRows:
+		token = rand.Text()
+		_, err = execWrite("INSERT INTO subscribers (email, token, status, created_at, ip) VALUES (?, ?, 'pending', ?, ?)",
+			email, token, time.Now().UTC(), clientIP(r))
+	case err == nil && status == "unsubscribed":
+		token = rand.Text()
+		_, err = execWrite("UPDATE subscribers SET status = 'pending', token = ?, unsubscribed_at = NULL, ip = ? WHERE email = ?",
+			token, clientIP(r), email)
+	}
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+
+	// Already confirmed: nothing to do, and nothing to tell a stranger about it
+	if status != "confirmed" {
+		link := baseURL(r) + "/subscribe/confirm?token=" + url.QueryEscape(token)
+		body := fmt.Sprintf("Someone, hopefully you, asked to get new posts from %s by email.\n\nTo confirm, open this link:\n\n%s\n\nIf it wasn't you, ignore this message and nothing more will be sent.\n",
+			siteTitle(), link)
+		go func() {
+			msg := textMessage(email, "Confirm your subscription to "+siteTitle(), body)
+			if err := sendMail(email, msg); err != nil {
+				log.Printf("newsletter: confirmation to %s: %v", email, err)
+			}
+		}()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	jsonResponse(w, map[string]string{"status": "pending"})
+}
+
+// GET /subscribe/confirm?token= - The link in the confirmation email: a button too, or a
+// mail scanner following the link would subscribe whoever was typed into the form
+func handleConfirmPage(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	var status string
+	db.QueryRow("SELECT status FROM subscribers WHERE token = ?", token).Scan(&status)
+	switch status {
+	case "pending":
+		newsletterPage(w, "Confirm", "Get new posts from "+siteTitle()+" by email?",
+			&newsletterForm{Action: "/subscribe/confirm", Button: "Subscribe", Token: token})
+	case "confirmed":
+		newsletterPage(w, "Subscribed", "You're on the list. New posts from "+siteTitle()+" will arrive by email.", nil)
+	default:
+		httpError(w, r, "That link has expired or was never valid.", 404)
+	}
+}
+
+// POST /subscribe/confirm?token= - The button
+func handleConfirmSubscription(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		token = r.FormValue("token")
+	}
+	result, err := execWrite("UPDATE subscribers SET status = 'confirmed', confirmed_at = ? WHERE token = ? AND status = 'pending'",
+		time.Now().UTC(), token)
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		var status string
+		db.QueryRow("SELECT status FROM subscribers WHERE token = ?", token).Scan(&status)
+		if status != "confirmed" {
+			httpError(w, r, "That link has expired or was never valid.", 404)
+			return
+		}
+	}
+
+	newsletterPage(w, "Subscribed", "You're on the list. New posts from "+siteTitle()+" will arrive by email.", nil)
+}
+
+// GET /unsubscribe?token= - A button rather than a deed: mail scanners follow links
+func handleUnsubscribePage(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	var exists bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM subscribers WHERE token = ?)", token).Scan(&exists)
+	if !exists {
+		httpError(w, r, "That link has expired or was never valid.", 404)
+		return
+	}
+	newsletterPage(w, "Unsubscribe", "Stop getting new posts from "+siteTitle()+" by email?",
+		&newsletterForm{Action: "/unsubscribe", Button: "Unsubscribe", Token: token})
+}
+
+// POST /unsubscribe?token= - The button, and one-click unsubscribes from mail clients (RFC 8058)
+func handleUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		token = r.FormValue("token")
+	}
+	var exists bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM subscribers WHERE token = ?)", token).Scan(&exists)
+	if !exists {
+		httpError(w, r, "That link has expired or was never valid.", 404)
+		return
+	}
+	if _, err := execWrite("UPDATE subscribers SET status = 'unsubscribed', unsubscribed_at = ? WHERE token = ? AND status != 'unsubscribed'",
+		time.Now().UTC(), token); err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+
+	newsletterPage(w, "Unsubscribed", "Done. No more email from "+siteTitle()+".", nil)
+}
+
+var newsletterTmpl = template.Must(template.New("newsletter").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta name="robots" content="noindex">
+    <title>{{.Title}}</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, Helvetica, Arial, sans-serif; max-width: 680px; margin: 0 auto; padding: 2rem 1.5rem; line-height: 1.6; }
+        @media (prefers-color-scheme: dark) { body { background: #111111; color: #e1e1e1; } a { color: #4da3ff; } }
+    </style>
+</head>
+<body>
+    <h1>{{.Title}}</h1>
+    <p>{{.Message}}</p>
+    {{with .Form}}<form method="post" action="{{.Action}}"><input type="hidden" name="token" value="{{.Token}}"><button>{{.Button}}</button></form>{{end}}
+    <p><a href="/">Go back home</a></p>
+</body>
+</html>`))
+
+// newsletterForm is a page's one button, which posts the subscriber's token to Action.
+type newsletterForm struct {
+	Action, Button, Token string
+}
+
+func newsletterPage(w http.ResponseWriter, title, message string, form *newsletterForm) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	newsletterTmpl.Execute(w, map[string]any{"Title": title, "Message": message, "Form": form})
+}
+
+// GET /api/subscribers?status= - The list; status is pending, confirmed or unsubscribed (default all)
+func handleListSubscribers(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	query, args := "SELECT id, email, status, created_at, confirmed_at, unsubscribed_at FROM subscribers", []any{}
+	switch status := r.URL.Query().Get("status"); status {
+	case "":
+	case "pending", "confirmed", "unsubscribed":
+		query += " WHERE status = ?"
+		args = append(args, status)
+	default:
+		httpError(w, r, "status must be pending, confirmed or unsubscribed", 400)
+		return
+	}
+
+	rows, err := db.Query(query+" ORDER BY id DESC", args...)
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	subscribers := []Subscriber{}
+	for rows.Next() {
+		var s Subscriber
+		var confirmed, unsubscribed sql.NullTime
+		if err := rows.Scan(&s.ID, &s.Email, &s.Status, &s.CreatedAt, &confirmed, &unsubscribed); err != nil {
+			continue
+		}
+		if confirmed.Valid {
+			s.ConfirmedAt = &confirmed.Time
+		}
+		if unsubscribed.Valid {
+			s.UnsubscribedAt = &unsubscribed.Time
+		}
+		subscribers = append(subscribers, s)
+	}
+
+	jsonResponse(w, subscribers)
+}
+
+// DELETE /api/subscribers/{id} - Forget an address entirely
+func handleDeleteSubscriber(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	var email string
+	if err := db.QueryRow("SELECT email FROM subscribers WHERE id = ?", r.PathValue("id")).Scan(&email); err != nil {
+		httpError(w, r, "Subscriber not found", 404)
+		return
+	}
+	if _, err := execWrite("DELETE FROM subscribers WHERE id = ?", r.PathValue("id")); err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	audit(r, "subscriber.delete", email, "")
+
+	jsonResponse(w, map[string]string{"status": "deleted", "id": r.PathValue("id")})
+}
+
+// sendNewsletter emails p to every confirmed subscriber, unless it's been sent before.
+func sendNewsletter(base string, p Post) {
+	if !featureEnabled("newsletter") || cfg.SMTPAddr == "" {
+		return
+	}
+
+	// Claim the post first, so two quick publishes can't both send it
+	result, err := execWrite("INSERT INTO newsletter_sends (post_slug, sent_at, recipients) VALUES (?, ?, 0) ON CONFLICT(post_slug) DO NOTHING",
+		p.Slug, time.Now().UTC())
+	if err != nil {
+		log.Printf("newsletter: %v", err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return
+	}
+
+	host := base
+	if u, err := url.Parse(base); err == nil {
+		host = u.Host
+	}
+	body, err := renderPostHTML(p, siteHost(host))
+	if err != nil {
+		log.Printf("newsletter: render %s: %v", p.Slug, err)
+		return
+	}
+	// Mail clients have no page to resolve /media/... against
+	body = strings.NewReplacer(`src="/`, `src="`+base+"/", `href="/`, `href="`+base+"/").Replace(body)
+
+	rows, err := db.Query("SELECT email, token FROM subscribers WHERE status = 'confirmed'")
+	if err != nil {
+		log.Printf("newsletter: %v", err)
+		return
+	}
+	recipients := map[string]string{}
+	for rows.Next() {
+		var email, token string
+		if rows.Scan(&email, &token) == nil {
+			recipients[email] = token
+		}
+	}
+	rows.Close()
+
+	sent := 0
+	for email, token := range recipients {
+		unsubscribe := base + "/unsubscribe?token=" + url.QueryEscape(token)
+		if err := sendMail(email, postMessage(base, p, body, email, unsubscribe)); err != nil {
+			log.Printf("newsletter: %s to %s: %v", p.Slug, email, err)
+			continue
+		}
+		sent++
+	}
+	execWrite("UPDATE newsletter_sends SET recipients = ? WHERE post_slug = ?", sent, p.Slug)
+	log.Printf("newsletter: sent %s to %d of %d subscribers", p.Slug, sent, len(recipients))
+}
+
+// mailHeaders are the headers every message here starts with.
+func mailHeaders(to, subject string) textproto.MIMEHeader {
+	h := textproto.MIMEHeader{}
+	h.Set("From", cfg.SMTPFrom)
+	h.Set("To", to)
+	h.Set("Subject", mime.QEncoding.Encode("utf-8", subject))
+	h.Set("Date", time.Now().Format(time.RFC1123Z))
+	h.Set("MIME-Version", "1.0")
+	if from, err := mail.ParseAddress(cfg.SMTPFrom); err == nil {
+		if _, domain, ok := strings.Cut(from.Address, "@"); ok {
+			h.Set("Message-ID", "<"+strings.ToLower(rand.Text())+"@"+domain+">")
+		}
+	}
+	return h
+}
+
+func writeHeaders(b *bytes.Buffer, h textproto.MIMEHeader) {
+	for k, vs := range h {
+		for _, v := range vs {
+			fmt.Fprintf(b, "%s: %s\r\n", k, v)
+		}
+	}
+}
+
+// textMessage is a plain-text email.
+func textMessage(to, subject, body string) []byte {
+	var b bytes.Buffer
+	h := mailHeaders(to, subject)
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("Content-Transfer-Encoding", "quoted-printable")
+	writeHeaders(&b, h)
+	b.WriteString("\r\n")
+	qp := quotedprintable.NewWriter(&b)
+	qp.Write([]byte(body))
+	qp.Close()
+	return b.Bytes()
+}
+
+// postMessage is a post as an email: HTML with a plain-text alternative, and the
+// recipient's unsubscribe link in the footer and the headers.
+func postMessage(base string, p Post, body, to, unsubscribe string) []byte {
+	link := base + "/post/" + p.Slug
+	text := p.Title + "\n\n"
+	if p.Description != "" {
+		text += p.Description + "\n\n"
+	}
+	text += "Read it at " + link + "\n\n--\nUnsubscribe: " + unsubscribe + "\n"
+	page := `<!DOCTYPE html><html><body style="font-family: sans-serif; max-width: 680px; margin: 0 auto; line-height: 1.6;">` +
+		`<h1><a href="` + html.EscapeString(link) + `">` + html.EscapeString(p.Title) + `</a></h1>` + body +
+		`<hr><p style="font-size: small;">You're getting this because you subscribed to ` + html.EscapeString(siteTitle()) + `. ` +
+		`<a href="` + html.EscapeString(unsubscribe) + `">Unsubscribe</a></p></body></html>`
+
+	var b bytes.Buffer
+	parts := multipart.NewWriter(&b)
+	h := mailHeaders(to, p.Title)
+	h.Set("Content-Type", "multipart/alternative; boundary="+parts.Boundary())
+	h.Set("List-Unsubscribe", "<"+unsubscribe+">")
+	h.Set("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	var head bytes.Buffer
+	writeHeaders(&head, h)
+	head.WriteString("\r\n")
+
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", page},
+	} {
+		w, _ := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		qp := quotedprintable.NewWriter(w)
+		qp.Write([]byte(part.body))
+		qp.Close()
+	}
+	parts.Close()
+
+	return append(head.Bytes(), b.Bytes()...)
+}
+
+// sendMail hands one message to the configured SMTP server.
+func sendMail(to string, msg []byte) error {
+	from, err := mail.ParseAddress(cfg.SMTPFrom)
+	if err != nil {
+		return err
+	}
+	host, port, _ := net.SplitHostPort(cfg.SMTPAddr)
+	var auth smtp.Auth
+	if cfg.SMTPUser != "" {
+		auth = smtp.PlainAuth("", cfg.SMTPUser, cfg.SMTPPassword, host)
+	}
+	if port != "465" {
+		return smtp.SendMail(cfg.SMTPAddr, auth, from.Address, []string{to}, msg)
+	}
+
+	// Implicit TLS, which smtp.SendMail doesn't do
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", cfg.SMTPAddr, &tls.Config{ServerName: host})
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Opening the confirmation link only shows the button: a mail scanner following it
+// mustn't subscribe anyone.
+func TestConfirmNeedsThePost(t *testing.T) {
+	testServer(t)
+	if _, err := execWrite("INSERT INTO subscribers (email, token, status) VALUES ('reader@example.com', 'tok', 'pending')"); err != nil {
+		t.Fatal(err)
+	}
+	status := func() string {
+		var s string
+		db.QueryRow("SELECT status FROM subscribers WHERE token = 'tok'").Scan(&s)
+		return s
+	}
+
+	w := httptest.NewRecorder()
+	handleConfirmPage(w, httptest.NewRequest("GET", "/subscribe/confirm?token=tok", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `action="/subscribe/confirm"`) {
+		t.Fatalf("confirmation page: %d %s", w.Code, w.Body)
+	}
+	if s := status(); s != "pending" {
+		t.Fatalf("after opening the link: %s, want still pending", s)
+	}
+
+	r := httptest.NewRequest("POST", "/subscribe/confirm", strings.NewReader("token=tok"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	handleConfirmSubscription(w, r)
+	if w.Code != http.StatusOK || status() != "confirmed" {
+		t.Errorf("after the button: %d, %s", w.Code, status())
+	}
+
+	w = httptest.NewRecorder()
+	handleConfirmPage(w, httptest.NewRequest("GET", "/subscribe/confirm?token=nope", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("an unknown token: %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- OpenAPI: the JSON API, described for clients ---
+//
+// apiOps lists the endpoints by hand, next to nothing but a summary and the Go types they
+// read and write; the JSON Schemas are generated from those types, so the shapes can't drift
+// from what the handlers send. GET /api/openapi.json is the OpenAPI 3.1 document and
+// GET /api/docs the same, as a page. A handler that's added without an entry here stays
+// undocumented, so new routes get one.
+
+// apiOp is one documented endpoint.
+type apiOp struct {
+	method, path string
+	summary      string
+	scope        string // Key needed: "", scopePublish or scopeAdmin
+	feature      string // Flag the route is behind, if any
+	query        []apiParam
+	body         any    // Zero value of the JSON request type; nil for none
+	bodyType     string // Content type when the body isn't JSON
+	resp         any    // Zero value of the response type; nil when it isn't JSON
+	respType     string // Content type when the response isn't JSON
+}
+
+type apiParam struct {
+	name, description string
+}
+
+// oneOf is a response that takes one of several shapes.
+type oneOf []any
+
+// statusReply is the small acknowledgement most writes answer with, e.g. {"status": "deleted", "slug": "..."}.
+type statusReply map[string]any
+
+var apiOps = []apiOp{
+	// Posts
+	{method: "GET", path: "/api/posts", summary: "Published posts, newest first. Without limit or cursor, every post as a bare array; with either, a page",
+		query: []apiParam{{"tag", "Only posts with this tag"}, {"limit", "Posts per page"}, {"cursor", "next_cursor of the previous page"}},
+		resp:  oneOf{[]Post{}, PostPage{}}},
+	{method: "GET", path: "/api/posts/{slug}", summary: "One post, rendered. Drafts and scheduled posts need a key or a preview token",
+		query: []apiParam{{"token", "A preview token from /api/posts/{slug}/preview-link"}}, resp: Post{}},
+	{method: "POST", path: "/api/publish", summary: "Create or replace a post. Also takes text/markdown with front matter", scope: scopePublish,
+		query: []apiParam{{"on_conflict", "overwrite (default), rename or error, when the slug is taken"}},
+		body:  Post{}, resp: PublishResult{}},
+	{method: "PUT", path: "/api/posts/{slug}", summary: "Replace a post's title, description and content", scope: scopePublish, body: Post{}, resp: statusReply{}},
+	{method: "PATCH", path: "/api/posts/{slug}", summary: "Change only the fields sent", scope: scopePublish, body: postPatch{}, resp: statusReply{}},
+	{method: "DELETE", path: "/api/posts/{slug}", summary: "Move a post to the trash", scope: scopeAdmin, resp: statusReply{}},
+	{method: "POST", path: "/api/posts/{slug}/publish", summary: "Take a draft live, dated now", scope: scopePublish, resp: statusReply{}},
+	{method: "GET", path: "/api/posts/{slug}/revisions", summary: "Every version of a post, newest first", scope: scopeAdmin, resp: []Revision{}},
+	{method: "POST", path: "/api/posts/{slug}/revert/{rev}", summary: "Put an old version's text back", scope: scopeAdmin, resp: statusReply{}},
+	{method: "POST", path: "/api/inbound/mailgun", summary: "Turn an email forwarded by Mailgun into a draft; checked by Mailgun's signature, not a key",
+		bodyType: "multipart/form-data", resp: statusReply{}},
+	{method: "GET", path: "/api/posts/{slug}/preview-link", summary: "A link that shows a draft or scheduled post without a key, until it expires", scope: scopePublish,
+		query: []apiParam{{"hours", "How long the link works, 1 to 720 (default MALT_PREVIEW_HOURS, else 72)"}}, resp: PreviewLink{}},
+	{method: "GET", path: "/api/drafts", summary: "Work in progress, most recently pushed first", scope: scopePublish, resp: []Post{}},
+	{method: "GET", path: "/api/trash", summary: "Deleted posts, most recently deleted first", scope: scopeAdmin, resp: []TrashedPost{}},
+	{method: "POST", path: "/api/posts/{slug}/restore", summary: "Take a post back out of the trash", scope: scopeAdmin, resp: statusReply{}},
+	{method: "DELETE", path: "/api/trash/{slug}", summary: "Delete a trashed post for good", scope: scopeAdmin, resp: statusReply{}},
+	{method: "DELETE", path: "/api/trash", summary: "Empty the trash", scope: scopeAdmin, resp: statusReply{}},
+
+	// Browsing
+	{method: "GET", path: "/api/tags", summary: "Every tag with its post count", resp: []TagCount{}},
+	{method: "GET", path: "/api/search", summary: "Published posts matching every word, best first",
+		query: []apiParam{{"q", "The words; the last may be a prefix"}, {"limit", "At most this many, up to 100 (default 20)"}},
+		resp:  []SearchResult{}},
+	{method: "GET", path: "/api/archive", summary: "Every published post, grouped by year and month, newest first", resp: Archive{}},
+	{method: "GET", path: "/api/archive/{year}", summary: "The archive of one year", resp: Archive{}},
+	{method: "GET", path: "/api/archive/{year}/{month}", summary: "The archive of one month", resp: Archive{}},
+	{method: "GET", path: "/api/calendar", summary: "Posts per day of a month", scope: scopeAdmin,
+		query: []apiParam{{"month", "YYYY-MM, default this month"}, {"tz", "IANA time zone for the days, default UTC"}},
+		resp:  Calendar{}},
+	{method: "GET", path: "/api/stats", summary: "Numbers for the admin dashboard", scope: scopeAdmin,
+		query: []apiParam{{"days", "Window for views, 1 to 3660 (default 30)"}}, resp: Stats{}},
+
+	// Authors
+	{method: "GET", path: "/api/authors", summary: "Everyone posts can be credited to", resp: []Author{}},
+	{method: "GET", path: "/api/authors/{id}", summary: "One author", resp: Author{}},
+	{method: "GET", path: "/api/authors/{id}/posts", summary: "An author's published posts, newest first", resp: []Post{}},
+	{method: "POST", path: "/api/authors", summary: "Add an author", scope: scopeAdmin, body: Author{}, resp: Author{}},
+	{method: "PUT", path: "/api/authors/{id}", summary: "Replace an author's details", scope: scopeAdmin, body: Author{}, resp: Author{}},
+	{method: "DELETE", path: "/api/authors/{id}", summary: "Remove an author; their posts stay, without a byline", scope: scopeAdmin, resp: statusReply{}},
+
+	// Series
+	{method: "GET", path: "/api/series", summary: "Every series, with how many of its parts are out", resp: []Series{}},
+	{method: "GET", path: "/api/series/{slug}", summary: "A series and its parts, in order", resp: Series{}},
+	{method: "POST", path: "/api/series", summary: "Start a series; the slug comes from the title when it's missing", scope: scopeAdmin, body: Series{}, resp: Series{}},
+	{method: "PUT", path: "/api/series/{slug}", summary: "Change a series' title and description; the slug stays", scope: scopeAdmin, body: Series{}, resp: Series{}},
+	{method: "DELETE", path: "/api/series/{slug}", summary: "Remove a series; its posts stay, on their own", scope: scopeAdmin, resp: statusReply{}},
+
+	// Comments
+	{method: "GET", path: "/api/posts/{slug}/comments", summary: "Approved comments, oldest first", feature: "comments", resp: []Comment{}},
+	{method: "POST", path: "/api/posts/{slug}/comments", summary: "Leave a comment; a plain form post works too", feature: "comments", body: commentForm{}, resp: statusReply{}},
+	{method: "GET", path: "/api/comments", summary: "Moderation queue", scope: scopeAdmin, feature: "comments",
+		query: []apiParam{{"status", "pending (default), approved or all"}}, resp: []Comment{}},
+	{method: "POST", path: "/api/comments/{id}/approve", summary: "Show a comment under its post", scope: scopeAdmin, feature: "comments", resp: statusReply{}},
+	{method: "DELETE", path: "/api/comments/{id}", summary: "Remove a comment; its replies stay, as top-level comments", scope: scopeAdmin, feature: "comments", resp: statusReply{}},
+	{method: "POST", path: "/api/import/disqus", summary: "Import a Disqus XML export into native comments", scope: scopeAdmin, feature: "comments",
+		bodyType: "application/xml", resp: DisqusImport{}},
+	{method: "GET", path: "/api/posts/{slug}/mastodon-comments", summary: "Cached Fediverse replies, oldest first", feature: "federation", resp: []RemoteReply{}},
+	{method: "GET", path: "/api/posts/{slug}/bluesky-comments", summary: "Bluesky replies, oldest first", feature: "federation", resp: []RemoteReply{}},
+
+	// Experiments
+	{method: "GET", path: "/api/posts/{slug}/variants", summary: "A post's title experiment so far, control first", scope: scopeAdmin, feature: "experiments", resp: []Variant{}},
+	{method: "POST", path: "/api/posts/{slug}/variants", summary: "Add an alternative title and description", scope: scopeAdmin, feature: "experiments", body: Variant{}, resp: Variant{}},
+	{method: "DELETE", path: "/api/posts/{slug}/variants/{id}", summary: "Drop an arm from the experiment", scope: scopeAdmin, feature: "experiments", resp: statusReply{}},
+	{method: "POST", path: "/api/posts/{slug}/variants/{id}/winner", summary: "End the experiment; id 0 keeps the post's own title", scope: scopeAdmin, feature: "experiments", resp: statusReply{}},
+
+	// Media
+	{method: "POST", path: "/api/media", summary: `Upload one or more images as multipart "file" fields`, scope: scopePublish, bodyType: "multipart/form-data", resp: []Media{}},
+	{method: "GET", path: "/api/media", summary: "Every asset, newest first", scope: scopePublish, resp: []Media{}},
+	{method: "DELETE", path: "/api/media/{id}", summary: "Remove an asset", scope: scopeAdmin, resp: statusReply{}},
+
+	// Blogroll and planet
+	{method: "GET", path: "/api/links", summary: "The blogroll", resp: []Link{}},
+	{method: "GET", path: "/api/links/export", summary: "The blogroll as a download", respType: "application/json"},
+	{method: "POST", path: "/api/links", summary: "Add a link", scope: scopeAdmin, body: Link{}, resp: Link{}},
+	{method: "PUT", path: "/api/links/{id}", summary: "Replace a link", scope: scopeAdmin, body: Link{}, resp: Link{}},
+	{method: "DELETE", path: "/api/links/{id}", summary: "Remove a link", scope: scopeAdmin, resp: statusReply{}},
+	{method: "POST", path: "/api/import/opml", summary: "Add the feeds of an OPML file to the blogroll", scope: scopeAdmin, bodyType: "text/x-opml", resp: OPMLImport{}},
+	{method: "GET", path: "/api/feeds", summary: "The feeds the planet follows", feature: "planet", resp: []Feed{}},
+	{method: "POST", path: "/api/feeds", summary: "Follow a feed", scope: scopeAdmin, feature: "planet", body: Feed{}, resp: Feed{}},
+	{method: "DELETE", path: "/api/feeds/{id}", summary: "Unfollow a feed and drop its cached items", scope: scopeAdmin, feature: "planet", resp: statusReply{}},
+	{method: "GET", path: "/api/firehose", summary: "Everything the planet has seen lately, newest first", feature: "planet",
+		query: []apiParam{{"limit", "At most this many, up to 200 (default 50)"}}, resp: []FeedItem{}},
+
+	// Newsletter
+	{method: "POST", path: "/api/subscribe", summary: "Ask for posts by email; a confirmation link is mailed", feature: "newsletter",
+		body: struct {
+			Email string `json:"email"`
+		}{}, resp: statusReply{}},
+	{method: "GET", path: "/api/subscribers", summary: "The subscriber list", scope: scopeAdmin, feature: "newsletter",
+		query: []apiParam{{"status", "pending, confirmed or unsubscribed (default all)"}}, resp: []Subscriber{}},
+	{method: "DELETE", path: "/api/subscribers/{id}", summary: "Forget an address entirely", scope: scopeAdmin, feature: "newsletter", resp: statusReply{}},
+	{method: "GET", path: "/api/activitypub/followers", summary: "Who follows the blog from the Fediverse", scope: scopeAdmin, feature: "activitypub", resp: []map[string]any{}},
+
+	// Administration
+	{method: "GET", path: "/api/keys", summary: "Every API key, revoked ones included", scope: scopeAdmin, resp: []APIKey{}},
+	{method: "POST", path: "/api/keys", summary: "Make a key; the response is the only time it's shown", scope: scopeAdmin, body: APIKey{}, resp: APIKey{}},
+	{method: "DELETE", path: "/api/keys/{id}", summary: "Revoke a key", scope: scopeAdmin, resp: statusReply{}},
+	{method: "GET", path: "/api/webhooks", summary: "Every webhook, without secrets", scope: scopeAdmin, resp: []Webhook{}},
+	{method: "POST", path: "/api/webhooks", summary: "Register a receiver; the response has its signing secret", scope: scopeAdmin, body: webhookForm{}, resp: Webhook{}},
+	{method: "PUT", path: "/api/webhooks/{id}", summary: "Change a webhook's URL, events or active flag", scope: scopeAdmin, body: webhookForm{}, resp: statusReply{}},
+	{method: "DELETE", path: "/api/webhooks/{id}", summary: "Remove a webhook and its delivery log", scope: scopeAdmin, resp: statusReply{}},
+	{method: "POST", path: "/api/webhooks/{id}/ping", summary: "Queue a ping delivery", scope: scopeAdmin, resp: statusReply{}},
+	{method: "GET", path: "/api/webhooks/{id}/deliveries", summary: "The delivery log, newest first", scope: scopeAdmin,
+		query: []apiParam{{"limit", "At most this many, up to 1000 (default 50)"}}, resp: []WebhookDelivery{}},
+	{method: "GET", path: "/api/flags", summary: "Every feature flag and where its value comes from", scope: scopeAdmin, resp: []Flag{}},
+	{method: "PUT", path: "/api/flags/{name}", summary: "Switch a feature on or off at runtime", scope: scopeAdmin,
+		body: struct {
+			Enabled bool `json:"enabled"`
+		}{}, resp: Flag{}},
+	{method: "DELETE", path: "/api/flags/{name}", summary: "Forget the runtime override and fall back to env", scope: scopeAdmin, resp: Flag{}},
+	{method: "GET", path: "/api/audit", summary: "Audit log, most recent first", scope: scopeAdmin,
+		query: []apiParam{{"limit", "At most this many, up to 1000 (default 100)"}}, resp: []AuditEntry{}},
+	{method: "POST", path: "/api/import", summary: "Import a zipped folder of Markdown posts", scope: scopeAdmin,
+		query:    []apiParam{{"on_conflict", "skip (default), overwrite or rename"}},
+		bodyType: "application/zip", resp: ImportResult{}},
+	{method: "GET", path: "/api/backup", summary: "The database as of now, as a download, age-encrypted when recipients are configured", scope: scopeAdmin, respType: "application/vnd.sqlite3"},
+	{method: "POST", path: "/api/restore", summary: "Import a backup sent as the request body", scope: scopeAdmin,
+		query:    []apiParam{{"on_conflict", "skip, overwrite or rename"}},
+		bodyType: "application/vnd.sqlite3", resp: RestoreResult{}},
+}
+
+var pathParam = regexp.MustCompile(`\{(\w+)\}`)
+
+// schemaGen turns Go types into JSON Schemas, collecting named structs as components.
+type schemaGen struct {
+	components map[string]any
+}
+
+var (
+	typeTime    = reflect.TypeFor[time.Time]()
+	typeRawJSON = reflect.TypeFor[json.RawMessage]()
+)
+
+func (g *schemaGen) schema(t reflect.Type) map[string]any {
+	if t == typeTime {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	if t == typeRawJSON {
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := g.schema(t.Elem())
+		if ref, ok := s["$ref"]; ok {
+			return map[string]any{"oneOf": []any{map[string]any{"$ref": ref}, map[string]any{"type": "null"}}}
+		}
+		if typ, ok := s["type"].(string); ok {
+			s["type"] = []string{typ, "null"}
+		}
+		return s
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name := t.Name()
+		if _, ok := g.components[name]; !ok {
+			g.components[name] = nil // Placeholder, in case the type refers to itself
+			g.components[name] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{} // any: whatever JSON there is
+}
+
+// object lists a struct's JSON fields the way encoding/json would write them.
+func (g *schemaGen) object(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if inner, ok := g.object(f.Type)["properties"].(map[string]any); ok {
+				for k, v := range inner {
+					props[k] = v
+				}
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = g.schema(f.Type)
+	}
+	return map[string]any{"type": "object", "properties": props}
+}
+
+// content is the media type map for a body of v, or of contentType when it isn't JSON.
+func (g *schemaGen) content(v any, contentType string) map[string]any {
+	if contentType != "" {
+		return map[string]any{contentType: map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}}
+	}
+	var schema map[string]any
+	switch v := v.(type) {
+	case oneOf:
+		var alts []any
+		for _, alt := range v {
+			alts = append(alts, g.schema(reflect.TypeOf(alt)))
+		}
+		schema = map[string]any{"oneOf": alts}
+	case statusReply:
+		schema = map[string]any{"$ref": "#/components/schemas/StatusReply"}
+	default:
+		schema = g.schema(reflect.TypeOf(v))
+	}
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+// openAPIDoc builds the document once; only servers depends on the request.
+var openAPIDoc = sync.OnceValue(func() map[string]any {
+	g := &schemaGen{components: map[string]any{
+		"Error": map[string]any{"type": "object", "properties": map[string]any{
+			"error": map[string]any{"type": "string"}, "status": map[string]any{"type": "integer"},
+			"request_id": map[string]any{"type": "string"},
+		}},
+		"ValidationError": map[string]any{"type": "object", "properties": map[string]any{
+			"error": map[string]any{"type": "string"}, "status": map[string]any{"type": "integer"},
+			"request_id": map[string]any{"type": "string"},
+			"fields":     map[string]any{"type": "array", "items": map[string]any{"$ref": "#/components/schemas/FieldError"}},
+		}},
+		"StatusReply": map[string]any{"type": "object", "properties": map[string]any{"status": map[string]any{"type": "string"}},
+			"additionalProperties": map[string]any{}},
+	}}
+	g.schema(reflect.TypeFor[FieldError]())
+	errorResponse := func(description, schema string) map[string]any {
+		return map[string]any{"description": description, "content": map[string]any{"application/json": map[string]any{
+			"schema": map[string]any{"$ref": "#/components/schemas/" + schema}}}}
+	}
+
+	paths := map[string]any{}
+	for _, op := range apiOps {
+		o := map[string]any{
+			"summary":     op.summary,
+			"operationId": operationID(op),
+			"tags":        []string{apiTag(op.path)},
+		}
+
+		var params []any
+		for _, m := range pathParam.FindAllStringSubmatch(op.path, -1) {
+			params = append(params, map[string]any{"name": m[1], "in": "path", "required": true, "schema": map[string]any{"type": "string"}})
+		}
+		for _, q := range op.query {
+			params = append(params, map[string]any{"name": q.name, "in": "query", "description": q.description, "schema": map[string]any{"type": "string"}})
+		}
+		if params != nil {
+			o["parameters"] = params
+		}
+
+		if op.body != nil || op.bodyType != "" {
+			o["requestBody"] = map[string]any{"required": true, "content": g.content(op.body, op.bodyType)}
+		}
+
+		ok := map[string]any{"description": "OK"}
+		if op.resp != nil || op.respType != "" {
+			ok["content"] = g.content(op.resp, op.respType)
+		}
+		responses := map[string]any{"200": ok, "4XX": errorResponse("The request was refused; error says why", "Error")}
+		if op.body != nil {
+			responses["422"] = errorResponse("The body failed validation, field by field", "ValidationError")
+		}
+		if op.scope != "" {
+			o["security"] = []any{map[string]any{"key": []string{}}}
+			o["description"] = "Needs an " + op.scope + " key."
+			if op.scope == scopePublish {
+				o["description"] = "Needs a publish or admin key."
+			}
+		}
+		if op.feature != "" {
+			o["x-feature"] = op.feature
+			o["description"] = strings.TrimSpace(fmtString(o["description"]) + " Only while the " + op.feature + " feature is on.")
+		}
+		o["responses"] = responses
+
+		item, _ := paths[op.path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[op.path] = item
+		}
+		item[strings.ToLower(op.method)] = o
+	}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":       siteTitle() + " API",
+			"version":     "1",
+			"description": "The JSON API of single-malt. Errors are JSON too: {\"error\", \"status\", \"request_id\"}.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": g.components,
+			"securitySchemes": map[string]any{
+				"key": map[string]any{"type": "apiKey", "in": "header", "name": "X-MALT-KEY"},
+			},
+		},
+	}
+})
+
+func fmtString(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+// apiTag groups an endpoint by the first segment after /api: posts, comments, webhooks, ...
+func apiTag(path string) string {
+	seg, _, _ := strings.Cut(strings.TrimPrefix(path, "/api/"), "/")
+	return seg
+}
+
+// operationID is method and path in camel case, e.g. getPostsBySlugRevisions.
+func operationID(op apiOp) string {
+	id := strings.ToLower(op.method)
+	for _, seg := range strings.Split(strings.TrimPrefix(op.path, "/api/"), "/") {
+		if m := pathParam.FindStringSubmatch(seg); m != nil {
+			seg = "by_" + m[1]
+		}
+		for _, word := range strings.FieldsFunc(seg, func(r rune) bool { return r == '_' || r == '-' }) {
+			id += strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return id
+}
+
+// GET /api/openapi.json - This API as an OpenAPI 3.1 document
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	doc := map[string]any{"servers": []any{map[string]any{"url": baseURL(r)}}}
+	for k, v := range openAPIDoc() {
+		doc[k] = v
+	}
+	jsonResponse(w, doc)
+}
+
+// docsOp is an endpoint as the docs page shows it. Body and Response are schema names, or
+// BodyType and ResponseType a content type when the body isn't JSON.
+type docsOp struct {
+	Method, Path, Summary, Auth, Feature string
+	Query                                [][2]string // Name, description
+	Body, Response                       []string
+	BodyType, ResponseType               string
+}
+
+// docsSchema is a component schema as the docs page shows it.
+type docsSchema struct {
+	Name   string
+	Fields [][2]string // Name, type
+}
+
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font: 15px/1.5 system-ui, sans-serif; max-width: 60rem; margin: 2rem auto; padding: 0 1rem; color: #222; }
+h2 { margin-top: 2.5rem; border-bottom: 1px solid #ddd; text-transform: capitalize; }
+.op { margin: 1rem 0; }
+.method { display: inline-block; min-width: 4rem; font: bold 13px monospace; }
+code, .path { font-family: ui-monospace, monospace; }
+.meta, .params { color: #666; font-size: 14px; margin-left: 4rem; }
+table { border-collapse: collapse; margin-bottom: 1.5rem; }
+td { padding: 0.1rem 1rem 0.1rem 0; font-family: ui-monospace, monospace; font-size: 14px; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Machine-readable: <a href="/api/openapi.json">/api/openapi.json</a>. Send keys as <code>X-MALT-KEY</code>; errors are <code>{"error", "status", "request_id"}</code>.</p>
+{{range .Groups}}
+<h2>{{.Name}}</h2>
+{{range .Ops}}
+<div class="op">
+<span class="method">{{.Method}}</span> <span class="path">{{.Path}}</span> - {{.Summary}}
+<div class="meta">
+{{- if .Auth}}{{.Auth}}. {{end}}
+{{- if .Feature}}Only while the {{.Feature}} feature is on. {{end}}
+{{- if .BodyType}}Body: <code>{{.BodyType}}</code>. {{end}}
+{{- if .Body}}Body: {{template "names" .Body}}. {{end}}
+{{- if .ResponseType}}Returns <code>{{.ResponseType}}</code>.{{end}}
+{{- if .Response}}Returns {{template "names" .Response}}.{{end}}</div>
+{{range .Query}}<div class="params"><code>?{{index . 0}}</code> {{index . 1}}</div>{{end}}
+</div>
+{{end}}
+{{end}}
+<h2>Schemas</h2>
+{{range .Schemas}}
+<h3 id="{{.Name}}">{{.Name}}</h3>
+<table>{{range .Fields}}<tr><td>{{index . 0}}</td><td>{{index . 1}}</td></tr>{{end}}</table>
+{{end}}
+</body>
+</html>
+{{define "names"}}{{range $i, $name := .}}{{if $i}} or {{end}}<a href="#{{$name}}">{{$name}}</a>{{end}}{{end}}`
+
+var docsTmpl = template.Must(template.New("docs").Parse(docsPage))
+
+// GET /api/docs - The same, as a page to read
+func handleAPIDocs(w http.ResponseWriter, r *http.Request) {
+	type group struct {
+		Name string
+		Ops  []docsOp
+	}
+	var groups []*group // In the order apiOps first mentions them
+	byTag := map[string]*group{}
+	for _, op := range apiOps {
+		d := docsOp{Method: op.method, Path: op.path, Summary: op.summary, Feature: op.feature,
+			Body: schemaNames(op.body), Response: schemaNames(op.resp), BodyType: op.bodyType, ResponseType: op.respType}
+		for _, q := range op.query {
+			d.Query = append(d.Query, [2]string{q.name, q.description})
+		}
+		switch op.scope {
+		case scopeAdmin:
+			d.Auth = "Admin key"
+		case scopePublish:
+			d.Auth = "Publish or admin key"
+		}
+		tag := apiTag(op.path)
+		if byTag[tag] == nil {
+			byTag[tag] = &group{Name: tag}
+			groups = append(groups, byTag[tag])
+		}
+		byTag[tag].Ops = append(byTag[tag].Ops, d)
+	}
+
+	components := openAPIDoc()["components"].(map[string]any)["schemas"].(map[string]any)
+	var schemas []docsSchema
+	for name, s := range components {
+		props, _ := s.(map[string]any)["properties"].(map[string]any)
+		ds := docsSchema{Name: name}
+		for field, fs := range props {
+			ds.Fields = append(ds.Fields, [2]string{field, schemaTypeName(fs)})
+		}
+		sort.Slice(ds.Fields, func(i, j int) bool { return ds.Fields[i][0] < ds.Fields[j][0] })
+		schemas = append(schemas, ds)
+	}
+	sort.Slice(schemas, func(i, j int) bool { return schemas[i].Name < schemas[j].Name })
+
+	var page bytes.Buffer
+	if err := docsTmpl.Execute(&page, map[string]any{"Title": siteTitle() + " API", "Groups": groups, "Schemas": schemas}); err != nil {
+		httpError(w, r, "Could not render the docs", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page.Bytes())
+}
+
+// schemaNames names the schemas of a request or response type for the docs page.
+func schemaNames(v any) []string {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case statusReply:
+		return []string{"StatusReply"}
+	case oneOf:
+		var names []string
+		for _, alt := range v {
+			names = append(names, schemaNames(alt)...)
+		}
+		return names
+	}
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Name() == "" {
+		return nil // Anonymous, so there's no schema to link to
+	}
+	return []string{t.Name()}
+}
+
+// schemaTypeName is a short, readable type for a property: string, Post, []Comment, ...
+func schemaTypeName(s any) string {
+	m, _ := s.(map[string]any)
+	if ref, ok := m["$ref"].(string); ok {
+		return strings.TrimPrefix(ref, "#/components/schemas/")
+	}
+	if alts, ok := m["oneOf"].([]any); ok && len(alts) > 0 {
+		return schemaTypeName(alts[0]) + "?"
+	}
+	switch typ := m["type"].(type) {
+	case string:
+		switch typ {
+		case "array":
+			return "[]" + schemaTypeName(m["items"])
+		case "object":
+			if m["additionalProperties"] != nil {
+				return "map of " + schemaTypeName(m["additionalProperties"])
+			}
+			return "object"
+		case "string":
+			if f, ok := m["format"].(string); ok {
+				return f
+			}
+		}
+		return typ
+	case []string:
+		if f, ok := m["format"].(string); ok {
+			return f + "?"
+		}
+		return typ[0] + "?"
+	}
+	return "any"
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// --- OPML: the blogroll in and out in the format every feed reader speaks ---
+
+const maxOPMLImport = 8 << 20
+
+type opmlDoc struct {
+	XMLName xml.Name      `xml:"opml"`
+	Version string        `xml:"version,attr"`
+	Title   string        `xml:"head>title"`
+	Created string        `xml:"head>dateCreated,omitempty"`
+	Body    []opmlOutline `xml:"body>outline"`
+}
+
+type opmlOutline struct {
+	Text        string        `xml:"text,attr"`
+	Title       string        `xml:"title,attr,omitempty"`
+	Type        string        `xml:"type,attr,omitempty"`
+	XMLURL      string        `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL     string        `xml:"htmlUrl,attr,omitempty"`
+	Description string        `xml:"description,attr,omitempty"`
+	Outlines    []opmlOutline `xml:"outline"`
+}
+
+// OPMLImport is the summary returned to whoever ran the import.
+type OPMLImport struct {
+	LinksAdded   int `json:"links_added"`
+	LinksSkipped int `json:"links_skipped"` // Already in the blogroll, or not an http(s) URL
+	FeedsAdded   int `json:"feeds_added"`
+}
+
+func httpURL(raw string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
+	}
+	return u.String(), true
+}
+
+// flattenOutlines yields every subscription with the folder it sits in as its category.
+func flattenOutlines(outlines []opmlOutline, category string, visit func(o opmlOutline, category string)) {
+	for _, o := range outlines {
+		if o.XMLURL == "" && o.HTMLURL == "" {
+			// A folder
+			name := o.Text
+			if name == "" {
+				name = o.Title
+			}
+			flattenOutlines(o.Outlines, name, visit)
+			continue
+		}
+		visit(o, category)
+		flattenOutlines(o.Outlines, category, visit)
+	}
+}
+
+// POST /api/import/opml - Add a reader's subscription list (the request body) to the blogroll.
+// With ?planet=1 the feeds are followed by the planet as well.
+func handleImportOPML(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	var doc opmlDoc
+	if err := xml.NewDecoder(http.MaxBytesReader(w, r.Body, maxOPMLImport)).Decode(&doc); err != nil {
+		httpError(w, r, "Bad OPML: "+err.Error(), 400)
+		return
+	}
+	withPlanet := r.URL.Query().Get("planet") == "1"
+
+	var summary OPMLImport
+	var newFeeds []int64
+	err := withTx(r.Context(), func(tx *sql.Tx) error {
+		var failed error
+		flattenOutlines(doc.Body, "", func(o opmlOutline, category string) {
+			if failed != nil {
+				return
+			}
+			feedURL, hasFeed := httpURL(o.XMLURL)
+			siteURL, ok := httpURL(o.HTMLURL)
+			if !ok {
+				siteURL, ok = feedURL, hasFeed
+			}
+			if !ok {
+				summary.LinksSkipped++
+				return
+			}
+
+			l := Link{Title: strings.TrimSpace(o.Title), URL: siteURL, Description: o.Description, Category: category, FeedURL: feedURL}
+			if l.Title == "" {
+				l.Title = strings.TrimSpace(o.Text)
+			}
+			if l.Title == "" {
+				u, _ := url.Parse(siteURL)
+				l.Title = u.Host
+			}
+
+			var exists bool
+			tx.QueryRow("SELECT EXISTS(SELECT 1 FROM links WHERE url = ?)", l.URL).Scan(&exists)
+			if exists {
+				summary.LinksSkipped++
+			} else {
+				_, failed = tx.Exec("INSERT INTO links (title, url, description, category, feed_url) VALUES (?, ?, ?, ?, ?)",
+					l.Title, l.URL, l.Description, l.Category, l.FeedURL)
+				summary.LinksAdded++
+			}
+
+			if withPlanet && hasFeed && failed == nil {
+				var id int64
+				err := tx.QueryRow("INSERT INTO feeds (title, url, site_url) VALUES (?, ?, ?) ON CONFLICT DO NOTHING RETURNING id",
+					l.Title, feedURL, siteURL).Scan(&id)
+				switch {
+				case err == nil:
+					newFeeds = append(newFeeds, id)
+					summary.FeedsAdded++
+				case err != sql.ErrNoRows:
+					failed = err
+					return
+				}
+			}
+		})
+		return failed
+	})
+	if err != nil {
+		httpError(w, r, "Failed to save: "+err.Error(), 500)
+		return
+	}
+
+	if featureEnabled("planet") {
+		go func() {
+			for _, id := range newFeeds {
+				refreshFeed(id)
+			}
+		}()
+	}
+
+	jsonResponse(w, summary)
+}
+
+// GET /blogroll.opml - The blogroll for readers to subscribe to, one folder per category
+func handleBlogrollOPML(w http.ResponseWriter, r *http.Request) {
+	links, err := listLinks()
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+
+	doc := opmlDoc{Version: "2.0", Title: "Blogroll | " + r.Host, Created: time.Now().UTC().Format(time.RFC1123Z)}
+	folders := map[string]int{} // Category -> index in doc.Body
+	for _, l := range links {
+		o := opmlOutline{Text: l.Title, Title: l.Title, HTMLURL: l.URL, XMLURL: l.FeedURL, Description: l.Description}
+		if l.FeedURL != "" {
+			o.Type = "rss"
+		}
+		if l.Category == "" {
+			doc.Body = append(doc.Body, o)
+			continue
+		}
+		i, ok := folders[l.Category]
+		if !ok {
+			i = len(doc.Body)
+			folders[l.Category] = i
+			doc.Body = append(doc.Body, opmlOutline{Text: l.Category, Title: l.Category})
+		}
+		doc.Body[i].Outlines = append(doc.Body[i].Outlines, o)
+	}
+
+	w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(doc)
+}
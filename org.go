@@ -0,0 +1,202 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// --- Org-mode: the subset that shows up in blog drafts, rendered without pandoc ---
+//
+// Headlines, paragraphs, lists, tables, #+BEGIN_SRC/EXAMPLE/QUOTE blocks, links and
+// the usual *bold* /italic/ _underline_ +strike+ =verbatim= ~code~ markup.
+
+var (
+	orgHeadline = regexp.MustCompile(`^(\*+)\s+(.*)$`)
+	orgTodo     = regexp.MustCompile(`^(TODO|DONE|NEXT|WAITING|CANCELLED)\s+`)
+	orgTags     = regexp.MustCompile(`\s+:[\w@#%:]+:\s*$`)
+	orgListItem = regexp.MustCompile(`^(\s*)([-+]|\d+[.)])\s+(.*)$`)
+	orgRule     = regexp.MustCompile(`^-{5,}$`)
+	orgLink     = regexp.MustCompile(`\[\[([^\]]+)\](?:\[([^\]]+)\])?\]`)
+	orgCode     = regexp.MustCompile(`(^|[\s(\["'>])[=~]([^\s=~](?:[^=~]*[^\s=~])?)[=~]($|[\s)\]"'.,;:!?<-])`)
+
+	orgBold      = emphasis("*", "strong")
+	orgItalic    = emphasis("/", "em")
+	orgUnderline = emphasis("_", "u")
+	orgStrike    = emphasis("+", "del")
+)
+
+func renderOrg(src string) string {
+	var b strings.Builder
+	var para paragraph
+	var items []listItem
+	var indents []int
+	var table [][]string
+
+	flushList := func() {
+		if len(items) > 0 {
+			b.WriteString(renderList(items) + "\n")
+		}
+		items, indents = nil, nil
+	}
+	flushTable := func() {
+		if len(table) > 0 {
+			b.WriteString(orgTable(table))
+		}
+		table = nil
+	}
+	flushAll := func() {
+		para.flush(&b, orgInline)
+		flushList()
+		flushTable()
+	}
+
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		upper := strings.ToUpper(trimmed)
+
+		if trimmed == "" {
+			flushAll()
+			continue
+		}
+
+		// #+BEGIN_x ... #+END_x
+		if strings.HasPrefix(upper, "#+BEGIN_") {
+			flushAll()
+			kind, arg, _ := strings.Cut(trimmed[len("#+BEGIN_"):], " ")
+			kind = strings.ToUpper(kind)
+
+			var body []string
+			for i++; i < len(lines) && !strings.EqualFold(strings.TrimSpace(lines[i]), "#+END_"+kind); i++ {
+				body = append(body, lines[i])
+			}
+
+			switch kind {
+			case "SRC":
+				lang, _, _ := strings.Cut(strings.TrimSpace(arg), " ")
+				b.WriteString(codeBlock(lang, body))
+			case "EXAMPLE":
+				b.WriteString(codeBlock("", body))
+			case "QUOTE":
+				b.WriteString("<blockquote>\n" + renderOrg(strings.Join(body, "\n")) + "</blockquote>\n")
+			default:
+				b.WriteString(`<div class="` + html.EscapeString(strings.ToLower(kind)) + `">` + "\n" + renderOrg(strings.Join(body, "\n")) + "</div>\n")
+			}
+			continue
+		}
+
+		// #+KEYWORD: lines and # comments produce no output
+		if strings.HasPrefix(trimmed, "#+") || trimmed == "#" || strings.HasPrefix(trimmed, "# ") {
+			continue
+		}
+
+		if m := orgHeadline.FindStringSubmatch(line); m != nil {
+			flushAll()
+			level := min(len(m[1]), 6)
+			text := orgTags.ReplaceAllString(orgTodo.ReplaceAllString(m[2], ""), "")
+			tag := "h" + string(rune('0'+level))
+			b.WriteString("<" + tag + ">" + orgInline(text) + "</" + tag + ">\n")
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "|") {
+			para.flush(&b, orgInline)
+			flushList()
+			if !strings.HasPrefix(trimmed, "|-") {
+				cells := strings.Split(strings.Trim(trimmed, "|"), "|")
+				table = append(table, cells)
+			} else if len(table) == 1 {
+				table = append(table, nil) // nil row marks "the rows above are the header"
+			}
+			continue
+		}
+
+		if orgRule.MatchString(trimmed) {
+			flushAll()
+			b.WriteString("<hr>\n")
+			continue
+		}
+
+		if m := orgListItem.FindStringSubmatch(line); m != nil {
+			para.flush(&b, orgInline)
+			flushTable()
+
+			indent := len(m[1])
+			for len(indents) > 0 && indents[len(indents)-1] > indent {
+				indents = indents[:len(indents)-1]
+			}
+			if len(indents) == 0 || indents[len(indents)-1] < indent {
+				indents = append(indents, indent)
+			}
+
+			ordered := m[2] != "-" && m[2] != "+"
+			items = append(items, listItem{Level: len(indents), Ordered: ordered, HTML: orgInline(m[3])})
+			continue
+		}
+
+		// An indented line right after a list item continues it
+		if len(items) > 0 && line != trimmed {
+			items[len(items)-1].HTML += " " + orgInline(trimmed)
+			continue
+		}
+
+		flushList()
+		flushTable()
+		para = append(para, trimmed)
+	}
+	flushAll()
+
+	return b.String()
+}
+
+func orgTable(rows [][]string) string {
+	var b strings.Builder
+	b.WriteString("<table>\n")
+
+	cellTag := "td"
+	if len(rows) > 1 && rows[1] == nil {
+		cellTag = "th"
+	}
+	for _, row := range rows {
+		if row == nil {
+			cellTag = "td"
+			continue
+		}
+		b.WriteString("<tr>")
+		for _, cell := range row {
+			b.WriteString("<" + cellTag + ">" + orgInline(strings.TrimSpace(cell)) + "</" + cellTag + ">")
+		}
+		b.WriteString("</tr>\n")
+	}
+
+	b.WriteString("</table>\n")
+	return b.String()
+}
+
+func orgInline(s string) string {
+	var shelf inlineShelf
+	s = html.EscapeString(unshelvable(s))
+
+	s = orgCode.ReplaceAllStringFunc(s, func(m string) string {
+		sub := orgCode.FindStringSubmatch(m)
+		return sub[1] + shelf.park("<code>"+sub[2]+"</code>") + sub[3]
+	})
+
+	s = orgLink.ReplaceAllStringFunc(s, func(m string) string {
+		sub := orgLink.FindStringSubmatch(m)
+		target := strings.TrimPrefix(sub[1], "file:")
+		href := safeHref(target)
+		if sub[2] == "" {
+			if isImagePath(target) {
+				return shelf.park(`<img src="` + href + `" alt="">`)
+			}
+			return shelf.park(`<a href="` + href + `">` + sub[1] + `</a>`)
+		}
+		return shelf.park(`<a href="` + href + `">` + sub[2] + `</a>`)
+	})
+
+	s = orgStrike(orgUnderline(orgItalic(orgBold(s))))
+	return shelf.restore(s)
+}
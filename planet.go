@@ -0,0 +1,439 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Planet: other people's feeds, fetched in the background and served as one firehose ---
+
+// Feed is an external RSS/Atom feed the planet follows.
+type Feed struct {
+	ID            int64      `json:"id"`
+	Title         string     `json:"title"`
+	URL           string     `json:"url"`      // The feed itself
+	SiteURL       string     `json:"site_url"` // Where humans go
+	LastFetchedAt *time.Time `json:"last_fetched_at"`
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+// FeedItem is one cached entry from a followed feed.
+type FeedItem struct {
+	FeedTitle   string    `json:"feed_title"`
+	SiteURL     string    `json:"site_url"`
+	Title       string    `json:"title"`
+	URL         string    `json:"url"`
+	Summary     string    `json:"summary"` // Plain text, trimmed
+	PublishedAt time.Time `json:"published_at"`
+}
+
+const (
+	maxFeedBytes    = 5 << 20 // Nobody's feed needs to be bigger than this
+	maxItemsPerFeed = 100     // Older entries are pruned after each fetch
+	summaryLength   = 300
+)
+
+var feedClient = &http.Client{Timeout: 15 * time.Second}
+
+// feedDoc decodes both RSS 2.0 (<rss><channel>) and Atom (<feed>) in one pass.
+type feedDoc struct {
+	XMLName xml.Name
+	Channel struct {
+		Title string `xml:"title"`
+		Link  string `xml:"link"`
+		Items []struct {
+			GUID        string `xml:"guid"`
+			Title       string `xml:"title"`
+			Link        string `xml:"link"`
+			Description string `xml:"description"`
+			PubDate     string `xml:"pubDate"`
+		} `xml:"item"`
+	} `xml:"channel"`
+	Title   string     `xml:"title"`
+	Links   []atomLink `xml:"link"`
+	Entries []struct {
+		ID        string     `xml:"id"`
+		Title     string     `xml:"title"`
+		Links     []atomLink `xml:"link"`
+		Summary   string     `xml:"summary"`
+		Content   string     `xml:"content"`
+		Published string     `xml:"published"`
+		Updated   string     `xml:"updated"`
+	} `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+// parsedItem is a feed entry normalized across formats, ready for the feed_items table.
+type parsedItem struct {
+	GUID, Title, URL, Summary string
+	PublishedAt               time.Time
+}
+
+// alternate picks the rel="alternate" (or rel-less) link, which is the human-facing one.
+func alternate(links []atomLink) string {
+	for _, l := range links {
+		if l.Rel == "" || l.Rel == "alternate" {
+			return l.Href
+		}
+	}
+	return ""
+}
+
+var feedDateFormats = []string{
+	time.RFC1123Z, time.RFC1123, time.RFC3339, time.RFC822Z, time.RFC822,
+	"Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST", "2006-01-02",
+}
+
+// parseFeedDate tries the date formats feeds actually use. Undated entries count as "now".
+func parseFeedDate(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range feedDateFormats {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Now().UTC()
+}
+
+// plainText flattens an HTML fragment into a single line of text.
+func plainText(s string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(htmlTagPattern.ReplaceAllString(s, " "))), " ")
+}
+
+// plainSummary turns an HTML description into a short plain-text teaser.
+func plainSummary(s string) string {
+	s = plainText(s)
+	if r := []rune(s); len(r) > summaryLength {
+		s = strings.TrimSpace(string(r[:summaryLength])) + "…"
+	}
+	return s
+}
+
+// parseFeed normalizes RSS 2.0 or Atom into a title, site link and items.
+func parseFeed(body io.Reader) (title, site string, items []parsedItem, err error) {
+	var doc feedDoc
+	if err := xml.NewDecoder(body).Decode(&doc); err != nil {
+		return "", "", nil, err
+	}
+
+	switch doc.XMLName.Local {
+	case "rss":
+		for _, it := range doc.Channel.Items {
+			guid := it.GUID
+			if guid == "" {
+				guid = it.Link
+			}
+			items = append(items, parsedItem{guid, it.Title, it.Link, plainSummary(it.Description), parseFeedDate(it.PubDate)})
+		}
+		return doc.Channel.Title, doc.Channel.Link, items, nil
+	case "feed":
+		for _, e := range doc.Entries {
+			summary, date := e.Summary, e.Published
+			if summary == "" {
+				summary = e.Content
+			}
+			if date == "" {
+				date = e.Updated
+			}
+			items = append(items, parsedItem{e.ID, e.Title, alternate(e.Links), plainSummary(summary), parseFeedDate(date)})
+		}
+		return doc.Title, alternate(doc.Links), items, nil
+	}
+	return "", "", nil, fmt.Errorf("not an RSS or Atom feed (<%s>)", doc.XMLName.Local)
+}
+
+// fetchFeed pulls one feed, politely: conditional GET with the ETag/Last-Modified we saw last time.
+func fetchFeed(id int64) error {
+	var feedURL, etag, lastModified string
+	err := db.QueryRow("SELECT url, etag, last_modified FROM feeds WHERE id = ?", id).Scan(&feedURL, &etag, &lastModified)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("GET", feedURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "single-malt planet")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	res, err := feedClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotModified:
+		_, err = execWrite("UPDATE feeds SET last_fetched_at = ?, last_error = '' WHERE id = ?", time.Now().UTC(), id)
+		return err
+	case res.StatusCode != http.StatusOK:
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	title, site, items, err := parseFeed(io.LimitReader(res.Body, maxFeedBytes))
+	if err != nil {
+		return err
+	}
+
+	for _, it := range items {
+		if it.GUID == "" {
+			continue
+		}
+		_, err := execWrite(`
+			INSERT INTO feed_items (feed_id, guid, title, url, summary, published_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT(feed_id, guid) DO UPDATE SET
+				title=excluded.title,
+				url=excluded.url,
+				summary=excluded.summary
+		`, id, it.GUID, it.Title, it.URL, it.Summary, it.PublishedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Keep the cache bounded: only the newest entries per feed survive
+	_, err = execWrite(`
+		DELETE FROM feed_items WHERE feed_id = ? AND id NOT IN (
+			SELECT id FROM feed_items WHERE feed_id = ? ORDER BY published_at DESC LIMIT ?
+		)`, id, id, maxItemsPerFeed)
+	if err != nil {
+		return err
+	}
+
+	// A title set by hand wins over whatever the feed calls itself
+	_, err = execWrite(`
+		UPDATE feeds SET
+			title = CASE WHEN title = '' THEN ? ELSE title END,
+			site_url = ?, etag = ?, last_modified = ?, last_fetched_at = ?, last_error = ''
+		WHERE id = ?
+	`, title, site, res.Header.Get("ETag"), res.Header.Get("Last-Modified"), time.Now().UTC(), id)
+	return err
+}
+
+// refreshFeeds fetches every followed feed once, recording failures on the feed row.
+func refreshFeeds() {
+	rows, err := db.Query("SELECT id FROM feeds")
+	if err != nil {
+		log.Printf("planet: %v", err)
+		return
+	}
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		refreshFeed(id)
+	}
+}
+
+func refreshFeed(id int64) {
+	if err := fetchFeed(id); err != nil {
+		log.Printf("planet: feed %d: %v", id, err)
+		execWrite("UPDATE feeds SET last_fetched_at = ?, last_error = ? WHERE id = ?", time.Now().UTC(), err.Error(), id)
+	}
+}
+
+// runPlanet is the background job: refresh all feeds every MALT_PLANET_INTERVAL (default 30m).
+func runPlanet() {
+	every, err := time.ParseDuration(os.Getenv("MALT_PLANET_INTERVAL"))
+	if err != nil || every < time.Minute {
+		every = 30 * time.Minute
+	}
+
+	for {
+		if featureEnabled("planet") {
+			refreshFeeds()
+		}
+		time.Sleep(every)
+	}
+}
+
+// GET /api/feeds - The feeds the planet follows
+func handleListFeeds(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query("SELECT id, title, url, site_url, last_fetched_at, last_error FROM feeds ORDER BY title")
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	feeds := []Feed{}
+	for rows.Next() {
+		var f Feed
+		var fetched sql.NullTime
+		if err := rows.Scan(&f.ID, &f.Title, &f.URL, &f.SiteURL, &fetched, &f.LastError); err != nil {
+			continue
+		}
+		if fetched.Valid {
+			f.LastFetchedAt = &fetched.Time
+		}
+		feeds = append(feeds, f)
+	}
+
+	jsonResponse(w, feeds)
+}
+
+// POST /api/feeds - Follow a feed. The first fetch happens right away.
+func handleCreateFeed(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	var f Feed
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		httpError(w, r, "Bad JSON", 400)
+		return
+	}
+
+	u, err := url.Parse(strings.TrimSpace(f.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		httpError(w, r, "URL must be an absolute http(s) link", 400)
+		return
+	}
+	f.URL = u.String()
+
+	f.ID, err = insertID("INSERT INTO feeds (title, url) VALUES (?, ?)", strings.TrimSpace(f.Title), f.URL)
+	if err != nil {
+		httpError(w, r, "Failed to save: "+err.Error(), 500)
+		return
+	}
+
+	go refreshFeed(f.ID)
+
+	jsonResponse(w, f)
+}
+
+// DELETE /api/feeds/{id} - Unfollow a feed and drop its cached items
+func handleDeleteFeed(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	id := r.PathValue("id")
+	result, err := execWrite("DELETE FROM feeds WHERE id = ?", id)
+	if err != nil {
+		httpError(w, r, "Database error: "+err.Error(), 500)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		httpError(w, r, "Feed not found", 404)
+		return
+	}
+	execWrite("DELETE FROM feed_items WHERE feed_id = ?", id)
+
+	jsonResponse(w, map[string]string{"status": "deleted", "id": id})
+}
+
+// firehose returns the newest cached items across all feeds.
+func firehose(limit int) ([]FeedItem, error) {
+	rows, err := db.Query(`
+		SELECT f.title, f.site_url, i.title, i.url, i.summary, i.published_at
+		FROM feed_items i JOIN feeds f ON f.id = i.feed_id
+		ORDER BY i.published_at DESC
+		LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []FeedItem{}
+	for rows.Next() {
+		var it FeedItem
+		if err := rows.Scan(&it.FeedTitle, &it.SiteURL, &it.Title, &it.URL, &it.Summary, &it.PublishedAt); err != nil {
+			continue
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+// GET /api/firehose?limit=50 - Everything the planet has seen lately, newest first
+func handleFirehose(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	items, err := firehose(limit)
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+
+	jsonResponse(w, items)
+}
+
+const defaultPlanetPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Planet | Goholic</title>
+    <meta name="description" content="What the neighbourhood is writing.">
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, Helvetica, Arial, sans-serif; max-width: 680px; margin: 0 auto; padding: 2rem 1.5rem; line-height: 1.6; }
+        @media (prefers-color-scheme: dark) { body { background: #111111; color: #e1e1e1; } a { color: #4da3ff; } }
+        .meta { color: #888; font-size: 0.85rem; }
+        article { margin-bottom: 2rem; }
+    </style>
+</head>
+<body>
+    <p><a href="/">goholic.in</a></p>
+    <h1>Planet</h1>
+    {{range .}}
+    <article>
+        <h2><a href="{{.URL}}">{{.Title}}</a></h2>
+        <div class="meta"><a href="{{.SiteURL}}">{{.FeedTitle}}</a> &middot; {{.PublishedAt.Format "Jan 2, 2006"}}</div>
+        <p>{{.Summary}}</p>
+    </article>
+    {{else}}
+    <p>Nothing from the neighbourhood yet.</p>
+    {{end}}
+</body>
+</html>`
+
+var fallbackPlanetTmpl = template.Must(template.New("planet").Parse(defaultPlanetPage))
+
+// GET /planet - The aggregated page (only routed when MALT_PLANET_PAGE is on)
+func handlePlanetPage(w http.ResponseWriter, r *http.Request) {
+	items, err := firehose(50)
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	themeTemplate(fallbackPlanetTmpl, "planet.html").Execute(w, items)
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"no placeholders", "SELECT 1", "SELECT 1"},
+		{"numbered in order", "UPDATE posts SET title = ? WHERE slug = ?", "UPDATE posts SET title = $1 WHERE slug = $2"},
+		{"in a string", "SELECT '?' WHERE a = ?", "SELECT '?' WHERE a = $1"},
+		{"doubled quote", "SELECT 'it''s ?' WHERE a = ?", "SELECT 'it''s ?' WHERE a = $1"},
+		{"doubled quote at the end", "SELECT '''' WHERE a = ? AND b = '?'''", "SELECT '''' WHERE a = $1 AND b = '?'''"},
+		{"quoted name", `SELECT "wh?t" FROM t WHERE a = ?`, `SELECT "wh?t" FROM t WHERE a = $1`},
+		{"one quote inside the other", `SELECT '"?' WHERE a = ? AND b = "'?"`, `SELECT '"?' WHERE a = $1 AND b = "'?"`},
+		{"ten and up", "VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", "VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rebind(tt.in); got != tt.want {
+				t.Errorf("rebind(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
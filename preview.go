@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Previews: drafts and scheduled posts, for reviewers without a key ---
+//
+// GET /api/posts/{slug}/preview-link hands out /post/{slug}?token=..., which shows the post
+// as it stands to anyone who has it, until the token expires: ?hours=, else
+// MALT_PREVIEW_HOURS (default 72). The token is the expiry signed with the slug, by a key
+// made and kept in the database, so it opens that one post and nothing else, and needs no
+// bookkeeping. Previews are noindex and kept out of shared caches.
+
+const maxPreviewHours = 24 * 30
+
+// PreviewLink is a shareable link to a post that isn't out yet.
+type PreviewLink struct {
+	URL       string    `json:"url"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// previewKey signs preview tokens; made and stored the first time it's needed.
+var previewKey = sync.OnceValues(func() ([]byte, error) {
+	var key string
+	err := db.QueryRow("SELECT secret FROM preview_keys WHERE id = 1").Scan(&key)
+	if err == nil {
+		return []byte(key), nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	// Instances starting together can all get here; the first insert wins and they all read it back
+	_, err = execWrite("INSERT INTO preview_keys (id, secret, created_at) VALUES (1, ?, ?) ON CONFLICT(id) DO NOTHING",
+		rand.Text()+rand.Text(), time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	err = db.QueryRow("SELECT secret FROM preview_keys WHERE id = 1").Scan(&key)
+	return []byte(key), err
+})
+
+func previewMAC(key []byte, slug, expires string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(slug + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:18])
+}
+
+// previewToken signs slug until expires: the expiry in base 36, a dot, and the MAC.
+func previewToken(slug string, expires time.Time) (string, error) {
+	key, err := previewKey()
+	if err != nil {
+		return "", err
+	}
+	exp := strconv.FormatInt(expires.Unix(), 36)
+	return exp + "." + previewMAC(key, slug, exp), nil
+}
+
+// validPreview says whether token opens slug right now.
+func validPreview(slug, token string) bool {
+	exp, mac, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(exp, 36, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+	key, err := previewKey()
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(mac), []byte(previewMAC(key, slug, exp)))
+}
+
+// isLive says whether readers can see p: published, and not dated in the future.
+func isLive(p Post) bool {
+	return p.Status == "published" && !p.PublishedAt.After(time.Now())
+}
+
+// canView says whether r may see p. Posts that aren't live need a publish key or a preview
+// token for them; a preview is kept out of search engines, and by cacheControl out of shared caches.
+func canView(w http.ResponseWriter, r *http.Request, p Post) bool {
+	if isLive(p) || hasScope(r, scopePublish) {
+		return true
+	}
+	if !validPreview(p.Slug, r.URL.Query().Get("token")) {
+		return false
+	}
+	w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+	return true
+}
+
+// GET /api/posts/{slug}/preview-link?hours=72 - A link that shows the post without a key, until it expires
+func handlePreviewLink(w http.ResponseWriter, r *http.Request) {
+	if !requireScope(w, r, scopePublish) {
+		return
+	}
+
+	p, err := store.GetPost(r.PathValue("slug"))
+	if err == sql.ErrNoRows {
+		httpError(w, r, "Post not found", 404)
+		return
+	}
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+
+	hours := max(1, envInt("MALT_PREVIEW_HOURS", 72))
+	if q := r.URL.Query().Get("hours"); q != "" {
+		n, err := strconv.Atoi(q)
+		if err != nil || n < 1 || n > maxPreviewHours {
+			httpError(w, r, "hours must be between 1 and "+strconv.Itoa(maxPreviewHours), 400)
+			return
+		}
+		hours = n
+	}
+
+	expires := time.Now().UTC().Add(time.Duration(hours) * time.Hour).Truncate(time.Second)
+	token, err := previewToken(p.Slug, expires)
+	if err != nil {
+		httpError(w, r, "Could not sign the link: "+err.Error(), 500)
+		return
+	}
+	link := baseURL(r) + "/post/" + url.PathEscape(p.Slug) + "?token=" + url.QueryEscape(token)
+
+	jsonResponse(w, PreviewLink{URL: link, Token: token, ExpiresAt: expires})
+}
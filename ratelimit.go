@@ -0,0 +1,152 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Rate limiting: a token bucket per IP on writes, and a lockout for key guessing ---
+//
+// Writes (anything but GET/HEAD/OPTIONS on /api/ and /xmlrpc) draw from a bucket of
+// MALT_RATE_BURST tokens (default 20) refilled at MALT_RATE_LIMIT per minute (default 60);
+// MALT_RATE_LIMIT=0 turns it off. Separately, an IP that sends MALT_AUTH_MAX_FAILURES wrong
+// keys in a row (default 5) is locked out for a minute, doubling with each further miss up
+// to an hour. A right key clears its record. Both are in memory, so per instance, and reset
+// on restart.
+// IPs are the connection's, so behind a proxy everyone shares one bucket.
+
+type bucket struct {
+	tokens float64
+	seen   time.Time
+}
+
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // Tokens per second
+	burst   float64
+	buckets map[string]*bucket
+}
+
+// allow takes a token for ip, or says how long until there is one.
+func (l *rateLimiter) allow(ip string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		if len(l.buckets) >= 10000 {
+			l.sweep(now)
+		}
+		b = &bucket{tokens: l.burst, seen: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.seen).Seconds()*l.rate)
+	b.seen = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep forgets buckets that have refilled anyway.
+func (l *rateLimiter) sweep(now time.Time) {
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for ip, b := range l.buckets {
+		if now.Sub(b.seen) > full {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+// rateLimit wraps the site in the write limiter, when there is one.
+func rateLimit(next http.Handler) http.Handler {
+	perMinute := envInt("MALT_RATE_LIMIT", 60)
+	if perMinute <= 0 {
+		return next
+	}
+	limiter := &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(max(1, envInt("MALT_RATE_BURST", 20))),
+		buckets: map[string]*bucket{},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		write := r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions
+		if write && (strings.HasPrefix(r.URL.Path, "/api/") || strings.HasPrefix(r.URL.Path, "/xmlrpc")) {
+			if ok, wait := limiter.allow(clientIP(r), time.Now()); !ok {
+				tooMany(w, r, wait, "Slow down")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func tooMany(w http.ResponseWriter, r *http.Request, wait time.Duration, msg string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	httpError(w, r, msg, http.StatusTooManyRequests)
+}
+
+type authFailures struct {
+	count int
+	until time.Time // Locked out until then
+}
+
+var (
+	authMu       sync.Mutex
+	authFailedBy = map[string]*authFailures{}
+)
+
+// checkKey is keyScope with the lockout around it: it returns the key's scope, or how long
+// the caller's IP has to wait before it may try again.
+func checkKey(r *http.Request, key string) (scope string, wait time.Duration) {
+	if key == "" {
+		return "", 0
+	}
+	ip, now := clientIP(r), time.Now()
+
+	authMu.Lock()
+	if f := authFailedBy[ip]; f != nil && now.Before(f.until) {
+		authMu.Unlock()
+		return "", f.until.Sub(now)
+	}
+	authMu.Unlock()
+
+	scope = keyScope(key)
+
+	authMu.Lock()
+	if scope != "" {
+		delete(authFailedBy, ip)
+		authMu.Unlock()
+		return scope, 0
+	}
+	f := authFailedBy[ip]
+	if f == nil {
+		if len(authFailedBy) >= 10000 {
+			for ip, f := range authFailedBy {
+				if now.Sub(f.until) > time.Hour {
+					delete(authFailedBy, ip)
+				}
+			}
+		}
+		f = &authFailures{}
+		authFailedBy[ip] = f
+	}
+	f.count++
+	count := f.count
+	over := count - envInt("MALT_AUTH_MAX_FAILURES", 5)
+	if over >= 0 {
+		f.until = now.Add(min(time.Minute<<min(over, 6), time.Hour))
+	}
+	authMu.Unlock()
+
+	if over == 0 {
+		audit(r, "auth.lockout", ip, strconv.Itoa(count)+" wrong keys in a row")
+	}
+	return "", 0
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"unicode"
+)
+
+// --- Reading stats: word count, reading time and an excerpt, worked out when a post is written ---
+//
+// The list endpoint leaves Content out to stay small, so what a client would want it for is
+// stored next to the rendered body instead, and refreshed whenever that is. Words are
+// counted in the rendered text, so Markdown syntax and tags aren't words. Chinese and
+// Japanese don't put spaces between words; there each character counts as one.
+
+const (
+	wordsPerMinute = 200
+	excerptLength  = 280 // Characters, before the ellipsis
+)
+
+// ReadingStats are the numbers stored with a post's rendered body.
+type ReadingStats struct {
+	Words   int
+	Minutes int // Rounded up; 0 only for posts without words
+	Excerpt string
+}
+
+// readingStats measures a rendered body.
+func readingStats(body string) ReadingStats {
+	text := plainText(body)
+	s := ReadingStats{Words: countWords(text), Excerpt: makeExcerpt(text)}
+	s.Minutes = (s.Words + wordsPerMinute - 1) / wordsPerMinute
+	return s
+}
+
+// countWords counts the words in plain text.
+func countWords(text string) int {
+	n := 0
+	for _, field := range strings.Fields(text) {
+		word := false
+		for _, r := range field {
+			switch {
+			case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana):
+				n++
+			case unicode.IsLetter(r) || unicode.IsDigit(r):
+				word = true
+			}
+		}
+		if word {
+			n++
+		}
+	}
+	return n
+}
+
+// makeExcerpt shortens text to excerptLength, at a space when there's one in the second half.
+func makeExcerpt(text string) string {
+	r := []rune(text)
+	if len(r) <= excerptLength {
+		return text
+	}
+	cut := string(r[:excerptLength])
+	if i := strings.LastIndexByte(cut, ' '); i > len(cut)/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,;:-–—") + "…"
+}
+
+// initReadingStats measures posts written before the stats were stored.
+func initReadingStats() {
+	rows, err := db.Query("SELECT slug, content, content_format, raw_html FROM posts WHERE word_count IS NULL")
+	if err != nil {
+		log.Fatal(err)
+	}
+	var posts []Post
+	for rows.Next() {
+		var p Post
+		if rows.Scan(&p.Slug, &p.Content, &p.ContentFormat, &p.RawHTML) == nil {
+			posts = append(posts, p)
+		}
+	}
+	rows.Close()
+
+	for _, p := range posts {
+		if err := storeRendered(p); err != nil {
+			log.Printf("reading stats %s: %v", p.Slug, err)
+		}
+	}
+}
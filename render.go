@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	goldhtml "github.com/yuin/goldmark/renderer/html"
+)
+
+// --- Rendering: whatever the author wrote in, readers get HTML ---
+
+// The formats Content can be written in. HTML is passed through as-is, which is
+// what every post was before content_format existed.
+const (
+	FormatHTML     = "html"
+	FormatMarkdown = "markdown"
+	FormatAsciiDoc = "asciidoc"
+	FormatOrg      = "org"
+)
+
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.Table, extension.Strikethrough, extension.TaskList, extension.Footnote),
+	// Markdown posts may embed HTML, same as HTML posts always could
+	goldmark.WithRendererOptions(goldhtml.WithUnsafe()),
+)
+
+func validFormat(format string) bool {
+	switch format {
+	case FormatHTML, FormatMarkdown, FormatAsciiDoc, FormatOrg:
+		return true
+	}
+	return false
+}
+
+// renderContent turns a post body into HTML according to its format.
+func renderContent(format, src string) (string, error) {
+	switch format {
+	case FormatHTML, "":
+		return src, nil
+	case FormatMarkdown:
+		var buf bytes.Buffer
+		if err := markdown.Convert([]byte(src), &buf); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	case FormatAsciiDoc:
+		return renderAsciiDoc(src), nil
+	case FormatOrg:
+		return renderOrg(src), nil
+	}
+	return "", fmt.Errorf("unknown content format %q", format)
+}
+
+// --- Shared pieces for the hand-rolled AsciiDoc and Org renderers ---
+
+// listItem is one bullet; renderList nests them by Level (1 = top).
+type listItem struct {
+	Level   int
+	Ordered bool
+	HTML    string
+}
+
+func renderList(items []listItem) string {
+	var b strings.Builder
+	var open []bool // ordered-ness of each open list, outermost first
+	tag := func(ordered bool) string {
+		if ordered {
+			return "ol"
+		}
+		return "ul"
+	}
+
+	for _, it := range items {
+		// Never skip a level: a "***" under a "*" is just one deeper
+		level := min(max(it.Level, 1), len(open)+1)
+
+		for len(open) > level {
+			b.WriteString("</li></" + tag(open[len(open)-1]) + ">")
+			open = open[:len(open)-1]
+		}
+
+		switch {
+		case len(open) < level:
+			b.WriteString("<" + tag(it.Ordered) + ">")
+			open = append(open, it.Ordered)
+		case open[len(open)-1] != it.Ordered:
+			b.WriteString("</li></" + tag(open[len(open)-1]) + "><" + tag(it.Ordered) + ">")
+			open[len(open)-1] = it.Ordered
+		default:
+			b.WriteString("</li>")
+		}
+		b.WriteString("<li>" + it.HTML)
+	}
+
+	for len(open) > 0 {
+		b.WriteString("</li></" + tag(open[len(open)-1]) + ">")
+		open = open[:len(open)-1]
+	}
+	return b.String()
+}
+
+// safeHref keeps links to the web, mail and this site; anything else (javascript: and
+// friends) becomes "#".
+func safeHref(u string) string {
+	u = strings.TrimSpace(u)
+	lower := strings.ToLower(u)
+	for _, scheme := range []string{"http://", "https://", "mailto:", "/", "#", "./", "../"} {
+		if strings.HasPrefix(lower, scheme) {
+			return u
+		}
+	}
+	if !strings.Contains(lower, ":") {
+		return u // relative
+	}
+	return "#"
+}
+
+func isImagePath(u string) bool {
+	lower := strings.ToLower(u)
+	for _, ext := range []string{".png", ".jpg", ".jpeg", ".gif", ".webp", ".svg", ".avif"} {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// inlineShelf parks generated HTML behind placeholders so later emphasis passes can't
+// mangle it (think "/" inside an href vs. Org's /italic/). Placeholders are NUL-delimited,
+// so text has to go through unshelvable before anything is parked.
+type inlineShelf []string
+
+var shelfToken = regexp.MustCompile("\x00(\\d+)\x00")
+
+func (s *inlineShelf) park(fragment string) string {
+	*s = append(*s, fragment)
+	return "\x00" + strconv.Itoa(len(*s)-1) + "\x00"
+}
+
+// unshelvable replaces NUL the way CommonMark does, so text can't pose as a placeholder.
+func unshelvable(text string) string {
+	return strings.ReplaceAll(text, "\x00", "\uFFFD")
+}
+
+func (s inlineShelf) restore(text string) string {
+	// Parked fragments can contain placeholders themselves (a link around code), so repeat,
+	// but no deeper than there are fragments. Tokens that aren't ours stay as they are.
+	for range len(s) {
+		if !shelfToken.MatchString(text) {
+			break
+		}
+		text = shelfToken.ReplaceAllStringFunc(text, func(tok string) string {
+			i, err := strconv.Atoi(tok[1 : len(tok)-1])
+			if err != nil || i >= len(s) {
+				return tok
+			}
+			return s[i]
+		})
+	}
+	return text
+}
+
+// emphasis wraps text between a pair of marker characters in tag. Markers only count at
+// word boundaries, so snake_case and 2*3*4 survive.
+func emphasis(marker, tag string) func(string) string {
+	m := regexp.QuoteMeta(marker)
+	re := regexp.MustCompile(`(^|[\s(\["'>])` + m + `([^\s` + m + `](?:[^` + m + `]*[^\s` + m + `])?)` + m + `($|[\s)\]"'.,;:!?<-])`)
+	return func(s string) string {
+		// Two passes: adjacent spans share a boundary character
+		for range 2 {
+			s = re.ReplaceAllString(s, "$1<"+tag+">$2</"+tag+">$3")
+		}
+		return s
+	}
+}
+
+// paragraph collects consecutive lines until a blank line or a block ends it.
+type paragraph []string
+
+func (p *paragraph) flush(b *strings.Builder, inline func(string) string) {
+	if len(*p) == 0 {
+		return
+	}
+	b.WriteString("<p>" + inline(strings.Join(*p, " ")) + "</p>\n")
+	*p = nil
+}
+
+func codeBlock(lang string, lines []string) string {
+	class := ""
+	if lang != "" {
+		class = ` class="language-` + html.EscapeString(lang) + `"`
+	}
+	return "<pre><code" + class + ">" + html.EscapeString(strings.Join(lines, "\n")) + "</code></pre>\n"
+}
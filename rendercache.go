@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+)
+
+// --- Render cache: posts are rendered when written, not every time they're read ---
+//
+// posts.content_html holds the rendered, text-passed and sanitized body. The link policy
+// depends on the host a request came in on, so it's applied on the way out. The cache is
+// keyed by the settings that shape the output: change one and each post re-renders on its
+// next read.
+
+// renderKey fingerprints the settings renderBody depends on.
+func renderKey() string {
+	return fmt.Sprintf("sanitize=%t raw=%t autolink=%t typography=%t emoji=%t:%s",
+		sanitizeEnabled(), rawHTMLMode() != "off", envBool("MALT_AUTOLINK", false), envBool("MALT_TYPOGRAPHY", false),
+		envBool("MALT_EMOJI_SHORTCODES", true), os.Getenv("MALT_EMOJI"))
+}
+
+// renderBody is p.Content as HTML: rendered from its format, run through the text passes
+// and sanitized unless trusted.
+func renderBody(p Post) (string, error) {
+	body, err := renderContent(p.ContentFormat, p.Content)
+	if err != nil {
+		return "", err
+	}
+	body = applyTextPasses(body)
+	if (!p.RawHTML || rawHTMLMode() == "off") && sanitizeEnabled() {
+		body = sanitizeHTML(body)
+	}
+	return body, nil
+}
+
+// withRendered is p ready for the Store: its body rendered into ContentHTML, with the
+// reading stats measured from it.
+func withRendered(p Post) (Post, error) {
+	body, err := renderBody(p)
+	if err != nil {
+		return p, err
+	}
+	stats := readingStats(body)
+	p.ContentHTML, p.WordCount, p.ReadingMinutes, p.Excerpt = body, stats.Words, stats.Minutes, stats.Excerpt
+	return p, nil
+}
+
+// storeRendered refreshes the cached body of a stored post, and the reading stats measured from it.
+func storeRendered(p Post) error {
+	body, err := renderBody(p)
+	if err != nil {
+		return err
+	}
+	stats := readingStats(body)
+	_, err = execWrite("UPDATE posts SET content_html = ?, content_html_key = ?, word_count = ?, reading_minutes = ?, excerpt = ? WHERE slug = ?",
+		body, renderKey(), stats.Words, stats.Minutes, stats.Excerpt, p.Slug)
+	return err
+}
+
+// renderPostHTML is what readers get for p.Content: the cached body, re-rendered if the
+// settings moved on since, with the link policy applied.
+func renderPostHTML(p Post, site string) (string, error) {
+	var cached sql.NullString
+	var key string
+	db.QueryRow("SELECT content_html, content_html_key FROM posts WHERE slug = ?", p.Slug).Scan(&cached, &key)
+
+	body, err := cachedBody(p, cached, key)
+	if err != nil {
+		return "", err
+	}
+	return applyLinkPolicy(body, site), nil
+}
+
+// cachedBody is p's body from the cache columns a caller already read, rendered and stored
+// again when they're empty or stale.
+func cachedBody(p Post, cached sql.NullString, key string) (string, error) {
+	if cached.Valid && key == renderKey() {
+		return cached.String, nil
+	}
+	body, err := renderBody(p)
+	if err != nil {
+		return "", err
+	}
+	if err := storeRendered(p); err != nil {
+		log.Printf("render cache %s: %v", p.Slug, err)
+	}
+	return body, nil
+}
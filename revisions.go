@@ -0,0 +1,164 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// --- Revisions: every version of a post's text, so an accidental PUT isn't the end of it ---
+//
+// A revision is written after each change to the title, description or content, numbered
+// per post from 1. Posts from before revisions existed get their current text as rev 1.
+
+// Revision is one saved version of a post.
+type Revision struct {
+	Rev           int       `json:"rev"`
+	Action        string    `json:"action"` // What wrote it: "publish", "update", "revert", ...
+	Title         string    `json:"title"`
+	Description   string    `json:"description"`
+	Content       string    `json:"content"`
+	ContentFormat string    `json:"content_format"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// initRevisions gives every post without history a baseline to go back to.
+func initRevisions() {
+	rows, err := db.Query("SELECT slug FROM posts WHERE slug NOT IN (SELECT post_slug FROM post_revisions)")
+	if err != nil {
+		log.Fatal(err)
+	}
+	var slugs []string
+	for rows.Next() {
+		var slug string
+		if rows.Scan(&slug) == nil {
+			slugs = append(slugs, slug)
+		}
+	}
+	rows.Close()
+
+	for _, slug := range slugs {
+		recordRevision(writer, slug, "baseline")
+	}
+}
+
+// recordRevision snapshots a post's current text, unless it matches the latest revision.
+// A failure is logged, and returned for transactions that should roll back over it; on its
+// own it never fails the request that caused it.
+func recordRevision(q querier, slug, action string) error {
+	var cur, last Revision
+	err := q.QueryRow("SELECT title, description, content, content_format FROM posts WHERE slug = ?", slug).
+		Scan(&cur.Title, &cur.Description, &cur.Content, &cur.ContentFormat)
+	if err != nil {
+		return nil
+	}
+
+	err = q.QueryRow(`
+		SELECT rev, title, description, content, content_format FROM post_revisions
+		WHERE post_slug = ? ORDER BY rev DESC LIMIT 1`, slug).
+		Scan(&last.Rev, &last.Title, &last.Description, &last.Content, &last.ContentFormat)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("revisions %s: %v", slug, err)
+		return err
+	}
+	if err == nil && cur.Title == last.Title && cur.Description == last.Description &&
+		cur.Content == last.Content && cur.ContentFormat == last.ContentFormat {
+		return nil
+	}
+
+	_, err = q.Exec(`
+		INSERT INTO post_revisions (post_slug, rev, action, title, description, content, content_format, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		slug, last.Rev+1, action, cur.Title, cur.Description, cur.Content, cur.ContentFormat, time.Now().UTC())
+	if err != nil {
+		log.Printf("revisions %s: %v", slug, err)
+	}
+	return err
+}
+
+// GET /api/posts/{slug}/revisions - Every version, newest first
+func handleListRevisions(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT rev, action, title, description, content, content_format, created_at FROM post_revisions
+		WHERE post_slug = ? ORDER BY rev DESC`, r.PathValue("slug"))
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	revisions := []Revision{}
+	for rows.Next() {
+		var rev Revision
+		if err := rows.Scan(&rev.Rev, &rev.Action, &rev.Title, &rev.Description, &rev.Content, &rev.ContentFormat, &rev.CreatedAt); err != nil {
+			continue
+		}
+		revisions = append(revisions, rev)
+	}
+	if len(revisions) == 0 {
+		httpError(w, r, "Post not found", 404)
+		return
+	}
+
+	jsonResponse(w, revisions)
+}
+
+// POST /api/posts/{slug}/revert/{rev} - Put an old version's text back. The revert is itself a
+// new revision, so it can be undone the same way.
+func handleRevertPost(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	slug := r.PathValue("slug")
+	n, err := strconv.Atoi(r.PathValue("rev"))
+	if err != nil {
+		httpError(w, r, "Revision not found", 404)
+		return
+	}
+
+	var rev Revision
+	err = db.QueryRow(`
+		SELECT title, description, content, content_format FROM post_revisions
+		WHERE post_slug = ? AND rev = ?`, slug, n).
+		Scan(&rev.Title, &rev.Description, &rev.Content, &rev.ContentFormat)
+	if err != nil {
+		httpError(w, r, "Revision not found", 404)
+		return
+	}
+
+	// What was valid then may not be now (a shorter title limit, a format since dropped)
+	p, err := store.GetPost(slug)
+	if err == sql.ErrNoRows {
+		httpError(w, r, "Post not found", 404)
+		return
+	}
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	p.Title, p.Description, p.Content, p.ContentFormat = rev.Title, rev.Description, rev.Content, rev.ContentFormat
+	if fields := validatePost(p); len(fields) > 0 {
+		validationFailed(w, r, fields)
+		return
+	}
+
+	wasLive := isLive(p)
+	if p, err = withRendered(p); err != nil {
+		httpError(w, r, "Could not render content: "+err.Error(), 400)
+		return
+	}
+	if err := store.UpsertPost(r.Context(), &p, "revert"); err != nil {
+		httpError(w, r, "Database error: "+err.Error(), 500)
+		return
+	}
+	postChanged(r, slug, wasLive)
+
+	jsonResponse(w, map[string]any{"status": "reverted", "slug": slug, "rev": n})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// --- Sanitizer: post bodies are rendered through an allowlist unless marked raw_html ---
+//
+// On by default; MALT_SANITIZE=0 turns it off site-wide. Posts that genuinely need an
+// embed or a script set raw_html, which is recorded in the audit log. Who may set it is
+// MALT_RAW_HTML: "admin" (the default) trusts admin keys only, so a leaked publish key can't
+// put a script in front of every reader; "publish" trusts every key that can publish;
+// "off" trusts nobody and sanitizes raw_html posts too.
+
+// Elements whose content goes too, not just the tags.
+var sanitizeDrop = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"noscript": true, "template": true, "form": true, "textarea": true, "select": true,
+}
+
+var sanitizeTags = map[string]bool{
+	"a": true, "abbr": true, "aside": true, "b": true, "blockquote": true, "br": true, "caption": true,
+	"cite": true, "code": true, "dd": true, "del": true, "details": true, "div": true, "dl": true,
+	"dt": true, "em": true, "figcaption": true, "figure": true, "h1": true, "h2": true, "h3": true,
+	"h4": true, "h5": true, "h6": true, "hr": true, "i": true, "img": true, "input": true, "ins": true,
+	"kbd": true, "li": true, "mark": true, "ol": true, "p": true, "pre": true, "q": true, "s": true,
+	"section": true, "small": true, "span": true, "strong": true, "sub": true, "summary": true,
+	"sup": true, "table": true, "tbody": true, "td": true, "tfoot": true, "th": true, "thead": true,
+	"tr": true, "u": true, "ul": true, "video": true, "audio": true, "source": true,
+}
+
+var sanitizeAttrs = map[string]bool{
+	"href": true, "src": true, "alt": true, "title": true, "class": true, "id": true,
+	"width": true, "height": true, "colspan": true, "rowspan": true, "align": true,
+	"start": true, "reversed": true, "open": true, "controls": true, "poster": true,
+	"type": true, "checked": true, "disabled": true, "role": true, "cite": true, "datetime": true,
+}
+
+func sanitizeEnabled() bool {
+	return envBool("MALT_SANITIZE", true)
+}
+
+func rawHTMLMode() string {
+	switch mode := strings.ToLower(os.Getenv("MALT_RAW_HTML")); mode {
+	case "publish", "off":
+		return mode
+	}
+	return scopeAdmin
+}
+
+// rawHTMLAllowed reports whether r's key may store a post the sanitizer skips. savePost
+// only runs for requests that may publish, so "publish" needs no check of its own.
+func rawHTMLAllowed(r *http.Request) bool {
+	switch rawHTMLMode() {
+	case "off":
+		return false
+	case "publish":
+		return true
+	}
+	return hasScope(r, scopeAdmin)
+}
+
+// sanitizeHTML keeps allowlisted tags and attributes and drops everything else. Links and
+// sources must be web, mail or site-relative; inputs survive only as task-list checkboxes.
+func sanitizeHTML(src string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(src))
+	dropping := 0 // Depth inside a dropped element
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return b.String()
+
+		case html.TextToken:
+			if dropping == 0 {
+				b.WriteString(html.EscapeString(string(z.Text())))
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			if sanitizeDrop[t.Data] {
+				if tt == html.StartTagToken {
+					dropping++
+				}
+				continue
+			}
+			if dropping > 0 || !sanitizeTags[t.Data] {
+				continue
+			}
+			if t.Data == "input" && !isCheckbox(t) {
+				continue
+			}
+
+			b.WriteString("<" + t.Data)
+			for _, a := range t.Attr {
+				key := strings.ToLower(a.Key)
+				if !sanitizeAttrs[key] || a.Namespace != "" {
+					continue
+				}
+				val := a.Val
+				if key == "href" || key == "src" || key == "poster" || key == "cite" {
+					val = safeHref(val)
+				}
+				b.WriteString(" " + key + `="` + html.EscapeString(val) + `"`)
+			}
+			b.WriteString(">")
+
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			if sanitizeDrop[tag] {
+				if dropping > 0 {
+					dropping--
+				}
+				continue
+			}
+			if dropping == 0 && sanitizeTags[tag] && tag != "input" {
+				b.WriteString("</" + tag + ">")
+			}
+		}
+	}
+}
+
+func isCheckbox(t html.Token) bool {
+	for _, a := range t.Attr {
+		if a.Key == "type" {
+			return strings.EqualFold(a.Val, "checkbox")
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testServer points cfg at a fresh database under t.TempDir, with "admin" as MALT_SECRET.
+func testServer(t *testing.T) {
+	t.Helper()
+	cfg.DSN = filepath.Join(t.TempDir(), "malt.db")
+	cfg.Secret = "admin"
+	initDB()
+	t.Cleanup(func() {
+		db.Close()
+		writer.Close()
+	})
+}
+
+// call runs handler on a request with key as X-MALT-KEY and slug as the {slug} path value.
+func call(handler http.HandlerFunc, method, slug, key, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/api/posts/"+slug, strings.NewReader(body))
+	r.Header.Set("X-MALT-KEY", key)
+	r.SetPathValue("slug", slug)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// A publish key mustn't get content past the sanitizer by editing a post an admin marked raw_html.
+func TestRawHTMLEditNeedsAdmin(t *testing.T) {
+	testServer(t)
+	t.Setenv("MALT_RAW_HTML", "")
+
+	w := call(handleCreateKey, "POST", "", "admin", `{"name": "ci", "scope": "publish"}`)
+	var key APIKey
+	if err := json.NewDecoder(w.Body).Decode(&key); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("creating a publish key: %d %v", w.Code, err)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("new key sent as %q", ct)
+	}
+	const trusted = `<p>Embed</p><script>track()</script>`
+	w = call(handlePublish, "POST", "", "admin", `{"slug": "raw", "title": "Raw", "content": "`+trusted+`", "raw_html": true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("admin publishing a raw_html post: %d %s", w.Code, w.Body)
+	}
+
+	edits := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		body    string
+	}{
+		{"PUT", handleUpdatePost, "PUT", `{"title": "Raw", "content": "<script>steal()</script>"}`},
+		{"PATCH", handlePatchPost, "PATCH", `{"content": "<script>steal()</script>"}`},
+	}
+	for _, e := range edits {
+		t.Run(e.name, func(t *testing.T) {
+			if w := call(e.handler, e.method, "raw", key.Key, e.body); w.Code != http.StatusForbidden {
+				t.Errorf("publish key: got %d, want 403: %s", w.Code, w.Body)
+			}
+			if p, err := store.GetPost("raw"); err != nil || p.Content != trusted {
+				t.Errorf("content after a refused edit: %q, %v", p.Content, err)
+			}
+			if w := call(e.handler, e.method, "raw", "admin", e.body); w.Code != http.StatusOK {
+				t.Errorf("admin key: got %d, want 200: %s", w.Code, w.Body)
+			}
+			call(handlePatchPost, "PATCH", "raw", "admin", `{"content": "`+trusted+`"}`)
+		})
+	}
+
+	// Giving up the trust is allowed, and then the publish key's content is sanitized
+	w = call(handlePatchPost, "PATCH", "raw", key.Key, `{"raw_html": false, "content": "<p>Plain</p><script>steal()</script>"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("publish key dropping raw_html: %d %s", w.Code, w.Body)
+	}
+	p, err := store.GetPost("raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, err := renderPostHTML(p, "example.com"); err != nil || strings.Contains(body, "<script") {
+		t.Errorf("sanitized body: %q, %v", body, err)
+	}
+}
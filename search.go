@@ -0,0 +1,144 @@
+package main
+
+import (
+	"database/sql/driver"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// --- Search: an FTS5 index over posts, kept in sync by triggers ---
+//
+// The index holds plain text (plain_text() strips markup on the way in), so snippets read
+// like prose. Its rowids are the posts' rowids. On Postgres the index is a tsvector column
+// Postgres keeps itself, with the same weights and no stemming, but accents count there.
+
+func init() {
+	// Triggers call this on every write to posts
+	err := sqlite.RegisterDeterministicScalarFunction("plain_text", 1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		s, _ := args[0].(string)
+		return plainText(s), nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// SearchResult is one hit, best first. Snippet is HTML: escaped text with <mark>ed matches.
+type SearchResult struct {
+	Slug        string    `json:"slug"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	PublishedAt time.Time `json:"published_at"`
+	Snippet     string    `json:"snippet"`
+}
+
+// searchSchema is the index and the triggers that keep it in sync; part of migrateBaseline.
+const searchSchema = `
+	CREATE VIRTUAL TABLE IF NOT EXISTS posts_fts USING fts5(title, description, content, tokenize = 'unicode61 remove_diacritics 2');
+
+	CREATE TRIGGER IF NOT EXISTS posts_fts_insert AFTER INSERT ON posts BEGIN
+		INSERT INTO posts_fts (rowid, title, description, content) VALUES (new.rowid, new.title, new.description, plain_text(new.content));
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS posts_fts_update AFTER UPDATE OF title, description, content ON posts BEGIN
+		DELETE FROM posts_fts WHERE rowid = old.rowid;
+		INSERT INTO posts_fts (rowid, title, description, content) VALUES (new.rowid, new.title, new.description, plain_text(new.content));
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS posts_fts_delete AFTER DELETE ON posts BEGIN
+		DELETE FROM posts_fts WHERE rowid = old.rowid;
+	END;`
+
+// postgresSearchSchema is the index on Postgres, part of its step 6. A regular expression
+// stands in for plain_text, which is Go.
+const postgresSearchSchema = `
+	ALTER TABLE posts ADD COLUMN search tsvector GENERATED ALWAYS AS (
+		setweight(to_tsvector('simple', COALESCE(title, '')), 'A') ||
+		setweight(to_tsvector('simple', COALESCE(description, '')), 'B') ||
+		setweight(to_tsvector('simple', regexp_replace(COALESCE(content, ''), '<[^>]*>', ' ', 'g')), 'C')
+	) STORED;
+	CREATE INDEX idx_posts_search ON posts USING GIN (search);`
+
+// ftsQuery turns what a visitor typed into an FTS5 query: every word must appear, the last
+// one may be a prefix (search-as-you-type). Quoting keeps FTS syntax characters literal.
+func ftsQuery(q string) string {
+	words := strings.Fields(q)
+	for i, w := range words {
+		words[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"`
+	}
+	if len(words) > 0 {
+		words[len(words)-1] += "*"
+	}
+	return strings.Join(words, " ")
+}
+
+// tsQuery is ftsQuery for Postgres's to_tsquery.
+func tsQuery(q string) string {
+	words := strings.Fields(q)
+	for i, w := range words {
+		words[i] = "'" + strings.NewReplacer(`\`, `\\`, "'", "''").Replace(w) + "'"
+	}
+	if len(words) > 0 {
+		words[len(words)-1] += ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// GET /api/search?q=sqlite+wal&limit=20 - Published posts matching every word, best first
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	match := ftsQuery(r.URL.Query().Get("q"))
+	if match == "" {
+		httpError(w, r, "q is required", 400)
+		return
+	}
+	limit := 20
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= 100 {
+		limit = n
+	}
+
+	// Title hits count most, then the description, then the body
+	query, args := `
+		SELECT p.slug, p.title, p.description, p.published_at,
+			snippet(posts_fts, -1, char(2), char(3), '…', 16)
+		FROM posts_fts f JOIN posts p ON p.rowid = f.rowid
+		WHERE posts_fts MATCH ? AND p.status = 'published' AND p.deleted_at IS NULL AND p.published_at <= ?
+		ORDER BY bm25(posts_fts, 10.0, 5.0, 1.0)
+		LIMIT ?`, []any{match, time.Now().UTC(), limit}
+	if onPostgres {
+		// Weights are {D, C, B, A}: body, description, title
+		query, args = `
+			SELECT p.slug, p.title, p.description, p.published_at,
+				ts_headline('simple', regexp_replace(COALESCE(p.content, ''), '<[^>]*>', ' ', 'g'), q, ?)
+			FROM posts p, to_tsquery('simple', ?) q
+			WHERE p.search @@ q AND p.status = 'published' AND p.deleted_at IS NULL AND p.published_at <= ?
+			ORDER BY ts_rank('{0, 0.1, 0.5, 1}', p.search, q) DESC
+			LIMIT ?`, []any{"StartSel=\x02, StopSel=\x03, MaxWords=16, MinWords=8", tsQuery(r.URL.Query().Get("q")), time.Now().UTC(), limit}
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		httpError(w, r, "Search failed", 500)
+		return
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var res SearchResult
+		if err := rows.Scan(&res.Slug, &res.Title, &res.Description, &res.PublishedAt, &res.Snippet); err != nil {
+			continue
+		}
+		if onPostgres {
+			res.Snippet = html.UnescapeString(res.Snippet) // The entities plain_text would have decoded
+		}
+		res.Snippet = strings.NewReplacer("\x02", "<mark>", "\x03", "</mark>").Replace(html.EscapeString(res.Snippet))
+		results = append(results, res)
+	}
+
+	jsonResponse(w, results)
+}
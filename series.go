@@ -0,0 +1,291 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// --- Series: posts read in order, like the parts of a tutorial ---
+//
+// A post joins a series by its slug when it's published, at series_position; without one it
+// goes at the end, or keeps its place if it was already in. GET /api/posts/{slug} and the
+// post page then carry series_nav: which part this is, and the parts either side. Readers
+// only ever see parts that are out, so a scheduled part 3 doesn't leave a hole between 2
+// and 4. Deleting a series leaves its posts, on their own.
+
+// Series is an ordered group of posts.
+type Series struct {
+	Slug        string       `json:"slug"`
+	Title       string       `json:"title"`
+	Description string       `json:"description,omitempty"`
+	Count       int          `json:"count"`           // Parts that are out
+	Posts       []SeriesPart `json:"posts,omitempty"` // In order; GET /api/series/{slug} only
+}
+
+// SeriesPart is one post of a series.
+type SeriesPart struct {
+	Slug        string    `json:"slug"`
+	Title       string    `json:"title"`
+	Position    int       `json:"position"` // series_position as stored; gaps are fine
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// SeriesNav is where a post stands in its series.
+type SeriesNav struct {
+	Slug     string      `json:"slug"`
+	Title    string      `json:"title"`
+	Part     int         `json:"part"`  // 1-based, counting only parts that are out
+	Count    int         `json:"count"` // This post included, even as a draft
+	Previous *SeriesPart `json:"previous,omitempty"`
+	Next     *SeriesPart `json:"next,omitempty"`
+}
+
+func getSeries(slug string) (Series, error) {
+	var s Series
+	err := db.QueryRow("SELECT slug, title, description FROM series WHERE slug = ?", slug).
+		Scan(&s.Slug, &s.Title, &s.Description)
+	return s, err
+}
+
+// seriesExists is for validation: a post may only join a series that's there.
+func seriesExists(slug string) bool {
+	var ok bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM series WHERE slug = ?)", slug).Scan(&ok)
+	return ok
+}
+
+// seriesParts lists the parts of a series readers can see, in order.
+func seriesParts(slug string) ([]SeriesPart, error) {
+	rows, err := db.Query(`
+		SELECT p.slug, p.title, p.series_position, p.published_at FROM posts p JOIN series s ON s.id = p.series_id
+		WHERE s.slug = ? AND p.status = 'published' AND p.deleted_at IS NULL AND p.published_at <= ?
+		ORDER BY p.series_position, p.published_at, p.slug`, slug, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	parts := []SeriesPart{}
+	for rows.Next() {
+		var sp SeriesPart
+		if err := rows.Scan(&sp.Slug, &sp.Title, &sp.Position, &sp.PublishedAt); err != nil {
+			continue
+		}
+		parts = append(parts, sp)
+	}
+	return parts, rows.Err()
+}
+
+// seriesNav places p among the parts of its series. A post that isn't out yet is placed
+// where it will be, so a preview shows the right neighbours.
+func seriesNav(p Post) (*SeriesNav, error) {
+	s, err := getSeries(p.Series)
+	if err != nil {
+		return nil, err
+	}
+	parts, err := seriesParts(p.Series)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.ContainsFunc(parts, func(sp SeriesPart) bool { return sp.Slug == p.Slug }) {
+		parts = append(parts, SeriesPart{Slug: p.Slug, Title: p.Title, Position: p.SeriesPosition, PublishedAt: p.PublishedAt})
+		slices.SortStableFunc(parts, func(a, b SeriesPart) int {
+			if a.Position != b.Position {
+				return a.Position - b.Position
+			}
+			return a.PublishedAt.Compare(b.PublishedAt)
+		})
+	}
+
+	i := slices.IndexFunc(parts, func(sp SeriesPart) bool { return sp.Slug == p.Slug })
+	nav := &SeriesNav{Slug: s.Slug, Title: s.Title, Part: i + 1, Count: len(parts)}
+	if i > 0 {
+		nav.Previous = &parts[i-1]
+	}
+	if i < len(parts)-1 {
+		nav.Next = &parts[i+1]
+	}
+	return nav, nil
+}
+
+// seriesPosition is where a post without a series_position goes in series: where it
+// already is, or after the last part. Called inside the publish transaction so two posts
+// joining at once don't take the same place.
+func seriesPosition(tx *sql.Tx, post, series string) (int, error) {
+	var pos int
+	err := tx.QueryRow(`
+		SELECT COALESCE(
+			(SELECT p.series_position FROM posts p JOIN series s ON s.id = p.series_id WHERE p.slug = ? AND s.slug = ?),
+			(SELECT MAX(p.series_position) + 1 FROM posts p JOIN series s ON s.id = p.series_id WHERE s.slug = ?),
+			1)`, post, series, series).Scan(&pos)
+	return pos, err
+}
+
+// seriesByName finds a series for front matter, which names it by slug or by title (any case).
+// "" when there's no such series.
+func seriesByName(name string) string {
+	name = strings.TrimSpace(name)
+	var slug string
+	db.QueryRow("SELECT slug FROM series WHERE slug = ? OR lower(title) = lower(?) ORDER BY slug = ? DESC LIMIT 1",
+		strings.ToLower(name), name, strings.ToLower(name)).Scan(&slug)
+	return slug
+}
+
+// decodeSeries parses and validates a Series body. The slug comes from the title when it's missing.
+func decodeSeries(r *http.Request) (Series, string) {
+	var s Series
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		return s, "Bad JSON"
+	}
+
+	if s.Title = strings.TrimSpace(s.Title); s.Title == "" {
+		return s, "title is required"
+	}
+	s.Description = strings.TrimSpace(s.Description)
+	if s.Slug = strings.ToLower(strings.TrimSpace(s.Slug)); s.Slug == "" {
+		s.Slug = slugify(s.Title)
+	}
+	if !validSlug.MatchString(s.Slug) || len(s.Slug) > maxSlugLen {
+		return s, "slug must be letters and digits, separated by single hyphens or underscores"
+	}
+	return s, ""
+}
+
+// GET /api/series - Every series, with how many of its parts are out
+func handleListSeries(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query(`
+		SELECT s.slug, s.title, s.description, COUNT(p.slug) FROM series s
+		LEFT JOIN posts p ON p.series_id = s.id AND p.status = 'published' AND p.deleted_at IS NULL AND p.published_at <= ?
+		GROUP BY s.id ORDER BY s.title, s.slug`, time.Now().UTC())
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	series := []Series{}
+	for rows.Next() {
+		var s Series
+		if err := rows.Scan(&s.Slug, &s.Title, &s.Description, &s.Count); err != nil {
+			continue
+		}
+		series = append(series, s)
+	}
+
+	jsonResponse(w, series)
+}
+
+// GET /api/series/{slug} - A series and its parts, in order
+func handleGetSeries(w http.ResponseWriter, r *http.Request) {
+	s, err := getSeries(r.PathValue("slug"))
+	if err == sql.ErrNoRows {
+		httpError(w, r, "Series not found", 404)
+		return
+	}
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	if s.Posts, err = seriesParts(s.Slug); err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	s.Count = len(s.Posts)
+
+	jsonResponse(w, s)
+}
+
+// POST /api/series - Start a series: {"title": "...", "slug": "...", "description": "..."}
+func handleCreateSeries(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	s, problem := decodeSeries(r)
+	if problem != "" {
+		httpError(w, r, problem, 400)
+		return
+	}
+	if seriesExists(s.Slug) {
+		httpError(w, r, "A series with this slug already exists", 409)
+		return
+	}
+
+	_, err := execWrite("INSERT INTO series (slug, title, description, created_at) VALUES (?, ?, ?, ?)",
+		s.Slug, s.Title, s.Description, time.Now().UTC())
+	if err != nil {
+		httpError(w, r, "Failed to save: "+err.Error(), 500)
+		return
+	}
+	s.Posts = nil
+
+	jsonResponse(w, s)
+}
+
+// PUT /api/series/{slug} - Change a series' title and description; the slug stays
+func handleUpdateSeries(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	s, problem := decodeSeries(r)
+	if problem != "" {
+		httpError(w, r, problem, 400)
+		return
+	}
+	s.Slug, s.Posts = r.PathValue("slug"), nil
+
+	result, err := execWrite("UPDATE series SET title = ?, description = ? WHERE slug = ?", s.Title, s.Description, s.Slug)
+	if err != nil {
+		httpError(w, r, "Database error: "+err.Error(), 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		httpError(w, r, "Series not found", 404)
+		return
+	}
+
+	// Every part shows the series' title
+	execWrite("UPDATE posts SET updated_at = ? WHERE series_id = (SELECT id FROM series WHERE slug = ?)", time.Now().UTC(), s.Slug)
+
+	jsonResponse(w, s)
+}
+
+// DELETE /api/series/{slug} - Remove a series; its posts stay, on their own
+func handleDeleteSeries(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	slug := r.PathValue("slug")
+	found := false
+	err := withTx(r.Context(), func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			UPDATE posts SET series_id = NULL, series_position = NULL, updated_at = ?
+			WHERE series_id = (SELECT id FROM series WHERE slug = ?)`, time.Now().UTC(), slug)
+		if err != nil {
+			return err
+		}
+		result, err := tx.Exec("DELETE FROM series WHERE slug = ?", slug)
+		if err != nil {
+			return err
+		}
+		n, _ := result.RowsAffected()
+		found = n > 0
+		return nil
+	})
+	if err != nil {
+		httpError(w, r, "Database error: "+err.Error(), 500)
+		return
+	}
+	if !found {
+		httpError(w, r, "Series not found", 404)
+		return
+	}
+
+	jsonResponse(w, map[string]string{"status": "deleted", "slug": slug})
+}
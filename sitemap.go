@@ -0,0 +1,226 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// --- Sitemap: one file while the site is small, an index of numbered files once it isn't ---
+//
+// Every URL lists its images (link cards and inline <img>) per Google's image sitemap
+// extension. Drafts, scheduled and noindex posts are left out.
+
+const (
+	sitemapNS      = "http://www.sitemaps.org/schemas/sitemap/0.9"
+	imageSitemapNS = "http://www.google.com/schemas/sitemap-image/1.1"
+
+	sitemapMaxURLs   = 50000 // Per file, per the protocol
+	sitemapMaxImages = 1000  // Per URL
+)
+
+type sitemapURL struct {
+	Loc     string         `xml:"loc"`
+	LastMod string         `xml:"lastmod,omitempty"`
+	Images  []sitemapImage `xml:"image:image"`
+}
+
+type sitemapImage struct {
+	Loc string `xml:"image:loc"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	NS      string       `xml:"xmlns,attr"`
+	ImageNS string       `xml:"xmlns:image,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	NS       string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapRef `xml:"sitemap"`
+}
+
+type sitemapRef struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+func sitemapPageSize() int {
+	if n, err := strconv.Atoi(os.Getenv("MALT_SITEMAP_PAGE_SIZE")); err == nil && n > 0 && n < sitemapMaxURLs {
+		return n
+	}
+	return sitemapMaxURLs
+}
+
+// sitemapURLs lists every indexable URL, site pages first, then posts oldest first so
+// existing pages keep their contents as the site grows.
+func sitemapURLs(r *http.Request) ([]sitemapURL, error) {
+	base := baseURL(r)
+	urls := []sitemapURL{{Loc: base + "/"}, {Loc: base + "/links"}}
+	if envBool("MALT_PLANET_PAGE", false) && featureEnabled("planet") {
+		urls = append(urls, sitemapURL{Loc: base + "/planet"})
+	}
+
+	rows, err := db.Query(`
+		SELECT slug, published_at, updated_at, content, content_format, raw_html, link_image, content_html, content_html_key FROM posts
+		WHERE status = 'published' AND deleted_at IS NULL AND NOT noindex ORDER BY published_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	for rows.Next() {
+		var p Post
+		var cached sql.NullString
+		var key string
+		if err := rows.Scan(&p.Slug, &p.PublishedAt, &p.UpdatedAt, &p.Content, &p.ContentFormat, &p.RawHTML, &p.LinkImage, &cached, &key); err != nil {
+			continue
+		}
+		if p.PublishedAt.After(now) {
+			continue // Scheduled
+		}
+		body, _ := cachedBody(p, cached, key)
+
+		u := sitemapURL{Loc: base + "/post/" + url.PathEscape(p.Slug), LastMod: lastMod(p).Format(time.RFC3339)}
+		for _, img := range postImages(p, base, body) {
+			if len(u.Images) == sitemapMaxImages {
+				break
+			}
+			u.Images = append(u.Images, sitemapImage{Loc: img})
+		}
+		urls = append(urls, u)
+	}
+	return urls, rows.Err()
+}
+
+// lastMod is when a post last changed for readers. A scheduled post edited before it went
+// live still changed, as far as the web can tell, on its publish date.
+func lastMod(p Post) time.Time {
+	if p.UpdatedAt.Before(p.PublishedAt) {
+		return p.PublishedAt.UTC()
+	}
+	return p.UpdatedAt.UTC()
+}
+
+// postImages finds a post's link-card image and the images in its rendered body, as absolute URLs.
+func postImages(p Post, base, body string) []string {
+	var images []string
+	seen := map[string]bool{}
+	add := func(src string) {
+		ref, err := url.Parse(strings.TrimSpace(src))
+		if err != nil || src == "" || ref.Scheme == "data" {
+			return
+		}
+		root, _ := url.Parse(base + "/")
+		abs := root.ResolveReference(ref).String()
+		if !seen[abs] {
+			seen[abs] = true
+			images = append(images, abs)
+		}
+	}
+
+	if p.LinkImage != "" {
+		add(p.LinkImage)
+	}
+	z := html.NewTokenizer(strings.NewReader(body))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return images
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		if t := z.Token(); t.Data == "img" {
+			for _, a := range t.Attr {
+				if a.Key == "src" {
+					add(a.Val)
+				}
+			}
+		}
+	}
+}
+
+func writeXML(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(v)
+}
+
+// GET /sitemap.xml - The sitemap itself, or an index of /sitemaps/{n}.xml past 50,000 URLs
+func handleSitemap(w http.ResponseWriter, r *http.Request) {
+	urls, err := sitemapURLs(r)
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+
+	size := sitemapPageSize()
+	if len(urls) <= size {
+		writeXML(w, sitemapURLSet{NS: sitemapNS, ImageNS: imageSitemapNS, URLs: urls})
+		return
+	}
+
+	index := sitemapIndex{NS: sitemapNS}
+	for page := 1; (page-1)*size < len(urls); page++ {
+		chunk := urls[(page-1)*size : min(page*size, len(urls))]
+		entry := sitemapRef{Loc: baseURL(r) + "/sitemaps/" + strconv.Itoa(page) + ".xml"}
+		for _, u := range chunk {
+			entry.LastMod = max(entry.LastMod, u.LastMod)
+		}
+		index.Sitemaps = append(index.Sitemaps, entry)
+	}
+	writeXML(w, index)
+}
+
+// GET /sitemaps/{page} - One numbered file of the sitemap index, e.g. /sitemaps/2.xml
+func handleSitemapPage(w http.ResponseWriter, r *http.Request) {
+	page, err := strconv.Atoi(strings.TrimSuffix(r.PathValue("page"), ".xml"))
+	if err != nil || page < 1 {
+		httpError(w, r, "Nothing lives at this address.", 404)
+		return
+	}
+
+	urls, err := sitemapURLs(r)
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+
+	size := sitemapPageSize()
+	if (page-1)*size >= len(urls) {
+		httpError(w, r, "Nothing lives at this address.", 404)
+		return
+	}
+	writeXML(w, sitemapURLSet{NS: sitemapNS, ImageNS: imageSitemapNS, URLs: urls[(page-1)*size : min(page*size, len(urls))]})
+}
+
+// GET /robots.txt - MALT_ROBOTS_TXT is served as written; otherwise crawlers get everything but
+// the API, and the sitemap's address
+func handleRobotsTxt(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if path := os.Getenv("MALT_ROBOTS_TXT"); path != "" {
+		body, err := os.ReadFile(path)
+		if err != nil {
+			httpError(w, r, "Could not read robots.txt", 500)
+			return
+		}
+		w.Write(body)
+		return
+	}
+
+	fmt.Fprintf(w, "User-agent: *\nDisallow: /api/\n\nSitemap: %s/sitemap.xml\n", baseURL(r))
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// --- SSR: the SPA shell, filled in for crawlers and link unfurlers ---
+//
+// index.html stays the single source of the page. Its <title> and description are swapped
+// for the URL's own head tags, and #app gets a plain rendering of the content, which the
+// SPA replaces as soon as it boots.
+
+// pageMeta is everything a URL says about itself in <head>.
+type pageMeta struct {
+	Title       string
+	Description string
+	Canonical   string
+	Type        string // og:type: "website" or "article"
+	Image       string
+	Robots      string
+	SiteName    string
+	Published   string // RFC 3339, articles only
+	Modified    string
+	Author      string // Articles with a byline only
+	AuthorURL   string
+}
+
+const headTags = `<title>{{.Title}}</title>
+    <meta name="description" content="{{.Description}}">
+    {{- if .Author}}
+    <meta name="author" content="{{.Author}}">
+    {{- end}}
+    <link rel="canonical" href="{{.Canonical}}">
+    {{- if .Robots}}
+    <meta name="robots" content="{{.Robots}}">
+    {{- end}}
+    <meta property="og:site_name" content="{{.SiteName}}">
+    <meta property="og:type" content="{{.Type}}">
+    <meta property="og:title" content="{{.Title}}">
+    <meta property="og:description" content="{{.Description}}">
+    <meta property="og:url" content="{{.Canonical}}">
+    {{- if .Image}}
+    <meta property="og:image" content="{{.Image}}">
+    {{- end}}
+    {{- if .Published}}
+    <meta property="article:published_time" content="{{.Published}}">
+    <meta property="article:modified_time" content="{{.Modified}}">
+    {{- end}}
+    {{- if .AuthorURL}}
+    <meta property="article:author" content="{{.AuthorURL}}">
+    {{- end}}
+    <meta name="twitter:card" content="{{if .Image}}summary_large_image{{else}}summary{{end}}">
+    <meta name="twitter:title" content="{{.Title}}">
+    <meta name="twitter:description" content="{{.Description}}">
+    {{- if .Image}}
+    <meta name="twitter:image" content="{{.Image}}">
+    {{- end}}`
+
+const articleBody = `<article>
+                <header>
+                    <h1>{{.Title}}</h1>
+                    <time datetime="{{.PublishedAt.Format "2006-01-02T15:04:05Z07:00"}}">{{.PublishedAt.Format "January 2, 2006"}}</time>
+                    {{- if .ReadingMinutes}} · {{.ReadingMinutes}} min read{{end}}
+                    {{- with .Author}}
+                    <p class="byline">by {{if .URL}}<a href="{{.URL}}" rel="author">{{.Name}}</a>{{else}}{{.Name}}{{end}}</p>
+                    {{- end}}
+                </header>
+                {{- if eq .Type "link"}}
+                <a href="{{.LinkURL}}" class="link-card"><strong>{{or .LinkTitle .LinkURL}}</strong></a>
+                {{- end}}
+                <div class="content">{{.Body}}</div>
+                {{- with .SeriesNav}}
+                <nav class="series">
+                    <p>Part {{.Part}} of {{.Count}} of {{.Title}}</p>
+                    {{- with .Previous}}
+                    <a href="/post/{{.Slug}}" rel="prev" data-link>&larr; {{.Title}}</a>
+                    {{- end}}
+                    {{- with .Next}}
+                    <a href="/post/{{.Slug}}" rel="next" data-link>{{.Title}} &rarr;</a>
+                    {{- end}}
+                </nav>
+                {{- end}}
+            </article>`
+
+const homeBody = `{{range .}}
+                <a href="/post/{{.Slug}}" class="post-item" data-link>
+                    <span class="post-date">{{.PublishedAt.Format "January 2, 2006"}}</span>
+                    <h2 class="post-title">{{.Title}}</h2>
+                    <p class="post-desc">{{.Description}}</p>
+                </a>
+            {{- end}}`
+
+var (
+	headTmpl    = template.Must(template.New("head").Parse(headTags))
+	articleTmpl = template.Must(template.New("article").Parse(articleBody))
+	homeTmpl    = template.Must(template.New("home").Parse(homeBody))
+
+	shellHead = regexp.MustCompile(`(?s)<title>.*?</title>\s*<meta name="description"[^>]*>`)
+	shellApp  = regexp.MustCompile(`(?s)<div id="app">\s*</div>`)
+)
+
+// siteDescription describes the blog as a whole, for the homepage.
+func siteDescription() string {
+	if d := os.Getenv("MALT_SITE_DESCRIPTION"); d != "" {
+		return d
+	}
+	return "A minimal go blog."
+}
+
+// summarize falls back to the opening of the post's rendered body when it has no description.
+func summarize(p Post, body string) string {
+	if p.Description != "" {
+		return p.Description
+	}
+	return plainSummary(body)
+}
+
+// serveShell writes index.html with meta in <head> and body pre-rendered inside #app.
+func serveShell(w http.ResponseWriter, r *http.Request, meta pageMeta, body *template.Template, data any) {
+	shell, err := os.ReadFile(filepath.Join(cfg.StaticDir, "index.html"))
+	if err != nil {
+		httpError(w, r, "Could not read index.html", 500)
+		return
+	}
+
+	if isStaticExport(r) {
+		shell = shellScripts.ReplaceAll(shell, nil)
+	}
+
+	var head, app bytes.Buffer
+	if err := headTmpl.Execute(&head, meta); err != nil {
+		httpError(w, r, "Could not render page", 500)
+		return
+	}
+	if err := body.Execute(&app, data); err != nil {
+		httpError(w, r, "Could not render page", 500)
+		return
+	}
+
+	// Literal replacements: rendered posts are full of $ signs
+	shell = shellHead.ReplaceAllLiteral(shell, head.Bytes())
+	shell = shellApp.ReplaceAllLiteral(shell, append(append([]byte(`<div id="app">`), app.Bytes()...), "</div>"...))
+
+	modified, _ := time.Parse(time.RFC3339, meta.Modified)
+	if notModified(w, r, bodyETag(shell), modified) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(shell)
+}
+
+// GET / - The SPA shell, with the post list already in it
+func handleIndex(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query("SELECT slug, title, description, published_at FROM posts WHERE status = 'published' AND deleted_at IS NULL ORDER BY published_at DESC")
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	posts := []Post{}
+	for rows.Next() {
+		var p Post
+		if err := rows.Scan(&p.Slug, &p.Title, &p.Description, &p.PublishedAt); err != nil || p.PublishedAt.After(now) {
+			continue
+		}
+		posts = append(posts, p)
+	}
+
+	meta := pageMeta{
+		Title:       siteTitle(),
+		Description: siteDescription(),
+		Canonical:   baseURL(r) + "/",
+		Type:        "website",
+		SiteName:    siteTitle(),
+	}
+	serveShell(w, r, meta, homeTmpl, posts)
+}
+
+// GET /post/{slug} - The SPA shell, but only for posts that exist, with the post already in it
+func handlePostPage(w http.ResponseWriter, r *http.Request) {
+	p, err := store.GetPost(r.PathValue("slug"))
+	if err != nil && err != sql.ErrNoRows {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	if err == sql.ErrNoRows || !canView(w, r, p) {
+		httpError(w, r, "Post not found", 404)
+		return
+	}
+	if p.Series != "" {
+		p.SeriesNav, _ = seriesNav(p)
+	}
+	if robots := p.Robots(); robots != "" {
+		w.Header().Set("X-Robots-Tag", robots)
+	}
+
+	body, err := renderPostHTML(p, siteHost(r.Host))
+	if err != nil {
+		httpError(w, r, "Could not render post: "+err.Error(), 500)
+		return
+	}
+
+	base := baseURL(r)
+	meta := pageMeta{
+		Title:       p.Title + " | " + siteTitle(),
+		Description: summarize(p, body),
+		Canonical:   base + "/post/" + url.PathEscape(p.Slug),
+		Type:        "article",
+		Robots:      p.Robots(),
+		SiteName:    siteTitle(),
+		Published:   p.PublishedAt.UTC().Format(time.RFC3339),
+		Modified:    lastMod(p).Format(time.RFC3339),
+	}
+	if !isLive(p) {
+		meta.Robots = "noindex, nofollow"
+	}
+	if p.Author != nil {
+		meta.Author, meta.AuthorURL = p.Author.Name, p.Author.URL
+	}
+	if images := postImages(p, base, body); len(images) > 0 {
+		meta.Image = images[0]
+	}
+
+	serveShell(w, r, meta, articleTmpl, struct {
+		Post
+		Body template.HTML
+	}{p, template.HTML(body)})
+}
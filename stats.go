@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+type MonthCount struct {
+	Month string `json:"month"` // 2025-07
+	Posts int    `json:"posts"`
+}
+
+// Stats is the admin dashboard summary. Sections only appear once the data behind them exists.
+type Stats struct {
+	Posts      map[string]int `json:"posts"` // Count by status
+	TotalWords int            `json:"total_words"`
+	PerMonth   []MonthCount   `json:"per_month"`       // Newest month first
+	Tags       []TagCount     `json:"tags"`            // Most used first
+	Views      *ViewStats     `json:"views,omitempty"` // With the analytics feature on
+}
+
+var htmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// GET /api/stats?days=30 - Numbers for the admin dashboard; days is the window for views
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	days := 30
+	if d := r.URL.Query().Get("days"); d != "" {
+		n, err := strconv.Atoi(d)
+		if err != nil || n < 1 || n > 3660 {
+			httpError(w, r, "days must be 1 to 3660", 400)
+			return
+		}
+		days = n
+	}
+
+	rows, err := db.Query("SELECT COALESCE(word_count, 0), published_at, status FROM posts WHERE deleted_at IS NULL ORDER BY published_at DESC")
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	stats := Stats{Posts: map[string]int{"published": 0, "draft": 0}, PerMonth: []MonthCount{}}
+	for rows.Next() {
+		var p Post
+		if err := rows.Scan(&p.WordCount, &p.PublishedAt, &p.Status); err != nil {
+			continue
+		}
+
+		stats.Posts[p.Status]++
+		stats.TotalWords += p.WordCount
+		if p.Status != "published" {
+			continue
+		}
+
+		// Rows arrive newest first, so a new month is always appended at the end
+		month := p.PublishedAt.Format("2006-01")
+		if n := len(stats.PerMonth); n == 0 || stats.PerMonth[n-1].Month != month {
+			stats.PerMonth = append(stats.PerMonth, MonthCount{Month: month})
+		}
+		stats.PerMonth[len(stats.PerMonth)-1].Posts++
+	}
+
+	if stats.Tags, err = tagCounts(); err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	if featureEnabled("analytics") {
+		if stats.Views, err = viewStats(days); err != nil {
+			httpError(w, r, "Database error", 500)
+			return
+		}
+	}
+
+	jsonResponse(w, stats)
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Store: where posts live ---
+//
+// Posts are written through Store, each write in one transaction with the post's tags and
+// revision, and read back through it. Its implementation, sqlStore, runs the same SQL on
+// SQLite (malt.db, the default) and on Postgres (see postgres.go), and so does everything
+// built around posts (tags, revisions, media, planet, ...), which queries db directly.
+
+// Store keeps posts.
+type Store interface {
+	ListPosts(q PostQuery) (PostPage, error)
+	GetPost(slug string) (Post, error) // sql.ErrNoRows when there's no such post
+	// UpsertPost saves p whole, with its tags unless they're nil, and records a revision
+	// written by action. p.ContentHTML is the rendered body to cache (see withRendered).
+	// It stamps p.UpdatedAt, and numbers p in its series when it has no position there yet.
+	UpsertPost(ctx context.Context, p *Post, action string) error
+	// InsertPost is UpsertPost for a new post: if p.Slug is taken, p gets the next free slug
+	// for its title instead.
+	InsertPost(ctx context.Context, p *Post, action string) error
+	DeletePost(slug string) (deleted bool, err error) // Moves the post to the trash; GetPost no longer finds it
+	RestorePost(slug string) (restored bool, err error)
+	PurgePost(slug string) (purged bool, err error) // Deletes a trashed post for good, with everything kept under its slug
+}
+
+// PostQuery selects live posts for ListPosts (published, and not scheduled), newest first.
+type PostQuery struct {
+	Tag    string
+	Limit  int    // 0 for all of them
+	Cursor string // NextCursor of the previous page
+}
+
+// PostPage is one page of GET /api/posts, returned when ?limit= or ?cursor= is given.
+type PostPage struct {
+	Posts      []Post `json:"posts"`
+	Total      int    `json:"total"`                 // Across all pages, with the same filters
+	NextCursor string `json:"next_cursor,omitempty"` // Absent on the last page
+}
+
+var errBadCursor = errors.New("bad cursor")
+
+var store Store
+
+// writer is the one connection writes run on: transactions through withTx, single statements
+// through execWrite. SQLite has a single writer anyway; this way writes queue for it here,
+// each with a deadline, rather than in the busy handler. db is for reads. The exception is
+// backup's VACUUM INTO, which only reads this database. Postgres takes writes from many
+// connections at once, so there writer is db.
+var writer *sql.DB
+
+// txTimeout bounds a transaction or a single write, waiting for the writer included.
+const txTimeout = 10 * time.Second
+
+// querier is what *sql.DB and *sql.Tx share, for writes that run alone or as part of withTx.
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// execWrite runs one statement on the writer. Don't call it inside withTx: the writer is
+// taken until fn returns, so it would wait out txTimeout and fail.
+func execWrite(query string, args ...any) (sql.Result, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), txTimeout)
+	defer cancel()
+	return writer.ExecContext(ctx, query, args...)
+}
+
+// insertID runs an INSERT on the writer, as execWrite does, and returns the new row's id.
+// RETURNING stands in for LastInsertId, which Postgres doesn't have; sql.ErrNoRows means
+// nothing was inserted.
+func insertID(query string, args ...any) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), txTimeout)
+	defer cancel()
+	var id int64
+	err := writer.QueryRowContext(ctx, query+" RETURNING id", args...).Scan(&id)
+	return id, err
+}
+
+// withTx runs fn in a transaction on the writer and commits it if fn returns nil. fn must do
+// its writes through tx: the writer is busy until it returns, and the database locked (on
+// Postgres, writeLock taken).
+func withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(ctx, txTimeout)
+	defer cancel()
+
+	tx, err := writer.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if onPostgres {
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(?)", writeLock); err != nil {
+			return err
+		}
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// openStore connects to the configured database, default malt.db in the working directory.
+func openStore() {
+	dsn := cfg.dsn()
+	var err error
+	if onPostgres = isPostgresDSN(dsn); onPostgres {
+		if db, err = openPostgres(dsn); err != nil {
+			log.Fatal(err)
+		}
+		writer = db
+	} else {
+		if db, err = sql.Open("sqlite", dsn); err != nil {
+			log.Fatal(err)
+		}
+		if writer, err = sql.Open("sqlite", dsn); err != nil {
+			log.Fatal(err)
+		}
+		writer.SetMaxOpenConns(1)
+	}
+	store = timedStore{sqlStore{db}}
+}
+
+type sqlStore struct {
+	db *sql.DB
+}
+
+func (s sqlStore) ListPosts(q PostQuery) (PostPage, error) {
+	var page PostPage
+	where := " WHERE status = 'published' AND deleted_at IS NULL AND published_at <= ?"
+	args := []any{time.Now().UTC()}
+	if q.Tag != "" {
+		where += " AND slug IN (SELECT pt.post_slug FROM post_tags pt JOIN tags t ON t.id = pt.tag_id WHERE t.name = ?)"
+		args = append(args, strings.ToLower(q.Tag))
+	}
+
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM posts"+where, args...).Scan(&page.Total); err != nil {
+		return page, err
+	}
+
+	// Keyset pagination: the cursor is the sort key of the last post on the previous page,
+	// so posts published meanwhile don't shift later pages around
+	if q.Cursor != "" {
+		at, slug, ok := decodeCursor(q.Cursor)
+		if !ok {
+			return page, errBadCursor
+		}
+		where += " AND (published_at < ? OR (published_at = ? AND slug > ?))"
+		args = append(args, at, at, slug)
+	}
+
+	// One extra row says whether there is a next page
+	limit := ""
+	if q.Limit > 0 {
+		limit = " LIMIT ?"
+		args = append(args, q.Limit+1)
+	}
+	rows, err := s.db.Query("SELECT slug, title, description, published_at, CAST(published_at AS TEXT) AS sort_key, updated_at, type, link_url, COALESCE(author_id, 0), "+
+		"COALESCE(word_count, 0), COALESCE(reading_minutes, 0), COALESCE(excerpt, '') FROM posts"+
+		where+" ORDER BY published_at DESC, slug"+limit, args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var p Post
+		var key string
+		// Note: We don't fetch 'Content' here to keep the list payload tiny
+		if err := rows.Scan(&p.Slug, &p.Title, &p.Description, &p.PublishedAt, &key, &p.UpdatedAt, &p.Type, &p.LinkURL, &p.AuthorID,
+			&p.WordCount, &p.ReadingMinutes, &p.Excerpt); err != nil {
+			continue
+		}
+		page.Posts = append(page.Posts, p)
+		keys = append(keys, key)
+	}
+
+	if q.Limit > 0 && len(page.Posts) > q.Limit {
+		page.Posts = page.Posts[:q.Limit]
+		page.NextCursor = encodeCursor(keys[q.Limit-1], page.Posts[q.Limit-1].Slug)
+	}
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+	return page, attachAuthors(page.Posts)
+}
+
+// encodeCursor and decodeCursor wrap a post's sort key (published_at as stored, slug) in an
+// opaque token.
+func encodeCursor(publishedAt, slug string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(publishedAt + "\x00" + slug))
+}
+
+func decodeCursor(cursor string) (publishedAt, slug string, ok bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(raw), "\x00")
+}
+
+// GetPost reads every stored field of one post.
+func (s sqlStore) GetPost(slug string) (Post, error) {
+	var p Post
+	row := s.db.QueryRow(`
+		SELECT slug, title, description, content, published_at, type, link_url, link_title, link_description, link_image,
+			mastodon_status, bluesky_uri, content_format, raw_html, status, noindex, nofollow, updated_at, COALESCE(author_id, 0),
+			COALESCE((SELECT slug FROM series WHERE id = posts.series_id), ''), COALESCE(series_position, 0),
+			COALESCE(word_count, 0), COALESCE(reading_minutes, 0), COALESCE(excerpt, '')
+		FROM posts WHERE slug = ? AND deleted_at IS NULL`, slug)
+	err := row.Scan(&p.Slug, &p.Title, &p.Description, &p.Content, &p.PublishedAt,
+		&p.Type, &p.LinkURL, &p.LinkTitle, &p.LinkDescription, &p.LinkImage,
+		&p.MastodonStatus, &p.BlueskyURI, &p.ContentFormat, &p.RawHTML, &p.Status, &p.NoIndex, &p.NoFollow, &p.UpdatedAt, &p.AuthorID,
+		&p.Series, &p.SeriesPosition, &p.WordCount, &p.ReadingMinutes, &p.Excerpt)
+	if err != nil {
+		return p, err
+	}
+	if p.AuthorID != 0 {
+		if a, err := getAuthor(p.AuthorID); err == nil {
+			p.Author = &a
+		}
+	}
+
+	tags, err := loadTags(slug)
+	p.Tags = tags[slug]
+	return p, err
+}
+
+const insertPost = `
+	INSERT INTO posts (slug, title, description, content, published_at, type, link_url, link_title, link_description, link_image,
+		mastodon_status, bluesky_uri, content_format, raw_html, simhash, status, noindex, nofollow, updated_at,
+		content_html, content_html_key, author_id, series_id, series_position, word_count, reading_minutes, excerpt)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, 0),
+		(SELECT id FROM series WHERE slug = ?), NULLIF(?, 0), ?, ?, ?)`
+
+const upsertPost = insertPost + `
+	ON CONFLICT(slug) DO UPDATE SET
+		title=excluded.title,
+		content=excluded.content,
+		description=excluded.description,
+		published_at=excluded.published_at,
+		type=excluded.type,
+		link_url=excluded.link_url,
+		link_title=excluded.link_title,
+		link_description=excluded.link_description,
+		link_image=excluded.link_image,
+		mastodon_status=excluded.mastodon_status,
+		bluesky_uri=excluded.bluesky_uri,
+		content_format=excluded.content_format,
+		raw_html=excluded.raw_html,
+		simhash=excluded.simhash,
+		status=excluded.status,
+		noindex=excluded.noindex,
+		nofollow=excluded.nofollow,
+		updated_at=excluded.updated_at,
+		content_html=excluded.content_html,
+		content_html_key=excluded.content_html_key,
+		author_id=excluded.author_id,
+		series_id=excluded.series_id,
+		series_position=excluded.series_position,
+		word_count=excluded.word_count,
+		reading_minutes=excluded.reading_minutes,
+		excerpt=excluded.excerpt`
+
+// postArgs are p's values for insertPost.
+func postArgs(p Post) []any {
+	fingerprint := simhash(p.Title, p.ContentFormat, p.Content)
+	return []any{p.Slug, p.Title, p.Description, p.Content, p.PublishedAt,
+		p.Type, p.LinkURL, p.LinkTitle, p.LinkDescription, p.LinkImage,
+		p.MastodonStatus, p.BlueskyURI, p.ContentFormat, p.RawHTML, int64(fingerprint), p.Status, p.NoIndex, p.NoFollow, p.UpdatedAt,
+		p.ContentHTML, renderKey(), p.AuthorID, p.Series, p.SeriesPosition,
+		p.WordCount, p.ReadingMinutes, p.Excerpt}
+}
+
+func (s sqlStore) InsertPost(ctx context.Context, p *Post, action string) error {
+	return s.savePost(ctx, p, action, insertPost+" ON CONFLICT(slug) DO NOTHING")
+}
+
+func (s sqlStore) UpsertPost(ctx context.Context, p *Post, action string) error {
+	return s.savePost(ctx, p, action, upsertPost)
+}
+
+// savePost writes p with query, insertPost or upsertPost. An insert that a clash turned into
+// nothing is tried again under the next free slug.
+func (s sqlStore) savePost(ctx context.Context, p *Post, action, query string) error {
+	p.UpdatedAt = time.Now().UTC()
+	return withTx(ctx, func(tx *sql.Tx) error {
+		if p.Series != "" && p.SeriesPosition == 0 {
+			pos, err := seriesPosition(tx, p.Slug, p.Series)
+			if err != nil {
+				return err
+			}
+			p.SeriesPosition = pos
+		}
+		for n := 0; ; n++ {
+			result, err := tx.Exec(query, postArgs(*p)...)
+			if err != nil {
+				return err
+			}
+			if saved, _ := result.RowsAffected(); saved > 0 {
+				break
+			}
+			if n == 5 {
+				return errors.New("no free slug for " + strconv.Quote(p.Title))
+			}
+			p.Slug = uniqueSlug(slugify(p.Title))
+		}
+		if p.Tags != nil {
+			if err := saveTags(tx, p.Slug, p.Tags); err != nil {
+				return err
+			}
+		}
+		return recordRevision(tx, p.Slug, action)
+	})
+}
+
+func (s sqlStore) DeletePost(slug string) (bool, error) {
+	result, err := execWrite("UPDATE posts SET deleted_at = ? WHERE slug = ? AND deleted_at IS NULL", time.Now().UTC(), slug)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+func (s sqlStore) RestorePost(slug string) (bool, error) {
+	result, err := execWrite("UPDATE posts SET deleted_at = NULL, updated_at = ? WHERE slug = ? AND deleted_at IS NOT NULL", time.Now().UTC(), slug)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+func (s sqlStore) PurgePost(slug string) (bool, error) {
+	purged := false
+	err := withTx(context.Background(), func(tx *sql.Tx) error {
+		result, err := tx.Exec("DELETE FROM posts WHERE slug = ? AND deleted_at IS NOT NULL", slug)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return nil
+		}
+		// Everything else kept per slug goes too, or a new post under the slug would inherit it
+		for _, table := range []string{
+			"post_tags", "post_revisions", "comments", "post_views", "post_variants", "experiment_stats",
+			"mastodon_replies", "bluesky_replies", "bluesky_threads", "newsletter_sends", "announcements",
+		} {
+			if _, err := tx.Exec("DELETE FROM "+table+" WHERE post_slug = ?", slug); err != nil {
+				return err
+			}
+		}
+		purged = true
+		return nil
+	})
+	return purged && err == nil, err
+}
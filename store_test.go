@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDecodeCursor(t *testing.T) {
+	tests := []struct {
+		name, cursor string
+		at, slug     string
+		ok           bool
+	}{
+		{"round trip", encodeCursor("2020-01-01 00:00:00+00:00", "hello"), "2020-01-01 00:00:00+00:00", "hello", true},
+		{"empty slug", encodeCursor("2020-01-01", ""), "2020-01-01", "", true},
+		{"not base64", "!!!", "", "", false},
+		{"no separator", "MjAyMC0wMS0wMQ", "", "", false}, // "2020-01-01"
+		{"empty", "", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, slug, ok := decodeCursor(tt.cursor)
+			if ok != tt.ok || ok && (at != tt.at || slug != tt.slug) {
+				t.Errorf("decodeCursor(%q) = %q, %q, %t; want %q, %q, %t", tt.cursor, at, slug, ok, tt.at, tt.slug, tt.ok)
+			}
+		})
+	}
+}
+
+// Paging with the cursor visits every live post once, newest first, ties broken by slug.
+func TestListPostsPages(t *testing.T) {
+	testServer(t)
+	for _, p := range []struct{ slug, at string }{
+		{"oldest", "2020-01-01T00:00:00Z"},
+		{"tie-b", "2021-06-01T00:00:00Z"},
+		{"tie-a", "2021-06-01T00:00:00Z"},
+		{"newer", "2022-01-01T00:00:00Z"},
+		{"newest", "2023-01-01T00:00:00Z"},
+		{"scheduled", time.Now().Add(time.Hour).UTC().Format(time.RFC3339)},
+	} {
+		publish(t, `{"slug": "`+p.slug+`", "title": "T", "content": "<p>Body</p>", "published_at": "`+p.at+`"}`)
+	}
+	publish(t, `{"slug": "draft", "title": "T", "content": "<p>Body</p>", "status": "draft"}`)
+	want := []string{"newest", "newer", "tie-a", "tie-b", "oldest"}
+
+	for _, limit := range []int{1, 2, 3, 5, 10} {
+		var got []string
+		cursor := ""
+		for pages := 0; ; pages++ {
+			if pages > len(want) {
+				t.Fatalf("limit %d: the pages don't end", limit)
+			}
+			page, err := store.ListPosts(PostQuery{Limit: limit, Cursor: cursor})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if page.Total != len(want) {
+				t.Errorf("limit %d: total %d, want %d", limit, page.Total, len(want))
+			}
+			for _, p := range page.Posts {
+				got = append(got, p.Slug)
+			}
+			if cursor = page.NextCursor; cursor == "" {
+				break
+			}
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("limit %d: paged through %q, want %q", limit, got, want)
+		}
+	}
+
+	if _, err := store.ListPosts(PostQuery{Limit: 2, Cursor: "!!!"}); !errors.Is(err, errBadCursor) {
+		t.Errorf("a bad cursor: %v, want errBadCursor", err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// --- Tags: topics on posts, for filtering and archive-by-topic pages ---
+
+// TagCount is a tag and how many live posts carry it.
+type TagCount struct {
+	Name  string `json:"name"`
+	Posts int    `json:"posts"`
+}
+
+// normalizeTags lowercases, trims and de-duplicates, keeping the author's order.
+func normalizeTags(tags []string) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t != "" && !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// saveTags replaces a post's tags, as part of tx.
+func saveTags(tx *sql.Tx, slug string, tags []string) error {
+	if _, err := tx.Exec("DELETE FROM post_tags WHERE post_slug = ?", slug); err != nil {
+		return err
+	}
+	for _, t := range tags {
+		if _, err := tx.Exec("INSERT INTO tags (name) VALUES (?) ON CONFLICT DO NOTHING", t); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO post_tags (post_slug, tag_id) SELECT ?, id FROM tags WHERE name = ? ON CONFLICT DO NOTHING", slug, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadTags returns the tags of the given posts, or of every post when none are given.
+func loadTags(slugs ...string) (map[string][]string, error) {
+	query := "SELECT pt.post_slug, t.name FROM post_tags pt JOIN tags t ON t.id = pt.tag_id"
+	args := []any{}
+	if len(slugs) > 0 {
+		query += " WHERE pt.post_slug IN (?" + strings.Repeat(", ?", len(slugs)-1) + ")"
+		for _, s := range slugs {
+			args = append(args, s)
+		}
+	}
+
+	rows, err := db.Query(query+" ORDER BY t.name", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := map[string][]string{}
+	for rows.Next() {
+		var slug, name string
+		if rows.Scan(&slug, &name) == nil {
+			tags[slug] = append(tags[slug], name)
+		}
+	}
+	return tags, rows.Err()
+}
+
+// tagCounts lists tags in use on live posts, most used first. A scheduled post's tags
+// wait for it.
+func tagCounts() ([]TagCount, error) {
+	rows, err := db.Query(`
+		SELECT t.name, COUNT(*) FROM tags t
+		JOIN post_tags pt ON pt.tag_id = t.id
+		JOIN posts p ON p.slug = pt.post_slug AND p.status = 'published' AND p.deleted_at IS NULL AND p.published_at <= ?
+		GROUP BY t.id`, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []TagCount{}
+	for rows.Next() {
+		var c TagCount
+		if rows.Scan(&c.Name, &c.Posts) == nil {
+			counts = append(counts, c)
+		}
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Posts != counts[j].Posts {
+			return counts[i].Posts > counts[j].Posts
+		}
+		return counts[i].Name < counts[j].Name
+	})
+	return counts, rows.Err()
+}
+
+// GET /api/tags - Every tag with its post count
+func handleListTags(w http.ResponseWriter, r *http.Request) {
+	counts, err := tagCounts()
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+
+	jsonResponse(w, counts)
+}
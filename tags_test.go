@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// A scheduled post's tags stay off /tags until it goes live.
+func TestTagCountsSkipScheduled(t *testing.T) {
+	testServer(t)
+	soon := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	publish(t, `{"slug": "live", "title": "Live", "content": "<p>Body</p>", "tags": ["go", "db"]}`)
+	publish(t, `{"slug": "later", "title": "Later", "content": "<p>Body</p>", "tags": ["go", "secret"], "published_at": "`+soon+`"}`)
+
+	counts, err := tagCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int{}
+	for _, c := range counts {
+		got[c.Name] = c.Posts
+	}
+	if got["go"] != 1 || got["db"] != 1 || got["secret"] != 0 {
+		t.Errorf("tag counts = %v, want go and db once, secret not at all", got)
+	}
+
+	// The listing still carries each post's tags
+	w := httptest.NewRecorder()
+	handleListPosts(w, httptest.NewRequest("GET", "/api/posts?limit=10", nil))
+	var page PostPage
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("listing: %d %v", w.Code, err)
+	}
+	if len(page.Posts) != 1 || len(page.Posts[0].Tags) != 2 {
+		t.Errorf("listing = %+v, want the live post with its 2 tags", page.Posts)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// themeDir is where site templates live: themes/<MALT_THEME>, or the static dir when no theme is set.
+func themeDir() string {
+	if name := os.Getenv("MALT_THEME"); name != "" {
+		return filepath.Join("themes", filepath.Base(name))
+	}
+	return cfg.StaticDir
+}
+
+// themeTemplate returns the first of names that exists in the theme, else fallback.
+// Templates are read per call so theme edits show up without a restart.
+func themeTemplate(fallback *template.Template, names ...string) *template.Template {
+	for _, name := range names {
+		path := filepath.Join(themeDir(), name)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		t, err := template.ParseFiles(path)
+		if err != nil {
+			log.Printf("theme template %s: %v", path, err)
+			continue
+		}
+		return t
+	}
+	return fallback
+}
+
+// siteTitle names the blog in feeds and generated pages.
+func siteTitle() string {
+	if title := os.Getenv("MALT_SITE_TITLE"); title != "" {
+		return title
+	}
+	return "Goholic"
+}
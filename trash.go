@@ -0,0 +1,164 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// --- Trash: deleted posts wait here before they're gone for good ---
+//
+// DELETE /api/posts/{slug} only stamps deleted_at: readers, feeds and search stop seeing the
+// post, webhooks and followers hear it was deleted, and its slug stays taken. Restoring it
+// brings it back as it was, tags, revisions and comments included. Posts are purged for good
+// by hand, or MALT_TRASH_DAYS (default 30; 0 keeps them forever) after they were deleted.
+
+// TrashedPost is a post as the trash lists it.
+type TrashedPost struct {
+	Slug      string     `json:"slug"`
+	Title     string     `json:"title"`
+	Status    string     `json:"status"` // What it was before it was deleted
+	DeletedAt time.Time  `json:"deleted_at"`
+	PurgeAt   *time.Time `json:"purge_at,omitempty"` // Absent when the trash is never emptied
+}
+
+func trashDays() int {
+	return max(0, envInt("MALT_TRASH_DAYS", 30))
+}
+
+// GET /api/trash - Deleted posts, most recently deleted first
+func handleListTrash(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	rows, err := db.Query("SELECT slug, title, status, deleted_at FROM posts WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC")
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	days := trashDays()
+	posts := []TrashedPost{}
+	for rows.Next() {
+		var p TrashedPost
+		if err := rows.Scan(&p.Slug, &p.Title, &p.Status, &p.DeletedAt); err != nil {
+			continue
+		}
+		if days > 0 {
+			at := p.DeletedAt.AddDate(0, 0, days)
+			p.PurgeAt = &at
+		}
+		posts = append(posts, p)
+	}
+
+	jsonResponse(w, posts)
+}
+
+// POST /api/posts/{slug}/restore - Take a post back out of the trash
+func handleRestorePost(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	slug := r.PathValue("slug")
+	restored, err := store.RestorePost(slug)
+	if err != nil {
+		httpError(w, r, "Database error: "+err.Error(), 500)
+		return
+	}
+	if !restored {
+		httpError(w, r, "No such post in the trash", 404)
+		return
+	}
+	postChanged(r, slug, false)
+
+	jsonResponse(w, map[string]string{"status": "restored", "slug": slug})
+}
+
+// DELETE /api/trash/{slug} - Delete a trashed post for good
+func handlePurgePost(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	slug := r.PathValue("slug")
+	purged, err := store.PurgePost(slug)
+	if err != nil {
+		httpError(w, r, "Database error: "+err.Error(), 500)
+		return
+	}
+	if !purged {
+		httpError(w, r, "No such post in the trash", 404)
+		return
+	}
+	audit(r, "post.purge", slug, "")
+
+	jsonResponse(w, map[string]string{"status": "purged", "slug": slug})
+}
+
+// DELETE /api/trash - Empty the trash
+func handleEmptyTrash(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	slugs, err := trashedBefore(time.Now().UTC())
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	purged := []string{}
+	for _, slug := range slugs {
+		if ok, err := store.PurgePost(slug); err != nil {
+			httpError(w, r, "Database error: "+err.Error(), 500)
+			return
+		} else if ok {
+			audit(r, "post.purge", slug, "emptied the trash")
+			purged = append(purged, slug)
+		}
+	}
+
+	jsonResponse(w, map[string]any{"status": "emptied", "purged": purged})
+}
+
+// trashedBefore lists the posts deleted before t.
+func trashedBefore(t time.Time) ([]string, error) {
+	rows, err := db.Query("SELECT slug FROM posts WHERE deleted_at IS NOT NULL AND deleted_at <= ?", t)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var slugs []string
+	for rows.Next() {
+		var slug string
+		if rows.Scan(&slug) == nil {
+			slugs = append(slugs, slug)
+		}
+	}
+	return slugs, rows.Err()
+}
+
+// runTrash purges posts that have been in the trash for MALT_TRASH_DAYS, once an hour.
+func runTrash() {
+	for ; ; time.Sleep(time.Hour) {
+		days := trashDays()
+		if days == 0 {
+			continue
+		}
+		slugs, err := trashedBefore(time.Now().UTC().AddDate(0, 0, -days))
+		if err != nil {
+			log.Printf("trash: %v", err)
+			continue
+		}
+		for _, slug := range slugs {
+			if _, err := store.PurgePost(slug); err != nil {
+				log.Printf("trash: %s: %v", slug, err)
+			} else {
+				log.Printf("trash: purged %s after %d days", slug, days)
+			}
+		}
+	}
+}
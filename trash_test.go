@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+// A purged post leaves nothing behind for the next post under its slug to inherit.
+func TestPurgeLeavesNothing(t *testing.T) {
+	testServer(t)
+	publish(t, `{"slug": "gone", "title": "Gone", "content": "<p>Body</p>", "tags": ["go"]}`)
+	for _, q := range []string{
+		"INSERT INTO post_views (post_slug, day, views, uniques) VALUES ('gone', '2020-01-01', 5, 3)",
+		"INSERT INTO post_variants (post_slug, title) VALUES ('gone', 'Variant')",
+		"INSERT INTO experiment_stats (post_slug, variant_id, impressions) VALUES ('gone', 1, 9)",
+		"INSERT INTO mastodon_replies (id, post_slug) VALUES ('m1', 'gone')",
+		"INSERT INTO bluesky_replies (id, post_slug) VALUES ('b1', 'gone')",
+		"INSERT INTO newsletter_sends (post_slug, sent_at) VALUES ('gone', '2020-01-01 00:00:00')",
+	} {
+		if _, err := execWrite(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := store.DeletePost("gone"); err != nil {
+		t.Fatal(err)
+	}
+	if purged, err := store.PurgePost("gone"); !purged || err != nil {
+		t.Fatalf("PurgePost: %t, %v", purged, err)
+	}
+
+	for _, table := range []string{
+		"post_tags", "post_revisions", "post_views", "post_variants", "experiment_stats",
+		"mastodon_replies", "bluesky_replies", "newsletter_sends", "announcements",
+	} {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM " + table + " WHERE post_slug = 'gone'").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n > 0 {
+			t.Errorf("%s still has %d rows for the purged post", table, n)
+		}
+	}
+}
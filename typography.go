@@ -0,0 +1,121 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+// --- Text passes: bare-URL autolinking, smart typography and emoji, after the format renderer ---
+//
+// Autolinking and typography are per-site switches and off by default: MALT_AUTOLINK=1,
+// MALT_TYPOGRAPHY=1. Emoji shortcodes are on (see emoji.go). The passes only touch text,
+// never markup, code, or text that's already a link.
+
+// Text inside these is left exactly as written.
+var textPassSkip = map[string]bool{
+	"a": true, "code": true, "pre": true, "kbd": true, "samp": true,
+	"script": true, "style": true, "textarea": true,
+}
+
+var bareURL = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// applyTextPasses runs the enabled passes over body's text nodes.
+func applyTextPasses(body string) string {
+	autolink, typography := envBool("MALT_AUTOLINK", false), envBool("MALT_TYPOGRAPHY", false)
+	emoji := envBool("MALT_EMOJI_SHORTCODES", true)
+	if !autolink && !typography && !emoji {
+		return body
+	}
+
+	escape := html.EscapeString
+	if autolink {
+		escape = linkify
+	}
+
+	var b strings.Builder
+	var prev rune = ' ' // Last character of the previous text node, for quote direction
+	skipping := 0
+	z := html.NewTokenizer(strings.NewReader(body))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return b.String()
+
+		case html.TextToken:
+			if skipping > 0 {
+				b.Write(z.Raw())
+				continue
+			}
+			text := string(z.Text())
+			if typography {
+				text = smartypants(text, prev)
+			}
+			if r := []rune(text); len(r) > 0 {
+				prev = r[len(r)-1]
+			}
+			if emoji {
+				b.WriteString(expandEmoji(text, escape))
+			} else {
+				b.WriteString(escape(text))
+			}
+
+		case html.StartTagToken, html.EndTagToken:
+			name, _ := z.TagName()
+			if textPassSkip[string(name)] {
+				if tt == html.StartTagToken {
+					skipping++
+				} else if skipping > 0 {
+					skipping--
+				}
+			}
+			b.Write(z.Raw())
+
+		default:
+			b.Write(z.Raw())
+		}
+	}
+}
+
+// linkify escapes text and wraps bare URLs in links. Trailing punctuation stays outside.
+func linkify(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range bareURL.FindAllStringIndex(text, -1) {
+		u := strings.TrimRight(text[m[0]:m[1]], ".,;:!?)'’\"”")
+		end := m[0] + len(u)
+		b.WriteString(html.EscapeString(text[last:m[0]]))
+		b.WriteString(`<a href="` + html.EscapeString(u) + `">` + html.EscapeString(u) + `</a>`)
+		last = end
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+	return b.String()
+}
+
+// smartypants curls quotes and turns ---, -- and ... into em dash, en dash and ellipsis.
+// prev is the character before text, since a quote's direction depends on it.
+func smartypants(text string, prev rune) string {
+	text = strings.NewReplacer("---", "—", "--", "–", "...", "…").Replace(text)
+
+	var b strings.Builder
+	for _, r := range text {
+		opening := unicode.IsSpace(prev) || strings.ContainsRune("([{—–-", prev)
+		switch {
+		case r == '"' && opening:
+			b.WriteRune('“')
+		case r == '"':
+			b.WriteRune('”')
+		case r == '\'' && opening:
+			b.WriteRune('‘')
+		case r == '\'':
+			b.WriteRune('’') // Closing quote or apostrophe
+		default:
+			b.WriteRune(r)
+		}
+		prev = r
+	}
+	return b.String()
+}
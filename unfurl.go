@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const maxUnfurlBytes = 1 << 20 // The <head> is all we want; it's near the top
+
+// Networks that are never fetched on a publisher's behalf, on top of what netip already
+// classifies as loopback/private/link-local: carrier NAT and "this network".
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+}
+
+// unfurlClient refuses to talk to anything but the public internet. The check runs at dial
+// time, on the resolved address, so DNS tricks and redirects to 127.0.0.1 don't get through.
+var unfurlClient = &http.Client{
+	Timeout: 5 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{Timeout: 3 * time.Second, Control: publicOnly}).DialContext,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return errors.New("redirect to a non-http URL")
+		}
+		return nil
+	},
+}
+
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return fmt.Errorf("refusing to fetch non-public address %s", ip)
+	}
+	for _, p := range blockedPrefixes {
+		if p.Contains(ip) {
+			return fmt.Errorf("refusing to fetch non-public address %s", ip)
+		}
+	}
+	return nil
+}
+
+// unfurl fetches p.LinkURL and fills in whatever the publisher left empty:
+// the Link* fields from the target's <head>, and Title/Description from those.
+func unfurl(p *Post) error {
+	target, err := url.Parse(strings.TrimSpace(p.LinkURL))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return errors.New("link posts need an absolute http(s) link_url")
+	}
+	p.LinkURL = target.String()
+
+	meta, final, err := fetchHead(p.LinkURL)
+	if err != nil {
+		return err
+	}
+
+	fill := func(dst *string, candidates ...string) {
+		for _, c := range candidates {
+			if *dst != "" {
+				return
+			}
+			*dst = strings.TrimSpace(c)
+		}
+	}
+	fill(&p.LinkTitle, meta["og:title"], meta["twitter:title"], meta["title"])
+	fill(&p.LinkDescription, meta["og:description"], meta["twitter:description"], meta["description"])
+	fill(&p.LinkImage, meta["og:image"], meta["twitter:image"])
+
+	// Images are often relative to the page they came from
+	if img, err := final.Parse(p.LinkImage); err == nil && p.LinkImage != "" {
+		p.LinkImage = img.String()
+	}
+
+	fill(&p.Title, p.LinkTitle)
+	fill(&p.Description, p.LinkDescription)
+	return nil
+}
+
+// fetchHead GETs an HTML page and collects <title> and <meta> name/property → content pairs.
+// It returns the final URL after redirects, for resolving relative links.
+func fetchHead(rawURL string) (map[string]string, *url.URL, error) {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("User-Agent", "single-malt link preview")
+	req.Header.Set("Accept", "text/html")
+
+	res, err := unfurlClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	if mt, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type")); mt != "text/html" {
+		return nil, nil, fmt.Errorf("not an HTML page (%s)", mt)
+	}
+
+	meta := map[string]string{}
+	z := html.NewTokenizer(io.LimitReader(res.Body, maxUnfurlBytes))
+	inTitle := false
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return meta, res.Request.URL, nil
+		case html.TextToken:
+			if inTitle && meta["title"] == "" {
+				meta["title"] = string(z.Text())
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = false
+			case "head":
+				return meta, res.Request.URL, nil
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = true
+			case "body":
+				return meta, res.Request.URL, nil
+			case "meta":
+				var key, content string
+				for hasAttr {
+					var k, v []byte
+					k, v, hasAttr = z.TagAttr()
+					switch string(k) {
+					case "name", "property":
+						key = strings.ToLower(string(v))
+					case "content":
+						content = string(v)
+					}
+				}
+				if _, seen := meta[key]; key != "" && !seen {
+					meta[key] = content
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// --- Validation: what a post has to look like before it's stored ---
+//
+// Every write that stores a post's text checks the whole post with validatePost first:
+// savePost (publish, PATCH, imports, XML-RPC), PUT, revert and email-to-post. A/B variants,
+// whose winner replaces the title and description, are held to the same limits. The JSON
+// endpoints also refuse bodies over MALT_POST_MAX_MB (default 5) and fields Post doesn't
+// have. Problems come back together as a 422 listing each field, so a client can show them
+// next to the inputs instead of fixing one and resubmitting to find the next.
+
+const (
+	maxTitleLen       = 200
+	maxDescriptionLen = 500
+	maxSlugLen        = 200
+)
+
+// Letters and digits, in any script, with single hyphens or underscores between them
+var validSlug = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{M}\p{N}]*([-_][\p{L}\p{M}\p{N}]+)*$`)
+
+// FieldError is one problem with one field of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func maxPostBytes() int64 {
+	return int64(max(1, envInt("MALT_POST_MAX_MB", 5))) << 20
+}
+
+// validateText checks the fields every post needs, whatever else it is.
+func validateText(p Post) []FieldError {
+	var fields []FieldError
+	switch title := strings.TrimSpace(p.Title); {
+	case title == "":
+		fields = append(fields, FieldError{"title", "is required"})
+	case utf8.RuneCountInString(title) > maxTitleLen:
+		fields = append(fields, FieldError{"title", fmt.Sprintf("must be at most %d characters", maxTitleLen)})
+	}
+	if utf8.RuneCountInString(p.Description) > maxDescriptionLen {
+		fields = append(fields, FieldError{"description", fmt.Sprintf("must be at most %d characters", maxDescriptionLen)})
+	}
+	switch {
+	case strings.TrimSpace(p.Content) == "" && p.Type != "link":
+		fields = append(fields, FieldError{"content", "is required"})
+	case int64(len(p.Content)) > maxPostBytes():
+		fields = append(fields, FieldError{"content", fmt.Sprintf("must be at most %d MB", maxPostBytes()>>20)})
+	}
+	return fields
+}
+
+// validatePost checks a post savePost is about to store, defaults already filled in.
+func validatePost(p Post) []FieldError {
+	fields := validateText(p)
+	switch {
+	case utf8.RuneCountInString(p.Slug) > maxSlugLen:
+		fields = append(fields, FieldError{"slug", fmt.Sprintf("must be at most %d characters", maxSlugLen)})
+	case !validSlug.MatchString(p.Slug):
+		fields = append(fields, FieldError{"slug", "must be letters and digits, separated by single hyphens or underscores"})
+	}
+	if p.Status != "published" && p.Status != "draft" {
+		fields = append(fields, FieldError{"status", "must be published or draft"})
+	}
+	if !validFormat(p.ContentFormat) {
+		fields = append(fields, FieldError{"content_format", "must be html, markdown, asciidoc or org"})
+	}
+	if p.Type != "article" && p.Type != "link" {
+		fields = append(fields, FieldError{"type", "must be article or link"})
+	}
+	if p.AuthorID != 0 {
+		if _, err := getAuthor(p.AuthorID); err != nil {
+			fields = append(fields, FieldError{"author_id", "is not an author"})
+		}
+	}
+	switch {
+	case p.Series != "" && !seriesExists(p.Series):
+		fields = append(fields, FieldError{"series", "is not a series"})
+	case p.SeriesPosition < 0:
+		fields = append(fields, FieldError{"series_position", "can't be negative"})
+	}
+	return fields
+}
+
+// validationFailed answers 422 with every field problem, in httpError's JSON shape.
+func validationFailed(w http.ResponseWriter, r *http.Request, fields []FieldError) {
+	body := map[string]any{"error": "Validation failed", "status": 422, "fields": fields}
+	if id := requestID(r); id != "" {
+		body["request_id"] = id
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(body)
+}
+
+// decodeStrict reads a JSON body of at most maxPostBytes into v, refusing fields v doesn't
+// have. On false it has already answered: 413, 422 naming the field, or 400.
+func decodeStrict(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPostBytes()))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+
+	var tooBig *http.MaxBytesError
+	var wrongType *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &tooBig):
+		httpError(w, r, fmt.Sprintf("Body is over %d MB", tooBig.Limit>>20), 413)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		validationFailed(w, r, []FieldError{{field, "is not a field"}})
+	case errors.As(err, &wrongType):
+		validationFailed(w, r, []FieldError{{wrongType.Field, "can't be a " + wrongType.Value}})
+	default:
+		httpError(w, r, "Bad JSON", 400)
+	}
+	return false
+}
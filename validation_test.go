@@ -0,0 +1,51 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidatePost(t *testing.T) {
+	testServer(t)
+	valid := func() Post {
+		return Post{Slug: "a-post", Title: "A post", Content: "<p>Body</p>", Status: "published", ContentFormat: "html", Type: "article"}
+	}
+
+	tests := []struct {
+		name   string
+		edit   func(p *Post)
+		fields []string // The fields refused, in order
+	}{
+		{"valid", func(p *Post) {}, nil},
+		{"unicode slug", func(p *Post) { p.Slug = "привет_мир-2" }, nil},
+		{"link post without content", func(p *Post) { p.Type, p.Content = "link", "" }, nil},
+		{"blank title", func(p *Post) { p.Title = "   " }, []string{"title"}},
+		{"long title", func(p *Post) { p.Title = strings.Repeat("é", maxTitleLen+1) }, []string{"title"}},
+		{"long description", func(p *Post) { p.Description = strings.Repeat("d", maxDescriptionLen+1) }, []string{"description"}},
+		{"no content", func(p *Post) { p.Content = "" }, []string{"content"}},
+		{"slug with a space", func(p *Post) { p.Slug = "a post" }, []string{"slug"}},
+		{"slug with a double hyphen", func(p *Post) { p.Slug = "a--post" }, []string{"slug"}},
+		{"slug with a trailing hyphen", func(p *Post) { p.Slug = "post-" }, []string{"slug"}},
+		{"long slug", func(p *Post) { p.Slug = strings.Repeat("s", maxSlugLen+1) }, []string{"slug"}},
+		{"unknown status", func(p *Post) { p.Status = "live" }, []string{"status"}},
+		{"unknown format", func(p *Post) { p.ContentFormat = "rtf" }, []string{"content_format"}},
+		{"unknown type", func(p *Post) { p.Type = "photo" }, []string{"type"}},
+		{"no such author", func(p *Post) { p.AuthorID = 42 }, []string{"author_id"}},
+		{"no such series", func(p *Post) { p.Series = "nope" }, []string{"series"}},
+		{"everything at once", func(p *Post) { p.Title, p.Content, p.Slug, p.Status = "", "", "", "" }, []string{"title", "content", "slug", "status"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := valid()
+			tt.edit(&p)
+			var got []string
+			for _, f := range validatePost(p) {
+				got = append(got, f.Field)
+			}
+			if !slices.Equal(got, tt.fields) {
+				t.Errorf("refused %q, want %q", got, tt.fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Webhooks: tell other services when a post goes live, changes or goes away ---
+//
+// Each webhook is a URL, the events it wants and a secret. Every delivery is a JSON POST
+// signed with HMAC-SHA256 over the body, sent as X-Malt-Signature: sha256=<hex>, so the
+// receiver can check it came from here. Deliveries are queued in the database and sent by a
+// background worker: a non-2xx answer or a network error is retried with backoff, and after
+// the last attempt the delivery is marked failed. Every attempt's outcome stays in the log.
+//
+// Events: post.published (a post went live: new, from a draft, or scheduled and its time came),
+// post.updated (a live post changed, or was taken back to a draft), post.deleted. Edits to
+// drafts and to scheduled posts don't fire anything.
+
+const (
+	eventPublished = "post.published"
+	eventUpdated   = "post.updated"
+	eventDeleted   = "post.deleted"
+	eventPing      = "ping" // Sent by POST /api/webhooks/{id}/ping only
+)
+
+var webhookEvents = []string{eventPublished, eventUpdated, eventDeleted}
+
+// webhookBackoff is the wait before each retry; one more failure after the last is final.
+var webhookBackoff = []time.Duration{time.Minute, 5 * time.Minute, 30 * time.Minute, 2 * time.Hour}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhookLease is how long a delivery being sent is kept from other workers.
+const webhookLease = time.Minute
+
+// webhookWake nudges the worker when there's something to send right now.
+var webhookWake = make(chan struct{}, 1)
+
+// Webhook is a registered receiver. The secret is only returned on creation.
+type Webhook struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is one event queued for one webhook.
+type WebhookDelivery struct {
+	ID            int64      `json:"id"`
+	WebhookID     int64      `json:"webhook_id"`
+	Event         string     `json:"event"`
+	Payload       string     `json:"payload"`
+	Status        string     `json:"status"` // pending, delivered or failed
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	ResponseCode  int        `json:"response_code,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+}
+
+// webhookPost is the post as it appears in a payload: enough to purge a URL or announce it.
+type webhookPost struct {
+	Slug        string    `json:"slug"`
+	URL         string    `json:"url"`
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Status      string    `json:"status,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	PublishedAt time.Time `json:"published_at,omitzero"`
+	UpdatedAt   time.Time `json:"updated_at,omitzero"`
+}
+
+// postLive says whether slug is live now. Call it before a write and hand the answer to
+// postChanged after.
+func postLive(slug string) bool {
+	p, err := store.GetPost(slug)
+	return err == nil && isLive(p)
+}
+
+// postChanged fires the event for a write to slug, given whether it was live before the write.
+// A scheduled post fires post.published from runAnnouncements, once its time has come.
+func postChanged(r *http.Request, slug string, wasLive bool) {
+	p, err := store.GetPost(slug)
+	if err != nil {
+		return
+	}
+	switch {
+	case isLive(p) && !wasLive:
+		queueWebhooks(baseURL(r), eventPublished, p)
+	case wasLive:
+		queueWebhooks(baseURL(r), eventUpdated, p)
+	}
+	syncAnnouncement(baseURL(r), p)
+}
+
+// postDeleted fires post.deleted; the payload only has what's left of the post, its slug.
+func postDeleted(r *http.Request, slug string) {
+	queueWebhooks(baseURL(r), eventDeleted, Post{Slug: slug})
+	unannounce(baseURL(r), slug)
+}
+
+// queueWebhooks records a delivery of event for every active webhook that wants it. base is
+// the site URL the payload links to.
+func queueWebhooks(base, event string, p Post) {
+	rows, err := db.Query("SELECT id, events FROM webhooks WHERE active")
+	if err != nil {
+		log.Printf("webhooks: %v", err)
+		return
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		var events string
+		if rows.Scan(&id, &events) == nil && slices.Contains(strings.Split(events, ","), event) {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return
+	}
+
+	payload := webhookPayload(base, event, p)
+	for _, id := range ids {
+		enqueueDelivery(id, event, payload)
+	}
+}
+
+func webhookPayload(base, event string, p Post) []byte {
+	body, _ := json.Marshal(map[string]any{
+		"event":       event,
+		"occurred_at": time.Now().UTC(),
+		"site":        base,
+		"post": webhookPost{
+			Slug:        p.Slug,
+			URL:         base + "/post/" + p.Slug,
+			Title:       p.Title,
+			Description: p.Description,
+			Status:      p.Status,
+			Tags:        p.Tags,
+			PublishedAt: p.PublishedAt,
+			UpdatedAt:   p.UpdatedAt,
+		},
+	})
+	return body
+}
+
+func enqueueDelivery(webhookID int64, event string, payload []byte) (int64, error) {
+	now := time.Now().UTC()
+	id, err := insertID(`
+		INSERT INTO webhook_deliveries (webhook_id, event, payload, status, attempts, next_attempt_at, created_at)
+		VALUES (?, ?, ?, 'pending', 0, ?, ?)`, webhookID, event, string(payload), now, now)
+	if err != nil {
+		log.Printf("webhooks: queue %s for #%d: %v", event, webhookID, err)
+		return 0, err
+	}
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+	return id, nil
+}
+
+// runWebhooks sends due deliveries, waking up when one is queued and every 30s for retries.
+func runWebhooks() {
+	tick := time.NewTicker(30 * time.Second)
+	defer tick.Stop()
+	for {
+		sendDueWebhooks()
+		select {
+		case <-webhookWake:
+		case <-tick.C:
+		}
+	}
+}
+
+func sendDueWebhooks() {
+	rows, err := db.Query(`
+		SELECT d.id, d.event, d.payload, d.attempts, w.url, w.secret
+		FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.status = 'pending' AND d.next_attempt_at <= ?
+		ORDER BY d.id LIMIT 50`, time.Now().UTC())
+	if err != nil {
+		log.Printf("webhooks: %v", err)
+		return
+	}
+	type due struct {
+		id                          int64
+		event, payload, url, secret string
+		attempts                    int
+	}
+	var batch []due
+	for rows.Next() {
+		var d due
+		if rows.Scan(&d.id, &d.event, &d.payload, &d.attempts, &d.url, &d.secret) == nil {
+			batch = append(batch, d)
+		}
+	}
+	rows.Close()
+
+	for _, d := range batch {
+		// Other instances may have read the same batch: the one that moves next_attempt_at out
+		// of their reach sends it. Should it die mid-send, the delivery is retried after the lease.
+		now := time.Now().UTC()
+		claim, err := execWrite("UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id = ? AND status = 'pending' AND next_attempt_at <= ?",
+			now.Add(webhookLease), d.id, now)
+		if err != nil {
+			log.Printf("webhooks: %v", err)
+			continue
+		}
+		if n, _ := claim.RowsAffected(); n == 0 {
+			continue
+		}
+
+		code, err := deliverWebhook(d.url, d.secret, d.event, d.id, []byte(d.payload))
+		attempts := d.attempts + 1
+		now = time.Now().UTC()
+		switch {
+		case err == nil:
+			execWrite("UPDATE webhook_deliveries SET status = 'delivered', attempts = ?, response_code = ?, error = '', next_attempt_at = NULL, delivered_at = ? WHERE id = ?",
+				attempts, code, now, d.id)
+		case attempts > len(webhookBackoff):
+			log.Printf("webhooks: giving up on delivery #%d (%s to %s): %v", d.id, d.event, d.url, err)
+			execWrite("UPDATE webhook_deliveries SET status = 'failed', attempts = ?, response_code = ?, error = ?, next_attempt_at = NULL WHERE id = ?",
+				attempts, code, err.Error(), d.id)
+		default:
+			execWrite("UPDATE webhook_deliveries SET attempts = ?, response_code = ?, error = ?, next_attempt_at = ? WHERE id = ?",
+				attempts, code, err.Error(), now.Add(webhookBackoff[attempts-1]), d.id)
+		}
+	}
+}
+
+// deliverWebhook POSTs one payload; anything but a 2xx is an error.
+func deliverWebhook(target, secret, event string, deliveryID int64, payload []byte) (int, error) {
+	req, err := http.NewRequest("POST", target, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "single-malt webhooks")
+	req.Header.Set("X-Malt-Event", event)
+	req.Header.Set("X-Malt-Delivery", strconv.FormatInt(deliveryID, 10))
+	req.Header.Set("X-Malt-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, fmt.Errorf("%s answered %s", target, resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// webhookForm is the body of POST and PUT /api/webhooks.
+type webhookForm struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+func (f *webhookForm) validate() string {
+	f.URL = strings.TrimSpace(f.URL)
+	if u, err := url.Parse(f.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "url must be an http(s) URL"
+	}
+	if len(f.Events) == 0 {
+		f.Events = webhookEvents
+	}
+	for _, e := range f.Events {
+		if !slices.Contains(webhookEvents, e) {
+			return "events must be among " + strings.Join(webhookEvents, ", ")
+		}
+	}
+	slices.Sort(f.Events)
+	f.Events = slices.Compact(f.Events)
+	return ""
+}
+
+// GET /api/webhooks - Every webhook, without secrets
+func handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	rows, err := db.Query("SELECT id, url, events, active, created_at FROM webhooks ORDER BY id")
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	hooks := []Webhook{}
+	for rows.Next() {
+		var h Webhook
+		var events string
+		if err := rows.Scan(&h.ID, &h.URL, &events, &h.Active, &h.CreatedAt); err != nil {
+			continue
+		}
+		h.Events = strings.Split(events, ",")
+		hooks = append(hooks, h)
+	}
+
+	jsonResponse(w, hooks)
+}
+
+// POST /api/webhooks - Register a receiver: {"url": "...", "events": ["post.published"]}.
+// No events means all of them. The response is the only time the signing secret is shown.
+func handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	var f webhookForm
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		httpError(w, r, "Bad JSON", 400)
+		return
+	}
+	if msg := f.validate(); msg != "" {
+		httpError(w, r, msg, 400)
+		return
+	}
+
+	h := Webhook{URL: f.URL, Events: f.Events, Active: f.Active == nil || *f.Active, Secret: rand.Text(), CreatedAt: time.Now().UTC()}
+	var err error
+	h.ID, err = insertID("INSERT INTO webhooks (url, secret, events, active, created_at) VALUES (?, ?, ?, ?, ?)",
+		h.URL, h.Secret, strings.Join(h.Events, ","), h.Active, h.CreatedAt)
+	if err != nil {
+		httpError(w, r, "Database error: "+err.Error(), 500)
+		return
+	}
+	audit(r, "webhook.create", h.URL, strings.Join(h.Events, ","))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	jsonResponse(w, h)
+}
+
+// PUT /api/webhooks/{id} - Change a webhook's URL, events or active flag; the secret stays
+func handleUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	var f webhookForm
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		httpError(w, r, "Bad JSON", 400)
+		return
+	}
+	if msg := f.validate(); msg != "" {
+		httpError(w, r, msg, 400)
+		return
+	}
+
+	id := r.PathValue("id")
+	query, args := "UPDATE webhooks SET url = ?, events = ?", []any{f.URL, strings.Join(f.Events, ",")}
+	if f.Active != nil {
+		query, args = query+", active = ?", append(args, *f.Active)
+	}
+	result, err := execWrite(query+" WHERE id = ?", append(args, id)...)
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		httpError(w, r, "Webhook not found", 404)
+		return
+	}
+	audit(r, "webhook.update", f.URL, strings.Join(f.Events, ","))
+
+	jsonResponse(w, map[string]string{"status": "updated", "id": id})
+}
+
+// DELETE /api/webhooks/{id} - Remove a webhook and its delivery log
+func handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	id := r.PathValue("id")
+	var target string
+	if err := db.QueryRow("SELECT url FROM webhooks WHERE id = ?", id).Scan(&target); err != nil {
+		httpError(w, r, "Webhook not found", 404)
+		return
+	}
+	if _, err := execWrite("DELETE FROM webhook_deliveries WHERE webhook_id = ?", id); err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	execWrite("DELETE FROM webhooks WHERE id = ?", id)
+	audit(r, "webhook.delete", target, "")
+
+	jsonResponse(w, map[string]string{"status": "deleted", "id": id})
+}
+
+// POST /api/webhooks/{id}/ping - Queue a "ping" delivery, to check the receiver and its secret
+func handlePingWebhook(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	var exists bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM webhooks WHERE id = ?)", id).Scan(&exists)
+	if !exists {
+		httpError(w, r, "Webhook not found", 404)
+		return
+	}
+
+	payload, _ := json.Marshal(map[string]any{"event": eventPing, "occurred_at": time.Now().UTC(), "site": baseURL(r)})
+	delivery, err := enqueueDelivery(id, eventPing, payload)
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	jsonResponse(w, map[string]any{"status": "queued", "delivery": delivery})
+}
+
+// GET /api/webhooks/{id}/deliveries - The delivery log, newest first; ?limit= (default 50)
+func handleListDeliveries(w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
+		return
+	}
+
+	limit := 50
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= 1000 {
+		limit = n
+	}
+
+	rows, err := db.Query(`
+		SELECT id, webhook_id, event, payload, status, attempts, next_attempt_at, response_code, error, created_at, delivered_at
+		FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ?`, r.PathValue("id"), limit)
+	if err != nil {
+		httpError(w, r, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		var next, delivered sql.NullTime
+		var code sql.NullInt64
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &next, &code, &d.Error, &d.CreatedAt, &delivered); err != nil {
+			continue
+		}
+		if next.Valid {
+			d.NextAttemptAt = &next.Time
+		}
+		if delivered.Valid {
+			d.DeliveredAt = &delivered.Time
+		}
+		d.ResponseCode = int(code.Int64)
+		deliveries = append(deliveries, d)
+	}
+
+	jsonResponse(w, deliveries)
+}
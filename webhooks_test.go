@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// publishedDeliveries counts the post.published deliveries queued for slug.
+func publishedDeliveries(t *testing.T, slug string) int {
+	t.Helper()
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM webhook_deliveries WHERE event = ? AND payload LIKE ?",
+		eventPublished, `%"slug":"`+slug+`"%`).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// A scheduled post isn't live, so post.published waits until its time comes, and fires once.
+func TestScheduledPostPublishedAtGoLive(t *testing.T) {
+	testServer(t)
+	w := call(handleCreateWebhook, "POST", "", "admin", `{"url": "https://hooks.example/in", "events": ["post.published"]}`)
+	if w.Code != http.StatusCreated || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("creating the webhook: %d %s %s", w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+
+	publish(t, `{"slug": "now", "title": "Now", "content": "<p>Body</p>"}`)
+	if n := publishedDeliveries(t, "now"); n != 1 {
+		t.Errorf("a post published now: %d post.published deliveries, want 1", n)
+	}
+
+	soon := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	publish(t, `{"slug": "later", "title": "Later", "content": "<p>Body</p>", "published_at": "`+soon+`"}`)
+	if n := publishedDeliveries(t, "later"); n != 0 {
+		t.Errorf("a scheduled post: %d post.published deliveries before it went live", n)
+	}
+
+	// Its time comes
+	if _, err := execWrite("UPDATE posts SET published_at = ? WHERE slug = 'later'", time.Now().Add(-time.Minute).UTC()); err != nil {
+		t.Fatal(err)
+	}
+	announceDue()
+	announceDue()
+	if n := publishedDeliveries(t, "later"); n != 1 {
+		t.Errorf("a scheduled post gone live: %d post.published deliveries, want 1", n)
+	}
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- MetaWeblog over XML-RPC, so MarsEdit, Open Live Writer and friends can post ---
+//
+// The password is MALT_SECRET or a publish key (see keys.go); the username is ignored.
+// There is one blog, ID "1", and a post's ID is its slug. Editors find the endpoint through /rsd.xml.
+
+const maxXMLRPCRequest = 32 << 20
+
+type xmlrpcCall struct {
+	Method string        `xml:"methodName"`
+	Params []xmlrpcValue `xml:"params>param>value"`
+}
+
+type xmlrpcValue struct {
+	String   *string         `xml:"string"`
+	Int      *string         `xml:"int"`
+	I4       *string         `xml:"i4"`
+	Boolean  *string         `xml:"boolean"`
+	Double   *string         `xml:"double"`
+	DateTime *string         `xml:"dateTime.iso8601"`
+	Base64   *string         `xml:"base64"`
+	Struct   *[]xmlrpcMember `xml:"struct>member"`
+	Array    *[]xmlrpcValue  `xml:"array>data>value"`
+	Text     string          `xml:",chardata"` // A bare <value>text</value> is a string
+}
+
+type xmlrpcMember struct {
+	Name  string      `xml:"name"`
+	Value xmlrpcValue `xml:"value"`
+}
+
+// xmlrpcFault is an error the client gets to see.
+type xmlrpcFault struct {
+	Code int
+	Msg  string
+}
+
+func (f xmlrpcFault) Error() string { return f.Msg }
+
+// decode turns a value into string, int, bool, float64, time.Time, []byte, map[string]any or []any.
+func (v xmlrpcValue) decode() any {
+	switch {
+	case v.String != nil:
+		return *v.String
+	case v.Int != nil, v.I4 != nil:
+		s := v.Int
+		if s == nil {
+			s = v.I4
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(*s))
+		return n
+	case v.Boolean != nil:
+		return strings.TrimSpace(*v.Boolean) == "1"
+	case v.Double != nil:
+		f, _ := strconv.ParseFloat(strings.TrimSpace(*v.Double), 64)
+		return f
+	case v.DateTime != nil:
+		return parseISO8601(*v.DateTime)
+	case v.Base64 != nil:
+		b, _ := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(*v.Base64), ""))
+		return b
+	case v.Struct != nil:
+		m := map[string]any{}
+		for _, member := range *v.Struct {
+			m[member.Name] = member.Value.decode()
+		}
+		return m
+	case v.Array != nil:
+		list := []any{}
+		for _, item := range *v.Array {
+			list = append(list, item.decode())
+		}
+		return list
+	}
+	return v.Text
+}
+
+// parseISO8601 accepts the handful of spellings editors send: 20250701T09:30:00, with or
+// without a Z or offset, and the dashed RFC 3339 form.
+func parseISO8601(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{"20060102T15:04:05Z07:00", "20060102T15:04:05", "20060102T150405Z07:00", "20060102T150405", time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+func writeXMLRPCValue(b *strings.Builder, v any) {
+	b.WriteString("<value>")
+	switch v := v.(type) {
+	case string:
+		b.WriteString("<string>" + html.EscapeString(v) + "</string>")
+	case int:
+		b.WriteString("<int>" + strconv.Itoa(v) + "</int>")
+	case bool:
+		if v {
+			b.WriteString("<boolean>1</boolean>")
+		} else {
+			b.WriteString("<boolean>0</boolean>")
+		}
+	case time.Time:
+		b.WriteString("<dateTime.iso8601>" + v.UTC().Format("20060102T15:04:05") + "</dateTime.iso8601>")
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b.WriteString("<struct>")
+		for _, k := range keys {
+			b.WriteString("<member><name>" + html.EscapeString(k) + "</name>")
+			writeXMLRPCValue(b, v[k])
+			b.WriteString("</member>")
+		}
+		b.WriteString("</struct>")
+	case []any:
+		b.WriteString("<array><data>")
+		for _, item := range v {
+			writeXMLRPCValue(b, item)
+		}
+		b.WriteString("</data></array>")
+	default:
+		b.WriteString("<string>" + html.EscapeString(fmt.Sprint(v)) + "</string>")
+	}
+	b.WriteString("</value>")
+}
+
+func writeXMLRPC(w http.ResponseWriter, result any, err error) {
+	var b strings.Builder
+	b.WriteString(xml.Header + "<methodResponse>")
+	if err != nil {
+		fault, ok := err.(xmlrpcFault)
+		if !ok {
+			fault = xmlrpcFault{Code: 500, Msg: err.Error()}
+		}
+		b.WriteString("<fault>")
+		writeXMLRPCValue(&b, map[string]any{"faultCode": fault.Code, "faultString": fault.Msg})
+		b.WriteString("</fault>")
+	} else {
+		b.WriteString("<params><param>")
+		writeXMLRPCValue(&b, result)
+		b.WriteString("</param></params>")
+	}
+	b.WriteString("</methodResponse>")
+
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+// xmlrpcArgs gives typed access to a call's parameters; missing ones read as zero values.
+type xmlrpcArgs []any
+
+func (a xmlrpcArgs) str(i int) string {
+	if i < len(a) {
+		switch v := a[i].(type) {
+		case string:
+			return v
+		case int:
+			return strconv.Itoa(v)
+		}
+	}
+	return ""
+}
+
+func (a xmlrpcArgs) boolean(i int, def bool) bool {
+	if i < len(a) {
+		if v, ok := a[i].(bool); ok {
+			return v
+		}
+	}
+	return def
+}
+
+func (a xmlrpcArgs) integer(i int, def int) int {
+	if i < len(a) {
+		if v, ok := a[i].(int); ok {
+			return v
+		}
+	}
+	return def
+}
+
+func (a xmlrpcArgs) fields(i int) map[string]any {
+	if i < len(a) {
+		if v, ok := a[i].(map[string]any); ok {
+			return v
+		}
+	}
+	return map[string]any{}
+}
+
+var errXMLRPCAuth = xmlrpcFault{Code: 403, Msg: "Incorrect username or password."}
+
+// POST /xmlrpc - MetaWeblog, plus the Blogger methods editors call alongside it
+func handleXMLRPC(w http.ResponseWriter, r *http.Request) {
+	var call xmlrpcCall
+	if err := xml.NewDecoder(http.MaxBytesReader(w, r.Body, maxXMLRPCRequest)).Decode(&call); err != nil {
+		writeXMLRPC(w, nil, xmlrpcFault{Code: -32700, Msg: "parse error: " + err.Error()})
+		return
+	}
+	var args xmlrpcArgs
+	for _, v := range call.Params {
+		args = append(args, v.decode())
+	}
+
+	// (blogid|postid|appkey, username, password, ...), except deletePost, which has both an appkey and a postid
+	password := args.str(2)
+	if call.Method == "blogger.deletePost" {
+		password = args.str(3)
+	}
+	// Editors only need a publish key; deleting takes an admin one
+	need := scopePublish
+	if call.Method == "blogger.deletePost" {
+		need = scopeAdmin
+	}
+	scope, _ := checkKey(r, password)
+	if scope != scopeAdmin && scope != need {
+		writeXMLRPC(w, nil, errXMLRPCAuth)
+		return
+	}
+	r = r.WithContext(context.WithValue(r.Context(), scopeKey{}, scope))
+
+	result, err := dispatchXMLRPC(r, call.Method, args)
+	if err != nil {
+		log.Printf("xmlrpc: %s: %v", call.Method, err)
+	}
+	writeXMLRPC(w, result, err)
+}
+
+func dispatchXMLRPC(r *http.Request, method string, args xmlrpcArgs) (any, error) {
+	base := baseURL(r)
+	switch method {
+	case "blogger.getUsersBlogs", "metaWeblog.getUsersBlogs":
+		return []any{map[string]any{"blogid": "1", "blogName": r.Host, "url": base + "/", "isAdmin": true, "xmlrpc": base + "/xmlrpc"}}, nil
+
+	case "metaWeblog.getCategories":
+		counts, err := tagCounts()
+		if err != nil {
+			return nil, err
+		}
+		categories := []any{}
+		for _, c := range counts {
+			categories = append(categories, map[string]any{"categoryId": c.Name, "categoryName": c.Name, "description": c.Name, "title": c.Name})
+		}
+		return categories, nil
+
+	case "metaWeblog.getRecentPosts":
+		rows, err := db.Query("SELECT slug FROM posts WHERE deleted_at IS NULL ORDER BY published_at DESC LIMIT ?", args.integer(3, 10))
+		if err != nil {
+			return nil, err
+		}
+		var slugs []string
+		for rows.Next() {
+			var slug string
+			if rows.Scan(&slug) == nil {
+				slugs = append(slugs, slug)
+			}
+		}
+		rows.Close()
+
+		posts := []any{}
+		for _, slug := range slugs {
+			if p, err := store.GetPost(slug); err == nil {
+				posts = append(posts, metaWeblogPost(p, base))
+			}
+		}
+		return posts, nil
+
+	case "metaWeblog.getPost":
+		p, err := store.GetPost(args.str(0))
+		if err != nil {
+			return nil, xmlrpcFault{Code: 404, Msg: "No such post."}
+		}
+		return metaWeblogPost(p, base), nil
+
+	case "metaWeblog.newPost":
+		p := Post{ContentFormat: FormatHTML}
+		applyMetaWeblog(&p, args.fields(3), args.boolean(4, true))
+		if _, err := savePost(r, &p); err != nil {
+			return nil, xmlrpcFault{Code: 500, Msg: err.Error()}
+		}
+		return p.Slug, nil
+
+	case "metaWeblog.editPost":
+		p, err := store.GetPost(args.str(0))
+		if err != nil {
+			return nil, xmlrpcFault{Code: 404, Msg: "No such post."}
+		}
+		applyMetaWeblog(&p, args.fields(3), args.boolean(4, true))
+		if p.Slug != args.str(0) {
+			return nil, xmlrpcFault{Code: 400, Msg: "Changing a post's slug isn't supported; publish it anew."}
+		}
+		if _, err := savePost(r, &p); err != nil {
+			return nil, xmlrpcFault{Code: 500, Msg: err.Error()}
+		}
+		return true, nil
+
+	case "blogger.deletePost":
+		deleted, err := store.DeletePost(args.str(1))
+		if err != nil {
+			return nil, err
+		}
+		if !deleted {
+			return nil, xmlrpcFault{Code: 404, Msg: "No such post."}
+		}
+		postDeleted(r, args.str(1))
+		return true, nil
+
+	case "metaWeblog.newMediaObject":
+		file := args.fields(3)
+		name, _ := file["name"].(string)
+		bits, _ := file["bits"].([]byte)
+		m, err := storeMedia(name, bits)
+		switch {
+		case errors.Is(err, errMediaType), errors.Is(err, errMediaTooLarge):
+			return nil, xmlrpcFault{Code: 400, Msg: name + ": " + err.Error()}
+		case err != nil:
+			return nil, err
+		}
+		return map[string]any{"id": m.ID, "file": m.Filename, "url": base + m.URL, "type": m.ContentType}, nil
+	}
+
+	return nil, xmlrpcFault{Code: -32601, Msg: "Unknown method " + method}
+}
+
+// metaWeblogPost is a post in the shape editors expect.
+func metaWeblogPost(p Post, base string) map[string]any {
+	status := "publish"
+	if p.Status == "draft" {
+		status = "draft"
+	}
+	categories := []any{}
+	for _, t := range p.Tags {
+		categories = append(categories, t)
+	}
+	return map[string]any{
+		"postid":      p.Slug,
+		"userid":      "1",
+		"title":       p.Title,
+		"description": p.Content,
+		"mt_excerpt":  p.Description,
+		"wp_slug":     p.Slug,
+		"link":        base + "/post/" + p.Slug,
+		"permaLink":   base + "/post/" + p.Slug,
+		"dateCreated": p.PublishedAt,
+		"post_status": status,
+		"categories":  categories,
+	}
+}
+
+// applyMetaWeblog copies the fields an editor sent onto p; fields it left out keep their value.
+func applyMetaWeblog(p *Post, fields map[string]any, publish bool) {
+	str := func(key string) (string, bool) {
+		v, ok := fields[key].(string)
+		return v, ok
+	}
+	was := p.Status
+
+	if v, ok := str("title"); ok {
+		p.Title = v
+	}
+	if v, ok := str("description"); ok {
+		p.Content = v
+		if more, ok := str("mt_text_more"); ok && more != "" {
+			p.Content += "\n" + more
+		}
+	}
+	if v, ok := str("mt_excerpt"); ok {
+		p.Description = v
+	}
+	if p.Slug == "" {
+		if v, ok := str("wp_slug"); ok {
+			p.Slug = v
+		} else if v, ok := str("mt_basename"); ok {
+			p.Slug = v
+		}
+	}
+	if list, ok := fields["categories"].([]any); ok {
+		p.Tags = []string{}
+		for _, c := range list {
+			if name, ok := c.(string); ok {
+				p.Tags = append(p.Tags, name)
+			}
+		}
+	}
+	dated := false
+	if t, ok := fields["dateCreated"].(time.Time); ok && !t.IsZero() {
+		p.PublishedAt, dated = t, true
+	}
+
+	p.Status = "published"
+	if !publish {
+		p.Status = "draft"
+	}
+	if v, ok := str("post_status"); ok {
+		switch v {
+		case "draft", "pending", "private":
+			p.Status = "draft"
+		case "publish":
+			p.Status = "published"
+		}
+	}
+	if was == "draft" && p.Status == "published" && !dated {
+		p.PublishedAt = time.Time{} // Published now, like POST /api/posts/{slug}/publish
+	}
+}
+
+// GET /rsd.xml - Really Simple Discovery, how editors find /xmlrpc from the home page
+func handleRSD(w http.ResponseWriter, r *http.Request) {
+	base := html.EscapeString(baseURL(r))
+	w.Header().Set("Content-Type", "application/rsd+xml; charset=utf-8")
+	fmt.Fprintf(w, `%s<rsd version="1.0" xmlns="http://archipelago.phrasewise.com/rsd">
+  <service>
+    <engineName>single-malt</engineName>
+    <homePageLink>%s/</homePageLink>
+    <apis>
+      <api name="MetaWeblog" preferred="true" apiLink="%s/xmlrpc" blogID="1"/>
+      <api name="Blogger" preferred="false" apiLink="%s/xmlrpc" blogID="1"/>
+    </apis>
+  </service>
+</rsd>
+`, xml.Header, base, base, base)
+}
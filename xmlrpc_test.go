@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// editPost is a metaWeblog.editPost call for slug with title and, unless empty, dateCreated.
+func editPost(slug, title, dateCreated, status string) string {
+	date := ""
+	if dateCreated != "" {
+		date = `<member><name>dateCreated</name><value><dateTime.iso8601>` + dateCreated + `</dateTime.iso8601></value></member>`
+	}
+	return `<?xml version="1.0"?><methodCall><methodName>metaWeblog.editPost</methodName><params>
+		<param><value><string>` + slug + `</string></value></param>
+		<param><value><string>me</string></value></param>
+		<param><value><string>admin</string></value></param>
+		<param><value><struct>
+			<member><name>title</name><value><string>` + title + `</string></value></member>` + date + `
+			<member><name>post_status</name><value><string>` + status + `</string></value></member>
+		</struct></value></param>
+		<param><value><boolean>1</boolean></value></param>
+	</params></methodCall>`
+}
+
+// callXMLRPC posts body to /xmlrpc, failing the test on a fault.
+func callXMLRPC(t *testing.T, body string) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handleXMLRPC(w, httptest.NewRequest("POST", "/xmlrpc", strings.NewReader(body)))
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "<fault>") {
+		t.Fatalf("xmlrpc: %d %s", w.Code, w.Body)
+	}
+	return w.Body.String()
+}
+
+// Editors set a post's date with dateCreated, and publishing a draft without one publishes it now.
+func TestEditPostDates(t *testing.T) {
+	testServer(t)
+	publish(t, `{"slug": "edited", "title": "Edited", "content": "<p>Body</p>"}`)
+
+	callXMLRPC(t, editPost("edited", "Edited again", "20200101T00:00:00", "publish"))
+	p, err := store.GetPost("edited")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC); p.Title != "Edited again" || !p.PublishedAt.Equal(want) {
+		t.Errorf("after editPost: title %q, published_at %v, want %v", p.Title, p.PublishedAt, want)
+	}
+
+	publish(t, `{"slug": "draft", "title": "Draft", "content": "<p>Body</p>", "status": "draft", "published_at": "2020-01-01T00:00:00Z"}`)
+	start := time.Now()
+	callXMLRPC(t, editPost("draft", "Draft", "", "publish"))
+	if p, _ := store.GetPost("draft"); p.Status != "published" || p.PublishedAt.Before(start.Add(-time.Second)) {
+		t.Errorf("draft published by editPost: status %q, published_at %v", p.Status, p.PublishedAt)
+	}
+}